@@ -0,0 +1,69 @@
+package pigeon
+
+import (
+	"errors"
+	"fmt"
+	"net/textproto"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// envelopeFrom returns the envelope sender (the Return-Path) for a message
+// with headers hdr: the From address if cfg.AlignEnvelopeFrom is set,
+// otherwise cfg.EnvelopeFrom rendered with data, or the (first) From address
+// if that is empty. The Sender header does not change the envelope sender.
+//
+// DMARC only credits an SPF pass if the envelope sender's domain aligns with
+// the From domain. A misaligned envelope sender is logged as a warning, or
+// reported as an error if cfg.StrictAlignment is set.
+func envelopeFrom(cfg EmailConfig, hdr textproto.MIMEHeader, data any) (string, error) {
+	list := parseAddressList(hdr.Get("From"))
+	if len(list) == 0 {
+		return "", errors.New("missing From address")
+	}
+	fromAddr, err := extractAddrWith(list[0], cfg.AddressParser)
+	if err != nil {
+		return "", fmt.Errorf("invalid From address: %w", err)
+	}
+	if cfg.AlignEnvelopeFrom {
+		return fromAddr, nil
+	}
+
+	from := fromAddr
+	v, err := renderConfigValue(cfg, "EnvelopeFrom", cfg.EnvelopeFrom, data)
+	if err != nil {
+		return "", err
+	}
+	if v = strings.TrimSpace(v); v != "" {
+		if from, err = extractAddrWith(v, cfg.AddressParser); err != nil {
+			return "", fmt.Errorf("invalid envelope_from %q: %w", v, err)
+		}
+	}
+
+	if !aligned(addrDomain(from), addrDomain(fromAddr)) {
+		if cfg.StrictAlignment {
+			return "", fmt.Errorf("envelope sender %s is not aligned with From domain %s", from, addrDomain(fromAddr))
+		}
+		cfg.logWarn("envelope sender is not aligned with From domain; SPF will not count towards DMARC",
+			"envelope_from", from, "from", fromAddr)
+	}
+	return from, nil
+}
+
+// addrDomain returns the lower-cased domain of the bare address addr.
+func addrDomain(addr string) string {
+	_, domain, _ := strings.Cut(addr, "@")
+	return strings.ToLower(domain)
+}
+
+// aligned reports whether the domains a and b are in relaxed alignment
+// (RFC 7489, section 3.1), i.e. share the same organizational domain.
+func aligned(a, b string) bool {
+	if a == b {
+		return true
+	}
+	orgA, errA := publicsuffix.EffectiveTLDPlusOne(a)
+	orgB, errB := publicsuffix.EffectiveTLDPlusOne(b)
+	return errA == nil && errB == nil && orgA == orgB
+}
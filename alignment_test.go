@@ -0,0 +1,76 @@
+package pigeon
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestBuildMessageEnvelope_Alignment(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      EmailConfig
+		wantFrom string
+		wantWarn bool
+		wantErr  bool
+	}{
+		{
+			name:     "aligned subdomain",
+			cfg:      EmailConfig{EnvelopeFrom: "bounces@mail.example.com"},
+			wantFrom: "bounces@mail.example.com",
+		},
+		{
+			name:     "aligned under public suffix",
+			cfg:      EmailConfig{From: "news@shop.example.co.uk", EnvelopeFrom: "bounce@mta.example.co.uk"},
+			wantFrom: "bounce@mta.example.co.uk",
+		},
+		{
+			name:     "misaligned warns",
+			cfg:      EmailConfig{EnvelopeFrom: "bounce+{{ .ID }}@esp.example.net"},
+			wantFrom: "bounce+42@esp.example.net",
+			wantWarn: true,
+		},
+		{
+			name:     "sender is not the envelope sender",
+			cfg:      EmailConfig{Sender: "relay@example.org"},
+			wantFrom: "alerts@example.com",
+		},
+		{
+			name:    "misaligned strict",
+			cfg:     EmailConfig{EnvelopeFrom: "bounce@esp.example.net", StrictAlignment: true},
+			wantErr: true,
+		},
+		{
+			name:     "align from",
+			cfg:      EmailConfig{EnvelopeFrom: "bounce@esp.example.net", AlignEnvelopeFrom: true, StrictAlignment: true},
+			wantFrom: "alerts@example.com",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			cfg := tt.cfg
+			cfg.TemplatePath = tplWriteTemp(t, "To: b@example.org\nSubject: Alignment\n\nbody")
+			cfg.From = chooseNonEmpty(cfg.From, "Alerts <alerts@example.com>")
+			cfg.Logger = slog.New(slog.NewTextHandler(&logs, nil))
+
+			_, env, err := BuildMessageEnvelope(cfg, map[string]int{"ID": 42})
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "not aligned") {
+					t.Fatalf("BuildMessageEnvelope err = %v, want alignment error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("BuildMessageEnvelope: %v", err)
+			}
+			if env.From != tt.wantFrom {
+				t.Errorf("envelope From = %q, want %q", env.From, tt.wantFrom)
+			}
+			if got := strings.Contains(logs.String(), "level=WARN"); got != tt.wantWarn {
+				t.Errorf("warning logged = %v, want %v:\n%s", got, tt.wantWarn, logs.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package pigeon
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// feedbackTypes are the Feedback-Type values registered by RFC 5965 and
+// RFC 6591.
+var feedbackTypes = map[string]bool{
+	"abuse": true, "auth-failure": true, "fraud": true, "not-spam": true, "other": true, "virus": true,
+}
+
+// FeedbackReport describes an Abuse Reporting Format report (RFC 5965).
+type FeedbackReport struct {
+	// From and To are the addresses the report is sent from and to.
+	From string
+	To   string
+	// FeedbackType is the kind of feedback, e.g. "abuse" or "fraud" (required).
+	FeedbackType string
+	// UserAgent names the software generating the report (required).
+	UserAgent string
+	// Description is the human-readable explanation (optional).
+	Description string
+
+	// The remaining fields describe the original message and are included
+	// in the report when set (optional).
+	ArrivalDate      time.Time
+	OriginalMailFrom string
+	OriginalRcptTo   []string
+	ReportingMTA     string
+	SourceIP         string
+	ReportedDomain   string
+	ReportedURI      []string
+}
+
+// BuildARF builds an Abuse Reporting Format message (RFC 5965) about
+// original. The result is a multipart/report message with a human-readable
+// explanation, a message/feedback-report part describing the feedback, and
+// the original message attached as message/rfc822.
+func BuildARF(original []byte, report FeedbackReport) ([]byte, error) {
+	if report.From == "" || report.To == "" {
+		return nil, errors.New("arf: from and to must be specified")
+	}
+	if !feedbackTypes[report.FeedbackType] {
+		return nil, fmt.Errorf("arf: invalid feedback type %q", report.FeedbackType)
+	}
+	if report.UserAgent == "" {
+		return nil, errors.New("arf: user agent must be specified")
+	}
+	if _, err := mail.ReadMessage(bytes.NewReader(original)); err != nil {
+		return nil, fmt.Errorf("arf: failed to parse original message: %w", err)
+	}
+	fields := []string{report.UserAgent, report.OriginalMailFrom, report.ReportingMTA, report.SourceIP, report.ReportedDomain}
+	fields = append(fields, report.OriginalRcptTo...)
+	fields = append(fields, report.ReportedURI...)
+	for _, v := range fields {
+		if strings.ContainsAny(v, "\r\n") {
+			return nil, errors.New("arf: report fields must not contain CR or LF")
+		}
+	}
+
+	description := report.Description
+	if description == "" {
+		description = fmt.Sprintf("This is a feedback report of type %q about the attached message.", report.FeedbackType)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	// Part 1: human-readable explanation.
+	pw, _ := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=UTF-8"},
+		"Content-Transfer-Encoding": {"8bit"},
+	})
+	fmt.Fprintf(pw, "%s\r\n", toCRLF(description))
+
+	// Part 2: machine-readable feedback report.
+	pw, _ = mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"message/feedback-report"}})
+	fmt.Fprintf(pw, "Feedback-Type: %s\r\n", report.FeedbackType)
+	fmt.Fprintf(pw, "User-Agent: %s\r\n", report.UserAgent)
+	fmt.Fprintf(pw, "Version: 1\r\n")
+	if !report.ArrivalDate.IsZero() {
+		fmt.Fprintf(pw, "Arrival-Date: %s\r\n", report.ArrivalDate.Format(time.RFC1123Z))
+	}
+	if report.OriginalMailFrom != "" {
+		fmt.Fprintf(pw, "Original-Mail-From: <%s>\r\n", report.OriginalMailFrom)
+	}
+	for _, rcpt := range report.OriginalRcptTo {
+		fmt.Fprintf(pw, "Original-Rcpt-To: <%s>\r\n", rcpt)
+	}
+	if report.ReportingMTA != "" {
+		fmt.Fprintf(pw, "Reporting-MTA: dns; %s\r\n", report.ReportingMTA)
+	}
+	if report.SourceIP != "" {
+		fmt.Fprintf(pw, "Source-IP: %s\r\n", report.SourceIP)
+	}
+	if report.ReportedDomain != "" {
+		fmt.Fprintf(pw, "Reported-Domain: %s\r\n", report.ReportedDomain)
+	}
+	for _, uri := range report.ReportedURI {
+		fmt.Fprintf(pw, "Reported-Uri: %s\r\n", uri)
+	}
+
+	// Part 3: the original message.
+	pw, _ = mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"message/rfc822"}})
+	pw.Write([]byte(toCRLF(string(original))))
+	mw.Close()
+
+	hdr := make(textproto.MIMEHeader)
+	hdr.Set("From", report.From)
+	hdr.Set("To", report.To)
+	hdr.Set("Subject", fmt.Sprintf("FW: %s report", report.FeedbackType))
+	hdr.Set("Date", time.Now().UTC().Format(time.RFC1123Z))
+	hdr.Set("MIME-Version", "1.0")
+	hdr.Set("Content-Type", fmt.Sprintf("multipart/report; report-type=feedback-report; boundary=%s", mw.Boundary()))
+
+	var msg bytes.Buffer
+	writeHeaders(&msg, hdr, maxLineLength)
+	msg.WriteString("\r\n")
+	body.WriteTo(&msg)
+	return msg.Bytes(), nil
+}
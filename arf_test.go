@@ -0,0 +1,115 @@
+package pigeon
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildARF(t *testing.T) {
+	original := "From: spammer@example.org\r\nTo: victim@example.net\r\nSubject: Buy now\r\n\r\nCheap stuff.\r\n"
+
+	b, err := BuildARF([]byte(original), FeedbackReport{
+		From:             "abuse@example.net",
+		To:               "abuse@example.org",
+		FeedbackType:     "abuse",
+		UserAgent:        "pigeon-fbl/1.0",
+		ArrivalDate:      time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		OriginalMailFrom: "spammer@example.org",
+		OriginalRcptTo:   []string{"victim@example.net"},
+		SourceIP:         "192.0.2.1",
+	})
+	if err != nil {
+		t.Fatalf("BuildARF error: %v", err)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("ParseMediaType: %v", err)
+	}
+	if mediaType != "multipart/report" || params["report-type"] != "feedback-report" {
+		t.Fatalf("Content-Type = %q %v", mediaType, params)
+	}
+
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	wantTypes := []string{"text/plain", "message/feedback-report", "message/rfc822"}
+	var bodies []string
+	for i, want := range wantTypes {
+		p, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("part %d: %v", i, err)
+		}
+		if ct, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type")); ct != want {
+			t.Errorf("part %d Content-Type = %q, want %q", i, ct, want)
+		}
+		b, _ := io.ReadAll(p)
+		bodies = append(bodies, string(b))
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("expected exactly 3 parts, got err=%v", err)
+	}
+
+	for _, want := range []string{
+		"Feedback-Type: abuse\r\n",
+		"User-Agent: pigeon-fbl/1.0\r\n",
+		"Version: 1\r\n",
+		"Arrival-Date: Tue, 02 Jan 2024 03:04:05 +0000\r\n",
+		"Original-Mail-From: <spammer@example.org>\r\n",
+		"Original-Rcpt-To: <victim@example.net>\r\n",
+		"Source-IP: 192.0.2.1\r\n",
+	} {
+		if !strings.Contains(bodies[1], want) {
+			t.Errorf("feedback-report part missing %q: %q", want, bodies[1])
+		}
+	}
+
+	inner, err := mail.ReadMessage(strings.NewReader(bodies[2]))
+	if err != nil {
+		t.Fatalf("original part does not parse: %v", err)
+	}
+	if inner.Header.Get("Subject") != "Buy now" {
+		t.Errorf("original Subject = %q", inner.Header.Get("Subject"))
+	}
+}
+
+func TestBuildARF_Invalid(t *testing.T) {
+	original := []byte("From: a@example.org\r\nTo: b@example.net\r\n\r\nHi\r\n")
+	valid := FeedbackReport{From: "abuse@example.net", To: "abuse@example.org", FeedbackType: "abuse", UserAgent: "test/1"}
+
+	tests := []struct {
+		name   string
+		modify func(r *FeedbackReport)
+		orig   []byte
+	}{
+		{name: "missing feedback type", modify: func(r *FeedbackReport) { r.FeedbackType = "" }},
+		{name: "unknown feedback type", modify: func(r *FeedbackReport) { r.FeedbackType = "spam" }},
+		{name: "missing user agent", modify: func(r *FeedbackReport) { r.UserAgent = "" }},
+		{name: "missing to", modify: func(r *FeedbackReport) { r.To = "" }},
+		{name: "header injection", modify: func(r *FeedbackReport) { r.SourceIP = "192.0.2.1\r\nX-Evil: 1" }},
+		{name: "unparsable original", orig: []byte("not a message")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := valid
+			if tt.modify != nil {
+				tt.modify(&r)
+			}
+			orig := original
+			if tt.orig != nil {
+				orig = tt.orig
+			}
+			if _, err := BuildARF(orig, r); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package pigeon
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// Attachment is a file attached to the message. In YAML and JSON it may be
+// written as just its path, or as a mapping with the fields below.
+type Attachment struct {
+	// Path is the file path, or http(s) URL, of the attachment. It is a
+	// template rendered with the message data, e.g.
+	// "invoices/{{ .InvoiceID }}.pdf".
+	Path string `yaml:"path" json:"path"`
+	// Description is sent as the part's Content-Description (optional).
+	Description string `yaml:"description,omitempty" json:"description,omitempty"`
+	// ContentID is sent as the part's Content-ID, so that an HTML body can
+	// refer to the attachment as "cid:<content_id>". An ID without a domain
+	// part gets EmailConfig.ContentIDDomain added, and the HTML body's
+	// references are updated to match (optional).
+	ContentID string `yaml:"content_id,omitempty" json:"content_id,omitempty"`
+	// When is a template rendered with the message data; if it renders
+	// blank, "false", "0" or "no", the attachment is left out, e.g.
+	// "{{ gt (len .Rows) 0 }}" (optional).
+	When string `yaml:"when,omitempty" json:"when,omitempty"`
+	// Charset is added as the charset parameter of a text/* attachment's
+	// Content-Type, e.g. "Shift_JIS" (optional).
+	Charset string `yaml:"charset,omitempty" json:"charset,omitempty"`
+	// SourceCharset is the charset the file is stored in. When set, the
+	// content is transcoded to Charset, which defaults to UTF-8 (optional).
+	SourceCharset string `yaml:"source_charset,omitempty" json:"source_charset,omitempty"`
+	// DetectCharset sets the charset of a text/* attachment whose charset is
+	// not given from a byte order mark or, failing that, declares UTF-8 if
+	// the content is valid UTF-8. Content in an unknown charset is sent
+	// without one rather than mislabeled (optional).
+	DetectCharset bool `yaml:"detect_charset,omitempty" json:"detect_charset,omitempty"`
+	// ContentType overrides the media type guessed from the file name or
+	// sent by the server, e.g. "message/rfc822" (optional).
+	ContentType string `yaml:"content_type,omitempty" json:"content_type,omitempty"`
+	// Encoding is the Content-Transfer-Encoding of the attachment: "base64",
+	// the default, or "quoted-printable", which keeps text/* attachments
+	// readable. Other types are always sent as base64 (optional).
+	Encoding string `yaml:"encoding,omitempty" json:"encoding,omitempty"`
+	// Content, if set, is the attachment's data, and Path is only used as
+	// its file name. It cannot be set from YAML or JSON; see WrapAsRFC822.
+	Content string `yaml:"-" json:"-"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler for Attachment, accepting either
+// a path or a mapping.
+func (a *Attachment) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var path string
+	if err := unmarshal(&path); err == nil {
+		*a = Attachment{Path: path}
+		return nil
+	}
+	type plain Attachment
+	return unmarshal((*plain)(a))
+}
+
+// MarshalYAML implements yaml.Marshaler for Attachment. An attachment with
+// only a path is written as the path.
+func (a Attachment) MarshalYAML() (interface{}, error) {
+	if a == (Attachment{Path: a.Path}) {
+		return a.Path, nil
+	}
+	type plain Attachment
+	return plain(a), nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler for Attachment, accepting either
+// a path or an object.
+func (a *Attachment) UnmarshalJSON(b []byte) error {
+	var path string
+	if err := json.Unmarshal(b, &path); err == nil {
+		*a = Attachment{Path: path}
+		return nil
+	}
+	type plain Attachment
+	return json.Unmarshal(b, (*plain)(a))
+}
+
+// contentID returns a.ContentID enclosed in angle brackets, as the
+// Content-ID header requires (RFC 2392), or "" if it is unset.
+func (a Attachment) contentID() string {
+	id := a.bareContentID()
+	if id == "" {
+		return ""
+	}
+	return "<" + id + ">"
+}
+
+// bareContentID returns a.ContentID without surrounding space or angle
+// brackets.
+func (a Attachment) bareContentID() string {
+	return strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(a.ContentID), "<"), ">")
+}
+
+// quotedPrintable reports whether the attachment is sent quoted-printable
+// rather than base64.
+func (a Attachment) quotedPrintable() bool {
+	return strings.EqualFold(a.Encoding, "quoted-printable")
+}
+
+// checkEncoding checks that a.Encoding is valid for an attachment of the
+// media type ctype: quoted-printable is only allowed for text.
+func (a Attachment) checkEncoding(ctype string) error {
+	switch {
+	case a.Encoding == "" || strings.EqualFold(a.Encoding, "base64"):
+		return nil
+	case !a.quotedPrintable():
+		return fmt.Errorf("attachment %s: invalid encoding %q: must be base64 or quoted-printable", a.Path, a.Encoding)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(ctype); !strings.HasPrefix(mediaType, "text/") {
+		return fmt.Errorf("attachment %s: quoted-printable encoding requires a text/* type, not %q", a.Path, ctype)
+	}
+	return nil
+}
+
+// charset returns the charset declared for the attachment: Charset, or UTF-8
+// if the content is transcoded without one.
+func (a Attachment) charset() string {
+	if a.Charset == "" && a.SourceCharset != "" {
+		return "UTF-8"
+	}
+	return a.Charset
+}
+
+// detectCharset returns the charset of the text data, from its byte order
+// mark or, without one, "UTF-8" if data is valid UTF-8. It returns "" if the
+// charset cannot be told.
+func detectCharset(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		return "UTF-8"
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}), bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		// "UTF-16" tells the reader to take the byte order from the BOM
+		// (RFC 2781).
+		return "UTF-16"
+	case utf8.Valid(data):
+		return "UTF-8"
+	}
+	return ""
+}
+
+// labelCharset applies spec.DetectCharset to the attachment data of media
+// type ctype: it returns spec with the detected Charset and ctype without a
+// charset parameter, such as the one mime.TypeByExtension adds, so that an
+// undetected charset is not declared. Attachments whose charset is given in
+// Charset, SourceCharset or ContentType are returned unchanged.
+func labelCharset(spec Attachment, ctype string, data []byte) (Attachment, string) {
+	if !spec.DetectCharset || spec.charset() != "" {
+		return spec, ctype
+	}
+	mediaType, params, err := mime.ParseMediaType(ctype)
+	if err != nil || !strings.HasPrefix(mediaType, "text/") {
+		return spec, ctype
+	}
+	if _, ok := params["charset"]; ok && spec.ContentType != "" {
+		return spec, ctype
+	}
+	spec.Charset = detectCharset(data)
+	delete(params, "charset")
+	return spec, mime.FormatMediaType(mediaType, params)
+}
+
+// transcode converts data from the charset from to the charset to. Charset
+// names are looked up as in the WHATWG Encoding Standard, e.g. "Shift_JIS",
+// "ISO-8859-1" or "UTF-8".
+func transcode(data []byte, from, to string) ([]byte, error) {
+	src, err := htmlindex.Get(from)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported charset %q: %w", from, err)
+	}
+	dst, err := htmlindex.Get(to)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported charset %q: %w", to, err)
+	}
+	text, err := src.NewDecoder().Bytes(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", from, err)
+	}
+	out, err := dst.NewEncoder().Bytes(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %w", to, err)
+	}
+	return out, nil
+}
+
+// attachments returns c.Attachments followed by c.AttachmentSpecs.
+func (c *EmailConfig) attachments() []Attachment {
+	if len(c.Attachments) == 0 {
+		return c.AttachmentSpecs
+	}
+	specs := make([]Attachment, 0, len(c.Attachments)+len(c.AttachmentSpecs))
+	for _, path := range c.Attachments {
+		specs = append(specs, Attachment{Path: path})
+	}
+	return append(specs, c.AttachmentSpecs...)
+}
+
+// renderAttachments returns cfg's attachments with their paths rendered
+// with data, leaving out those whose When condition is false.
+func renderAttachments(cfg EmailConfig, data any) ([]Attachment, error) {
+	specs := cfg.attachments()
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	out := make([]Attachment, 0, len(specs))
+	for _, a := range specs {
+		if a.When != "" {
+			cond, err := renderConfigValue(cfg, "attachment when", a.When, data)
+			if err != nil {
+				return nil, err
+			}
+			if !truthy(cond) {
+				continue
+			}
+		}
+		path, err := renderConfigValue(cfg, "attachment", a.Path, data)
+		if err != nil {
+			return nil, err
+		}
+		a.Path = path
+		out = append(out, a)
+	}
+	return out, nil
+}
+
+// truthy reports whether the rendered condition s is true: anything but
+// blank, "false", "0", "no" or "<no value>", the output of a missing map key.
+func truthy(s string) bool {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "false", "0", "no", "<no value>":
+		return false
+	}
+	return true
+}
+
+// readAttachment reads the local attachment at path. A missing file is
+// reported differently from one that cannot be read; errors.Is(err,
+// fs.ErrNotExist) tells them apart.
+func readAttachment(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, attachmentFileError(path, err)
+	}
+	return data, nil
+}
+
+// attachmentFileError wraps an error from opening the local attachment path.
+func attachmentFileError(path string, err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("attachment %s does not exist: %w", path, err)
+	}
+	return fmt.Errorf("failed to read attachment %s: %w", path, err)
+}
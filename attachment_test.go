@@ -0,0 +1,379 @@
+package pigeon
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+func TestAttachment_Unmarshal(t *testing.T) {
+	want := []Attachment{
+		{Path: "/tmp/plain.txt"},
+		{Path: "/tmp/logo.png", Description: "Company logo", ContentID: "logo"},
+	}
+
+	cfg, err := Load(`
+attachments:
+  - /tmp/first.txt
+attachment_specs:
+  - /tmp/plain.txt
+  - path: /tmp/logo.png
+    description: Company logo
+    content_id: logo
+`)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if !reflect.DeepEqual(cfg.Attachments, []string{"/tmp/first.txt"}) {
+		t.Errorf("YAML attachments = %q", cfg.Attachments)
+	}
+	if !reflect.DeepEqual(cfg.AttachmentSpecs, want) {
+		t.Errorf("YAML attachment_specs = %+v, want %+v", cfg.AttachmentSpecs, want)
+	}
+	if got := cfg.attachments(); len(got) != 3 || got[0].Path != "/tmp/first.txt" || got[2] != want[1] {
+		t.Errorf("attachments() = %+v, want Attachments then AttachmentSpecs", got)
+	}
+
+	var got []Attachment
+	if err := json.Unmarshal([]byte(`["/tmp/plain.txt", {"path": "/tmp/logo.png", "description": "Company logo", "content_id": "logo"}]`), &got); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("JSON attachments = %+v, want %+v", got, want)
+	}
+
+	// A plain path is written back as a plain path.
+	s := cfg.String()
+	if !strings.Contains(s, "- /tmp/plain.txt") || !strings.Contains(s, "content_id: logo") {
+		t.Errorf("String() = %q", s)
+	}
+}
+
+func TestBuildMessage_AttachmentDescriptionAndContentID(t *testing.T) {
+	attPath := filepath.Join(t.TempDir(), "logo.png")
+	if err := os.WriteFile(attPath, []byte("png"), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	cfg := EmailConfig{
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Logo\n\nSee the logo."),
+		AttachmentSpecs: []Attachment{
+			{Path: attPath, Description: "Logo für Grüße", ContentID: "logo@example.com"},
+		},
+	}
+	raw, err := BuildMessage(cfg, nil)
+	if err != nil {
+		t.Fatalf("BuildMessage: %v", err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("ParseMediaType: %v", err)
+	}
+
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	var found bool
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("NextPart: %v", err)
+		}
+		if p.FileName() != "logo.png" {
+			continue
+		}
+		found = true
+		desc, err := new(mime.WordDecoder).DecodeHeader(p.Header.Get("Content-Description"))
+		if err != nil || desc != "Logo für Grüße" {
+			t.Errorf("Content-Description = %q (%v), want %q", desc, err, "Logo für Grüße")
+		}
+		if got := p.Header.Get("Content-Id"); got != "<logo@example.com>" {
+			t.Errorf("Content-ID = %q, want <logo@example.com>", got)
+		}
+	}
+	if !found {
+		t.Fatalf("attachment part not found:\n%s", raw)
+	}
+
+	cfg.AttachmentSpecs[0].ContentID = "two words"
+	if _, err := BuildMessage(cfg, nil); err == nil || !strings.Contains(err.Error(), "invalid content_id") {
+		t.Errorf("BuildMessage with invalid content_id: err = %v", err)
+	}
+}
+
+func TestBuildMessage_AttachmentCharset(t *testing.T) {
+	text := "名前,数量\nりんご,3\n"
+	sjis, err := japanese.ShiftJIS.NewEncoder().String(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	sjisPath := filepath.Join(dir, "sjis.csv")
+	utf8Path := filepath.Join(dir, "utf8.csv")
+	if err := os.WriteFile(sjisPath, []byte(sjis), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(utf8Path, []byte(text), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		att  Attachment
+		want string
+	}{
+		{name: "declared", att: Attachment{Path: sjisPath, Charset: "Shift_JIS"}, want: sjis},
+		{name: "transcoded", att: Attachment{Path: utf8Path, SourceCharset: "UTF-8", Charset: "Shift_JIS"}, want: sjis},
+		{name: "to utf-8", att: Attachment{Path: sjisPath, SourceCharset: "Shift_JIS"}, want: text},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := EmailConfig{
+				TemplatePath:    tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: CSV\n\nSee attached."),
+				AttachmentSpecs: []Attachment{tt.att},
+			}
+			raw, err := BuildMessage(cfg, nil)
+			if err != nil {
+				t.Fatalf("BuildMessage: %v", err)
+			}
+			msg, err := mail.ReadMessage(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatalf("ReadMessage: %v", err)
+			}
+			_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+			if err != nil {
+				t.Fatalf("ParseMediaType: %v", err)
+			}
+			mr := multipart.NewReader(msg.Body, params["boundary"])
+			var p *multipart.Part
+			for p == nil || p.FileName() == "" {
+				if p, err = mr.NextPart(); err != nil {
+					t.Fatalf("NextPart: %v", err)
+				}
+			}
+			if _, params, _ = mime.ParseMediaType(p.Header.Get("Content-Type")); params["charset"] != tt.att.charset() {
+				t.Errorf("Content-Type = %q, want charset %q", p.Header.Get("Content-Type"), tt.att.charset())
+			}
+			body, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, p))
+			if err != nil {
+				t.Fatalf("decode body: %v", err)
+			}
+			if string(body) != tt.want {
+				t.Errorf("body = %q, want %q", body, tt.want)
+			}
+		})
+	}
+
+	cfg := EmailConfig{
+		TemplatePath:    tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: CSV\n\nSee attached."),
+		AttachmentSpecs: []Attachment{{Path: utf8Path, SourceCharset: "UTF-8", Charset: "x-unknown"}},
+	}
+	if _, err := BuildMessage(cfg, nil); err == nil || !strings.Contains(err.Error(), "unsupported charset") {
+		t.Errorf("BuildMessage with unknown charset: err = %v", err)
+	}
+	cfg.AttachmentSpecs = []Attachment{{Path: utf8Path, SourceCharset: "UTF-8", Charset: "ISO-8859-1"}}
+	if _, err := BuildMessage(cfg, nil); err == nil {
+		t.Error("BuildMessage with unencodable text: want error")
+	}
+}
+
+func TestBuildMessage_AttachmentDetectCharset(t *testing.T) {
+	sjis, err := japanese.ShiftJIS.NewEncoder().String("名前,数量\nりんご,3\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	files := map[string]string{
+		// "Hi\n" in UTF-16LE with a byte order mark.
+		"utf16.txt": "\xff\xfeH\x00i\x00\n\x00",
+		"utf8.csv":  "名前,数量\n",
+		"sjis.csv":  sjis,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		att  Attachment
+		want string
+	}{
+		{att: Attachment{Path: "utf16.txt", DetectCharset: true}, want: "text/plain; charset=UTF-16"},
+		{att: Attachment{Path: "utf8.csv", DetectCharset: true}, want: "text/csv; charset=UTF-8"},
+		{att: Attachment{Path: "sjis.csv", DetectCharset: true}, want: "text/csv"},
+		{att: Attachment{Path: "sjis.csv", DetectCharset: true, Charset: "Shift_JIS"}, want: "text/csv; charset=Shift_JIS"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.att.Path, func(t *testing.T) {
+			tt.att.Path = filepath.Join(dir, tt.att.Path)
+			cfg := EmailConfig{
+				TemplatePath:    tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Text\n\nSee attached."),
+				AttachmentSpecs: []Attachment{tt.att},
+			}
+			raw, err := BuildMessage(cfg, nil)
+			if err != nil {
+				t.Fatalf("BuildMessage: %v", err)
+			}
+			msg, err := mail.ReadMessage(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatalf("ReadMessage: %v", err)
+			}
+			_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+			if err != nil {
+				t.Fatalf("ParseMediaType: %v", err)
+			}
+			mr := multipart.NewReader(msg.Body, params["boundary"])
+			var p *multipart.Part
+			for p == nil || p.FileName() == "" {
+				if p, err = mr.NextPart(); err != nil {
+					t.Fatalf("NextPart: %v", err)
+				}
+			}
+			got := p.Header.Get("Content-Type")
+			if want := tt.want + "; " + encodeNameParam(filepath.Base(tt.att.Path)); got != want {
+				t.Errorf("Content-Type = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestBuildMessage_AttachmentQuotedPrintable(t *testing.T) {
+	text := "Grüße aus dem Büro\n" + strings.Repeat("lorem ipsum ", 20) + "\nend=of=file\n"
+	dir := t.TempDir()
+	txtPath := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(txtPath, []byte(text), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := EmailConfig{
+		TemplatePath:    tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Notes\n\nSee attached."),
+		AttachmentSpecs: []Attachment{{Path: txtPath, Encoding: "quoted-printable"}},
+		QPLineLength:    40,
+	}
+	raw, res, err := BuildMessageResult(cfg, nil)
+	if err != nil {
+		t.Fatalf("BuildMessageResult: %v", err)
+	}
+	if got := res.Parts[len(res.Parts)-1].Encoding; got != "quoted-printable" {
+		t.Errorf("reported Encoding = %q, want quoted-printable", got)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("ParseMediaType: %v", err)
+	}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	var p *multipart.Part
+	for p == nil || p.FileName() == "" {
+		if p, err = mr.NextPart(); err != nil {
+			t.Fatalf("NextPart: %v", err)
+		}
+	}
+	// multipart.Reader decodes quoted-printable parts and removes the
+	// Content-Transfer-Encoding header, so check the raw message for it.
+	i := bytes.Index(raw, []byte("Content-Transfer-Encoding: quoted-printable\r\nContent-Type: text/plain"))
+	if i < 0 {
+		t.Fatalf("attachment not sent quoted-printable:\n%s", raw)
+	}
+	encoded := raw[i:]
+	encoded = encoded[bytes.Index(encoded, []byte("\r\n\r\n"))+4:]
+	encoded = encoded[:bytes.Index(encoded, []byte("\r\n--"))]
+	for _, line := range strings.Split(string(encoded), "\r\n") {
+		if len(line) > cfg.QPLineLength {
+			t.Errorf("encoded line %q longer than qp_line_length %d", line, cfg.QPLineLength)
+		}
+	}
+	body, err := io.ReadAll(p)
+	if err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if want := strings.ReplaceAll(text, "\n", "\r\n"); string(body) != want {
+		t.Errorf("body = %q, want %q", body, want)
+	}
+
+	binPath := filepath.Join(dir, "blob.bin")
+	if err := os.WriteFile(binPath, []byte{0, 1, 2}, 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range []Attachment{
+		{Path: binPath, Encoding: "quoted-printable"},
+		{Path: txtPath, Encoding: "uuencode"},
+	} {
+		cfg.AttachmentSpecs = []Attachment{a}
+		if _, err := BuildMessage(cfg, nil); err == nil {
+			t.Errorf("BuildMessage with %s as %s: want error", a.Path, a.Encoding)
+		}
+	}
+}
+
+func TestBuildMessage_AttachmentWhen(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"summary.txt", "errors.csv"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := EmailConfig{
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Report\n\nSee attached."),
+		AttachmentSpecs: []Attachment{
+			{Path: filepath.Join(dir, "summary.txt")},
+			{Path: filepath.Join(dir, "errors.csv"), When: "{{ gt .Errors 0 }}"},
+		},
+	}
+
+	for _, tt := range []struct {
+		errors int
+		want   []string
+	}{
+		{errors: 0, want: []string{"summary.txt"}},
+		{errors: 3, want: []string{"summary.txt", "errors.csv"}},
+	} {
+		parts, err := BuildParts(cfg, map[string]int{"Errors": tt.errors})
+		if err != nil {
+			t.Fatalf("BuildParts: %v", err)
+		}
+		var got []string
+		for _, p := range parts[1:] {
+			got = append(got, string(p.Body))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Errors = %d: attachments = %q, want %q", tt.errors, got, tt.want)
+		}
+	}
+
+	// With every attachment left out, the message is a single text part.
+	cfg.AttachmentSpecs = cfg.AttachmentSpecs[1:]
+	raw, err := BuildMessage(cfg, map[string]int{"Errors": 0})
+	if err != nil {
+		t.Fatalf("BuildMessage: %v", err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if ctype := msg.Header.Get("Content-Type"); !strings.HasPrefix(ctype, "text/plain") {
+		t.Errorf("Content-Type = %q, want text/plain", ctype)
+	}
+	if size, err := EstimateSize(cfg, map[string]int{"Errors": 0}); err != nil || size != int64(len(raw)) {
+		t.Errorf("EstimateSize = (%d, %v), want %d", size, err, len(raw))
+	}
+}
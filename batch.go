@@ -0,0 +1,173 @@
+package pigeon
+
+import (
+	"context"
+	"time"
+
+	"github.com/dotarpa/pigeon/tpl"
+)
+
+// BatchItem is one personalized message of a batch.
+type BatchItem struct {
+	// To lists the recipients (comma-separated) the message is delivered to.
+	// If empty, the recipients are taken from the rendered headers as with
+	// Send.
+	To string
+	// Data is the template data for this message.
+	Data any
+}
+
+// SendResult is the outcome of sending one BatchItem.
+type SendResult struct {
+	// Index is the position of the item in the batch.
+	Index int
+	// To is the item's To.
+	To string
+	// Sampled reports whether the item was selected for sending. It is
+	// false only for items left out by BatchConfig.SamplePercent, or that
+	// failed before the sample was taken.
+	Sampled bool
+	// Suppressed lists the recipients left out because they are on
+	// EmailConfig.Suppression.
+	Suppressed []string
+	// Invalid lists the recipients left out because
+	// EmailConfig.RecipientValidator rejected them.
+	Invalid []InvalidRecipient
+	// Retry reports whether a failure was temporary, as returned by Send.
+	Retry bool
+	// Err is nil if the message was sent.
+	Err error
+}
+
+// SendBatch sends one message per item, rendering the template with the
+// item's Data. The template is parsed once for the whole batch, and a failure
+// of one item does not stop the others. Results are returned in item order.
+//
+// An item's To only sets the envelope recipients; headers are rendered as for
+// Send, so a template To listing everyone is shown to every recipient unless
+// cfg.Batch.PerRecipientTo is set.
+//
+// With cfg.Batch.DripInterval set, SendBatch pauses that long between
+// deliveries, returning the remaining items with ctx's error if it is
+// canceled. Items that are skipped or fail before reaching the relay do not
+// wait.
+//
+// With cfg.Batch.SamplePercent set, only the items whose recipients fall in
+// the sample (see InSample) are sent; the others are reported with Sampled
+// false and no error.
+func SendBatch(ctx context.Context, cfg EmailConfig, items []BatchItem) []SendResult {
+	results := make([]SendResult, len(items))
+	cfg.Logger = contextLogger(ctx, cfg.Logger)
+	t, err := prepareBatch(cfg)
+	d := &dripper{interval: cfg.Batch.DripInterval}
+	for i, item := range items {
+		results[i] = sendBatchItem(ctx, cfg, t, d, err, i, item)
+	}
+	return results
+}
+
+// prepareBatch checks cfg for sending a batch and parses its template once.
+func prepareBatch(cfg EmailConfig) (*tpl.Template, error) {
+	if err := cfg.checkSmarthost(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Batch.checkSample(); err != nil {
+		return nil, err
+	}
+	return parseTemplate(cfg)
+}
+
+// sendBatchItem sends the item at index i of a batch with template t, or
+// fails it with setupErr, the error from prepareBatch, if set.
+func sendBatchItem(ctx context.Context, cfg EmailConfig, t *tpl.Template, d *dripper, setupErr error, i int, item BatchItem) SendResult {
+	res := SendResult{Index: i, To: item.To}
+	switch {
+	case setupErr != nil:
+		res.Err = setupErr
+	case ctx.Err() != nil:
+		res.Retry, res.Err = true, ctx.Err()
+	default:
+		sendItem(ctx, cfg, t, d, item, &res)
+	}
+	return res
+}
+
+// dripper spaces out the deliveries of a batch by interval.
+type dripper struct {
+	interval time.Duration
+	// delivered reports whether a message of the batch has been delivered,
+	// so that the next delivery waits.
+	delivered bool
+	// waits counts the pauses taken.
+	waits int
+}
+
+// wait pauses for d.interval if a message was delivered before, or until
+// ctx is done, in which case it returns ctx's error.
+func (d *dripper) wait(ctx context.Context) error {
+	if d.interval <= 0 || !d.delivered {
+		return nil
+	}
+	d.waits++
+	timer := time.NewTimer(d.interval)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// sendItem builds and delivers the message for a single batch item,
+// recording the outcome in res.
+func sendItem(ctx context.Context, cfg EmailConfig, t *tpl.Template, d *dripper, item BatchItem, res *SendResult) {
+	hdr, err := assembleHeaders(t, cfg, item.Data)
+	if err != nil {
+		res.Err = err
+		return
+	}
+
+	env, err := newEnvelope(cfg, hdr, item.Data)
+	if err != nil {
+		res.Err = err
+		return
+	}
+	if item.To != "" {
+		// The item's recipients replace To; the shared Cc and Bcc still
+		// receive a copy of each message.
+		if env.Rcpts, err = envelopeRcpts(cfg.AddressParser, item.To, hdr.Get("Cc"), hdr.Get("Bcc"), cfg.ArchiveBcc); err != nil {
+			res.Err = err
+			return
+		}
+		if cfg.Batch.PerRecipientTo {
+			hdr.Set("To", encodeHeaderValue("To", item.To))
+		}
+	}
+
+	if res.Sampled = cfg.Batch.sampled(env.Rcpts, cfg.ArchiveBcc); !res.Sampled {
+		return
+	}
+	if res.Suppressed, res.Err = suppress(cfg, hdr, &env); res.Err != nil {
+		return
+	}
+	if res.Invalid, res.Err = validateRecipients(cfg, &env); res.Err != nil {
+		return
+	}
+
+	msg := getBuffer()
+	defer putBuffer(msg)
+	if _, _, res.Err = writeMessage(msg, t, cfg, hdr, item.Data); res.Err != nil {
+		return
+	}
+	if cfg.Smarthost, res.Err = renderSmarthost(cfg, item.Data); res.Err != nil {
+		return
+	}
+	if err := d.wait(ctx); err != nil {
+		res.Retry, res.Err = true, err
+		return
+	}
+	if res.Retry, res.Err = deliver(ctx, cfg, env, msg.Bytes(), nil); res.Err == nil {
+		d.delivered = true
+	}
+}
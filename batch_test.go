@@ -0,0 +1,253 @@
+package pigeon
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSendBatch_PerRecipientTo(t *testing.T) {
+	m := &mockSMTP{}
+	m.start(t)
+
+	all := "alice@example.com, bob@example.com, carol@example.com"
+	cfg := EmailConfig{
+		Smarthost:    m.smarthost(),
+		From:         "news@example.com",
+		To:           all,
+		Cc:           "archive@example.com",
+		TemplatePath: tplWriteTemp(t, "Subject: Hello {{.Name}}\n\nHi {{.Name}}"),
+		Batch:        BatchConfig{PerRecipientTo: true},
+	}
+	items := []BatchItem{
+		{To: "alice@example.com", Data: map[string]string{"Name": "Alice"}},
+		{To: "Bob <bob@example.com>", Data: map[string]string{"Name": "Bob"}},
+		{To: "carol@example.com", Data: map[string]string{"Name": "Carol"}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	results := SendBatch(ctx, cfg, items)
+
+	for i, res := range results {
+		if res.Err != nil || res.Index != i {
+			t.Fatalf("result %d = %+v", i, res)
+		}
+		raw := <-m.received
+		msg, err := mail.ReadMessage(strings.NewReader(raw))
+		if err != nil {
+			t.Fatalf("ReadMessage: %v", err)
+		}
+		to, err := msg.Header.AddressList("To")
+		if err != nil {
+			t.Fatalf("To header: %v", err)
+		}
+		want, _ := mail.ParseAddress(items[i].To)
+		if len(to) != 1 || to[0].Address != want.Address {
+			t.Errorf("message %d To = %v, want only %s", i, to, want.Address)
+		}
+		if got := msg.Header.Get("Cc"); got != "archive@example.com" {
+			t.Errorf("message %d Cc = %q, want shared Cc", i, got)
+		}
+	}
+
+	// Each transaction is addressed to its item's recipient and the shared Cc.
+	var rcpts []string
+	for _, cmd := range m.Commands() {
+		if strings.HasPrefix(cmd, "RCPT TO:") {
+			rcpts = append(rcpts, strings.TrimPrefix(cmd, "RCPT TO:"))
+		}
+	}
+	if got := strings.Join(rcpts, " "); got != "<alice@example.com> <archive@example.com> <bob@example.com> <archive@example.com> <carol@example.com> <archive@example.com>" {
+		t.Errorf("RCPT TO = %s", got)
+	}
+}
+
+func TestSendBatch_SharedTo(t *testing.T) {
+	m := &mockSMTP{}
+	m.start(t)
+
+	cfg := EmailConfig{
+		Smarthost:    m.smarthost(),
+		From:         "news@example.com",
+		To:           "alice@example.com, bob@example.com",
+		TemplatePath: tplWriteTemp(t, "Subject: Hello\n\nHi"),
+	}
+	results := SendBatch(context.Background(), cfg, []BatchItem{{To: "alice@example.com"}, {To: "bob@example.com"}})
+	for _, res := range results {
+		if res.Err != nil {
+			t.Fatalf("result %d: %v", res.Index, res.Err)
+		}
+		raw := <-m.received
+		if !strings.Contains(raw, "To: alice@example.com, bob@example.com\n") {
+			t.Errorf("without PerRecipientTo the rendered To should be kept:\n%s", raw)
+		}
+	}
+}
+
+func TestSendBatch_TemplateError(t *testing.T) {
+	cfg := EmailConfig{Smarthost: HostPort{Host: "127.0.0.1", Port: "1"}, TemplatePath: "/nonexistent.tmpl"}
+	results := SendBatch(context.Background(), cfg, []BatchItem{{To: "a@example.com"}, {To: "b@example.com"}})
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+	for _, res := range results {
+		if res.Err == nil || res.Retry {
+			t.Errorf("result %d = %+v, want permanent error", res.Index, res)
+		}
+	}
+}
+
+func TestSendBatch_MissingAttachment(t *testing.T) {
+	m := &mockSMTP{}
+	m.start(t)
+
+	dir := t.TempDir()
+	for _, id := range []string{"1", "3"} {
+		if err := os.WriteFile(filepath.Join(dir, "invoice-"+id+".txt"), []byte("invoice "+id), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A directory exists but cannot be read as a file.
+	if err := os.Mkdir(filepath.Join(dir, "invoice-4.txt"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := EmailConfig{
+		Smarthost:       m.smarthost(),
+		From:            "billing@example.com",
+		To:              "{{ .To }}",
+		TemplatePath:    tplWriteTemp(t, "Subject: Invoice {{ .ID }}\n\nSee attached."),
+		AttachmentSpecs: []Attachment{{Path: filepath.Join(dir, "invoice-{{ .ID }}.txt")}},
+	}
+	items := []BatchItem{
+		{To: "alice@example.com", Data: map[string]string{"ID": "1", "To": "alice@example.com"}},
+		{To: "bob@example.com", Data: map[string]string{"ID": "2", "To": "bob@example.com"}},
+		{To: "carol@example.com", Data: map[string]string{"ID": "3", "To": "carol@example.com"}},
+		{To: "dave@example.com", Data: map[string]string{"ID": "4", "To": "dave@example.com"}},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	results := SendBatch(ctx, cfg, items)
+
+	for _, i := range []int{0, 2} {
+		if results[i].Err != nil {
+			t.Errorf("result %d: %v", i, results[i].Err)
+		}
+	}
+	if err := results[1].Err; !errors.Is(err, fs.ErrNotExist) || results[1].Retry {
+		t.Errorf("result 1 = %+v, want permanent missing-file error", results[1])
+	}
+	if err := results[3].Err; err == nil || errors.Is(err, fs.ErrNotExist) || results[3].Retry {
+		t.Errorf("result 3 = %+v, want permanent unreadable-file error", results[3])
+	}
+
+	for _, want := range []string{"Invoice 1", "Invoice 3"} {
+		msg, err := mail.ReadMessage(strings.NewReader(<-m.received))
+		if err != nil {
+			t.Fatalf("ReadMessage: %v", err)
+		}
+		if got := msg.Header.Get("Subject"); got != want {
+			t.Errorf("Subject = %q, want %q", got, want)
+		}
+	}
+	select {
+	case raw := <-m.received:
+		t.Errorf("unexpected message:\n%s", raw)
+	default:
+	}
+}
+
+func TestSendBatch_DripInterval(t *testing.T) {
+	m := &mockSMTP{}
+	m.start(t)
+	const drip = 100 * time.Millisecond
+	cfg := EmailConfig{
+		Smarthost:    m.smarthost(),
+		From:         "news@example.com",
+		To:           "subscribers@example.com",
+		TemplatePath: tplWriteTemp(t, "Subject: Hello\n\nHi"),
+		Batch:        BatchConfig{DripInterval: drip},
+	}
+	items := []BatchItem{{To: "a@example.com"}, {To: "b@example.com"}, {To: "c@example.com"}}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	for _, res := range SendBatch(ctx, cfg, items) {
+		if res.Err != nil {
+			t.Fatalf("result %d: %v", res.Index, res.Err)
+		}
+		<-m.received
+	}
+	// Three items have two gaps between them.
+	if elapsed := time.Since(start); elapsed < 2*drip {
+		t.Errorf("batch took %v, want at least %v", elapsed, 2*drip)
+	}
+
+	// Cancellation cuts a pause short and fails the remaining items.
+	cfg.Batch.DripInterval = time.Hour
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	results := SendBatch(ctx, cfg, items)
+	<-m.received
+	if results[0].Err != nil {
+		t.Errorf("first item: %v", results[0].Err)
+	}
+	for _, res := range results[1:] {
+		if !errors.Is(res.Err, context.DeadlineExceeded) || !res.Retry {
+			t.Errorf("result %d = %+v, want retryable deadline error", res.Index, res)
+		}
+	}
+}
+
+func TestSendBatch_DripSkipsFailedItems(t *testing.T) {
+	m := &mockSMTP{}
+	m.start(t)
+	cfg := EmailConfig{
+		Smarthost:    m.smarthost(),
+		From:         "news@example.com",
+		To:           "subscribers@example.com",
+		TemplatePath: tplWriteTemp(t, "Subject: Hello\n\nHi"),
+		Batch:        BatchConfig{DripInterval: time.Millisecond},
+		RecipientValidator: func(addr string) error {
+			if addr == "rejected@example.com" {
+				return errors.New("rejected")
+			}
+			return nil
+		},
+	}
+	items := []BatchItem{
+		{To: "not an address"},
+		{To: "a@example.com"},
+		{To: "rejected@example.com"},
+		{To: "not an address"},
+		{To: "b@example.com"},
+		{To: "c@example.com"},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tmpl, err := prepareBatch(cfg)
+	d := &dripper{interval: cfg.Batch.DripInterval}
+	var sent int
+	for i, item := range items {
+		if res := sendBatchItem(ctx, cfg, tmpl, d, err, i, item); res.Err == nil {
+			sent++
+			<-m.received
+		}
+	}
+	if sent != 3 {
+		t.Fatalf("sent %d items, want 3", sent)
+	}
+	// Only the deliveries after the first one wait.
+	if d.waits != 2 {
+		t.Errorf("waited %d times, want 2", d.waits)
+	}
+}
@@ -0,0 +1,37 @@
+package pigeon
+
+import "context"
+
+// SendBatchStream is like SendBatch but reads the items from a channel and
+// sends each result on the returned channel as soon as the item is done, so
+// that very large batches need not be held in memory. A SendResult's Index
+// counts the items in the order they were received.
+//
+// The returned channel is closed once items is closed. When ctx is canceled,
+// no further items are sent and no further results are delivered, but items
+// is still drained until it is closed, so that a producer that does not
+// watch ctx is not blocked.
+func SendBatchStream(ctx context.Context, cfg EmailConfig, items <-chan BatchItem) <-chan SendResult {
+	results := make(chan SendResult)
+	cfg.Logger = contextLogger(ctx, cfg.Logger)
+	t, err := prepareBatch(cfg)
+	d := &dripper{interval: cfg.Batch.DripInterval}
+
+	go func() {
+		defer close(results)
+		i := 0
+		for item := range items {
+			if ctx.Err() != nil {
+				// Drain without sending.
+				continue
+			}
+			res := sendBatchItem(ctx, cfg, t, d, err, i, item)
+			i++
+			select {
+			case results <- res:
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return results
+}
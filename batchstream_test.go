@@ -0,0 +1,92 @@
+package pigeon
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSendBatchStream(t *testing.T) {
+	m := &mockSMTP{}
+	m.start(t)
+	cfg := EmailConfig{
+		Smarthost:    m.smarthost(),
+		From:         "news@example.com",
+		To:           "subscribers@example.com",
+		TemplatePath: tplWriteTemp(t, "Subject: Hello {{ .Name }}\n\nHi"),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	const n = 5
+	items := make(chan BatchItem)
+	go func() {
+		defer close(items)
+		for i := range n {
+			items <- BatchItem{To: fmt.Sprintf("user%d@example.com", i), Data: map[string]any{"Name": i}}
+		}
+	}()
+
+	seen := make(map[int]string)
+	for res := range SendBatchStream(ctx, cfg, items) {
+		if res.Err != nil {
+			t.Fatalf("result %d: %v", res.Index, res.Err)
+		}
+		seen[res.Index] = res.To
+		<-m.received
+	}
+	if len(seen) != n {
+		t.Fatalf("got %d results, want %d", len(seen), n)
+	}
+	for i := range n {
+		if want := fmt.Sprintf("user%d@example.com", i); seen[i] != want {
+			t.Errorf("result %d To = %q, want %q", i, seen[i], want)
+		}
+	}
+}
+
+func TestSendBatchStream_Canceled(t *testing.T) {
+	cfg := EmailConfig{Smarthost: HostPort{Host: "127.0.0.1", Port: "1"}, TemplatePath: "/nonexistent.tmpl"}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// The producer ignores ctx: it sends every item and then closes items.
+	items := make(chan BatchItem)
+	started := make(chan struct{})
+	produced := make(chan struct{})
+	go func() {
+		defer close(produced)
+		items <- BatchItem{To: "a@example.com"}
+		close(started)
+		for range 100 {
+			items <- BatchItem{To: "b@example.com"}
+		}
+		close(items)
+	}()
+
+	results := SendBatchStream(ctx, cfg, items)
+	if res := <-results; res.Err == nil {
+		t.Errorf("result = %+v, want template error", res)
+	}
+	<-started
+	cancel()
+
+	// Nobody reads the results any more, but the producer still finishes,
+	// and the results channel is closed with items.
+	select {
+	case <-produced:
+	case <-time.After(5 * time.Second):
+		t.Fatal("producer blocked after cancellation")
+	}
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-results:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("results not closed")
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package pigeon
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/smtp"
+	"strings"
+)
+
+// bdatChunkSize is the largest chunk sent in a single BDAT command.
+const bdatChunkSize = 1 << 20
+
+// toBinaryMIME returns msg with the base64-encoded parts of a top-level
+// multipart/mixed body decoded and marked "Content-Transfer-Encoding: binary"
+// (RFC 3030), or false if msg has no such parts. Messages whose decoded data
+// would contain the multipart boundary are left alone.
+func toBinaryMIME(msg []byte) ([]byte, bool) {
+	i := bytes.Index(msg, []byte("\r\n\r\n"))
+	if i < 0 {
+		return nil, false
+	}
+	head, body := msg[:i+4], msg[i+4:]
+
+	ctype := headerValue(head, "Content-Type")
+	mediaType, params, err := mime.ParseMediaType(ctype)
+	if err != nil || mediaType != "multipart/mixed" || params["boundary"] == "" {
+		return nil, false
+	}
+	boundary := params["boundary"]
+
+	var out bytes.Buffer
+	out.Write(head)
+	mw := multipart.NewWriter(&out)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return nil, false
+	}
+
+	converted := false
+	mr := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		p, err := mr.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, false
+		}
+		data, err := io.ReadAll(p)
+		if err != nil {
+			return nil, false
+		}
+		if strings.EqualFold(p.Header.Get("Content-Transfer-Encoding"), "base64") {
+			dec, err := base64.StdEncoding.DecodeString(string(bytes.ReplaceAll(data, []byte("\r\n"), nil)))
+			if err != nil {
+				return nil, false
+			}
+			if bytes.Contains(dec, []byte("--"+boundary)) {
+				return nil, false
+			}
+			p.Header.Set("Content-Transfer-Encoding", "binary")
+			data, converted = dec, true
+		}
+		pw, err := mw.CreatePart(p.Header)
+		if err != nil {
+			return nil, false
+		}
+		pw.Write(data)
+	}
+	if !converted {
+		return nil, false
+	}
+	mw.Close()
+	return out.Bytes(), true
+}
+
+// headerValue returns the unfolded value of the first key field in the
+// CRLF-terminated header block head, or "" if there is none.
+func headerValue(head []byte, key string) string {
+	lines := strings.Split(string(head), "\r\n")
+	for n, line := range lines {
+		k, v, ok := strings.Cut(line, ":")
+		if !ok || !strings.EqualFold(k, key) {
+			continue
+		}
+		for _, cont := range lines[n+1:] {
+			if cont == "" || (cont[0] != ' ' && cont[0] != '\t') {
+				break
+			}
+			v += cont
+		}
+		return strings.TrimSpace(v)
+	}
+	return ""
+}
+
+// bdat transmits msg with the BDAT command of the CHUNKING extension
+// (RFC 3030), in chunks of at most bdatChunkSize bytes. Unlike DATA, the
+// message is sent as-is, without dot-stuffing.
+func bdat(c *smtp.Client, msg []byte) error {
+	for {
+		chunk, last := msg, true
+		if len(chunk) > bdatChunkSize {
+			chunk, last = msg[:bdatChunkSize], false
+		}
+		msg = msg[len(chunk):]
+
+		cmd := fmt.Sprintf("BDAT %d", len(chunk))
+		if last {
+			cmd += " LAST"
+		}
+		id := c.Text.Next()
+		c.Text.StartRequest(id)
+		c.Text.W.WriteString(cmd + "\r\n")
+		c.Text.W.Write(chunk)
+		err := c.Text.W.Flush()
+		c.Text.EndRequest(id)
+		if err != nil {
+			return err
+		}
+		c.Text.StartResponse(id)
+		_, _, err = c.Text.ReadResponse(250)
+		c.Text.EndResponse(id)
+		if err != nil || last {
+			return err
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package pigeon
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSend_BinaryMIME(t *testing.T) {
+	// Include CRLF, a lone dot line and every byte value, which would all
+	// need care over DATA.
+	payload := []byte("line1\r\n.\r\nline3\n")
+	for b := 0; b < 256; b++ {
+		payload = append(payload, byte(b))
+	}
+	attPath := filepath.Join(t.TempDir(), "blob.bin")
+	if err := os.WriteFile(attPath, payload, 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		extensions []string
+		dkim       bool
+		wantBinary bool
+	}{
+		{name: "chunking and binarymime", extensions: []string{"8BITMIME", "CHUNKING", "BINARYMIME"}, wantBinary: true},
+		{name: "binarymime only", extensions: []string{"8BITMIME", "BINARYMIME"}},
+		{name: "chunking only", extensions: []string{"8BITMIME", "CHUNKING"}},
+		// The DKIM body hash covers the base64 parts as signed.
+		{name: "dkim", extensions: []string{"8BITMIME", "CHUNKING", "BINARYMIME"}, dkim: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &mockSMTP{Extensions: tt.extensions, KeepCRLF: true}
+			m.start(t)
+
+			cfg := EmailConfig{
+				Smarthost:    m.smarthost(),
+				TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Binary\n\nSee attached."),
+				Attachments:  []string{attPath},
+				BinaryMIME:   true,
+			}
+			if tt.dkim {
+				_, key, _ := ed25519.GenerateKey(rand.Reader)
+				cfg.DKIM = &DKIMConfig{Domain: "example.com", Selector: "mail", PrivateKey: writeDKIMKey(t, key)}
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if _, err := Send(ctx, cfg, nil); err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+
+			var mailCmd string
+			usedBDAT := false
+			for _, cmd := range m.Commands() {
+				if strings.HasPrefix(cmd, "MAIL FROM:") {
+					mailCmd = cmd
+				}
+				if strings.HasPrefix(cmd, "BDAT ") {
+					usedBDAT = true
+				}
+			}
+			if got := strings.Contains(mailCmd, " BODY=BINARYMIME"); got != tt.wantBinary {
+				t.Errorf("MAIL command %q: BODY=BINARYMIME present = %v, want %v", mailCmd, got, tt.wantBinary)
+			}
+			if tt.wantBinary && strings.Contains(mailCmd, "8BITMIME") {
+				t.Errorf("MAIL command %q has two BODY parameters", mailCmd)
+			}
+			if usedBDAT != tt.wantBinary {
+				t.Errorf("BDAT used = %v, want %v", usedBDAT, tt.wantBinary)
+			}
+
+			raw := <-m.received
+			msg, err := mail.ReadMessage(strings.NewReader(raw))
+			if err != nil {
+				t.Fatalf("ReadMessage: %v", err)
+			}
+			_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+			if err != nil {
+				t.Fatalf("ParseMediaType: %v", err)
+			}
+			mr := multipart.NewReader(msg.Body, params["boundary"])
+			if _, err := mr.NextPart(); err != nil {
+				t.Fatalf("text part: %v", err)
+			}
+			p, err := mr.NextPart()
+			if err != nil {
+				t.Fatalf("attachment part: %v", err)
+			}
+			cte := p.Header.Get("Content-Transfer-Encoding")
+			if want := map[bool]string{true: "binary", false: "base64"}[tt.wantBinary]; cte != want {
+				t.Fatalf("attachment Content-Transfer-Encoding = %q, want %q", cte, want)
+			}
+			if tt.wantBinary {
+				got, _ := io.ReadAll(p)
+				if !bytes.Equal(got, payload) {
+					t.Errorf("attachment data = %q, want %q", got, payload)
+				}
+			}
+		})
+	}
+}
+
+func TestToBinaryMIME_BoundaryInData(t *testing.T) {
+	msg := []byte("Content-Type: multipart/mixed; boundary=b1\r\n\r\n" +
+		"--b1\r\nContent-Type: text/plain\r\n\r\nhi\r\n" +
+		"--b1\r\nContent-Transfer-Encoding: base64\r\n\r\nLS1iMQ==\r\n" + // "--b1"
+		"--b1--\r\n")
+	if _, ok := toBinaryMIME(msg); ok {
+		t.Error("toBinaryMIME converted a part containing the boundary")
+	}
+
+	msg = bytes.Replace(msg, []byte("LS1iMQ=="), []byte("AAEC"), 1)
+	out, ok := toBinaryMIME(msg)
+	if !ok {
+		t.Fatal("toBinaryMIME did not convert the base64 part")
+	}
+	if !bytes.Contains(out, []byte("Content-Transfer-Encoding: binary\r\n\r\n\x00\x01\x02\r\n--b1--")) {
+		t.Errorf("unexpected output: %q", out)
+	}
+}
@@ -0,0 +1,93 @@
+package pigeon
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// BuildBounce builds a delivery status notification (RFC 3464) reporting that
+// the original message could not be delivered. The result is a
+// multipart/report message with a human-readable explanation, a
+// message/delivery-status part listing the original recipients as failed, and
+// the original message attached as message/rfc822.
+//
+// from is the address the bounce is sent from (typically MAILER-DAEMON) and
+// to is the address it is returned to, usually the original sender.
+func BuildBounce(original []byte, reason string, from, to string) ([]byte, error) {
+	if from == "" || to == "" {
+		return nil, errors.New("bounce: from and to must be specified")
+	}
+	orig, err := mail.ReadMessage(bytes.NewReader(original))
+	if err != nil {
+		return nil, fmt.Errorf("bounce: failed to parse original message: %w", err)
+	}
+	if reason == "" {
+		reason = "delivery failed"
+	}
+
+	var failed []string
+	for _, f := range []string{"To", "Cc", "Bcc"} {
+		failed = append(failed, parseAddressList(orig.Header.Get(f))...)
+	}
+	if len(failed) == 0 {
+		return nil, errors.New("bounce: original message has no recipients")
+	}
+
+	reportingMTA := "localhost"
+	if addr, err := extractAddr(from); err == nil {
+		if i := strings.LastIndex(addr, "@"); i >= 0 {
+			reportingMTA = addr[i+1:]
+		}
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	// Part 1: human-readable explanation.
+	pw, _ := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=UTF-8"},
+		"Content-Transfer-Encoding": {"8bit"},
+	})
+	fmt.Fprintf(pw, "Your message could not be delivered to the following recipients:\r\n\r\n")
+	for _, rcpt := range failed {
+		fmt.Fprintf(pw, "  %s\r\n", rcpt)
+	}
+	fmt.Fprintf(pw, "\r\nReason: %s\r\n", reason)
+
+	// Part 2: machine-readable delivery status.
+	pw, _ = mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"message/delivery-status"}})
+	fmt.Fprintf(pw, "Reporting-MTA: dns; %s\r\n", reportingMTA)
+	fmt.Fprintf(pw, "Arrival-Date: %s\r\n", time.Now().UTC().Format(time.RFC1123Z))
+	for _, rcpt := range failed {
+		fmt.Fprintf(pw, "\r\nFinal-Recipient: rfc822; %s\r\n", rcpt)
+		fmt.Fprintf(pw, "Action: failed\r\n")
+		fmt.Fprintf(pw, "Status: 5.0.0\r\n")
+		fmt.Fprintf(pw, "Diagnostic-Code: smtp; %s\r\n", strings.ReplaceAll(reason, "\n", " "))
+	}
+
+	// Part 3: the original message.
+	pw, _ = mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"message/rfc822"}})
+	pw.Write([]byte(toCRLF(string(original))))
+	mw.Close()
+
+	hdr := make(textproto.MIMEHeader)
+	hdr.Set("From", from)
+	hdr.Set("To", to)
+	hdr.Set("Subject", "Undelivered Mail Returned to Sender")
+	hdr.Set("Date", time.Now().UTC().Format(time.RFC1123Z))
+	hdr.Set("Auto-Submitted", "auto-replied")
+	hdr.Set("MIME-Version", "1.0")
+	hdr.Set("Content-Type", fmt.Sprintf("multipart/report; report-type=delivery-status; boundary=%s", mw.Boundary()))
+
+	var msg bytes.Buffer
+	writeHeaders(&msg, hdr, maxLineLength)
+	msg.WriteString("\r\n")
+	body.WriteTo(&msg)
+	return msg.Bytes(), nil
+}
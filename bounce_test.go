@@ -0,0 +1,82 @@
+package pigeon
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestBuildBounce(t *testing.T) {
+	original := "From: alice@example.com\r\nTo: bob@example.net, carol@example.net\r\nSubject: Hello\r\n\r\nHi there.\r\n"
+
+	b, err := BuildBounce([]byte(original), "mailbox unavailable", "MAILER-DAEMON@mx.example.net", "alice@example.com")
+	if err != nil {
+		t.Fatalf("BuildBounce error: %v", err)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if got := msg.Header.Get("To"); got != "alice@example.com" {
+		t.Errorf("To = %q", got)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("ParseMediaType: %v", err)
+	}
+	if mediaType != "multipart/report" || params["report-type"] != "delivery-status" {
+		t.Fatalf("Content-Type = %q %v", mediaType, params)
+	}
+
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	wantTypes := []string{"text/plain", "message/delivery-status", "message/rfc822"}
+	var bodies []string
+	for i, want := range wantTypes {
+		p, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("part %d: %v", i, err)
+		}
+		if ct, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type")); ct != want {
+			t.Errorf("part %d Content-Type = %q, want %q", i, ct, want)
+		}
+		b, _ := io.ReadAll(p)
+		bodies = append(bodies, string(b))
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("expected exactly 3 parts, got err=%v", err)
+	}
+
+	if !strings.Contains(bodies[0], "mailbox unavailable") {
+		t.Errorf("human-readable part missing reason: %q", bodies[0])
+	}
+	for _, want := range []string{
+		"Reporting-MTA: dns; mx.example.net",
+		"Final-Recipient: rfc822; bob@example.net",
+		"Final-Recipient: rfc822; carol@example.net",
+		"Action: failed",
+		"Status: 5.0.0",
+	} {
+		if !strings.Contains(bodies[1], want) {
+			t.Errorf("delivery-status part missing %q: %q", want, bodies[1])
+		}
+	}
+
+	inner, err := mail.ReadMessage(strings.NewReader(bodies[2]))
+	if err != nil {
+		t.Fatalf("original part does not parse: %v", err)
+	}
+	if inner.Header.Get("Subject") != "Hello" {
+		t.Errorf("original Subject = %q", inner.Header.Get("Subject"))
+	}
+}
+
+func TestBuildBounce_InvalidOriginal(t *testing.T) {
+	if _, err := BuildBounce([]byte("not a message"), "x", "a@example.com", "b@example.com"); err == nil {
+		t.Errorf("expected error for unparsable original")
+	}
+}
@@ -0,0 +1,43 @@
+package pigeon
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// maxBoundaryLength is the longest EmailConfig.Boundary allowed: RFC 2046
+// allows 70 characters, less the "alt_" prefix of the nested
+// multipart/alternative boundary.
+const maxBoundaryLength = 70 - len("alt_")
+
+// boundaryChars are the characters RFC 2046, section 5.1.1, allows in a
+// boundary that need no quoting in the Content-Type boundary parameter.
+const boundaryChars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz'+_-."
+
+// newBoundary returns a random multipart boundary. The prefix identifies
+// pigeon, and the 128 random bits keep concurrent messages from sharing a
+// boundary and make it unpredictable to the data in the body.
+func newBoundary() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return "pigeon_" + hex.EncodeToString(b[:])
+}
+
+// boundary returns c.Boundary, or a new random boundary if it is unset.
+func (c *EmailConfig) boundary() (string, error) {
+	b := c.Boundary
+	if b == "" {
+		return newBoundary(), nil
+	}
+	if len(b) > maxBoundaryLength {
+		return "", fmt.Errorf("invalid boundary %q: must be at most %d characters", b, maxBoundaryLength)
+	}
+	for _, r := range b {
+		if !strings.ContainsRune(boundaryChars, r) {
+			return "", fmt.Errorf("invalid boundary %q: character %q is not allowed", b, r)
+		}
+	}
+	return b, nil
+}
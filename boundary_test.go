@@ -0,0 +1,82 @@
+package pigeon
+
+import (
+	"bytes"
+	"mime"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// rfc2046Boundary matches the boundaries allowed by RFC 2046, section 5.1.1.
+var rfc2046Boundary = regexp.MustCompile(`^[0-9A-Za-z'()+_,\-./:=? ]{0,69}[0-9A-Za-z'()+_,\-./:=?]$`)
+
+func TestBuildMessage_RandomBoundary(t *testing.T) {
+	att := filepath.Join(t.TempDir(), "a.txt")
+	if err := os.WriteFile(att, []byte("attached"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := EmailConfig{
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Boundary\n\nBody."),
+		Attachments:  []string{att},
+	}
+	boundaryOf := func(raw []byte) string {
+		msg, err := mail.ReadMessage(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatalf("ReadMessage: %v", err)
+		}
+		_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+		if err != nil {
+			t.Fatalf("ParseMediaType: %v", err)
+		}
+		return params["boundary"]
+	}
+
+	var (
+		wg   sync.WaitGroup
+		msgs [2][]byte
+		errs [2]error
+	)
+	for i := range msgs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			msgs[i], errs[i] = BuildMessage(cfg, nil)
+		}()
+	}
+	wg.Wait()
+	var boundaries []string
+	for i, raw := range msgs {
+		if errs[i] != nil {
+			t.Fatalf("BuildMessage: %v", errs[i])
+		}
+		b := boundaryOf(raw)
+		if !strings.HasPrefix(b, "pigeon_") || !rfc2046Boundary.MatchString(b) {
+			t.Errorf("boundary %q is not a valid pigeon_ boundary", b)
+		}
+		boundaries = append(boundaries, b)
+	}
+	if boundaries[0] == boundaries[1] {
+		t.Errorf("concurrent messages share boundary %q", boundaries[0])
+	}
+
+	cfg.Boundary = "fixed-boundary"
+	raw, err := BuildMessage(cfg, nil)
+	if err != nil {
+		t.Fatalf("BuildMessage with Boundary: %v", err)
+	}
+	if got := boundaryOf(raw); got != cfg.Boundary {
+		t.Errorf("boundary = %q, want %q", got, cfg.Boundary)
+	}
+
+	for _, b := range []string{"has space", "semi;colon", strings.Repeat("x", maxBoundaryLength+1)} {
+		cfg.Boundary = b
+		if _, err := BuildMessage(cfg, nil); err == nil {
+			t.Errorf("BuildMessage with Boundary %q: want error", b)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package pigeon
+
+import (
+	"bufio"
+	"net"
+)
+
+// bufferedConn is a net.Conn that reads and writes the network through
+// buffers of a fixed size. Reads and writes never move more than that many
+// bytes at once, so a tiny size splits them as much as possible, and a large
+// one needs fewer system calls than the 4096-byte buffers of net/smtp.
+//
+// Writes are kept in the buffer until the next read or Close, since an SMTP
+// client always finishes sending a command, or a pipelined group of them,
+// before it waits for the reply. A TLS layer added by STARTTLS reads and
+// writes through the same buffers.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// bufferConn returns conn with read and write buffers of cfg.BufferSize
+// bytes, or conn itself if BufferSize is not set. It is applied before the
+// SMTP client is created, so that the client reads the greeting through it.
+func bufferConn(conn net.Conn, cfg EmailConfig) net.Conn {
+	if cfg.BufferSize <= 0 {
+		return conn
+	}
+	return &bufferedConn{
+		Conn: conn,
+		r:    bufio.NewReaderSize(conn, cfg.BufferSize),
+		w:    bufio.NewWriterSize(conn, cfg.BufferSize),
+	}
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	if err := c.w.Flush(); err != nil {
+		return 0, err
+	}
+	if len(p) > c.r.Size() {
+		p = p[:c.r.Size()]
+	}
+	return c.r.Read(p)
+}
+
+func (c *bufferedConn) Write(p []byte) (n int, err error) {
+	// bufio.Writer writes a large p directly; pass it through the buffer
+	// in pieces instead.
+	for len(p) > 0 {
+		if c.w.Available() == 0 {
+			if err := c.w.Flush(); err != nil {
+				return n, err
+			}
+		}
+		m, err := c.w.Write(p[:min(len(p), c.w.Available())])
+		n += m
+		if err != nil {
+			return n, err
+		}
+		p = p[m:]
+	}
+	return n, nil
+}
+
+func (c *bufferedConn) Close() error {
+	c.w.Flush()
+	return c.Conn.Close()
+}
+
+// NetConn returns the wrapped connection.
+func (c *bufferedConn) NetConn() net.Conn {
+	return c.Conn
+}
@@ -0,0 +1,193 @@
+package pigeon
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSend_TinyBufferSize(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789\r\n.\r\n"), 1000)
+	for b := 0; b < 256; b++ {
+		payload = append(payload, byte(b))
+	}
+	attPath := filepath.Join(t.TempDir(), "blob.bin")
+	if err := os.WriteFile(attPath, payload, 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	serverTLS, clientTLS := testTLSConfigs(t)
+
+	tests := []struct {
+		name       string
+		extensions []string
+		serverTLS  *tls.Config
+	}{
+		{name: "data", extensions: []string{"8BITMIME", "SIZE 10240000"}},
+		{name: "bdat", extensions: []string{"8BITMIME", "CHUNKING", "BINARYMIME"}},
+		{name: "starttls", extensions: []string{"8BITMIME", "CHUNKING", "BINARYMIME"}, serverTLS: serverTLS},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &mockSMTP{
+				Banner:     "220 " + strings.Repeat("x", 100) + " ESMTP ready",
+				Extensions: tt.extensions,
+				TLSConfig:  tt.serverTLS,
+				KeepCRLF:   true,
+			}
+			m.start(t)
+			nc, err := net.Dial("tcp", m.addr)
+			if err != nil {
+				t.Fatalf("Dial: %v", err)
+			}
+			conn := &recordingConn{Conn: nc}
+
+			body := strings.Repeat("a fairly long line of body text\n", 100)
+			cfg := EmailConfig{
+				TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Tiny buffers\n\n"+body),
+				Attachments:  []string{attPath},
+				BinaryMIME:   true,
+				TLSConfig:    clientTLS,
+				BufferSize:   16,
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if _, err := SendOverConn(ctx, cfg, nil, conn); err != nil {
+				t.Fatalf("SendOverConn: %v", err)
+			}
+
+			// Every read and write on the network goes through the 16-byte
+			// buffers, so the replies and the message are split across
+			// many of them.
+			reads, writes := conn.sizes()
+			if n := slices.Max(reads); n > 16 {
+				t.Errorf("read of %d bytes, want at most 16", n)
+			}
+			if n := slices.Max(writes); n > 16 {
+				t.Errorf("write of %d bytes, want at most 16", n)
+			}
+			if len(reads) < 10 || len(writes) < len(payload)/16 {
+				t.Errorf("got %d reads and %d writes, want the data split across many", len(reads), len(writes))
+			}
+
+			msg, err := mail.ReadMessage(strings.NewReader(<-m.received))
+			if err != nil {
+				t.Fatalf("ReadMessage: %v", err)
+			}
+			_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+			if err != nil {
+				t.Fatalf("ParseMediaType: %v", err)
+			}
+			mr := multipart.NewReader(msg.Body, params["boundary"])
+			for i, want := range []string{body, string(payload)} {
+				p, err := mr.NextPart()
+				if err != nil {
+					t.Fatalf("NextPart: %v", err)
+				}
+				var r io.Reader = p
+				if p.Header.Get("Content-Transfer-Encoding") == "base64" {
+					r = base64.NewDecoder(base64.StdEncoding, p)
+				}
+				got, err := io.ReadAll(r)
+				if err != nil {
+					t.Fatalf("read part: %v", err)
+				}
+				if i == 0 {
+					// The text body's line endings depend on the transfer path.
+					got = bytes.ReplaceAll(got, []byte("\r\n"), []byte("\n"))
+				}
+				if string(got) != want {
+					t.Errorf("part %q: got %d bytes, want %d", p.Header.Get("Content-Type"), len(got), len(want))
+				}
+			}
+		})
+	}
+}
+
+// recordingConn records the size of each read and write on it.
+type recordingConn struct {
+	net.Conn
+	mu            sync.Mutex
+	reads, writes []int
+}
+
+func (c *recordingConn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	c.reads = append(c.reads, len(p))
+	c.mu.Unlock()
+	return c.Conn.Read(p)
+}
+
+func (c *recordingConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	c.writes = append(c.writes, len(p))
+	c.mu.Unlock()
+	return c.Conn.Write(p)
+}
+
+// sizes returns the sizes of the reads and writes so far.
+func (c *recordingConn) sizes() (reads, writes []int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.reads), slices.Clone(c.writes)
+}
+
+func BenchmarkSend_BufferSize(b *testing.B) {
+	attPath := filepath.Join(b.TempDir(), "blob.bin")
+	if err := os.WriteFile(attPath, bytes.Repeat([]byte{0, 1, 2, 3, 0xfe, 0xff}, 1<<20/6), 0600); err != nil {
+		b.Fatalf("WriteFile: %v", err)
+	}
+	tmplPath := tplWriteTemp(b, "From: a@example.com\nTo: b@example.com\nSubject: Bench\n\nSee attached.")
+
+	for _, path := range []struct {
+		name       string
+		extensions []string
+	}{
+		{name: "data", extensions: []string{"8BITMIME"}},
+		{name: "bdat", extensions: []string{"8BITMIME", "CHUNKING", "BINARYMIME"}},
+	} {
+		for _, size := range []int{0, 64 << 10} {
+			b.Run(fmt.Sprintf("%s/%d", path.name, size), func(b *testing.B) {
+				m := &mockSMTP{Extensions: path.extensions}
+				m.start(b)
+				go func() {
+					for range m.received {
+					}
+				}()
+				cfg := EmailConfig{
+					Smarthost:    m.smarthost(),
+					TemplatePath: tmplPath,
+					Attachments:  []string{attPath},
+					BinaryMIME:   true,
+					BufferSize:   size,
+				}
+				raw, err := BuildMessage(cfg, nil)
+				if err != nil {
+					b.Fatalf("BuildMessage: %v", err)
+				}
+
+				b.SetBytes(int64(len(raw)))
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := Send(context.Background(), cfg, nil); err != nil {
+						b.Fatalf("Send: %v", err)
+					}
+				}
+			})
+		}
+	}
+}
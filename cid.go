@@ -0,0 +1,68 @@
+package pigeon
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/dotarpa/pigeon/tpl"
+)
+
+// needsContentIDDomain reports whether any of attachments has a Content-ID
+// without a domain part.
+func needsContentIDDomain(attachments []Attachment) bool {
+	for _, a := range attachments {
+		if id := a.bareContentID(); id != "" && !strings.Contains(id, "@") {
+			return true
+		}
+	}
+	return false
+}
+
+// contentIDDomain returns the domain added to Content-IDs that have none:
+// cfg.ContentIDDomain, or the domain of the From address rendered with data.
+// It returns "" if neither is known.
+func contentIDDomain(t *tpl.Template, cfg EmailConfig, data any) (string, error) {
+	if cfg.ContentIDDomain != "" {
+		return cfg.ContentIDDomain, nil
+	}
+	from, err := renderHeader(t, cfg, "From", cfg.From, data)
+	if err != nil {
+		return "", err
+	}
+	addr, err := extractAddr(from)
+	if err != nil {
+		return "", nil
+	}
+	_, domain, _ := strings.Cut(addr, "@")
+	return domain, nil
+}
+
+// qualifyContentIDs returns attachments with "@domain" added to the
+// Content-IDs that have no domain part, and html with its "cid:" references
+// to those IDs rewritten to match. It changes nothing if domain is "".
+func qualifyContentIDs(attachments []Attachment, html, domain string) ([]Attachment, string) {
+	if domain == "" || !needsContentIDDomain(attachments) {
+		return attachments, html
+	}
+	out := make([]Attachment, len(attachments))
+	for i, a := range attachments {
+		id := a.bareContentID()
+		if id != "" && !strings.Contains(id, "@") {
+			a.ContentID = id + "@" + domain
+			html = rewriteCIDRefs(html, id, a.ContentID)
+		}
+		out[i] = a
+	}
+	return out, html
+}
+
+// rewriteCIDRefs replaces the "cid:" URLs referring to from in html with
+// ones referring to to. A reference ends at a quote, whitespace, ")", ">",
+// ";" or ",", so that "cid:logo" does not match "cid:logo2".
+func rewriteCIDRefs(html, from, to string) string {
+	if html == "" {
+		return html
+	}
+	re := regexp.MustCompile(`(?i)(\bcid:)` + regexp.QuoteMeta(from) + `(["'\s)>;,]|$)`)
+	return re.ReplaceAllString(html, "${1}"+strings.ReplaceAll(to, "$", "$$")+"${2}")
+}
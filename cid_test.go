@@ -0,0 +1,67 @@
+package pigeon
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildParts_ContentIDDomain(t *testing.T) {
+	logo := filepath.Join(t.TempDir(), "logo.png")
+	if err := os.WriteFile(logo, []byte("\x89PNG"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		domain string
+		want   string
+	}{
+		{name: "from domain", want: "logo@shop.example"},
+		{name: "configured", domain: "cdn.example", want: "logo@cdn.example"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := EmailConfig{
+				TemplatePath:    tplWriteTemp(t, "From: Shop <news@shop.example>\nTo: b@example.com\nSubject: Hi\n\nHi"),
+				HTML:            `<img src="cid:logo"><img src="CID:logo2"><a href="cid:logo">logo</a>`,
+				AttachmentSpecs: []Attachment{{Path: logo, ContentID: "logo"}, {Path: logo, ContentID: "<keep@elsewhere.example>"}},
+				ContentIDDomain: tt.domain,
+			}
+			parts, err := BuildParts(cfg, nil)
+			if err != nil {
+				t.Fatalf("BuildParts: %v", err)
+			}
+			if got := parts[2].Header.Get("Content-Id"); got != "<"+tt.want+">" {
+				t.Errorf("Content-ID = %q, want <%s>", got, tt.want)
+			}
+			if got := parts[3].Header.Get("Content-Id"); got != "<keep@elsewhere.example>" {
+				t.Errorf("Content-ID with a domain = %q, want it unchanged", got)
+			}
+			html := string(parts[1].Body)
+			if want := `<img src="cid:` + tt.want + `"><img src="CID:logo2"><a href="cid:` + tt.want + `">`; !strings.HasPrefix(html, want) {
+				t.Errorf("HTML = %s, want references to %s only", html, tt.want)
+			}
+
+			raw, err := BuildMessage(cfg, nil)
+			if err != nil {
+				t.Fatalf("BuildMessage: %v", err)
+			}
+			if size, err := EstimateSize(cfg, nil); err != nil || size != int64(len(raw)) {
+				t.Errorf("EstimateSize = %d, %v; message is %d bytes", size, err, len(raw))
+			}
+
+			// Without HTML the size is estimated without building.
+			cfg.HTML = ""
+			if raw, err = BuildMessage(cfg, nil); err != nil {
+				t.Fatalf("BuildMessage: %v", err)
+			}
+			if !strings.Contains(string(raw), "Content-Id: <"+tt.want+">") {
+				t.Errorf("message without HTML lacks Content-Id <%s>", tt.want)
+			}
+			if size, err := EstimateSize(cfg, nil); err != nil || size != int64(len(raw)) {
+				t.Errorf("EstimateSize without HTML = %d, %v; message is %d bytes", size, err, len(raw))
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package pigeon
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrClientClosed is returned by a Client's methods after Shutdown.
+var ErrClientClosed = errors.New("pigeon: client closed")
+
+// Client sends messages with a fixed configuration and keeps track of the
+// sends in progress, so that a service can stop sending gracefully with
+// Shutdown. With IdleTimeout set, it also reuses its smarthost connection
+// between sends. Its methods are safe for concurrent use.
+type Client struct {
+	cfg  EmailConfig
+	pool *sessionPool // nil unless cfg.IdleTimeout is set
+
+	mu       sync.Mutex
+	closed   bool
+	inflight sync.WaitGroup
+}
+
+// NewClient returns a Client that sends with cfg.
+func NewClient(cfg EmailConfig) *Client {
+	c := &Client{cfg: cfg}
+	if cfg.IdleTimeout > 0 {
+		c.pool = &sessionPool{timeout: cfg.IdleTimeout}
+	}
+	return c
+}
+
+// Send is like the package-level Send, with the client's configuration. After
+// Shutdown it returns ErrClientClosed without sending.
+func (c *Client) Send(ctx context.Context, data any) (retry bool, err error) {
+	res, err := c.SendReport(ctx, data)
+	return res.Retry, err
+}
+
+// SendReport is like the package-level SendReport, with the client's
+// configuration. After Shutdown it returns ErrClientClosed without sending.
+func (c *Client) SendReport(ctx context.Context, data any) (Result, error) {
+	if !c.begin() {
+		return Result{}, ErrClientClosed
+	}
+	defer c.inflight.Done()
+	return sendReport(ctx, c.cfg, data, c.pool)
+}
+
+// begin registers a send in progress, unless the client is shut down.
+func (c *Client) begin() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return false
+	}
+	c.inflight.Add(1)
+	return true
+}
+
+// Shutdown stops the client from accepting new sends and waits for those in
+// progress to finish, like http.Server.Shutdown. If ctx is done first, it
+// returns ctx's error; the sends are not interrupted, and their own contexts
+// still apply. Sends started after Shutdown fail with ErrClientClosed. An
+// idle connection kept for reuse is closed, and so are those of the sends in
+// progress once they finish.
+func (c *Client) Shutdown(ctx context.Context) error {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	if c.pool != nil {
+		c.pool.close()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		c.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,80 @@
+package pigeon
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestClient_Shutdown(t *testing.T) {
+	m := &mockSMTP{}
+	m.start(t)
+
+	// The rewriter holds each send in flight until release is closed.
+	entered, release := make(chan struct{}), make(chan struct{})
+	cfg := EmailConfig{
+		Smarthost:    m.smarthost(),
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Drain\n\nBody."),
+		MessageRewriter: func(msg []byte) ([]byte, error) {
+			entered <- struct{}{}
+			<-release
+			return msg, nil
+		},
+	}
+	c := NewClient(cfg)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	const n = 3
+	errs := make(chan error, n)
+	for range n {
+		go func() {
+			_, err := c.Send(ctx, nil)
+			errs <- err
+		}()
+	}
+	for range n {
+		<-entered
+	}
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- c.Shutdown(ctx) }()
+
+	// Wait until the shutdown is under way before sending again.
+	for {
+		c.mu.Lock()
+		closed := c.closed
+		c.mu.Unlock()
+		if closed {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := c.Send(ctx, nil); !errors.Is(err, ErrClientClosed) {
+		t.Errorf("Send after Shutdown = %v, want ErrClientClosed", err)
+	}
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned %v with sends in flight", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// A Shutdown whose context ends first reports it.
+	expired, cancelExpired := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelExpired()
+	if err := c.Shutdown(expired); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown with expired context = %v, want DeadlineExceeded", err)
+	}
+
+	close(release)
+	for range n {
+		if err := <-errs; err != nil {
+			t.Errorf("in-flight Send: %v", err)
+		}
+		<-m.received
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("Shutdown = %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+// Command pigeon sends a message described by a pigeon configuration file
+// and template.
+//
+// Usage:
+//
+//	pigeon -config config.yml [-template mail.tmpl] [-data data.json] [-D key=value ...] [-dry-run]
+//
+// The template data is read from a JSON or YAML file given with -data, and
+// each -D flag sets a field on top of it, e.g. -D Name=Bob for {{ .Name }}.
+// With -dry-run the message is written to standard output instead of being
+// sent. The exit status is 0 on success, 75 (EX_TEMPFAIL) if sending failed
+// but may be retried, 2 for usage errors and 1 otherwise.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/dotarpa/pigeon"
+)
+
+// exitTempFail is the sysexits.h status for a temporary failure.
+const exitTempFail = 75
+
+// options are the parsed command-line flags.
+type options struct {
+	configPath   string
+	templatePath string
+	dataPath     string
+	data         dataFlag
+	dryRun       bool
+}
+
+// dataFlag collects repeated -D key=value flags into template data.
+type dataFlag map[string]any
+
+func (d dataFlag) String() string {
+	pairs := make([]string, 0, len(d))
+	for k, v := range d {
+		pairs = append(pairs, fmt.Sprintf("%s=%v", k, v))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (d dataFlag) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("%q is not of the form key=value", s)
+	}
+	d[k] = v
+	return nil
+}
+
+// parseFlags parses the command-line arguments, writing usage errors to stderr.
+func parseFlags(args []string, stderr io.Writer) (*options, error) {
+	opts := &options{data: dataFlag{}}
+	fs := flag.NewFlagSet("pigeon", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&opts.configPath, "config", "", "path to the YAML configuration `file` (required)")
+	fs.StringVar(&opts.templatePath, "template", "", "path to the message template `file`; overrides template_path")
+	fs.StringVar(&opts.dataPath, "data", "", "path to a JSON or YAML `file` with template data")
+	fs.Var(opts.data, "D", "set template data `key=value` (repeatable)")
+	fs.BoolVar(&opts.dryRun, "dry-run", false, "print the message instead of sending it")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	if opts.configPath == "" {
+		return nil, errors.New("-config must be specified")
+	}
+	return opts, nil
+}
+
+// run executes the command and returns its exit status.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	opts, err := parseFlags(args, stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "pigeon: %v\n", err)
+		return 2
+	}
+
+	cfg, err := pigeon.LoadFile(opts.configPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "pigeon: failed to load config: %v\n", err)
+		return 1
+	}
+	if opts.templatePath != "" {
+		cfg.TemplatePath = opts.templatePath
+	}
+
+	data := map[string]any{}
+	if opts.dataPath != "" {
+		if data, err = pigeon.LoadData(opts.dataPath); err != nil {
+			fmt.Fprintf(stderr, "pigeon: %v\n", err)
+			return 1
+		}
+	}
+	for k, v := range opts.data {
+		data[k] = v
+	}
+
+	if opts.dryRun {
+		if err := pigeon.WriteEML(stdout, *cfg, data); err != nil {
+			fmt.Fprintf(stderr, "pigeon: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	retry, err := pigeon.Send(ctx, *cfg, data)
+	if err != nil {
+		fmt.Fprintf(stderr, "pigeon: %v\n", err)
+		if retry {
+			return exitTempFail
+		}
+		return 1
+	}
+	return 0
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	code := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    *options
+		wantErr string
+	}{
+		{
+			name: "all flags",
+			args: []string{"-config", "c.yml", "-template", "m.tmpl", "-data", "d.json", "-D", "Name=Bob", "-D", "Expr=a=b", "-dry-run"},
+			want: &options{configPath: "c.yml", templatePath: "m.tmpl", dataPath: "d.json", data: dataFlag{"Name": "Bob", "Expr": "a=b"}, dryRun: true},
+		},
+		{
+			name: "config only",
+			args: []string{"-config", "c.yml"},
+			want: &options{configPath: "c.yml", data: dataFlag{}},
+		},
+		{name: "missing config", args: []string{"-dry-run"}, wantErr: "-config must be specified"},
+		{name: "bad data", args: []string{"-config", "c.yml", "-D", "Name"}, wantErr: "not of the form key=value"},
+		{name: "positional", args: []string{"-config", "c.yml", "extra"}, wantErr: "unexpected arguments: extra"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stderr bytes.Buffer
+			got, err := parseFlags(tt.args, &stderr)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseFlags err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseFlags: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseFlags = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRun_DryRun(t *testing.T) {
+	dir := t.TempDir()
+	tmpl := filepath.Join(dir, "mail.tmpl")
+	if err := os.WriteFile(tmpl, []byte("Subject: Hello {{ .Name }}\n\nHi {{ .Name }}, your order {{ .Order }} has shipped."), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config := filepath.Join(dir, "config.yml")
+	if err := os.WriteFile(config, []byte("from: shop@example.com\nto: bob@example.com\nsmarthost: smtp.example.com:25\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	data := filepath.Join(dir, "data.json")
+	if err := os.WriteFile(data, []byte(`{"Name": "Alice", "Order": 1234}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), []string{"-config", config, "-template", tmpl, "-data", data, "-D", "Name=Bob", "-dry-run"}, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("run = %d, stderr:\n%s", code, stderr.String())
+	}
+	out := stdout.String()
+	for _, want := range []string{"From: shop@example.com\r\n", "To: bob@example.com\r\n", "Subject: Hello Bob\r\n", "\r\n\r\nHi Bob, your order 1234 has shipped."} {
+		if !strings.Contains(out, want) {
+			t.Errorf("dry-run output missing %q:\n%s", want, out)
+		}
+	}
+
+	stdout.Reset()
+	stderr.Reset()
+	if code := run(context.Background(), []string{"-template", tmpl}, &stdout, &stderr); code != 2 {
+		t.Errorf("run without -config = %d, want 2", code)
+	}
+	if code := run(context.Background(), []string{"-config", filepath.Join(dir, "missing.yml"), "-dry-run"}, &stdout, &stderr); code != 1 {
+		t.Errorf("run with missing config = %d, want 1", code)
+	}
+}
@@ -16,10 +16,17 @@
 package pigeon
 
 import (
+	"crypto/tls"
 	"fmt"
+	"io"
+	"log/slog"
 	"net"
+	"net/http"
 	"os"
+	"text/template"
+	"time"
 
+	"github.com/dotarpa/pigeon/tpl"
 	"gopkg.in/yaml.v3"
 )
 
@@ -56,6 +63,13 @@ func (s *Secret) UnmarshalYAML(unmarshal func(interface{}) error) error {
 
 // HostPort represents an SMTP smarthost as "host:port".
 // Used for the Smarthost field in EmailConfig.
+//
+// A smarthost may be a template rendered with the message data, e.g.
+// "{{ .Region }}-smtp.example.com:587". When loaded from YAML, a template is
+// kept whole in Host and split into host and port after rendering.
+//
+// A smarthost of the form "srv:<name>", e.g. "srv:_submission._tcp.example.com",
+// is kept whole in Host and resolved through DNS SRV records when sending.
 type HostPort struct {
 	Host string
 	Port string
@@ -76,6 +90,11 @@ func (hp *HostPort) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		hp.Host, hp.Port = "", ""
 		return nil
 	}
+	if isTemplate(raw) || isSRVName(raw) {
+		// Split and validated once rendered or resolved.
+		hp.Host, hp.Port = raw, ""
+		return nil
+	}
 
 	hp.Host, hp.Port, err = net.SplitHostPort(raw)
 	if err != nil {
@@ -98,6 +117,9 @@ func (hp HostPort) String() string {
 	if hp.Host == "" && hp.Port == "" {
 		return ""
 	}
+	if hp.Port == "" && (isTemplate(hp.Host) || isSRVName(hp.Host)) {
+		return hp.Host
+	}
 	return fmt.Sprintf("%s:%s", hp.Host, hp.Port)
 }
 
@@ -106,35 +128,383 @@ func (hp HostPort) String() string {
 type EmailConfig struct {
 	// From specifies the sender's email address.
 	From string `yaml:"from,omitempty" json:"from,omitempty"`
+	// Sender specifies the mailbox responsible for sending the message,
+	// which is required when From lists more than one address. It does not
+	// change the envelope sender; see EnvelopeFrom (optional).
+	Sender string `yaml:"sender,omitempty" json:"sender,omitempty"`
+	// EnvelopeFrom overrides the envelope sender given in MAIL FROM, which
+	// becomes the Return-Path; defaults to the Sender or From address
+	// (optional).
+	EnvelopeFrom string `yaml:"envelope_from,omitempty" json:"envelope_from,omitempty"`
+	// AlignEnvelopeFrom uses the From address as the envelope sender, so
+	// that SPF aligns with the From domain for DMARC (optional).
+	AlignEnvelopeFrom bool `yaml:"align_envelope_from,omitempty" json:"align_envelope_from,omitempty"`
+	// StrictAlignment rejects messages whose envelope sender domain is not
+	// aligned with the From domain, instead of logging a warning (optional).
+	StrictAlignment bool `yaml:"strict_alignment,omitempty" json:"strict_alignment,omitempty"`
 	// To specifies the primary recipients' addresses (comma-separated).
 	To string `yaml:"to,omitempty" json:"to,omitempty"`
 	// Cc specifies the CC recipients' addresses (comma-separated).
 	Cc string `yaml:"cc,omitempty" json:"cc,omitempty"`
 	// Bcc specifies the BCC recipients' addresses (comma-separated).
 	Bcc string `yaml:"bcc,omitempty" json:"bcc,omitempty"`
+	// UndisclosedRecipients is the To header of a message that has only Cc
+	// or Bcc recipients; it defaults to the empty group
+	// "undisclosed-recipients:;" (optional).
+	UndisclosedRecipients string `yaml:"undisclosed_recipients,omitempty" json:"undisclosed_recipients,omitempty"`
+	// ArchiveBcc lists addresses (comma-separated) that receive a copy of
+	// every message, e.g. for journaling. Unlike Bcc, it is not a template
+	// and only ever appears in the envelope, never in a header (optional).
+	ArchiveBcc string `yaml:"archive_bcc,omitempty" json:"archive_bcc,omitempty"`
+	// ReplyTo specifies the addresses replies should go to (optional).
+	ReplyTo string `yaml:"reply_to,omitempty" json:"reply_to,omitempty"`
 	// Hello specifies the value for the SMTP HELO/EHLO command.
 	Hello string `yaml:"hello,omitempty" json:"hello,omitempty"`
+	// Boundary, if set, is used as the multipart boundary instead of a
+	// random one, e.g. to produce reproducible messages in tests. It must
+	// not occur in the body (optional).
+	Boundary string `yaml:"boundary,omitempty" json:"boundary,omitempty"`
+	// AddReceivedHeader prepends a Received trace header recording how the
+	// message reached this host, from XClient's client if set, for when
+	// pigeon is part of a relay chain. The hop to the SMTP server is left to
+	// that server's own Received header (optional).
+	AddReceivedHeader bool `yaml:"add_received_header,omitempty" json:"add_received_header,omitempty"`
 	// Smarthost specifies the SMTP relay host as "host:port".
 	Smarthost HostPort `yaml:"smarthost,omitempty" json:"smarthost,omitempty"` // host:port
+	// DirectMX delivers straight to each recipient domain's MX hosts when
+	// Smarthost has no host. A port given in Smarthost (e.g. ":2525") replaces
+	// the default port 25. If only some domains fail, Send returns a
+	// *PartialDeliveryError listing their recipients.
+	DirectMX bool `yaml:"direct_mx,omitempty" json:"direct_mx,omitempty"`
+	// LocalAddr specifies the local IP address (or "ip:port") to send from (optional).
+	LocalAddr string `yaml:"local_addr,omitempty" json:"local_addr,omitempty"`
+	// DialFallbackTimeout limits each connection attempt when the smarthost
+	// resolves to several addresses, so that an unreachable address (e.g. a
+	// broken IPv6 route) quickly falls back to the next one (optional).
+	DialFallbackTimeout time.Duration `yaml:"dial_fallback_timeout,omitempty" json:"dial_fallback_timeout,omitempty"`
+	// TCPKeepAlive is the interval between TCP keep-alive probes on SMTP
+	// connections, so that a dead peer is noticed during long transfers. 0
+	// uses Go's default of 15 seconds and a negative value disables
+	// keep-alives (optional).
+	TCPKeepAlive time.Duration `yaml:"tcp_keepalive,omitempty" json:"tcp_keepalive,omitempty"`
+	// IdleTimeout makes a Client keep its smarthost connection open between
+	// sends and reuse it, ending it with QUIT once it has been idle this
+	// long, before the server times it out. 0 closes the connection after
+	// each send, as Send does (optional).
+	IdleTimeout time.Duration `yaml:"idle_timeout,omitempty" json:"idle_timeout,omitempty"`
+	// GreetingTimeout limits the wait for the server's 220 greeting after
+	// connecting; defaults to 5 minutes (optional).
+	GreetingTimeout time.Duration `yaml:"greeting_timeout,omitempty" json:"greeting_timeout,omitempty"`
+	// BufferSize sets the size in bytes of the read and write buffers of SMTP
+	// connections, also under TLS; defaults to the 4096 bytes of net/smtp.
+	// Larger buffers mean fewer system calls when sending large messages with
+	// BDAT or DATA (optional).
+	BufferSize int `yaml:"buffer_size,omitempty" json:"buffer_size,omitempty"`
+	// MaxReplyLineBytes limits the length of a line of a server reply, so
+	// that a broken or hostile server cannot make pigeon buffer an endless
+	// line; a longer line fails with ErrReplyTooLong. Defaults to 64 KiB
+	// (optional).
+	MaxReplyLineBytes int `yaml:"max_reply_line_bytes,omitempty" json:"max_reply_line_bytes,omitempty"`
+	// AddressParser, if set, is given each address that net/mail cannot
+	// parse, e.g. "user at example dot com" from a legacy system, and
+	// returns the bare address to use in the envelope (optional).
+	AddressParser AddressParser `yaml:"-" json:"-"`
+	// Resolver is used to look up smarthost, MX and SRV records; defaults to net.DefaultResolver.
+	Resolver Resolver `yaml:"-" json:"-"`
 	// AuthUsername specifies the username for SMTP authentication (if needed).
 	AuthUsername string `yaml:"auth_username,omitempty" json:"auth_username,omitempty"`
 	// AuthPassword specifies the password for SMTP authentication (if needed).
 	AuthPassword Secret `yaml:"auth_password,omitempty" json:"auth_password,omitempty"`
-	// Headers allows custom headers to be set in the message.
+	// InReplyTo is the Message-ID of the message being replied to, in angle
+	// brackets (e.g. "<1234@example.com>"), sent as In-Reply-To (optional).
+	InReplyTo string `yaml:"in_reply_to,omitempty" json:"in_reply_to,omitempty"`
+	// References lists the Message-IDs of the thread, oldest first and each in
+	// angle brackets, sent as References (optional).
+	References []string `yaml:"references,omitempty" json:"references,omitempty"`
+	// Headers allows custom headers to be set in the message. Values with
+	// template actions are rendered with the message data, e.g.
+	// "X-Tracking-ID: {{ .TrackID }}"; other values are sent as written.
+	// Headers that render blank are omitted.
 	Headers map[string]string `yaml:"headers,omitempty" json:"headers,omitempty"`
-	// RequireTLS forces the use of TLS when connecting to the SMTP server (optional).
+	// XMailer is the X-Mailer header value identifying the sending software.
+	// When unset it is "pigeon/" followed by Version; an empty string leaves
+	// the header out (optional).
+	XMailer *string `yaml:"x_mailer,omitempty" json:"x_mailer,omitempty"`
+	// RequireTLS controls the use of TLS (optional). When unset, STARTTLS is
+	// used if the server offers it, without verifying the server certificate
+	// unless TLSConfig or TLSRootCAFile is set. When true, STARTTLS is
+	// mandatory, the certificate is verified and, if
+	// the server supports REQUIRETLS (RFC 8689), the message is marked so that
+	// every later hop must use TLS too. When explicitly false, the message
+	// carries a "TLS-Required: No" header asking receivers to deliver it even
+	// if their TLS policy (MTA-STS, DANE) cannot be met.
 	RequireTLS *bool `yaml:"require_tls,omitempty" json:"require_tls,omitempty"`
+	// TLSConfig is used for STARTTLS; ServerName defaults to the server host.
+	// Setting it turns on certificate verification (optional).
+	TLSConfig *tls.Config `yaml:"-" json:"-"`
+	// TLSClientCertFile and TLSClientKeyFile are PEM files holding a client
+	// certificate and its private key, presented to servers that require
+	// mutual TLS (optional).
+	TLSClientCertFile string `yaml:"tls_client_cert_file,omitempty" json:"tls_client_cert_file,omitempty"`
+	TLSClientKeyFile  string `yaml:"tls_client_key_file,omitempty" json:"tls_client_key_file,omitempty"`
+	// TLSRootCAFile is a PEM bundle of CA certificates trusted for the
+	// server's certificate in addition to the system roots, replacing
+	// TLSConfig.RootCAs. Setting it turns on certificate verification
+	// (optional).
+	TLSRootCAFile string `yaml:"tls_root_ca_file,omitempty" json:"tls_root_ca_file,omitempty"`
+	// TLSRootCAOnly trusts only the certificates in TLSRootCAFile, ignoring
+	// the system roots (optional).
+	TLSRootCAOnly bool `yaml:"tls_root_ca_only,omitempty" json:"tls_root_ca_only,omitempty"`
+	// BinaryMIME sends attachments unencoded, with "Content-Transfer-Encoding:
+	// binary", to servers that advertise both CHUNKING and BINARYMIME
+	// (RFC 3030). Other servers, and all servers when DKIM is set, since the
+	// signature covers the encoded body, receive the usual base64 encoding
+	// (optional).
+	BinaryMIME bool `yaml:"binary_mime,omitempty" json:"binary_mime,omitempty"`
 	// Text can be used to directly set the plain text body (optional).
 	Text string `yaml:"text,omitempty" json:"text,omitempty"`
-	// HTML can be used to directly set the HTML body (optional, for future use).
+	// HTML is an html/template rendered with the message data as an HTML
+	// version of the body, sent alongside the template's text body as
+	// multipart/alternative (optional).
 	HTML string `yaml:"html,omitempty" json:"html,omitempty"`
+	// SanitizeHTML passes the rendered HTML body through an allow-list
+	// sanitizer that removes scripts, styles and other unsafe markup, e.g.
+	// for user-generated content (optional).
+	SanitizeHTML bool `yaml:"sanitize_html,omitempty" json:"sanitize_html,omitempty"`
+	// HTMLTransformer, if set, is given the rendered HTML body, after
+	// SanitizeHTML, and returns the HTML to send, e.g. with an open-tracking
+	// pixel added or links rewritten for click tracking. The text body is
+	// not passed through it. An error fails the send (optional).
+	HTMLTransformer HTMLTransformer `yaml:"-" json:"-"`
+	// TextContentTypeParams adds parameters to the Content-Type of the
+	// plain-text body, e.g. {"format": "flowed", "delsp": "yes"}. The charset
+	// defaults to UTF-8 (optional).
+	TextContentTypeParams map[string]string `yaml:"text_content_type_params,omitempty" json:"text_content_type_params,omitempty"`
+	// AllowEmptyBody permits sending a message whose body renders blank and
+	// that has no HTML body or attachments, which otherwise fails with
+	// ErrEmptyBody (optional).
+	AllowEmptyBody bool `yaml:"allow_empty_body,omitempty" json:"allow_empty_body,omitempty"`
+	// QPLineLength is the column at which quoted-printable text and
+	// attachments are soft-wrapped, and beyond which a line of ASCII text is
+	// quoted-printable encoded; at most and by default 76 (optional).
+	QPLineLength int `yaml:"qp_line_length,omitempty" json:"qp_line_length,omitempty"`
+	// Base64LineLength is the length of the base64-encoded lines of
+	// attachments, e.g. 64 for legacy systems; a multiple of 4, at most and
+	// by default 76 (optional).
+	Base64LineLength int `yaml:"base64_line_length,omitempty" json:"base64_line_length,omitempty"`
+	// ContentTransferEncoding sets the top-level Content-Transfer-Encoding.
+	// A plain-text message is encoded with it: "7bit", "8bit",
+	// "quoted-printable" or "base64". A multipart message may only be
+	// labeled "7bit", "8bit" or "binary". By default the encoding is chosen
+	// from the text (optional).
+	ContentTransferEncoding string `yaml:"content_transfer_encoding,omitempty" json:"content_transfer_encoding,omitempty"`
+	// MIMEVersion replaces the MIME-Version header value, which defaults to
+	// "1.0" (optional).
+	MIMEVersion string `yaml:"mime_version,omitempty" json:"mime_version,omitempty"`
+	// WrapText soft-wraps lines of the plain-text body longer than the given
+	// number of columns at word boundaries, for clients that do not reflow
+	// text. Quoted and indented lines are not wrapped; 0 disables wrapping
+	// (optional).
+	WrapText int `yaml:"wrap_text,omitempty" json:"wrap_text,omitempty"`
+	// HeaderFoldWidth is the column at which long header lines are folded;
+	// defaults to 78 as recommended by RFC 5322 (optional).
+	HeaderFoldWidth int `yaml:"header_fold_width,omitempty" json:"header_fold_width,omitempty"`
 	// Timezone specifies the IANA time zone to use for the Date header (e.g., "Asia/Tokyo").
 	Timezone string `yaml:"timezone,omitempty" json:"timezone,omitempty"`
 
 	// Attachments is a list of file paths to be attached to the email.
+	// http:// and https:// URLs are fetched when the message is built.
 	Attachments []string `yaml:"attachments,omitempty" json:"attachments,omitempty"`
+	// AttachmentSpecs are attachments with options such as a description or
+	// Content-ID (see Attachment). They follow Attachments in the message
+	// (optional).
+	AttachmentSpecs []Attachment `yaml:"attachment_specs,omitempty" json:"attachment_specs,omitempty"`
+
+	// ContentIDDomain is added to attachment Content-IDs that have no
+	// domain part, e.g. "logo" becomes "<logo@example.com>", since some
+	// clients require one. Defaults to the domain of the From address.
+	ContentIDDomain string `yaml:"content_id_domain,omitempty" json:"content_id_domain,omitempty"`
+	// DedupAttachments skips attachments whose resolved path or content is
+	// the same as an earlier attachment's (optional).
+	DedupAttachments bool `yaml:"dedup_attachments,omitempty" json:"dedup_attachments,omitempty"`
+	// HTTPClient fetches URL attachments; defaults to a client with a
+	// 30 second timeout.
+	HTTPClient *http.Client `yaml:"-" json:"-"`
 	// TemplatePath specifies the file path to the email template.
 	TemplatePath string `yaml:"template_path,omitempty" json:"template_path,omitempty"`
+	// TemplateText is the whole template, headers and body, inline, for
+	// messages too simple for a file of their own. It is used when
+	// TemplatePath is empty (optional).
+	TemplateText string `yaml:"template,omitempty" json:"template,omitempty"`
+	// TemplateResolver, if set, opens TemplatePath instead of os.Open, e.g.
+	// to load templates from object storage or a database (optional).
+	TemplateResolver func(path string) (io.ReadCloser, error) `yaml:"-" json:"-"`
+	// TemplateIncludeDir is the directory the template's include function
+	// reads from; defaults to the directory of TemplatePath (optional).
+	TemplateIncludeDir string `yaml:"template_include_dir,omitempty" json:"template_include_dir,omitempty"`
+	// TemplateFuncs adds functions to, or overrides the built-in functions
+	// of, templates loaded from TemplatePath and templated config values
+	// such as From or Headers (optional).
+	TemplateFuncs template.FuncMap `yaml:"-" json:"-"`
+	// Template is an already parsed template, e.g. from tpl.ParseReader. It
+	// takes precedence over TemplatePath (optional).
+	Template *tpl.Template `yaml:"-" json:"-"`
+	// TemplateName selects the template to use from Templates; it takes
+	// precedence over TemplatePath (optional).
+	TemplateName string `yaml:"template_name,omitempty" json:"template_name,omitempty"`
+	// Templates is a named set of parsed templates, e.g. from
+	// tpl.LoadManifest, that TemplateName refers to (optional).
+	Templates map[string]*tpl.Template `yaml:"-" json:"-"`
+
+	// OutboxDir, if set, makes Send write each message as an .eml file into
+	// this directory instead of delivering it, e.g. for local development.
+	OutboxDir string `yaml:"outbox_dir,omitempty" json:"outbox_dir,omitempty"`
+	// LineEnding selects the line endings of BuildMessage, WriteEML and
+	// outbox files: "crlf" (default) or "lf". Send always transmits CRLF.
+	LineEnding string `yaml:"line_ending,omitempty" json:"line_ending,omitempty"`
+
+	// Suppression lists addresses that must not be sent to. Suppressed
+	// recipients are removed from the envelope and reported in the Result;
+	// if none is left, the send fails with ErrAllSuppressed (optional).
+	Suppression SuppressionList `yaml:"-" json:"-"`
+	// SuppressHeaders also removes suppressed recipients from the To, Cc
+	// and Bcc header fields, so that the other recipients do not see them.
+	SuppressHeaders bool `yaml:"suppress_headers,omitempty" json:"suppress_headers,omitempty"`
+
+	// RecipientValidator, if set, checks each envelope recipient before the
+	// message is sent, e.g. against a list of known mailboxes. Recipients
+	// it returns an error for are removed from the envelope and reported in
+	// the Result; if none is left, the send fails with ErrAllInvalid
+	// (optional).
+	RecipientValidator func(addr string) error `yaml:"-" json:"-"`
+	// StrictRecipientValidation fails the send when RecipientValidator
+	// rejects any recipient, instead of leaving that recipient out.
+	StrictRecipientValidation bool `yaml:"strict_recipient_validation,omitempty" json:"strict_recipient_validation,omitempty"`
+
+	// DSN requests delivery status notifications (optional).
+	DSN *DSNConfig `yaml:"dsn,omitempty" json:"dsn,omitempty"`
+
+	// PGP enables OpenPGP/MIME signing and/or encryption (optional).
+	PGP *PGPConfig `yaml:"pgp,omitempty" json:"pgp,omitempty"`
+
+	// DKIM enables DKIM signing of outgoing messages (optional).
+	DKIM *DKIMConfig `yaml:"dkim,omitempty" json:"dkim,omitempty"`
+
+	// XClient passes the original client's address and name to a trusted
+	// relay with XCLIENT (optional).
+	XClient *XClientConfig `yaml:"xclient,omitempty" json:"xclient,omitempty"`
+
+	// MessageRewriter, if set, is given the fully built message, with CRLF
+	// line endings, just before it is delivered and returns the message to
+	// send in its place. An error fails the send permanently (optional).
+	MessageRewriter func(msg []byte) ([]byte, error) `yaml:"-" json:"-"`
+
+	// RecipientRewriter, if set, is given the envelope recipients just
+	// before delivery and returns the recipients to send to instead, e.g. to
+	// redirect all mail to a catch-all address in staging. The headers are
+	// left unchanged. An error, or an empty result, fails the send
+	// permanently (optional).
+	RecipientRewriter func(rcpts []string) ([]string, error) `yaml:"-" json:"-"`
+
+	// Logger receives diagnostic messages, such as skipped duplicate
+	// attachments, and a record of each connection, delivery and failed
+	// delivery. A logger set with ContextWithLogger takes its place; sends
+	// log to slog.Default() if neither is set (optional).
+	Logger *slog.Logger `yaml:"-" json:"-"`
+
+	// Batch configures SendBatch (optional).
+	Batch BatchConfig `yaml:"batch,omitempty" json:"batch,omitempty"`
+}
+
+// BatchConfig configures how SendBatch personalizes and sends messages.
+type BatchConfig struct {
+	// PerRecipientTo sets the To header of each message to that item's
+	// recipients, replacing the To rendered from the template or config, so
+	// that recipients do not see each other. Cc is kept as rendered.
+	PerRecipientTo bool `yaml:"per_recipient_to,omitempty" json:"per_recipient_to,omitempty"`
+	// SamplePercent, if set, sends only the items whose recipient falls in
+	// a deterministic sample of that percentage (0 to 100) of addresses,
+	// e.g. 10 to try a new template on a tenth of the list first. An item's
+	// first recipient decides (optional).
+	SamplePercent float64 `yaml:"sample_percent,omitempty" json:"sample_percent,omitempty"`
+	// DripInterval, if set, is a fixed pause between consecutive
+	// deliveries, so that a large batch does not arrive at the relay in a
+	// burst. Items that are skipped or fail do not count (optional).
+	DripInterval time.Duration `yaml:"drip_interval,omitempty" json:"drip_interval,omitempty"`
+}
+
+// DKIMConfig configures DKIM signing (RFC 6376) of outgoing messages. The
+// signature is added when the message is sent, after MessageRewriter.
+type DKIMConfig struct {
+	// Domain is the signing domain (the d= tag), e.g. "example.com".
+	Domain string `yaml:"domain,omitempty" json:"domain,omitempty"`
+	// Selector is the s= tag; the public key is published in DNS as
+	// "<selector>._domainkey.<domain>".
+	Selector string `yaml:"selector,omitempty" json:"selector,omitempty"`
+	// PrivateKey is the path to a PEM-encoded RSA or Ed25519 private key.
+	PrivateKey string `yaml:"private_key,omitempty" json:"private_key,omitempty"`
+	// Canonicalization is the c= tag, e.g. "simple/simple"; defaults to
+	// "relaxed/relaxed" (optional).
+	Canonicalization string `yaml:"canonicalization,omitempty" json:"canonicalization,omitempty"`
+	// SignedHeaders lists the header fields to sign, in order; defaults to
+	// From, To, Subject, Date and Message-ID. Every instance of a field that
+	// appears more than once is signed (optional).
+	SignedHeaders []string `yaml:"signed_headers,omitempty" json:"signed_headers,omitempty"`
+	// OverSign lists each of SignedHeaders once more than it appears,
+	// including fields the message does not have, so that no such field can
+	// be added without breaking the signature (optional).
+	OverSign bool `yaml:"over_sign,omitempty" json:"over_sign,omitempty"`
+}
+
+// PGPConfig configures OpenPGP/MIME (RFC 3156) protection of outgoing messages.
+// With only a signing key the message is sent as multipart/signed; when
+// recipient keys are listed it is sent as multipart/encrypted, and also signed
+// if a signing key is present.
+type PGPConfig struct {
+	// SigningKey is the path to an ASCII-armored private key used for signing.
+	SigningKey string `yaml:"signing_key,omitempty" json:"signing_key,omitempty"`
+	// Passphrase unlocks SigningKey if it is encrypted.
+	Passphrase Secret `yaml:"passphrase,omitempty" json:"passphrase,omitempty"`
+	// RecipientKeys lists paths to ASCII-armored public keys to encrypt to.
+	RecipientKeys []string `yaml:"recipient_keys,omitempty" json:"recipient_keys,omitempty"`
+}
+
+// logDebug logs a debug message to c.Logger. Sends always set the logger,
+// from the context, Logger or slog.Default() (see contextLogger); it is nil
+// only when a message is built without sending, and then nothing is logged.
+func (c *EmailConfig) logDebug(msg string, args ...any) {
+	if c.Logger != nil {
+		c.Logger.Debug(msg, args...)
+	}
+}
+
+// logInfo logs an informational message to c.Logger, like logDebug.
+func (c *EmailConfig) logInfo(msg string, args ...any) {
+	if c.Logger != nil {
+		c.Logger.Info(msg, args...)
+	}
+}
+
+// logWarn logs a warning to c.Logger, like logDebug.
+func (c *EmailConfig) logWarn(msg string, args ...any) {
+	if c.Logger != nil {
+		c.Logger.Warn(msg, args...)
+	}
+}
+
+// logFailure logs the failure err of a delivery to c.Logger, like logDebug:
+// at warn level if it is temporary, as reported by retry, and at error level
+// otherwise.
+func (c *EmailConfig) logFailure(msg string, retry bool, err error, args ...any) {
+	if c.Logger == nil {
+		return
+	}
+	args = append(args, "retry", retry, "err", err)
+	if retry {
+		c.Logger.Warn(msg, args...)
+	} else {
+		c.Logger.Error(msg, args...)
+	}
 }
 
 // Load parses the YAML string s and returns a new EmailConfig instance.
@@ -0,0 +1,55 @@
+package pigeon
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// SendOverConn is like Send, but runs the SMTP dialogue on conn instead of
+// dialing the smarthost, e.g. to tunnel over an already established
+// connection or to test against an in-process server. STARTTLS and
+// require_tls apply as with Send; the TLS server name is the smarthost host
+// if set, otherwise the host of conn's remote address. conn is closed when
+// SendOverConn returns, and canceling ctx aborts the dialogue.
+func SendOverConn(ctx context.Context, cfg EmailConfig, data any, conn net.Conn) (retry bool, err error) {
+	defer conn.Close()
+	cfg.Logger = contextLogger(ctx, cfg.Logger)
+
+	msg := getBuffer()
+	defer putBuffer(msg)
+	res, err := buildMessage(cfg, data, msg)
+	if err != nil {
+		return false, err
+	}
+	raw, env, err := prepare(cfg, res.Envelope, msg.Bytes())
+	if err != nil {
+		return false, err
+	}
+
+	host := cfg.Smarthost.Host
+	if host == "" {
+		host = conn.RemoteAddr().String()
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+	}
+
+	// Unblock the dialogue when ctx is done. The deadline survives the
+	// greeting's own.
+	conn = &deadlineConn{Conn: conn}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+	if err := ctx.Err(); err != nil {
+		return true, err
+	}
+	retry, err = transmit(conn, host, cfg, env.From, env.Rcpts, raw)
+	if err != nil && ctx.Err() != nil {
+		retry, err = true, fmt.Errorf("%w: %w", ctx.Err(), err)
+	}
+	if err != nil {
+		cfg.logFailure("message not sent", retry, err, "from", env.From, "rcpts", len(env.Rcpts))
+	}
+	return retry, err
+}
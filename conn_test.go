@@ -0,0 +1,110 @@
+package pigeon
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSendOverConn(t *testing.T) {
+	serverTLS, clientTLS := testTLSConfigs(t)
+	yes := true
+	tests := []struct {
+		name      string
+		serverTLS bool
+	}{
+		{name: "plain"},
+		{name: "starttls", serverTLS: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &mockSMTP{}
+			cfg := EmailConfig{
+				TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Pipe\n\nHello over a pipe"),
+			}
+			if tt.serverTLS {
+				m.TLSConfig = serverTLS
+				cfg.Smarthost = HostPort{Host: "localhost"}
+				cfg.TLSConfig = clientTLS
+				cfg.RequireTLS = &yes
+			}
+			var client net.Conn
+			if tt.serverTLS {
+				// Both ends of a net.Pipe block while sending their TLS
+				// close_notify, so use TCP instead.
+				m.start(t)
+				conn, err := net.Dial("tcp", m.addr)
+				if err != nil {
+					t.Fatalf("Dial: %v", err)
+				}
+				client = conn
+			} else {
+				m.received = make(chan string, 1)
+				var server net.Conn
+				client, server = net.Pipe()
+				go m.serve(server)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if _, err := SendOverConn(ctx, cfg, nil, client); err != nil {
+				t.Fatalf("SendOverConn: %v", err)
+			}
+			if raw := <-m.received; !strings.Contains(raw, "Hello over a pipe") {
+				t.Errorf("unexpected message:\n%s", raw)
+			}
+			if got := slices.Contains(m.Commands(), "STARTTLS"); got != tt.serverTLS {
+				t.Errorf("STARTTLS sent = %v, want %v", got, tt.serverTLS)
+			}
+		})
+	}
+}
+
+func TestSendOverConn_Canceled(t *testing.T) {
+	cfg := EmailConfig{
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Pipe\n\nHello"),
+	}
+	// The server never sends a greeting.
+	client, server := net.Pipe()
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	retry, err := SendOverConn(ctx, cfg, nil, client)
+	if !errors.Is(err, context.DeadlineExceeded) || !retry {
+		t.Errorf("SendOverConn = (%v, %v), want retryable deadline error", retry, err)
+	}
+}
+
+func TestSendOverConn_Rewriters(t *testing.T) {
+	m := &mockSMTP{}
+	m.start(t)
+	conn, err := net.Dial("tcp", m.addr)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	cfg := EmailConfig{
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Pipe\n\nHello"),
+		MessageRewriter: func(msg []byte) ([]byte, error) {
+			return append([]byte("X-Rewritten: yes\r\n"), msg...), nil
+		},
+		RecipientRewriter: func(rcpts []string) ([]string, error) {
+			return []string{"sink@example.com"}, nil
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := SendOverConn(ctx, cfg, nil, conn); err != nil {
+		t.Fatalf("SendOverConn: %v", err)
+	}
+	if raw := <-m.received; !strings.HasPrefix(raw, "X-Rewritten: yes\n") {
+		t.Errorf("message not rewritten:\n%s", raw)
+	}
+	if !slices.Contains(m.Commands(), "RCPT TO:<sink@example.com>") {
+		t.Errorf("commands = %q, want the rewritten recipient", m.Commands())
+	}
+}
@@ -0,0 +1,66 @@
+package pigeon
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LoadData reads template data from a JSON (.json) or YAML (.yaml, .yml)
+// file, for use as the data argument of Send. Nested objects become nested
+// maps, so that a template can refer to e.g. {{ .Order.ID }}. Numbers keep
+// their type: integers are decoded as int64 (int for YAML) and other numbers
+// as float64.
+func LoadData(path string) (map[string]any, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make(map[string]any)
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		if err := dec.Decode(&data); err != nil {
+			return nil, fmt.Errorf("failed to parse data file %s: %w", path, err)
+		}
+		for k, v := range data {
+			data[k] = convertJSONNumbers(v)
+		}
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(b, &data); err != nil {
+			return nil, fmt.Errorf("failed to parse data file %s: %w", path, err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported data file extension %q: must be .json, .yaml or .yml", ext)
+	}
+	return data, nil
+}
+
+// convertJSONNumbers replaces the json.Numbers in v with int64 values, or
+// float64 values for numbers that are not integers.
+func convertJSONNumbers(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		for k, e := range v {
+			v[k] = convertJSONNumbers(e)
+		}
+	case []any:
+		for i, e := range v {
+			v[i] = convertJSONNumbers(e)
+		}
+	}
+	return v
+}
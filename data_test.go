@@ -0,0 +1,84 @@
+package pigeon
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadData(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{
+			name: "data.json",
+			content: `{
+  "Name": "Bob",
+  "Order": {"ID": 1234, "Total": 19.5, "Express": true, "Items": [{"SKU": "A-1", "Qty": 2}]}
+}`,
+		},
+		{
+			name: "data.yaml",
+			content: `Name: Bob
+Order:
+  ID: 1234
+  Total: 19.5
+  Express: true
+  Items:
+    - SKU: A-1
+      Qty: 2
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.name)
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			data, err := LoadData(path)
+			if err != nil {
+				t.Fatalf("LoadData: %v", err)
+			}
+
+			cfg := EmailConfig{
+				TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Order {{ .Order.ID }}\n\n"+
+					"Hi {{ .Name }}, total {{ .Order.Total }}{{ if .Order.Express }} (express){{ end }}."+
+					"{{ range .Order.Items }} {{ .SKU }}x{{ .Qty }}{{ end }}{{ if gt .Order.ID 1000 }} big{{ end }}"),
+			}
+			raw, err := BuildMessage(cfg, data)
+			if err != nil {
+				t.Fatalf("BuildMessage: %v", err)
+			}
+			msg := string(raw)
+			if !strings.Contains(msg, "Subject: Order 1234\r\n") {
+				t.Errorf("subject not rendered:\n%s", msg)
+			}
+			if want := "Hi Bob, total 19.5 (express). A-1x2 big"; !strings.HasSuffix(msg, want) {
+				t.Errorf("body does not end with %q:\n%s", want, msg)
+			}
+		})
+	}
+}
+
+func TestLoadData_Errors(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"data.txt":  "Name: Bob",
+		"bad.json":  `{"Name": }`,
+		"list.yaml": "- a\n- b\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadData(path); err == nil {
+			t.Errorf("LoadData(%s): expected error", name)
+		}
+	}
+	if _, err := LoadData(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("LoadData of a missing file: expected error")
+	}
+}
@@ -0,0 +1,177 @@
+package pigeon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Resolver looks up the addresses of SMTP hosts. *net.Resolver satisfies it;
+// tests and applications may supply their own implementation.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+}
+
+// srvResolver is a Resolver that can also look up SRV records, as needed for
+// srv: smarthosts. *net.Resolver satisfies it. It is separate from Resolver
+// so that existing implementations need not add LookupSRV.
+type srvResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// resolver returns cfg.Resolver, or net.DefaultResolver if none is set.
+func (c *EmailConfig) resolver() Resolver {
+	if c.Resolver != nil {
+		return c.Resolver
+	}
+	return net.DefaultResolver
+}
+
+// isTemplate reports whether s contains template actions.
+func isTemplate(s string) bool {
+	return strings.Contains(s, "{{")
+}
+
+// renderSmarthost renders a templated cfg.Smarthost with data and validates
+// the result. Static smarthosts are returned unchanged.
+func renderSmarthost(cfg EmailConfig, data any) (HostPort, error) {
+	raw := cfg.Smarthost.String()
+	if !isTemplate(raw) {
+		return cfg.Smarthost, nil
+	}
+
+	rendered, err := renderConfigValue(cfg, "Smarthost", raw, data)
+	if err != nil {
+		return HostPort{}, err
+	}
+	var out HostPort
+	if rendered = strings.TrimSpace(rendered); isSRVName(rendered) {
+		out.Host = rendered
+		return out, nil
+	}
+	out.Host, out.Port, err = net.SplitHostPort(rendered)
+	if err != nil {
+		return HostPort{}, fmt.Errorf("invalid smarthost %q: %w", rendered, err)
+	}
+	if out.Host == "" || out.Port == "" {
+		return HostPort{}, fmt.Errorf("invalid smarthost %q: host and port must not be empty", rendered)
+	}
+	return out, nil
+}
+
+// newDialer returns a dialer honoring the context deadline, cfg.LocalAddr and
+// cfg.TCPKeepAlive.
+func newDialer(ctx context.Context, cfg EmailConfig) (*net.Dialer, error) {
+	d := &net.Dialer{KeepAlive: cfg.TCPKeepAlive}
+	if deadline, ok := ctx.Deadline(); ok {
+		d.Deadline = deadline
+	}
+	if cfg.LocalAddr != "" {
+		laddr, err := localTCPAddr(cfg.LocalAddr)
+		if err != nil {
+			return nil, err
+		}
+		d.LocalAddr = laddr
+	}
+	return d, nil
+}
+
+// dialSmarthost connects to hostPort using d. Without a DialFallbackTimeout
+// or custom Resolver the dialer's own dual-stack (happy eyeballs) logic is
+// used. Otherwise the host is resolved and its addresses are tried one by one,
+// alternating between IPv6 and IPv4, each attempt bounded by
+// DialFallbackTimeout.
+func dialSmarthost(ctx context.Context, d *net.Dialer, cfg EmailConfig, hostPort string) (net.Conn, error) {
+	if cfg.DialFallbackTimeout <= 0 && cfg.Resolver == nil {
+		return d.DialContext(ctx, "tcp", hostPort)
+	}
+
+	host, port, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) != nil {
+		return d.DialContext(ctx, "tcp", hostPort)
+	}
+
+	addrs, err := cfg.resolver().LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no addresses found for %s", host)
+	}
+
+	var errs []error
+	for _, addr := range interleaveFamilies(addrs) {
+		attemptCtx, cancel := ctx, func() {}
+		if cfg.DialFallbackTimeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, cfg.DialFallbackTimeout)
+		}
+		conn, err := d.DialContext(attemptCtx, "tcp", net.JoinHostPort(addr.String(), port))
+		cancel()
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// interleaveFamilies reorders addrs so that address families alternate,
+// starting with the family of the first address (RFC 8305, section 4).
+func interleaveFamilies(addrs []net.IPAddr) []net.IPAddr {
+	var first, second []net.IPAddr
+	firstIs4 := addrs[0].IP.To4() != nil
+	for _, a := range addrs {
+		if (a.IP.To4() != nil) == firstIs4 {
+			first = append(first, a)
+		} else {
+			second = append(second, a)
+		}
+	}
+
+	out := make([]net.IPAddr, 0, len(addrs))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			out = append(out, first[i])
+		}
+		if i < len(second) {
+			out = append(out, second[i])
+		}
+	}
+	return out
+}
+
+// localTCPAddr parses s ("ip" or "ip:port") into a TCP address and checks
+// that the IP is assigned to one of the host's network interfaces.
+func localTCPAddr(s string) (*net.TCPAddr, error) {
+	hostPort := s
+	if _, _, err := net.SplitHostPort(s); err != nil {
+		hostPort = net.JoinHostPort(s, "0")
+	}
+	addr, err := net.ResolveTCPAddr("tcp", hostPort)
+	if err != nil {
+		return nil, fmt.Errorf("invalid local address %q: %w", s, err)
+	}
+	if addr.IP == nil || addr.IP.IsUnspecified() {
+		return addr, nil
+	}
+
+	ifaddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list interface addresses: %w", err)
+	}
+	for _, a := range ifaddrs {
+		if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.Equal(addr.IP) {
+			return addr, nil
+		}
+	}
+	return nil, fmt.Errorf("local address %q is not assigned to any interface", s)
+}
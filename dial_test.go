@@ -0,0 +1,168 @@
+package pigeon
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubResolver answers lookups from fixed tables and records the queries.
+type stubResolver struct {
+	ips     map[string][]net.IPAddr
+	mx      map[string][]*net.MX
+	srv     map[string][]*net.SRV
+	queries []string
+}
+
+func (r *stubResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	r.queries = append(r.queries, host)
+	addrs, ok := r.ips[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return addrs, nil
+}
+
+func (r *stubResolver) LookupMX(_ context.Context, name string) ([]*net.MX, error) {
+	r.queries = append(r.queries, "MX "+name)
+	mxs, ok := r.mx[name]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return mxs, nil
+}
+
+func (r *stubResolver) LookupSRV(_ context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	r.queries = append(r.queries, "SRV "+name)
+	srvs, ok := r.srv[name]
+	if !ok {
+		return "", nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return name, srvs, nil
+}
+
+func TestSend_DialFallback(t *testing.T) {
+	m := &mockSMTP{}
+	m.start(t)
+	_, port, _ := net.SplitHostPort(m.addr)
+
+	// The first address is a non-routable documentation address (RFC 5737).
+	res := &stubResolver{ips: map[string][]net.IPAddr{
+		"smtp.test": {{IP: net.ParseIP("192.0.2.1")}, {IP: net.ParseIP("127.0.0.1")}},
+	}}
+
+	tmplPath := tplWriteTemp(t, "From: sender@example.com\nTo: recv@example.com\nSub: Fallback\n\nBody.")
+	cfg := EmailConfig{
+		Smarthost:           HostPort{Host: "smtp.test", Port: port},
+		DialFallbackTimeout: 200 * time.Millisecond,
+		Resolver:            res,
+		TemplatePath:        tmplPath,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := Send(ctx, cfg, nil); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("fallback took %v", elapsed)
+	}
+	if len(res.queries) != 1 || res.queries[0] != "smtp.test" {
+		t.Errorf("unexpected resolver queries: %v", res.queries)
+	}
+	if len(m.RemoteAddrs()) != 1 {
+		t.Errorf("expected one connection, got %v", m.RemoteAddrs())
+	}
+}
+
+func TestInterleaveFamilies(t *testing.T) {
+	in := []net.IPAddr{
+		{IP: net.ParseIP("2001:db8::1")},
+		{IP: net.ParseIP("2001:db8::2")},
+		{IP: net.ParseIP("192.0.2.1")},
+		{IP: net.ParseIP("192.0.2.2")},
+	}
+	want := []string{"2001:db8::1", "192.0.2.1", "2001:db8::2", "192.0.2.2"}
+
+	got := interleaveFamilies(in)
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i].String() != want[i] {
+			t.Errorf("got[%d] = %s, want %s", i, got[i].String(), want[i])
+		}
+	}
+}
+
+func TestSend_TemplatedSmarthost(t *testing.T) {
+	eu, us := &mockSMTP{}, &mockSMTP{}
+	eu.start(t)
+	us.start(t)
+
+	cfg, err := Load(fmt.Sprintf(`
+smarthost: '{{ if eq .Region "eu" }}%s{{ else }}%s{{ end }}'
+template_path: %s
+`, eu.addr, us.addr, tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Hi\n\nbody")))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, region := range []string{"eu", "us", "eu"} {
+		if _, err := Send(ctx, *cfg, map[string]string{"Region": region}); err != nil {
+			t.Fatalf("Send(%s): %v", region, err)
+		}
+	}
+	if got := len(eu.RemoteAddrs()); got != 2 {
+		t.Errorf("eu relay got %d connections, want 2", got)
+	}
+	if got := len(us.RemoteAddrs()); got != 1 {
+		t.Errorf("us relay got %d connections, want 1", got)
+	}
+
+	// A smarthost that renders without a port is a permanent error.
+	cfg.Smarthost = HostPort{Host: "{{ .Region }}-smtp.example.com"}
+	retry, err := Send(ctx, *cfg, map[string]string{"Region": "eu"})
+	if err == nil || retry || !strings.Contains(err.Error(), "eu-smtp.example.com") {
+		t.Errorf("Send = (%v, %v), want permanent invalid smarthost error", retry, err)
+	}
+
+	// Static smarthosts are unaffected.
+	cfg.Smarthost = us.smarthost()
+	if _, err := Send(ctx, *cfg, map[string]string{"Region": "eu"}); err != nil {
+		t.Fatalf("Send with static smarthost: %v", err)
+	}
+}
+
+func TestNewDialer_TCPKeepAlive(t *testing.T) {
+	for _, keepAlive := range []time.Duration{0, 30 * time.Second, -1} {
+		d, err := newDialer(context.Background(), EmailConfig{TCPKeepAlive: keepAlive})
+		if err != nil {
+			t.Fatalf("newDialer: %v", err)
+		}
+		if d.KeepAlive != keepAlive {
+			t.Errorf("KeepAlive = %v, want %v", d.KeepAlive, keepAlive)
+		}
+	}
+
+	// Sending works with keep-alives disabled.
+	m := &mockSMTP{}
+	m.start(t)
+	cfg := EmailConfig{
+		Smarthost:    m.smarthost(),
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Keepalive\n\nbody"),
+		TCPKeepAlive: -1,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := Send(ctx, cfg, nil); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+}
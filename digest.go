@@ -0,0 +1,79 @@
+package pigeon
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+)
+
+// BuildDigest bundles messages, each a complete message such as one
+// returned by BuildMessage, into a multipart/digest entity whose parts are
+// message/rfc822 (RFC 2046, section 5.1.5), e.g. for a daily digest of
+// notifications. The result starts with MIME-Version and Content-Type
+// headers; add From, To, Subject and the like in front of it to send it,
+// e.g. with SendRaw.
+//
+// Each message must parse as an RFC 5322 message. Line endings are
+// normalized to CRLF.
+func BuildDigest(messages [][]byte) ([]byte, error) {
+	if len(messages) == 0 {
+		return nil, errors.New("digest has no messages")
+	}
+	inner := make([][]byte, len(messages))
+	for i, msg := range messages {
+		if _, err := mail.ReadMessage(bytes.NewReader(msg)); err != nil {
+			return nil, fmt.Errorf("digest message %d: %w", i+1, err)
+		}
+		inner[i] = []byte(toCRLF(string(msg)))
+	}
+
+	boundary := digestBoundary(inner)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\nContent-Type: multipart/digest; boundary=%s\r\n\r\n", boundary)
+	mw := multipart.NewWriter(&buf)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return nil, err
+	}
+	for _, msg := range inner {
+		cte := "7bit"
+		if !isASCII(string(msg)) {
+			cte = "8bit"
+		}
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {"message/rfc822"},
+			"Content-Transfer-Encoding": {cte},
+		})
+		if err != nil {
+			return nil, err
+		}
+		pw.Write(msg)
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// digestBoundary returns a boundary that does not occur in any of messages,
+// which may themselves be multipart messages built by pigeon.
+func digestBoundary(messages [][]byte) string {
+	base := "digest_" + newBoundary()
+	boundary := base
+	for n := 1; ; n++ {
+		clash := false
+		for _, msg := range messages {
+			if bytes.Contains(msg, []byte("--"+boundary)) {
+				clash = true
+				break
+			}
+		}
+		if !clash {
+			return boundary
+		}
+		boundary = base + "_" + strconv.Itoa(n)
+	}
+}
@@ -0,0 +1,65 @@
+package pigeon
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"testing"
+)
+
+func TestBuildDigest(t *testing.T) {
+	var msgs [][]byte
+	for _, subject := range []string{"Disk space low", "Backup finished"} {
+		msg, err := BuildMessage(EmailConfig{
+			TemplatePath: tplWriteTemp(t, "From: monitor@example.com\nTo: ops@example.com\nSubject: "+subject+"\n\n"+subject+"."),
+		}, nil)
+		if err != nil {
+			t.Fatalf("BuildMessage: %v", err)
+		}
+		msgs = append(msgs, msg)
+	}
+
+	digest, err := BuildDigest(msgs)
+	if err != nil {
+		t.Fatalf("BuildDigest: %v", err)
+	}
+	full := append([]byte("From: monitor@example.com\r\nTo: ops@example.com\r\nSubject: Daily digest\r\n"), digest...)
+	msg, err := mail.ReadMessage(bytes.NewReader(full))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/digest" {
+		t.Fatalf("Content-Type = %q, want multipart/digest", msg.Header.Get("Content-Type"))
+	}
+
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for i := 0; ; i++ {
+		p, err := mr.NextRawPart()
+		if err == io.EOF {
+			if i != len(msgs) {
+				t.Errorf("digest has %d parts, want %d", i, len(msgs))
+			}
+			break
+		}
+		if err != nil {
+			t.Fatalf("NextRawPart: %v", err)
+		}
+		if ct := p.Header.Get("Content-Type"); ct != "message/rfc822" {
+			t.Errorf("part %d Content-Type = %q, want message/rfc822", i, ct)
+		}
+		body, _ := io.ReadAll(p)
+		if !bytes.Equal(body, msgs[i]) {
+			t.Errorf("part %d differs from message %d:\n%s", i, i, body)
+		}
+	}
+
+	if _, err := BuildDigest([][]byte{msgs[0], []byte("not a message")}); err == nil {
+		t.Error("expected error for a message that does not parse")
+	}
+	if _, err := BuildDigest(nil); err == nil {
+		t.Error("expected error for an empty digest")
+	}
+}
@@ -0,0 +1,291 @@
+package pigeon
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// ComputeBodyHash returns the base64-encoded SHA-256 DKIM body hash (the
+// "bh=" tag, RFC 6376 section 3.7) of body under the given body
+// canonicalization, "simple" or "relaxed". A "c=" value such as
+// "relaxed/simple" is accepted too, and its body part is used. Any other
+// value means "simple", the default in RFC 6376. Bare LF line endings in
+// body are treated as CRLF.
+//
+// It is meant for debugging signature mismatches, e.g. comparing the hash of
+// a message body with the bh= tag of its DKIM-Signature.
+func ComputeBodyHash(body []byte, canonicalization string) string {
+	if _, c, ok := strings.Cut(canonicalization, "/"); ok {
+		canonicalization = c
+	}
+	var canon []byte
+	if strings.EqualFold(strings.TrimSpace(canonicalization), "relaxed") {
+		canon = relaxedBody(body)
+	} else {
+		canon = simpleBody(body)
+	}
+	sum := sha256.Sum256(canon)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// bodyLines splits body into lines without their line endings, dropping the
+// empty lines at its end.
+func bodyLines(body []byte) [][]byte {
+	lines := bytes.Split(bytes.ReplaceAll(body, []byte("\r\n"), []byte("\n")), []byte("\n"))
+	for len(lines) > 0 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// simpleBody applies the "simple" body canonicalization (RFC 6376 section
+// 3.4.3): empty lines at the end are removed, and an empty body becomes a
+// single CRLF.
+func simpleBody(body []byte) []byte {
+	lines := bodyLines(body)
+	if len(lines) == 0 {
+		return []byte("\r\n")
+	}
+	return append(bytes.Join(lines, []byte("\r\n")), "\r\n"...)
+}
+
+// relaxedBody applies the "relaxed" body canonicalization (RFC 6376 section
+// 3.4.4): runs of whitespace within a line become a single space, whitespace
+// at the end of lines and empty lines at the end are removed, and an empty
+// body stays empty.
+func relaxedBody(body []byte) []byte {
+	lines := bodyLines(body)
+	for i, line := range lines {
+		lines[i] = compactWSP(line)
+	}
+	for len(lines) > 0 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return append(bytes.Join(lines, []byte("\r\n")), "\r\n"...)
+}
+
+// compactWSP replaces runs of spaces and tabs in line with a single space and
+// removes them from the end of the line.
+func compactWSP(line []byte) []byte {
+	out := make([]byte, 0, len(line))
+	space := false
+	for _, b := range line {
+		if b == ' ' || b == '\t' {
+			space = true
+			continue
+		}
+		if space {
+			out = append(out, ' ')
+			space = false
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// defaultDKIMHeaders are the header fields signed unless
+// DKIMConfig.SignedHeaders is set.
+var defaultDKIMHeaders = []string{"From", "To", "Subject", "Date", "Message-ID"}
+
+// signDKIM returns msg, which has CRLF line endings, with a DKIM-Signature
+// header added at the top, signed at time now with the key and settings of
+// dc.
+func signDKIM(dc *DKIMConfig, msg []byte, now time.Time) ([]byte, error) {
+	if dc.Domain == "" || dc.Selector == "" || dc.PrivateKey == "" {
+		return nil, errors.New("dkim: domain, selector and private_key must be specified")
+	}
+	signer, algo, err := loadDKIMKey(dc.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	canon := dc.Canonicalization
+	if canon == "" {
+		canon = "relaxed/relaxed"
+	}
+	headerCanon, bodyCanon, _ := strings.Cut(strings.ToLower(canon), "/")
+	if bodyCanon == "" {
+		bodyCanon = "simple"
+	}
+	for _, c := range []string{headerCanon, bodyCanon} {
+		if c != "simple" && c != "relaxed" {
+			return nil, fmt.Errorf("dkim: invalid canonicalization %q", dc.Canonicalization)
+		}
+	}
+	relaxed := headerCanon == "relaxed"
+
+	header, body, ok := bytes.Cut(msg, []byte("\r\n\r\n"))
+	if !ok {
+		header, body = bytes.TrimSuffix(msg, []byte("\r\n")), nil
+	}
+	fields := headerFields(string(header) + "\r\n")
+	names, signed := selectDKIMFields(fields, dc.signedHeaders(), dc.OverSign)
+
+	value := fmt.Sprintf("v=1; a=%s; c=%s/%s; d=%s; s=%s; t=%d; h=%s; bh=%s; b=",
+		algo, headerCanon, bodyCanon, dc.Domain, dc.Selector, now.Unix(),
+		strings.Join(names, ":"), ComputeBodyHash(body, bodyCanon))
+	var sigField bytes.Buffer
+	writeHeader(&sigField, "DKIM-Signature", value, maxLineLength)
+	unsigned := strings.TrimSuffix(sigField.String(), "\r\n")
+
+	sig, err := dkimSignature(signer, dkimHeaderHash(signed, unsigned, relaxed))
+	if err != nil {
+		return nil, fmt.Errorf("dkim: failed to sign: %w", err)
+	}
+
+	// Whitespace in the b= value is ignored, so the signature is folded into
+	// lines of its own.
+	var out bytes.Buffer
+	out.Grow(len(unsigned) + len(msg) + 512)
+	out.WriteString(unsigned)
+	b64 := base64.StdEncoding.EncodeToString(sig)
+	for len(b64) > 0 {
+		n := min(len(b64), maxLineLength-2)
+		out.WriteString("\r\n\t" + b64[:n])
+		b64 = b64[n:]
+	}
+	out.WriteString("\r\n")
+	out.Write(msg)
+	return out.Bytes(), nil
+}
+
+// dkimHeaderHash returns the SHA-256 hash of the signed header fields and
+// of sigField, the DKIM-Signature field with an empty b= tag and without its
+// final CRLF, in the header canonicalization chosen by relaxed (RFC 6376,
+// section 3.7).
+func dkimHeaderHash(signed []string, sigField string, relaxed bool) []byte {
+	h := sha256.New()
+	for _, f := range signed {
+		io.WriteString(h, canonHeader(f, relaxed))
+	}
+	io.WriteString(h, strings.TrimSuffix(canonHeader(sigField+"\r\n", relaxed), "\r\n"))
+	return h.Sum(nil)
+}
+
+// dkimSignature signs the header hash sum with signer.
+func dkimSignature(signer crypto.Signer, sum []byte) ([]byte, error) {
+	if _, ok := signer.(ed25519.PrivateKey); ok {
+		// Ed25519-SHA256 signs the hash itself (RFC 8463).
+		return signer.Sign(rand.Reader, sum, crypto.Hash(0))
+	}
+	return signer.Sign(rand.Reader, sum, crypto.SHA256)
+}
+
+// signedHeaders returns dc.SignedHeaders, or defaultDKIMHeaders if unset.
+func (dc *DKIMConfig) signedHeaders() []string {
+	if len(dc.SignedHeaders) > 0 {
+		return dc.SignedHeaders
+	}
+	return defaultDKIMHeaders
+}
+
+// headerFields splits a header block with CRLF line endings into its fields,
+// each including its continuation lines and final CRLF.
+func headerFields(header string) []string {
+	var fields []string
+	for _, line := range strings.SplitAfter(header, "\r\n") {
+		switch {
+		case line == "":
+		case (line[0] == ' ' || line[0] == '\t') && len(fields) > 0:
+			fields[len(fields)-1] += line
+		default:
+			fields = append(fields, line)
+		}
+	}
+	return fields
+}
+
+// selectDKIMFields returns the h= tag entries for signing the fields named in
+// want and the fields they select, in order. A field that appears several
+// times is listed once per instance, and the instances are selected from the
+// bottom up (RFC 6376, section 5.4.2). With overSign, every name is listed
+// once more, selecting no field.
+func selectDKIMFields(fields, want []string, overSign bool) (names, signed []string) {
+	byName := make(map[string][]string)
+	for _, f := range fields {
+		name, _, _ := strings.Cut(f, ":")
+		key := strings.ToLower(strings.TrimSpace(name))
+		byName[key] = append(byName[key], f)
+	}
+	seen := make(map[string]bool)
+	for _, name := range want {
+		key := strings.ToLower(name)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		instances := byName[key]
+		for i := len(instances) - 1; i >= 0; i-- {
+			names = append(names, name)
+			signed = append(signed, instances[i])
+		}
+		if overSign {
+			names = append(names, name)
+		}
+	}
+	return names, signed
+}
+
+// canonHeader returns the header field f, with its final CRLF, in the
+// "relaxed" (RFC 6376, section 3.4.2) or "simple" header canonicalization.
+func canonHeader(f string, relaxed bool) string {
+	if !relaxed {
+		return f
+	}
+	name, value, _ := strings.Cut(f, ":")
+	value = strings.NewReplacer("\r\n", "").Replace(value)
+	value = string(compactWSP([]byte(strings.TrimSpace(value))))
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + value + "\r\n"
+}
+
+// loadDKIMKey reads the PEM-encoded RSA or Ed25519 private key at path and
+// returns it with the name of its DKIM signing algorithm.
+func loadDKIMKey(path string) (crypto.Signer, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("dkim: failed to read private key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, "", fmt.Errorf("dkim: no PEM data in %s", path)
+	}
+	var key any
+	if block.Type == "RSA PRIVATE KEY" {
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("dkim: failed to parse private key %s: %w", path, err)
+	}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k, "rsa-sha256", nil
+	case ed25519.PrivateKey:
+		return k, "ed25519-sha256", nil
+	}
+	return nil, "", fmt.Errorf("dkim: unsupported key type %T in %s", key, path)
+}
+
+// dkimSign signs msg with cfg.DKIM, if set.
+func dkimSign(cfg EmailConfig, msg []byte) ([]byte, error) {
+	if cfg.DKIM == nil {
+		return msg, nil
+	}
+	return signDKIM(cfg.DKIM, msg, time.Now())
+}
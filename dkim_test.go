@@ -0,0 +1,297 @@
+package pigeon
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestComputeBodyHash(t *testing.T) {
+	hash := func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return base64.StdEncoding.EncodeToString(sum[:])
+	}
+	// The example of RFC 6376 section 3.4.5.
+	const example = " C \r\nD \t E\r\n\r\n\r\n"
+
+	tests := []struct {
+		name, body, canon, want string
+	}{
+		{name: "simple example", body: example, canon: "simple", want: hash(" C \r\nD \t E\r\n")},
+		{name: "relaxed example", body: example, canon: "relaxed", want: hash(" C\r\nD E\r\n")},
+		{name: "c= tag", body: example, canon: "simple/relaxed", want: hash(" C\r\nD E\r\n")},
+		{name: "default", body: example, canon: "", want: hash(" C \r\nD \t E\r\n")},
+		// Well-known hashes of an empty body.
+		{name: "simple empty", body: "", canon: "simple", want: "frcCV1k9oG9oKj3dpUqdJg1PxRT2RSN/XKdLCPjaYaY="},
+		{name: "relaxed empty", body: "", canon: "relaxed", want: "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="},
+		{name: "relaxed blank lines", body: " \r\n\t\r\n", canon: "relaxed", want: "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="},
+		{name: "missing final CRLF", body: "Hello", canon: "simple", want: hash("Hello\r\n")},
+		{name: "bare LF", body: "Hello  \nworld\n\n", canon: "relaxed", want: hash("Hello\r\nworld\r\n")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ComputeBodyHash([]byte(tt.body), tt.canon); got != tt.want {
+				t.Errorf("ComputeBodyHash(%q, %q) = %s, want %s", tt.body, tt.canon, got, tt.want)
+			}
+		})
+	}
+}
+
+// writeDKIMKey writes key to a PEM file in a temporary directory and returns
+// its path.
+func writeDKIMKey(t *testing.T, key any) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "dkim.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// dkimTags returns the tags of the DKIM-Signature field at the top of msg,
+// with whitespace removed, and the message that follows it.
+func dkimTags(t *testing.T, msg []byte) (map[string]string, []byte) {
+	t.Helper()
+	if !bytes.HasPrefix(msg, []byte("DKIM-Signature:")) {
+		t.Fatalf("message does not start with DKIM-Signature:\n%s", msg)
+	}
+	end := regexp.MustCompile(`\r\n[^ \t]`).FindIndex(msg)
+	field, rest := string(msg[len("DKIM-Signature:"):end[0]]), msg[end[0]+2:]
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(regexp.MustCompile(`\s+`).ReplaceAllString(field, ""), ";") {
+		k, v, _ := strings.Cut(tag, "=")
+		tags[k] = v
+	}
+	return tags, rest
+}
+
+// The example message and Ed25519 key of RFC 8463, appendix A.
+const (
+	rfc8463Message = "From: Joe SixPack <joe@football.example.com>\r\n" +
+		"To: Suzie Q <suzie@shopping.example.net>\r\n" +
+		"Subject: Is dinner ready?\r\n" +
+		"Date: Fri, 11 Jul 2003 21:00:37 -0700 (PDT)\r\n" +
+		"Message-ID: <20030712040037.46341.5F8J@football.example.com>\r\n" +
+		"\r\n" +
+		"Hi.\r\n" +
+		"\r\n" +
+		"We lost the game.  Are you hungry yet?\r\n" +
+		"\r\n" +
+		"Joe.\r\n"
+	rfc8463Seed = "nWGxne/9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A="
+	rfc8463BH   = "2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8="
+	// rfc8463Canon is the relaxed canonical form of the header fields
+	// signed by the default SignedHeaders.
+	rfc8463Canon = "from:Joe SixPack <joe@football.example.com>\r\n" +
+		"to:Suzie Q <suzie@shopping.example.net>\r\n" +
+		"subject:Is dinner ready?\r\n" +
+		"date:Fri, 11 Jul 2003 21:00:37 -0700 (PDT)\r\n" +
+		"message-id:<20030712040037.46341.5F8J@football.example.com>\r\n"
+)
+
+func rfc8463Key(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	seed, err := base64.StdEncoding.DecodeString(rfc8463Seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ed25519.NewKeyFromSeed(seed)
+}
+
+// TestDKIMHeaderHash_RFC8463 reproduces the Ed25519 signature of RFC 8463,
+// appendix A.3, which is deterministic, from the signature field given there.
+func TestDKIMHeaderHash_RFC8463(t *testing.T) {
+	header, body, _ := strings.Cut(rfc8463Message, "\r\n\r\n")
+	if got := ComputeBodyHash([]byte(body), "relaxed"); got != rfc8463BH {
+		t.Errorf("bh = %s, want %s", got, rfc8463BH)
+	}
+
+	sigField := "DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed;\r\n" +
+		" d=football.example.com; i=@football.example.com;\r\n" +
+		" q=dns/txt; s=brisbane; t=1528637909; h=from : to :\r\n" +
+		" subject : date : message-id : from : subject : date;\r\n" +
+		" bh=" + rfc8463BH + ";\r\n" +
+		" b="
+	// The repeated from, subject and date select no further fields.
+	_, signed := selectDKIMFields(headerFields(header+"\r\n"), []string{"from", "to", "subject", "date", "message-id"}, false)
+	sig, err := dkimSignature(rfc8463Key(t), dkimHeaderHash(signed, sigField, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = "/gCrinpcQOoIfuHNQIbq4pgh9kyIK3AQUdt9OdqQehSwhEIug4D11BusFa3bT3FY5OsU7ZbnKELq+eXdp1Q1Dw=="
+	if got := base64.StdEncoding.EncodeToString(sig); got != want {
+		t.Errorf("b = %s, want %s", got, want)
+	}
+}
+
+func TestSignDKIM_RFC8463(t *testing.T) {
+	key := rfc8463Key(t)
+	dc := &DKIMConfig{Domain: "football.example.com", Selector: "brisbane", PrivateKey: writeDKIMKey(t, key)}
+	signed, err := signDKIM(dc, []byte(rfc8463Message), time.Unix(1528637909, 0))
+	if err != nil {
+		t.Fatalf("signDKIM: %v", err)
+	}
+	tags, rest := dkimTags(t, signed)
+	if string(rest) != rfc8463Message {
+		t.Fatalf("message changed by signing:\n%s", rest)
+	}
+
+	// The expected signature is computed from the canonical form written out
+	// by hand, so that it does not share the signer's canonicalization.
+	canon := rfc8463Canon + "dkim-signature:v=1; a=ed25519-sha256; c=relaxed/relaxed;" +
+		" d=football.example.com; s=brisbane; t=1528637909;" +
+		" h=From:To:Subject:Date:Message-ID; bh=" + rfc8463BH + "; b="
+	sum := sha256.Sum256([]byte(canon))
+	if want := base64.StdEncoding.EncodeToString(ed25519.Sign(key, sum[:])); tags["b"] != want {
+		t.Errorf("b = %s, want %s\n%s", tags["b"], want, signed)
+	}
+}
+
+func TestSignDKIM_SimpleRSA(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := "From: a@example.com\r\nTo: b@example.com\r\n" +
+		"X-Tag: first\r\nX-Tag:  second,\r\n\tfolded\r\n\r\nHello,  world.\r\n\r\n"
+	dc := &DKIMConfig{
+		Domain: "example.com", Selector: "mail", PrivateKey: writeDKIMKey(t, key),
+		Canonicalization: "simple/simple", SignedHeaders: []string{"From", "X-Tag"},
+	}
+	signed, err := signDKIM(dc, []byte(msg), time.Unix(1700000000, 0))
+	if err != nil {
+		t.Fatalf("signDKIM: %v", err)
+	}
+	tags, _ := dkimTags(t, signed)
+	if want := ComputeBodyHash([]byte("Hello,  world.\r\n"), "simple"); tags["bh"] != want {
+		t.Errorf("bh = %s, want %s", tags["bh"], want)
+	}
+
+	// Simple canonicalization hashes the fields as they are, repeated ones
+	// from the bottom up, then the signature field up to "b=".
+	sigField := string(signed[:bytes.Index(signed, []byte("b=\r\n"))+len("b=")])
+	canon := "From: a@example.com\r\n" +
+		"X-Tag:  second,\r\n\tfolded\r\n" +
+		"X-Tag: first\r\n" +
+		sigField
+	sum := sha256.Sum256([]byte(canon))
+	sig, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		t.Fatalf("b= tag: %v", err)
+	}
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, sum[:], sig); err != nil {
+		t.Errorf("signature does not verify: %v\n%s", err, signed)
+	}
+}
+
+func TestSignDKIM(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPath, edPath := writeDKIMKey(t, rsaKey), writeDKIMKey(t, edKey)
+
+	msg := []byte("From: a@example.com\r\nTo: b@example.com\r\nSubject: Signed\r\n" +
+		"Date: Mon, 01 Jan 2024 00:00:00 +0000\r\nMessage-ID: <1@example.com>\r\n" +
+		"X-Tag: first\r\nX-Tag:  second,\r\n\tfolded\r\n\r\nHello,  world.\r\n\r\n")
+
+	tests := []struct {
+		name  string
+		dc    DKIMConfig
+		wantH string
+		wantA string
+		wantC string
+	}{
+		{
+			name:  "defaults",
+			dc:    DKIMConfig{PrivateKey: rsaPath},
+			wantH: "From:To:Subject:Date:Message-ID", wantA: "rsa-sha256", wantC: "relaxed/relaxed",
+		},
+		{
+			name:  "repeated field",
+			dc:    DKIMConfig{PrivateKey: rsaPath, SignedHeaders: []string{"From", "X-Tag", "Subject"}},
+			wantH: "From:X-Tag:X-Tag:Subject", wantA: "rsa-sha256", wantC: "relaxed/relaxed",
+		},
+		{
+			name:  "over-signed",
+			dc:    DKIMConfig{PrivateKey: rsaPath, SignedHeaders: []string{"From", "Reply-To"}, OverSign: true},
+			wantH: "From:From:Reply-To", wantA: "rsa-sha256", wantC: "relaxed/relaxed",
+		},
+		{
+			name:  "ed25519 simple",
+			dc:    DKIMConfig{PrivateKey: edPath, Canonicalization: "simple/simple", SignedHeaders: []string{"From", "X-Tag"}},
+			wantH: "From:X-Tag:X-Tag", wantA: "ed25519-sha256", wantC: "simple/simple",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.dc.Domain, tt.dc.Selector = "example.com", "mail"
+			signed, err := signDKIM(&tt.dc, msg, time.Unix(1700000000, 0))
+			if err != nil {
+				t.Fatalf("signDKIM: %v", err)
+			}
+			if !bytes.HasSuffix(signed, msg) {
+				t.Fatal("message changed by signing")
+			}
+			for _, line := range strings.Split(string(signed[:len(signed)-len(msg)]), "\r\n") {
+				if len(line) > maxLineLength {
+					t.Errorf("DKIM-Signature line longer than %d: %q", maxLineLength, line)
+				}
+			}
+			tags, _ := dkimTags(t, signed)
+			if tags["h"] != tt.wantH || tags["a"] != tt.wantA || tags["c"] != tt.wantC {
+				t.Errorf("h=%s a=%s c=%s, want h=%s a=%s c=%s", tags["h"], tags["a"], tags["c"], tt.wantH, tt.wantA, tt.wantC)
+			}
+			if tags["d"] != "example.com" || tags["s"] != "mail" || tags["t"] != "1700000000" {
+				t.Errorf("d=%s s=%s t=%s", tags["d"], tags["s"], tags["t"])
+			}
+		})
+	}
+
+	if _, err := signDKIM(&DKIMConfig{PrivateKey: rsaPath}, msg, time.Now()); err == nil {
+		t.Error("signDKIM without domain and selector: want error")
+	}
+	if _, err := signDKIM(&DKIMConfig{Domain: "example.com", Selector: "mail", PrivateKey: rsaPath, Canonicalization: "loose"}, msg, time.Now()); err == nil {
+		t.Error("signDKIM with invalid canonicalization: want error")
+	}
+}
+
+func TestBuildWireMessage_DKIM(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := EmailConfig{
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Signed\n\nBody."),
+		DKIM:         &DKIMConfig{Domain: "example.com", Selector: "mail", PrivateKey: writeDKIMKey(t, key)},
+	}
+	wire, err := BuildWireMessage(cfg, nil)
+	if err != nil {
+		t.Fatalf("BuildWireMessage: %v", err)
+	}
+	tags, rest := dkimTags(t, wire)
+	_, body, _ := strings.Cut(string(rest), "\r\n\r\n")
+	if tags["h"] != "From:To:Subject:Date" || tags["bh"] != ComputeBodyHash([]byte(body), "relaxed") {
+		t.Errorf("h=%s bh=%s:\n%s", tags["h"], tags["bh"], wire)
+	}
+}
@@ -0,0 +1,152 @@
+package pigeon
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+// CachingResolver is a Resolver that remembers the addresses of the hosts it
+// has looked up for a fixed time, so that frequent sends through the same
+// smarthost do not resolve its name every time. Entries are looked up again
+// once they expire, and failed lookups are not cached. Expired entries are
+// dropped as new ones are added. MX and SRV lookups are passed through.
+//
+// To keep the addresses of busy hosts fresh without a lookup in the send
+// path, run Run in a goroutine; it refreshes them periodically.
+//
+// Set it as EmailConfig.Resolver and share it between sends; it is safe for
+// concurrent use.
+type CachingResolver struct {
+	resolver Resolver
+	ttl      time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]cachedAddrs
+}
+
+// cachedAddrs is the result of an address lookup and when it expires. used
+// records whether it has been returned since it was last refreshed.
+type cachedAddrs struct {
+	addrs   []net.IPAddr
+	expires time.Time
+	used    bool
+}
+
+// NewCachingResolver returns a CachingResolver that caches the addresses
+// returned by r, or net.DefaultResolver if r is nil, for ttl.
+func NewCachingResolver(r Resolver, ttl time.Duration) *CachingResolver {
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	return &CachingResolver{resolver: r, ttl: ttl, now: time.Now, entries: make(map[string]cachedAddrs)}
+}
+
+// LookupIPAddr returns the cached addresses of host, looking them up if
+// there are none or they have expired.
+func (c *CachingResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	c.mu.Lock()
+	e, ok := c.entries[host]
+	if ok && c.now().Before(e.expires) {
+		e.used = true
+		c.entries[host] = e
+		c.mu.Unlock()
+		return slices.Clone(e.addrs), nil
+	}
+	c.mu.Unlock()
+
+	addrs, err := c.resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	c.store(host, addrs)
+	return addrs, nil
+}
+
+// store caches addrs for host and drops the expired entries.
+func (c *CachingResolver) store(host string, addrs []net.IPAddr) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	c.evict(now)
+	c.entries[host] = cachedAddrs{addrs: slices.Clone(addrs), expires: now.Add(c.ttl)}
+}
+
+// Refresh looks up again the hosts whose addresses have been returned since
+// they were last looked up, and drops the expired entries. A host whose
+// lookup fails keeps its addresses until they expire. It returns the first
+// lookup error, if any.
+func (c *CachingResolver) Refresh(ctx context.Context) error {
+	c.mu.Lock()
+	var hosts []string
+	for h, e := range c.entries {
+		if e.used {
+			hosts = append(hosts, h)
+		}
+	}
+	c.mu.Unlock()
+
+	var firstErr error
+	for _, h := range hosts {
+		addrs, err := c.resolver.LookupIPAddr(ctx, h)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to refresh addresses of %s: %w", h, err)
+			}
+			continue
+		}
+		c.store(h, addrs)
+	}
+	c.mu.Lock()
+	c.evict(c.now())
+	c.mu.Unlock()
+	return firstErr
+}
+
+// evict drops the entries expired at now. c.mu must be held.
+func (c *CachingResolver) evict(now time.Time) {
+	for h, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, h)
+		}
+	}
+}
+
+// Run calls Refresh every half TTL until ctx is done, so that the hosts in
+// use are looked up before their addresses expire and hosts no longer used
+// are dropped. Refresh errors are ignored; it returns ctx's error.
+func (c *CachingResolver) Run(ctx context.Context) error {
+	interval := c.ttl / 2
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.Refresh(ctx)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// LookupMX implements Resolver without caching.
+func (c *CachingResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	return c.resolver.LookupMX(ctx, name)
+}
+
+// LookupSRV passes the lookup to the underlying resolver without caching.
+// It fails if that resolver has no LookupSRV method.
+func (c *CachingResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	sr, ok := c.resolver.(srvResolver)
+	if !ok {
+		return "", nil, fmt.Errorf("resolver %T has no LookupSRV method", c.resolver)
+	}
+	return sr.LookupSRV(ctx, service, proto, name)
+}
@@ -0,0 +1,96 @@
+package pigeon
+
+import (
+	"context"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCachingResolver(t *testing.T) {
+	m := &mockSMTP{}
+	m.start(t)
+	_, port, _ := net.SplitHostPort(m.addr)
+
+	stub := &stubResolver{ips: map[string][]net.IPAddr{"smtp.test": {{IP: net.ParseIP("127.0.0.1")}}}}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := NewCachingResolver(stub, time.Minute)
+	cache.now = func() time.Time { return now }
+
+	cfg := EmailConfig{
+		Smarthost:    HostPort{Host: "smtp.test", Port: port},
+		Resolver:     cache,
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Cached\n\nBody."),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	send := func() {
+		t.Helper()
+		if _, err := Send(ctx, cfg, nil); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		<-m.received
+	}
+	send()
+	now = now.Add(30 * time.Second)
+	send()
+	if want := []string{"smtp.test"}; !slices.Equal(stub.queries, want) {
+		t.Errorf("queries within TTL = %v, want %v", stub.queries, want)
+	}
+
+	now = now.Add(time.Minute)
+	send()
+	if want := []string{"smtp.test", "smtp.test"}; !slices.Equal(stub.queries, want) {
+		t.Errorf("queries after expiry = %v, want %v", stub.queries, want)
+	}
+
+	// Failed lookups are not cached.
+	for range 2 {
+		if _, err := cache.LookupIPAddr(ctx, "missing.test"); err == nil {
+			t.Error("LookupIPAddr(missing.test): want error")
+		}
+	}
+	if n := len(stub.queries); n != 4 {
+		t.Errorf("got %d queries, want 4: %v", n, stub.queries)
+	}
+}
+
+func TestCachingResolver_Refresh(t *testing.T) {
+	stub := &stubResolver{ips: map[string][]net.IPAddr{
+		"busy.test": {{IP: net.ParseIP("192.0.2.1")}},
+		"idle.test": {{IP: net.ParseIP("192.0.2.2")}},
+	}}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := NewCachingResolver(stub, time.Minute)
+	cache.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	cache.LookupIPAddr(ctx, "busy.test")
+	cache.LookupIPAddr(ctx, "idle.test")
+	now = now.Add(20 * time.Second)
+	cache.LookupIPAddr(ctx, "busy.test")
+
+	// Only the host used since its lookup is refreshed.
+	now = now.Add(20 * time.Second)
+	if err := cache.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if want := []string{"busy.test", "idle.test", "busy.test"}; !slices.Equal(stub.queries, want) {
+		t.Errorf("queries = %v, want %v", stub.queries, want)
+	}
+
+	// The idle host expires and is dropped; the busy one is still cached.
+	now = now.Add(30 * time.Second)
+	if err := cache.Refresh(ctx); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if _, ok := cache.entries["idle.test"]; ok {
+		t.Error("expired entry idle.test not dropped")
+	}
+	cache.LookupIPAddr(ctx, "busy.test")
+	if n := len(stub.queries); n != 3 {
+		t.Errorf("got %d queries, want 3: %v", n, stub.queries)
+	}
+}
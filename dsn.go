@@ -0,0 +1,88 @@
+package pigeon
+
+import (
+	"errors"
+	"fmt"
+	"net/smtp"
+	"strings"
+)
+
+// DSNConfig requests delivery status notifications (RFC 3461) from servers
+// that advertise the DSN extension. Servers without it are sent the message
+// without the DSN parameters.
+type DSNConfig struct {
+	// Notify lists the events that trigger a DSN for each recipient:
+	// "SUCCESS", "FAILURE" and/or "DELAY", or "NEVER" alone to ask that no
+	// DSN be sent at all, e.g. for bulk mail whose bounces are tracked
+	// elsewhere. Empty leaves the choice to the server.
+	Notify []string `yaml:"notify,omitempty" json:"notify,omitempty"`
+	// Ret selects what a DSN returns of the message: "FULL" or "HDRS".
+	// Empty leaves the choice to the server.
+	Ret string `yaml:"ret,omitempty" json:"ret,omitempty"`
+}
+
+// params returns the RET parameter for MAIL and the NOTIFY parameter for
+// RCPT requested by d, either of which may be "".
+func (d *DSNConfig) params() (ret, notify string, err error) {
+	if d == nil {
+		return "", "", nil
+	}
+	switch r := strings.ToUpper(d.Ret); r {
+	case "":
+	case "FULL", "HDRS":
+		ret = "RET=" + r
+	default:
+		return "", "", fmt.Errorf("invalid dsn ret %q: must be FULL or HDRS", d.Ret)
+	}
+
+	events := make([]string, 0, len(d.Notify))
+	for _, n := range d.Notify {
+		switch e := strings.ToUpper(strings.TrimSpace(n)); e {
+		case "SUCCESS", "FAILURE", "DELAY", "NEVER":
+			events = append(events, e)
+		default:
+			return "", "", fmt.Errorf("invalid dsn notify %q: must be SUCCESS, FAILURE, DELAY or NEVER", n)
+		}
+	}
+	if len(events) > 1 && strings.Contains(strings.Join(events, ","), "NEVER") {
+		return "", "", errors.New("dsn notify NEVER cannot be combined with other values")
+	}
+	if len(events) > 0 {
+		notify = "NOTIFY=" + strings.Join(events, ",")
+	}
+	return ret, notify, nil
+}
+
+// dsnParams returns the DSN parameters of cfg to send to the server of c. They
+// are left out if the server does not advertise DSN.
+func dsnParams(c *smtp.Client, cfg EmailConfig) (ret, notify string, err error) {
+	ret, notify, err = cfg.DSN.params()
+	if err != nil || (ret == "" && notify == "") {
+		return "", "", err
+	}
+	if ok, _ := c.Extension("DSN"); !ok {
+		cfg.logInfo("server does not support DSN, not requesting delivery status notifications")
+		return "", "", nil
+	}
+	return ret, notify, nil
+}
+
+// rcptTo issues the RCPT command with additional ESMTP parameters, which
+// smtp.Client.Rcpt cannot send.
+func rcptTo(c *smtp.Client, to string, params ...string) error {
+	if len(params) == 0 {
+		return c.Rcpt(to)
+	}
+	if strings.ContainsAny(to, "\r\n") {
+		return errors.New("smtp: A line must not contain CR or LF")
+	}
+
+	id, err := c.Text.Cmd("RCPT TO:<%s> %s", to, strings.Join(params, " "))
+	if err != nil {
+		return err
+	}
+	c.Text.StartResponse(id)
+	defer c.Text.EndResponse(id)
+	_, _, err = c.Text.ReadResponse(25)
+	return err
+}
@@ -0,0 +1,81 @@
+package pigeon
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSend_DSN(t *testing.T) {
+	tests := []struct {
+		name       string
+		dsn        *DSNConfig
+		extensions []string
+		wantMail   string
+		wantRcpt   string
+		wantErr    string
+	}{
+		{
+			name:       "never",
+			dsn:        &DSNConfig{Notify: []string{"never"}, Ret: "HDRS"},
+			extensions: []string{"DSN"},
+			wantMail:   "MAIL FROM:<a@example.com> RET=HDRS",
+			wantRcpt:   "RCPT TO:<b@example.com> NOTIFY=NEVER",
+		},
+		{
+			name:       "failure and delay",
+			dsn:        &DSNConfig{Notify: []string{"FAILURE", "DELAY"}},
+			extensions: []string{"DSN"},
+			wantMail:   "MAIL FROM:<a@example.com>",
+			wantRcpt:   "RCPT TO:<b@example.com> NOTIFY=FAILURE,DELAY",
+		},
+		{
+			name:     "not advertised",
+			dsn:      &DSNConfig{Notify: []string{"NEVER"}, Ret: "FULL"},
+			wantMail: "MAIL FROM:<a@example.com>",
+			wantRcpt: "RCPT TO:<b@example.com>",
+		},
+		{
+			name:       "never combined",
+			dsn:        &DSNConfig{Notify: []string{"NEVER", "FAILURE"}},
+			extensions: []string{"DSN"},
+			wantErr:    "cannot be combined",
+		},
+		{
+			name:       "invalid ret",
+			dsn:        &DSNConfig{Ret: "BODY"},
+			extensions: []string{"DSN"},
+			wantErr:    "invalid dsn ret",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &mockSMTP{Extensions: tt.extensions}
+			m.start(t)
+			cfg := EmailConfig{
+				Smarthost:    m.smarthost(),
+				TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: DSN\n\nHello"),
+				DSN:          tt.dsn,
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			retry, err := Send(ctx, cfg, nil)
+			if tt.wantErr != "" {
+				if err == nil || retry || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Send = (%v, %v), want permanent error containing %q", retry, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+			cmds := m.Commands()
+			if !slices.Contains(cmds, tt.wantMail) || !slices.Contains(cmds, tt.wantRcpt) {
+				t.Errorf("commands = %q, want %q and %q", cmds, tt.wantMail, tt.wantRcpt)
+			}
+		})
+	}
+}
@@ -6,22 +6,26 @@ package pigeon
 import (
 	"bufio"
 	"bytes"
+	"container/list"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
 	"mime"
 	"mime/multipart"
-	"mime/quotedprintable"
 	"net"
 	"net/mail"
 	"net/smtp"
 	"net/textproto"
-	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
 	"text/template"
 	"time"
 
@@ -31,8 +35,34 @@ import (
 const (
 	maxLineLength    = 78
 	maxContentLength = 76
+
+	// maxPooledBufferSize bounds the capacity of buffers returned to
+	// bufferPool so that one very large message does not stay pinned in memory.
+	maxPooledBufferSize = 1 << 20
 )
 
+// bufferPool holds scratch buffers reused across sends to reduce allocations.
+var bufferPool = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
+// getBuffer returns an empty buffer from bufferPool.
+func getBuffer() *bytes.Buffer {
+	b := bufferPool.Get().(*bytes.Buffer)
+	b.Reset()
+	return b
+}
+
+// putBuffer returns b to bufferPool unless it has grown too large.
+// b must not be used after the call.
+func putBuffer(b *bytes.Buffer) {
+	if b.Cap() > maxPooledBufferSize {
+		return
+	}
+	b.Reset()
+	bufferPool.Put(b)
+}
+
 // Send builds and sends an email using the specified configuration and template data.
 //
 // If cfg.Attachments is non-empty, the message will be sent as multipart/mixed
@@ -43,266 +73,872 @@ const (
 //   - retry=true means a temporary error (the caller may want to retry later)
 //   - retry=false means a permanent error (invalid configuration, fatal SMTP error, etc.)
 func Send(ctx context.Context, cfg EmailConfig, data any) (retry bool, err error) {
-	if cfg.TemplatePath == "" {
-		return false, errors.New("TemplatePath must be specified")
+	res, err := SendReport(ctx, cfg, data)
+	return res.Retry, err
+}
+
+// checkSmarthost reports an error if cfg has no way to deliver mail.
+func (c *EmailConfig) checkSmarthost() error {
+	if c.Smarthost.Host == "" && c.Smarthost.Port == "" && !c.DirectMX && c.OutboxDir == "" {
+		return errors.New("smarthost must be specified")
+	}
+	return nil
+}
+
+// deliver sends msg to the envelope recipients, either through the
+// smarthost or directly to the recipient domains' MX hosts, or writes it to
+// the outbox directory if one is configured. MessageRewriter is applied
+// first, then the DKIM signature is added. Deliveries through the smarthost
+// use a session from pool, if not nil.
+func deliver(ctx context.Context, cfg EmailConfig, env Envelope, msg []byte, pool *sessionPool) (retry bool, err error) {
+	defer func() {
+		if err != nil {
+			cfg.logFailure("message not sent", retry, err, "from", env.From, "rcpts", len(env.Rcpts))
+		}
+	}()
+	if msg, env, err = prepare(cfg, env, msg); err != nil {
+		return false, err
 	}
 
-	if cfg.Smarthost.Host == "" && cfg.Smarthost.Port == "" {
-		return false, errors.New("smarthost must be specified")
+	if cfg.OutboxDir != "" {
+		return false, writeOutbox(cfg, env, msg)
 	}
 
-	t, err := tpl.ParseFile(cfg.TemplatePath)
+	d, err := newDialer(ctx, cfg)
 	if err != nil {
 		return false, err
 	}
 
-	// Build the message headers.
-	hdr := make(textproto.MIMEHeader)
+	if cfg.Smarthost.Host == "" && cfg.DirectMX {
+		return deliverMX(ctx, d, cfg, env.From, env.Rcpts, msg)
+	}
+	if cfg.Smarthost.isSRV() {
+		name := strings.TrimPrefix(cfg.Smarthost.Host, srvPrefix)
+		return deliverSRV(ctx, d, cfg, name, env.From, env.Rcpts, msg)
+	}
+
+	// Deliver the message via SMTP.
+	hostPort := cfg.Smarthost.String()
+	if hostPort == "" {
+		hostPort = "localhost:25"
+	}
+	if pool != nil {
+		return pool.deliver(ctx, d, cfg, hostPort, env.From, env.Rcpts, msg)
+	}
+	conn, err := dialSmarthost(ctx, d, cfg, hostPort)
+	if err != nil {
+		return true, err // network failure - retry allowed
+	}
+	defer conn.Close()
 
-	// Render template fields with data
-	var fromBuf, toBuf, ccBuf, bccBuf, subjBuf bytes.Buffer
+	return transmit(conn, smarthostName(hostPort), cfg, env.From, env.Rcpts, msg)
+}
 
-	fromTemplate := chooseNonEmpty(t.From(), cfg.From)
-	if fromTemplate == "" {
-		return false, errors.New("missing From address")
+// prepare readies msg and its envelope for delivery, as every send path
+// does: MessageRewriter is applied, the DKIM signature is added and the
+// recipients are rewritten with RecipientRewriter.
+func prepare(cfg EmailConfig, env Envelope, msg []byte) ([]byte, Envelope, error) {
+	msg, err := finalizeMessage(cfg, msg)
+	if err != nil {
+		return nil, env, err
+	}
+	if env.Rcpts, err = rewriteRecipients(cfg, env.Rcpts); err != nil {
+		return nil, env, err
 	}
+	return msg, env, nil
+}
 
-	// Parse and execute From field as template
-	fromTpl, err := template.New("from").Parse(fromTemplate)
+// finalizeMessage returns msg as it is sent: rewritten by MessageRewriter
+// and then DKIM-signed.
+func finalizeMessage(cfg EmailConfig, msg []byte) ([]byte, error) {
+	msg, err := rewriteMessage(cfg, msg)
 	if err != nil {
-		return false, fmt.Errorf("failed to parse From template: %w", err)
+		return nil, err
 	}
-	if err := fromTpl.Execute(&fromBuf, data); err != nil {
-		return false, fmt.Errorf("failed to execute From template: %w", err)
+	return dkimSign(cfg, msg)
+}
+
+// smarthostName returns the host part of hostPort.
+func smarthostName(hostPort string) string {
+	if idx := strings.LastIndex(hostPort, ":"); idx != -1 {
+		return hostPort[:idx]
 	}
-	from := fromBuf.String()
+	return hostPort
+}
 
-	hdr.Set("From", from)
+// BuildMessage renders the message described by cfg and data and returns it
+// as Send would transmit it, without contacting any server. Line endings
+// follow cfg.LineEnding.
+func BuildMessage(cfg EmailConfig, data any) ([]byte, error) {
+	msg, _, err := BuildMessageEnvelope(cfg, data)
+	return msg, err
+}
+
+// BuildMessageEnvelope is like BuildMessage but also returns the SMTP
+// envelope Send would use, e.g. for persisting the message to a queue.
+func BuildMessageEnvelope(cfg EmailConfig, data any) ([]byte, Envelope, error) {
+	msg, res, err := BuildMessageResult(cfg, data)
+	return msg, res.Envelope, err
+}
+
+// WriteEML writes the message described by cfg and data to w in .eml
+// (RFC 5322) form, e.g. for saving to disk. Line endings follow
+// cfg.LineEnding.
+func WriteEML(w io.Writer, cfg EmailConfig, data any) error {
+	_, err := writeEML(w, cfg, data)
+	return err
+}
 
-	toTemplate := chooseNonEmpty(t.To(), cfg.To)
-	if toTemplate == "" {
-		return false, errors.New("missing To address")
+// writeEML implements WriteEML, returning the Result describing the message.
+func writeEML(w io.Writer, cfg EmailConfig, data any) (Result, error) {
+	switch cfg.LineEnding {
+	case "", "crlf", "lf":
+	default:
+		return Result{}, fmt.Errorf("invalid line_ending %q: must be \"crlf\" or \"lf\"", cfg.LineEnding)
 	}
 
-	// Parse and execute To field as template
-	toTpl, err := template.New("to").Parse(toTemplate)
+	msg := getBuffer()
+	defer putBuffer(msg)
+	res, err := buildMessage(cfg, data, msg)
 	if err != nil {
-		return false, fmt.Errorf("failed to parse To template: %w", err)
+		return Result{}, err
 	}
-	if err := toTpl.Execute(&toBuf, data); err != nil {
-		return false, fmt.Errorf("failed to execute To template: %w", err)
+
+	out := applyLineEnding(cfg, msg.Bytes())
+	res.Size = len(out)
+	_, err = w.Write(out)
+	return res, err
+}
+
+// applyLineEnding converts msg, which has CRLF line endings, to the line
+// endings selected by cfg.LineEnding.
+func applyLineEnding(cfg EmailConfig, msg []byte) []byte {
+	if cfg.LineEnding == "lf" {
+		return bytes.ReplaceAll(msg, []byte("\r\n"), []byte("\n"))
 	}
-	to := toBuf.String()
-	hdr.Set("To", to)
+	return msg
+}
 
-	// Handle Cc if present
-	if ccTemplate := chooseNonEmpty(t.Cc(), cfg.Cc); ccTemplate != "" {
-		ccTpl, err := template.New("cc").Parse(ccTemplate)
-		if err != nil {
-			return false, fmt.Errorf("failed to parse Cc template: %w", err)
-		}
-		if err := ccTpl.Execute(&ccBuf, data); err != nil {
-			return false, fmt.Errorf("failed to execute Cc template: %w", err)
-		}
-		if cc := ccBuf.String(); cc != "" {
-			hdr.Set("Cc", cc)
-		}
+// Envelope is the SMTP envelope of a message: the addresses given in the
+// MAIL FROM and RCPT TO commands.
+type Envelope struct {
+	// From is the bare envelope sender address.
+	From string
+	// Rcpts are the bare addresses of the To, Cc and Bcc recipients and of
+	// the ArchiveBcc addresses, in order and without duplicates.
+	Rcpts []string
+
+	// messageID is the message's Message-ID, used to name outbox files.
+	messageID string
+}
+
+// newEnvelope returns the envelope for a message with headers hdr. The
+// envelope sender is chosen by envelopeFrom, and cfg.ArchiveBcc is added to
+// the recipients.
+func newEnvelope(cfg EmailConfig, hdr textproto.MIMEHeader, data any) (Envelope, error) {
+	from, err := envelopeFrom(cfg, hdr, data)
+	if err != nil {
+		return Envelope{}, err
+	}
+	rcpts, err := envelopeRcpts(cfg.AddressParser, hdr.Get("To"), hdr.Get("Cc"), hdr.Get("Bcc"), cfg.ArchiveBcc)
+	if err != nil {
+		return Envelope{}, err
 	}
+	return Envelope{From: from, Rcpts: rcpts, messageID: hdr.Get("Message-Id")}, nil
+}
 
-	// Handle Bcc if present
-	if bccTemplate := chooseNonEmpty(t.Bcc(), cfg.Bcc); bccTemplate != "" {
-		bccTpl, err := template.New("bcc").Parse(bccTemplate)
-		if err != nil {
-			return false, fmt.Errorf("failed to parse Bcc template: %w", err)
-		}
-		if err := bccTpl.Execute(&bccBuf, data); err != nil {
-			return false, fmt.Errorf("failed to execute Bcc template: %w", err)
+// envelopeRcpts returns the bare addresses in the address lists, dropping
+// duplicates (compared case-insensitively) after the first. Entries net/mail
+// cannot parse are given to parse, if set.
+func envelopeRcpts(parse AddressParser, lists ...string) ([]string, error) {
+	var rcpts []string
+	seen := make(map[string]bool)
+	for _, list := range lists {
+		for _, a := range parseAddressList(list) {
+			addr, err := extractAddrWith(a, parse)
+			if err != nil {
+				return nil, fmt.Errorf("invalid recipient %q: %w", a, err)
+			}
+			if key := strings.ToLower(addr); !seen[key] {
+				seen[key] = true
+				rcpts = append(rcpts, addr)
+			}
 		}
-		if bcc := bccBuf.String(); bcc != "" {
-			hdr.Set("Bcc", bcc)
+	}
+	return rcpts, nil
+}
+
+// parseTemplate returns cfg.Template or the template named cfg.TemplateName
+// in cfg.Templates, or loads the template referenced by cfg.TemplatePath, or
+// else parses cfg.TemplateText.
+func parseTemplate(cfg EmailConfig) (*tpl.Template, error) {
+	if cfg.Template != nil {
+		return cfg.Template, nil
+	}
+	if cfg.TemplateName != "" {
+		t, ok := cfg.Templates[cfg.TemplateName]
+		if !ok {
+			return nil, fmt.Errorf("template %q not found", cfg.TemplateName)
 		}
+		return t, nil
+	}
+	if cfg.TemplatePath == "" && cfg.TemplateText == "" {
+		return nil, errors.New("TemplatePath or TemplateText must be specified")
+	}
+	opts := cfg.templateOptions()
+	switch {
+	case cfg.TemplatePath == "":
+		return tpl.ParseString("template", cfg.TemplateText, opts...)
+	case cfg.TemplateResolver != nil:
+		return resolveTemplate(cfg.TemplateResolver, cfg.TemplatePath, opts...)
+	}
+	return tpl.ParseFile(cfg.TemplatePath, opts...)
+}
+
+// templateOptions returns the options cfg.TemplatePath or cfg.TemplateText
+// is parsed with.
+func (c *EmailConfig) templateOptions() []tpl.Option {
+	var opts []tpl.Option
+	if c.TemplateIncludeDir != "" {
+		opts = append(opts, tpl.WithIncludeDir(c.TemplateIncludeDir))
+	}
+	if c.TemplateFuncs != nil {
+		opts = append(opts, tpl.WithFuncs(c.TemplateFuncs))
+	}
+	return opts
+}
+
+// templateFuncs returns the functions available to templates in config
+// values, the same as in the message template: include reads from the
+// template's directory unless TemplateIncludeDir is set.
+func (c *EmailConfig) templateFuncs() template.FuncMap {
+	opts := c.templateOptions()
+	if c.TemplatePath != "" && c.TemplateResolver == nil {
+		opts = append([]tpl.Option{tpl.WithIncludeDir(filepath.Dir(c.TemplatePath))}, opts...)
+	}
+	return tpl.Funcs(opts...)
+}
+
+// resolveTemplate parses the template that resolve returns for path. Unlike
+// for local files, include reads from the current directory unless
+// TemplateIncludeDir is set, since path need not be a filesystem path.
+func resolveTemplate(resolve func(string) (io.ReadCloser, error), path string, opts ...tpl.Option) (*tpl.Template, error) {
+	rc, err := resolve(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve template %s: %w", path, err)
 	}
+	defer rc.Close()
+	return tpl.ParseReader(path, rc, opts...)
+}
 
-	// Subject is always taken from template(because config has no subject field for now).
-	subjTemplate := t.Subject()
-	if subjTemplate == "" {
-		subjTemplate = "(no subject)"
+// buildMessage renders the message described by cfg and data into msg and
+// returns the Result describing it.
+func buildMessage(cfg EmailConfig, data any, msg *bytes.Buffer) (Result, error) {
+	t, err := parseTemplate(cfg)
+	if err != nil {
+		return Result{}, err
 	}
 
-	// Parse and execute Subject field as template
-	subjTpl, err := template.New("subject").Parse(subjTemplate)
+	hdr, err := assembleHeaders(t, cfg, data)
+	if err != nil {
+		return Result{}, err
+	}
+	env, err := newEnvelope(cfg, hdr, data)
 	if err != nil {
-		return false, fmt.Errorf("failed to parse Subject template: %w", err)
+		return Result{}, err
 	}
-	if err := subjTpl.Execute(&subjBuf, data); err != nil {
-		return false, fmt.Errorf("failed to execute Subject template: %w", err)
+	suppressed, err := suppress(cfg, hdr, &env)
+	if err != nil {
+		return Result{Suppressed: suppressed}, err
+	}
+	invalid, err := validateRecipients(cfg, &env)
+	if err != nil {
+		return Result{Suppressed: suppressed, Invalid: invalid}, err
 	}
-	subj := subjBuf.String()
-	hdr.Set("Subject", encodingUTF8Subject(subj))
 
-	// Required headers.
-	hdr.Set("MIME-Version", "1.0")
+	parts, attachments, err := writeMessage(msg, t, cfg, hdr, data)
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{Envelope: env, MessageID: hdr.Get("Message-Id"), Size: msg.Len(), Parts: parts, Attachments: attachments, Suppressed: suppressed, Invalid: invalid}, nil
+}
 
-	// Use the specified timezone if set; otherwise, default to UTC.
-	var msgTime time.Time
-	if cfg.Timezone != "" {
-		loc, err := time.LoadLocation(cfg.Timezone)
-		if err == nil {
-			msgTime = time.Now().In(loc)
-		} else {
-			msgTime = time.Now().UTC()
-		}
-	} else {
-		msgTime = time.Now().UTC()
+// writeMessage renders the body of t with data and writes the complete
+// message, with the headers in hdr, to msg. The content headers are added to
+// hdr. It returns the leaf parts of the body, before any OpenPGP wrapping,
+// and the attachments.
+func writeMessage(msg *bytes.Buffer, t *tpl.Template, cfg EmailConfig, hdr textproto.MIMEHeader, data any) ([]PartInfo, []AttachmentResult, error) {
+	b, err := renderParts(t, cfg, data)
+	if err != nil {
+		return nil, nil, err
 	}
-	hdr.Set("Date", msgTime.Format(time.RFC1123Z))
+	text, html, textHdr := b.text, b.html, b.textHdr
 
-	// Add any custom headers from the configuration.
-	for k, v := range cfg.Headers {
-		if v == "" {
-			continue
-		}
-		hdr.Set(k, v)
+	parts := []PartInfo{partInfo(textHdr, "")}
+	var attachments []AttachmentResult
+	if html != "" {
+		parts = append(parts, partInfo(htmlPartHeader(html, b.qpWidth), ""))
 	}
 
-	var msg bytes.Buffer
+	boundary, err := cfg.boundary()
+	if err != nil {
+		return nil, nil, err
+	}
 
-	// If there are no attachments, send as plain text.
-	if len(cfg.Attachments) == 0 {
-		var bodyBuf bytes.Buffer
-		t.Execute(&bodyBuf, data)
+	body := getBuffer()
+	defer putBuffer(body)
 
-		if isASCII(bodyBuf.String()) && !hasLongLines(bodyBuf.String()) {
-			hdr.Set("Content-Type", "text/plain; charset=UTF-8")
-			hdr.Set("Content-Transfer-Encoding", "7bit")
+	// With an HTML body, the text and HTML versions are alternatives. The
+	// boundary must not start with the multipart/mixed one.
+	altBoundary := "alt_" + boundary
+	altHeader := textproto.MIMEHeader{
+		"Content-Type": {fmt.Sprintf("multipart/alternative; boundary=%s", altBoundary)},
+	}
+
+	// If there are no attachments, send the body alone.
+	if len(b.attachments) == 0 {
+		if html != "" {
+			for k, v := range altHeader {
+				hdr[k] = v
+			}
+			if err := writeAlternative(body, altBoundary, textHdr, text, html, b.qpWidth); err != nil {
+				return nil, nil, err
+			}
 		} else {
-			hdr.Set("Content-Type", "text/plain; charset=UTF-8")
-			hdr.Set("Content-Transfer-Encoding", "quoted-printable")
+			for k, v := range textHdr {
+				hdr[k] = v
+			}
+			if err := writeEncoded(body, text, textHdr.Get("Content-Transfer-Encoding"), b.qpWidth); err != nil {
+				return nil, nil, err
+			}
 		}
-
-		writeHeaders(&msg, hdr)
-		msg.WriteString("\r\n")
-		writeTextPart(&msg, t, data)
 	} else {
 		// Otherwise, construct a multipart/mixed message.
-		mw := multipart.NewWriter(&msg)
-		// Set a shorter boundary to avoid line wrapping issues
-		boundary := fmt.Sprintf("pigeon_%d", time.Now().Unix())
-		mw.SetBoundary(boundary)
+		mw := multipart.NewWriter(body)
+		if err := mw.SetBoundary(boundary); err != nil {
+			return nil, nil, err
+		}
 		hdr.Set("Content-Type", fmt.Sprintf("multipart/mixed; boundary=%s", boundary))
-		writeHeaders(&msg, hdr)
-		msg.WriteString("\r\n")
 
-		// part 1: text body
-		var bodyBuf bytes.Buffer
-		t.Execute(&bodyBuf, data)
-
-		textHdr := textproto.MIMEHeader{}
-		if isASCII(bodyBuf.String()) && !hasLongLines(bodyBuf.String()) {
-			textHdr.Set("Content-Type", "text/plain; charset=UTF-8")
-			textHdr.Set("Content-Transfer-Encoding", "7bit")
+		// part 1: text body, or text and HTML alternatives
+		if html != "" {
+			pw, _ := mw.CreatePart(altHeader)
+			if err := writeAlternative(pw, altBoundary, textHdr, text, html, b.qpWidth); err != nil {
+				return nil, nil, err
+			}
 		} else {
-			textHdr.Set("Content-Type", "text/plain; charset=UTF-8")
-			textHdr.Set("Content-Transfer-Encoding", "quoted-printable")
+			pw, _ := mw.CreatePart(textHdr)
+			if err := writeTextPart(pw, text, b.qpWidth); err != nil {
+				return nil, nil, err
+			}
 		}
 
-		pw, _ := mw.CreatePart(textHdr)
-		writeTextPart(pw, t, data)
-
 		// Part 2+: attachments.
-		for _, path := range cfg.Attachments {
-			if err := addAttachmentPart(mw, path); err != nil {
-				return false, err
+		for _, a := range b.attachments {
+			h, err := addAttachmentPart(mw, a, b.b64Width, b.qpWidth)
+			if err != nil {
+				return nil, nil, err
 			}
+			parts = append(parts, partInfo(h, a.name))
+			attachments = append(attachments, a.result(h, b.b64Width, b.qpWidth))
 		}
 		mw.Close()
 	}
 
-	// Deliver the message via SMTP.
-	hostPort := cfg.Smarthost.String()
-	if hostPort == "" {
-		hostPort = "localhost:25"
+	if b.cte != "" && (html != "" || len(b.attachments) > 0) {
+		hdr.Set("Content-Transfer-Encoding", b.cte)
 	}
-	d := &net.Dialer{}
-	if deadline, ok := ctx.Deadline(); ok {
-		d.Deadline = deadline
+
+	// Wrap the body in an OpenPGP/MIME envelope if configured.
+	if cfg.PGP != nil {
+		if err := wrapPGP(cfg.PGP, hdr, body); err != nil {
+			return nil, nil, err
+		}
 	}
-	conn, err := d.DialContext(ctx, "tcp", hostPort)
-	if err != nil {
-		return true, err // network failure - retry allowed
+
+	writeHeaders(msg, hdr, cfg.HeaderFoldWidth, fieldOrder(t)...)
+	msg.WriteString("\r\n")
+	body.WriteTo(msg)
+	return parts, attachments, nil
+}
+
+// fieldOrder returns the template's header keys in source order, which
+// writeHeaders uses to keep template headers in the order they were written.
+func fieldOrder(t *tpl.Template) []string {
+	order := make([]string, 0, len(t.Fields()))
+	for _, f := range t.Fields() {
+		order = append(order, f.Key)
 	}
-	defer conn.Close()
+	return order
+}
 
-	host := hostPort
-	if idx := strings.LastIndex(hostPort, ":"); idx != -1 {
-		host = hostPort[:idx]
+// renderBody executes the template body with data, wrapping it at
+// cfg.WrapText columns if set.
+func renderBody(t *tpl.Template, cfg EmailConfig, data any) (string, error) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if err := t.Execute(buf, data); err != nil {
+		return "", fmt.Errorf("failed to execute template: %w", err)
+	}
+	if cfg.WrapText > 0 {
+		return wrapText(buf.String(), cfg.WrapText), nil
+	}
+	return buf.String(), nil
+}
+
+// textContentType returns the Content-Type of the plain text body: text/plain
+// with charset UTF-8 and cfg.TextContentTypeParams, which may override the
+// charset.
+func textContentType(cfg EmailConfig) (string, error) {
+	params := map[string]string{"charset": "UTF-8"}
+	for k, v := range cfg.TextContentTypeParams {
+		params[strings.ToLower(k)] = v
+	}
+	ctype := mime.FormatMediaType("text/plain", params)
+	if ctype == "" {
+		return "", fmt.Errorf("invalid text_content_type_params %v", cfg.TextContentTypeParams)
+	}
+	return ctype, nil
+}
+
+// textPartHeader returns the content headers for a plain text body of type
+// ctype.
+func textPartHeader(ctype, text string, width int) textproto.MIMEHeader {
+	return textproto.MIMEHeader{
+		"Content-Type":              {ctype},
+		"Content-Transfer-Encoding": {transferEncoding(text, width)},
+	}
+}
+
+// transferEncoding returns the Content-Transfer-Encoding for a text body,
+// choosing quoted-printable when the text is not 7-bit safe.
+func transferEncoding(text string, width int) string {
+	if !isASCII(text) || hasLongLines(text, width) {
+		return "quoted-printable"
 	}
+	return "7bit"
+}
 
-	c, err := smtp.NewClient(conn, host)
+// transmit runs an SMTP transaction over conn, delivering msg from the
+// envelope sender to rcpts. host is the server name used for the client.
+func transmit(conn net.Conn, host string, cfg EmailConfig, from string, rcpts []string, msg []byte) (retry bool, err error) {
+	c, retry, err := openSession(conn, host, cfg)
 	if err != nil {
-		return true, err
+		return retry, err
 	}
 	defer func() {
 		if quitErr := c.Quit(); quitErr != nil {
 			// Log but don't override the main error
 		}
 	}()
+	return sendMail(c, conn, host, cfg, from, rcpts, msg)
+}
+
+// openSession greets the server on conn and readies the session for mail:
+// XCLIENT, EHLO with cfg.Hello and STARTTLS. The client is closed on error.
+func openSession(conn net.Conn, host string, cfg EmailConfig) (c *smtp.Client, retry bool, err error) {
+	conn = bufferConn(conn, cfg)
+	c, retry, err = greet(conn, host, cfg)
+	if err != nil {
+		return nil, retry, err
+	}
+	cfg.logDebug("connected to SMTP server", "host", host, "remote_addr", conn.RemoteAddr().String())
+	if c, retry, err = xclient(conn, c, host, cfg); err != nil {
+		_ = c.Quit()
+		return nil, retry, err
+	}
 
 	if cfg.Hello != "" {
 		_ = c.Hello(cfg.Hello)
 	}
 
-	if err := c.Mail(from); err != nil {
+	if retry, err := startTLS(c, host, cfg); err != nil {
+		_ = c.Quit()
+		return nil, retry, err
+	}
+	return c, false, nil
+}
+
+// sendMail runs a mail transaction on the session c, opened on conn,
+// delivering msg from the envelope sender to rcpts.
+func sendMail(c *smtp.Client, conn net.Conn, host string, cfg EmailConfig, from string, rcpts []string, msg []byte) (retry bool, err error) {
+	if cfg.AddReceivedHeader {
+		msg = prependReceived(cfg, receivedHeader(cfg, conn.LocalAddr(), rcpts), msg)
+	}
+
+	ret, notify, err := dsnParams(c, cfg)
+	if err != nil {
+		return false, err
+	}
+
+	var params []string
+	if ret != "" {
+		params = append(params, ret)
+	}
+	if ok, _ := c.Extension("REQUIRETLS"); ok && cfg.requireTLS() {
+		params = append(params, "REQUIRETLS")
+	}
+	// Converting to BINARYMIME would change the body under a DKIM signature.
+	var binary []byte
+	if cfg.BinaryMIME && cfg.DKIM == nil && hasExtensions(c, "CHUNKING", "BINARYMIME") {
+		if b, ok := toBinaryMIME(msg); ok {
+			binary = b
+			params = append(params, "BODY=BINARYMIME")
+		}
+	}
+	size := dataSize(msg)
+	if binary != nil {
+		size = len(binary)
+	}
+	if param, err := sizeParam(c, size); err != nil {
 		return false, err
+	} else if param != "" {
+		params = append(params, param)
+	}
+	if err := mailFrom(c, from, params...); err != nil {
+		return smtpFailure("MAIL", err, false)
 	}
 
-	for _, rcpt := range recipients(hdr) {
-		if err := c.Rcpt(rcpt); err != nil {
-			return false, err // recipient rejected - permanent
+	var rcptParams []string
+	if notify != "" {
+		rcptParams = append(rcptParams, notify)
+	}
+	for _, rcpt := range rcpts {
+		if err := rcptTo(c, rcpt, rcptParams...); err != nil {
+			// recipient rejected - permanent unless the server says otherwise
+			return smtpFailure("RCPT", err, false)
 		}
+		cfg.logDebug("recipient accepted", "rcpt", rcpt)
+	}
+
+	if binary != nil {
+		if err := bdat(c, binary); err != nil {
+			return smtpFailure("BDAT", err, true)
+		}
+		logSent(cfg, host, from, rcpts, len(binary))
+		return false, nil
 	}
 
 	wc, err := c.Data()
 	if err != nil {
-		return true, err
+		return smtpFailure("DATA", err, true)
 	}
-	if _, err := msg.WriteTo(wc); err != nil {
+	if _, err := wc.Write(msg); err != nil {
 		return true, err
 	}
 	if err := wc.Close(); err != nil {
-		return true, err
+		return smtpFailure("DATA", err, true)
 	}
+	logSent(cfg, host, from, rcpts, len(msg))
 	return false, nil
 }
 
-// addAttachmentPart adds a file as a base64-encoded attachment part to the multipart message.
-// It infers the content type from the file extension.
-func addAttachmentPart(mw *multipart.Writer, path string) error {
-	data, err := os.ReadFile(path)
+// logSent logs the delivery of a message of size bytes to rcpts via host.
+func logSent(cfg EmailConfig, host, from string, rcpts []string, size int) {
+	cfg.logInfo("message sent", "host", host, "from", from, "rcpts", len(rcpts), "size", size)
+}
+
+// maxHeaderTemplates bounds headerTemplates. Config values are few in
+// practice, but values that differ per message must not grow it forever.
+const maxHeaderTemplates = 256
+
+// headerTemplates caches parsed config-supplied header templates, keyed by
+// header name and template text, so repeated sends do not re-parse them.
+var headerTemplates = &templateCache{max: maxHeaderTemplates}
+
+// templateCache is a concurrency-safe cache of parsed templates that holds
+// at most max of them, evicting the least recently used.
+type templateCache struct {
+	mu    sync.Mutex
+	max   int
+	order list.List // of *templateCacheEntry, most recently used first
+	byKey map[string]*list.Element
+}
+
+type templateCacheEntry struct {
+	key string
+	t   *template.Template
+}
+
+// get returns the template cached under key, if any.
+func (c *templateCache) get(key string) (*template.Template, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.byKey[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*templateCacheEntry).t, true
+}
+
+// add caches t under key, evicting the least recently used template if the
+// cache is full.
+func (c *templateCache) add(key string, t *template.Template) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.byKey[key]; ok {
+		c.order.MoveToFront(e)
+		return
+	}
+	if c.byKey == nil {
+		c.byKey = make(map[string]*list.Element)
+	}
+	c.byKey[key] = c.order.PushFront(&templateCacheEntry{key: key, t: t})
+	if c.order.Len() > c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.byKey, oldest.Value.(*templateCacheEntry).key)
+	}
+}
+
+// renderHeader renders the header key using the template's precompiled value,
+// or the config-supplied fallback if the template does not set it. It returns
+// "" if neither is set.
+func renderHeader(t *tpl.Template, cfg EmailConfig, key, fallback string, data any) (string, error) {
+	if t.Header().Get(key) != "" {
+		buf := getBuffer()
+		defer putBuffer(buf)
+		if err := t.ExecuteHeader(buf, key, data); err != nil {
+			return "", fmt.Errorf("failed to execute %s template: %w", key, err)
+		}
+		return buf.String(), nil
+	}
+	return renderConfigValue(cfg, key, fallback, data)
+}
+
+// renderConfigValue renders a config-supplied header value as a template
+// with the functions of cfg's message template, caching the parsed template.
+// It returns "" if value is empty.
+func renderConfigValue(cfg EmailConfig, key, value string, data any) (string, error) {
+	if !isTemplate(value) {
+		return value, nil
+	}
+
+	// The parse depends only on which functions exist; the cached template
+	// is bound to this cfg's functions, e.g. its include directory, below.
+	funcs := cfg.templateFuncs()
+	cacheKey := key + "\x00" + value + "\x00" + funcNames(funcs)
+	ht, ok := headerTemplates.get(cacheKey)
+	if !ok {
+		var err error
+		if ht, err = template.New(key).Funcs(funcs).Parse(value); err != nil {
+			return "", templateParseError(key, err)
+		}
+		headerTemplates.add(cacheKey, ht)
+	}
+	ht, err := ht.Clone()
 	if err != nil {
-		return err
+		return "", fmt.Errorf("failed to execute %s template: %w", key, err)
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if err := ht.Funcs(funcs).Execute(buf, data); err != nil {
+		return "", fmt.Errorf("failed to execute %s template: %w", key, err)
+	}
+	return buf.String(), nil
+}
+
+// funcNames returns the sorted names of funcs, separated by commas.
+func funcNames(funcs template.FuncMap) string {
+	names := make([]string, 0, len(funcs))
+	for name := range funcs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// templateParseError wraps the error from parsing the template of the config
+// value key, naming the function if the template calls an undefined one.
+func templateParseError(key string, err error) error {
+	if fn, ok := tpl.UndefinedFunc(err); ok {
+		return fmt.Errorf("%s template calls undefined function %q: %w", key, fn, err)
+	}
+	return fmt.Errorf("failed to parse %s template: %w", key, err)
+}
+
+// attachment is an attachment loaded from a file or URL.
+type attachment struct {
+	Attachment
+	name  string
+	ctype string
+	data  []byte
+}
+
+// loadAttachments loads cfg.Attachments and cfg.AttachmentSpecs in order. If cfg.DedupAttachments
+// is set, attachments with the same resolved path or identical content as an
+// earlier one are skipped.
+func loadAttachments(cfg EmailConfig) ([]*attachment, error) {
+	var (
+		out    []*attachment
+		paths  = make(map[string]string)
+		hashes = make(map[[sha256.Size]byte]string)
+	)
+	for _, spec := range cfg.attachments() {
+		path := spec.Path
+		key := path
+		if cfg.DedupAttachments {
+			if !isURL(path) && spec.Content == "" {
+				key = resolvePath(path)
+			}
+			if prev, ok := paths[key]; ok {
+				cfg.logInfo("skipping duplicate attachment", "path", path, "duplicate_of", prev)
+				continue
+			}
+		}
+
+		a, err := loadAttachment(cfg, spec)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.DedupAttachments {
+			sum := sha256.Sum256(a.data)
+			if prev, ok := hashes[sum]; ok {
+				cfg.logInfo("skipping attachment with duplicate content", "path", path, "duplicate_of", prev)
+				continue
+			}
+			hashes[sum] = path
+			paths[key] = path
+		}
+		out = append(out, a)
+	}
+	return out, nil
+}
+
+// loadAttachment reads the attachment described by spec, inferring the
+// content type from the file extension. Paths that are http(s) URLs are
+// fetched with cfg's HTTP client.
+func loadAttachment(cfg EmailConfig, spec Attachment) (*attachment, error) {
+	if strings.ContainsAny(spec.ContentID, " \t\r\n") {
+		return nil, fmt.Errorf("invalid content_id %q for attachment %s", spec.ContentID, spec.Path)
+	}
+
+	var (
+		fname, ctype string
+		data         []byte
+		err          error
+	)
+	switch {
+	case spec.Content != "":
+		fname, data = filepath.Base(spec.Path), []byte(spec.Content)
+		ctype = mime.TypeByExtension(filepath.Ext(fname))
+	case isURL(spec.Path):
+		if fname, ctype, data, err = fetchAttachment(cfg.httpClient(), spec.Path); err != nil {
+			return nil, err
+		}
+	default:
+		if data, err = readAttachment(spec.Path); err != nil {
+			return nil, err
+		}
+		fname = filepath.Base(spec.Path)
+		ctype = mime.TypeByExtension(filepath.Ext(fname))
+	}
+	if data, err = transcodeAttachment(spec, data); err != nil {
+		return nil, err
+	}
+	if spec.ContentType != "" {
+		ctype = spec.ContentType
+	}
+	spec, ctype = labelCharset(spec, ctype, data)
+	if err := spec.checkEncoding(ctype); err != nil {
+		return nil, err
+	}
+	if isMessageType(ctype) {
+		data = []byte(toCRLF(string(data)))
+	}
+	return &attachment{Attachment: spec, name: fname, ctype: ctype, data: data}, nil
+}
+
+// transcodeAttachment converts data from spec.SourceCharset to the declared
+// charset, if a source charset is set.
+func transcodeAttachment(spec Attachment, data []byte) ([]byte, error) {
+	if spec.SourceCharset == "" {
+		return data, nil
+	}
+	data, err := transcode(data, spec.SourceCharset, spec.charset())
+	if err != nil {
+		return nil, fmt.Errorf("attachment %s: %w", spec.Path, err)
+	}
+	return data, nil
+}
+
+// resolvePath returns the absolute path of a file with symlinks resolved, or
+// path itself if it cannot be resolved.
+func resolvePath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if real, err := filepath.EvalSymlinks(abs); err == nil {
+		return real
+	}
+	return abs
+}
+
+// addAttachmentPart adds a as an attachment part to the multipart message
+// and returns the part's headers. Attachments are base64-encoded in lines of
+// b64Width characters, except for message/* parts, which are sent as they
+// are, and those with Encoding "quoted-printable", which are wrapped at
+// qpWidth columns.
+func addAttachmentPart(mw *multipart.Writer, a *attachment, b64Width, qpWidth int) (textproto.MIMEHeader, error) {
+	h := a.header()
+	pw, _ := mw.CreatePart(h)
+	switch {
+	case isMessageType(a.ctype):
+		pw.Write(a.data)
+	case a.quotedPrintable():
+		if err := writeQuotedPrintable(pw, string(a.data), qpWidth); err != nil {
+			return nil, fmt.Errorf("failed to encode attachment %s: %w", a.name, err)
+		}
+	default:
+		encodeAndWrapBase64(pw, a.data, b64Width)
+	}
+	return h, nil
+}
+
+// attachmentHeader returns the part headers for the local attachment spec.
+func attachmentHeader(spec Attachment) textproto.MIMEHeader {
+	fname := filepath.Base(spec.Path)
+	return attachmentPartHeader(spec, fname, mime.TypeByExtension(filepath.Ext(fname)))
+}
+
+// attachmentPartHeader returns the part headers for the attachment spec,
+// named fname.
+func attachmentPartHeader(spec Attachment, fname, ctype string) textproto.MIMEHeader {
+	if spec.ContentType != "" {
+		ctype = spec.ContentType
 	}
-	fname := filepath.Base(path)
-	ctype := mime.TypeByExtension(filepath.Ext(fname))
 	if ctype == "" {
 		ctype = "application/octet-stream"
 	}
-	hdr := textproto.MIMEHeader{
-		"Content-Type":              {fmt.Sprintf("%s; name=\"%s\"", ctype, fname)},
+	if cs := spec.charset(); cs != "" && strings.HasPrefix(ctype, "text/") {
+		if mediaType, params, err := mime.ParseMediaType(ctype); err == nil {
+			params["charset"] = cs
+			ctype = mime.FormatMediaType(mediaType, params)
+		}
+	}
+	h := textproto.MIMEHeader{
+		"Content-Type":              {ctype + "; " + encodeNameParam(fname)},
 		"Content-Transfer-Encoding": {"base64"},
-		"Content-Disposition":       {fmt.Sprintf("attachment; filename=\"%s\"", fname)},
+		"Content-Disposition":       {"attachment; " + EncodeAttachmentFilename(fname)},
 	}
-	pw, _ := mw.CreatePart(hdr)
-	encodeAndWrapBase64(pw, data)
-	return nil
+	if spec.Description != "" {
+		h.Set("Content-Description", mime.QEncoding.Encode("UTF-8", spec.Description))
+	}
+	if id := spec.contentID(); id != "" {
+		h.Set("Content-ID", id)
+	}
+	return h
 }
 
-// encodeAndWrapBase64 writes base64-encoded data to w, breaking lines at 76 characters per RFC 2045.
-func encodeAndWrapBase64(w io.Writer, b []byte) {
+// encodeAndWrapBase64 writes base64-encoded data to w, breaking lines at
+// width characters, a multiple of 4 and at most 76 per RFC 2045.
+func encodeAndWrapBase64(w io.Writer, b []byte, width int) {
 	enc := base64.StdEncoding
-	const line = 76
 	for len(b) > 0 {
-		n := line / 4 * 3 // encode quantized
+		n := width / 4 * 3 // encode quantized
 		if n > len(b) {
 			n = len(b)
 		}
@@ -321,21 +957,123 @@ func chooseNonEmpty(a, b string) string {
 	return b
 }
 
-// encodingUTF8Subject returns an RFC 2047 encoded UTF-8 subject using quoted-printable
-func encodingUTF8Subject(s string) string {
+// encodedWordRE matches an RFC 2047 encoded-word.
+var encodedWordRE = regexp.MustCompile(`=\?[^?\s]+\?[bBqQ]\?[^?\s]*\?=`)
+
+// encodingUTF8Subject returns an RFC 2047 encoded UTF-8 subject using quoted-printable.
+// The subject is split into encoded-words short enough for the folded
+// Subject field to fit in width columns (maxLineLength if width is 0).
+//
+// A subject that is already encoded is ASCII and passed through verbatim. One
+// that mixes encoded-words with raw UTF-8 text is decoded first, so that the
+// encoded-words are not encoded a second time.
+func encodingUTF8Subject(s string, width int) string {
 	if isASCII(s) {
 		return s
 	}
+	if encodedWordRE.MatchString(s) {
+		if dec, err := new(mime.WordDecoder).DecodeHeader(s); err == nil {
+			s = dec
+		}
+	}
+	if width <= 0 {
+		width = maxLineLength
+	}
+	// The first word follows "Subject: ", later ones a single folding space.
+	return qEncodeWords(s, width-len("Subject: "), width-1)
+}
+
+// qEncodeWords encodes s as space-separated RFC 2047 Q encoded-words, the
+// first at most first characters long and the rest at most rest. Words never
+// exceed the 75 characters allowed by RFC 2047 and never split a rune.
+func qEncodeWords(s string, first, rest int) string {
+	const prefix, suffix = "=?UTF-8?q?", "?="
+	const maxWord = 75
+
+	var words []string
+	var cur strings.Builder
+	limit := min(first, maxWord)
+	for _, r := range s {
+		enc := qEncodeRune(r)
+		if cur.Len() > 0 && len(prefix)+cur.Len()+len(enc)+len(suffix) > limit {
+			words = append(words, prefix+cur.String()+suffix)
+			cur.Reset()
+			limit = min(rest, maxWord)
+		}
+		cur.WriteString(enc)
+	}
+	words = append(words, prefix+cur.String()+suffix)
+	return strings.Join(words, " ")
+}
+
+// qEncodeRune returns r in the Q encoding of RFC 2047, section 4.2.
+func qEncodeRune(r rune) string {
+	if r == ' ' {
+		return "_"
+	}
+	if r > ' ' && r <= '~' && r != '=' && r != '?' && r != '_' {
+		return string(r)
+	}
+	var b strings.Builder
+	for _, c := range []byte(string(r)) {
+		fmt.Fprintf(&b, "=%02X", c)
+	}
+	return b.String()
+}
+
+// newMessageID returns a unique Message-ID in the domain of the from address,
+// or "localhost" if it has none.
+func newMessageID(from string) string {
+	domain := "localhost"
+	if addr, err := extractAddr(from); err == nil {
+		if i := strings.LastIndex(addr, "@"); i >= 0 && i < len(addr)-1 {
+			domain = addr[i+1:]
+		}
+	}
+	var b [8]byte
+	rand.Read(b[:])
+	return fmt.Sprintf("<%d.%x@%s>", time.Now().UnixNano(), b, domain)
+}
+
+// validMessageID reports whether id is an RFC 5322 msg-id of the form
+// "<left@right>".
+func validMessageID(id string) bool {
+	if len(id) < 2 || id[0] != '<' || id[len(id)-1] != '>' {
+		return false
+	}
+	left, right, ok := strings.Cut(id[1:len(id)-1], "@")
+	if !ok || left == "" || right == "" {
+		return false
+	}
+	return !strings.ContainsAny(left+right, "<>@ \t\r\n")
+}
 
-	var buf bytes.Buffer
-	qpWriter := quotedprintable.NewWriter(&buf)
-	qpWriter.Write([]byte(s))
-	qpWriter.Close()
+// addressHeaders lists the structured header fields whose values are address
+// lists (RFC 5322, section 3.6). Keys are in canonical form.
+var addressHeaders = map[string]bool{
+	"From": true, "Sender": true, "Reply-To": true,
+	"To": true, "Cc": true, "Bcc": true,
+	"Resent-From": true, "Resent-Sender": true,
+	"Resent-To": true, "Resent-Cc": true, "Resent-Bcc": true,
+}
 
-	// Remove any CRLF that quoted-printable might add and replace = with encoded form
-	encoded := strings.ReplaceAll(buf.String(), "\r\n", "")
-	encoded = strings.ReplaceAll(encoded, "=", "=3D")
-	return fmt.Sprintf("=?UTF-8?Q?%s?=", encoded)
+// encodeHeaderValue makes a non-ASCII header value safe for transmission.
+// In address headers only the display names are encoded, leaving the
+// addresses readable; other values become RFC 2047 encoded-words.
+func encodeHeaderValue(key, v string) string {
+	if isASCII(v) {
+		return v
+	}
+	if addressHeaders[textproto.CanonicalMIMEHeaderKey(key)] {
+		if list, err := mail.ParseAddressList(v); err == nil {
+			out := make([]string, len(list))
+			for i, a := range list {
+				out[i] = a.String()
+			}
+			return strings.Join(out, ", ")
+		}
+	}
+	return mime.QEncoding.Encode("UTF-8", v)
 }
 
 // isASCII returns true if s contains only ASCII characters.
@@ -348,69 +1086,141 @@ func isASCII(s string) bool {
 	return true
 }
 
-// writeHeaders writes the MIME headers to the buffer with simple line folding.
-func writeHeaders(buf *bytes.Buffer, h textproto.MIMEHeader) {
-	for k, vv := range h {
-		for _, v := range vv {
-			line := k + ": " + v
-			if len(line) <= maxLineLength {
-				buf.WriteString(line + "\r\n")
-			} else {
-				// Simple folding at reasonable break points
-				buf.WriteString(k + ": ")
-				remaining := v
-				lineLen := len(k) + 2
-
-				for len(remaining) > 0 {
-					available := maxLineLength - lineLen
-					if available <= 0 {
-						buf.WriteString("\r\n ")
-						lineLen = 1
-						available = maxLineLength - 1
-					}
-
-					if len(remaining) <= available {
-						buf.WriteString(remaining + "\r\n")
-						break
-					}
-
-					// Find a good break point
-					breakPoint := available
-					for i := available - 1; i > available/2 && i < len(remaining); i-- {
-						if remaining[i] == ' ' || remaining[i] == ';' {
-							breakPoint = i + 1
-							break
-						}
-					}
-
-					buf.WriteString(remaining[:breakPoint] + "\r\n ")
-					remaining = remaining[breakPoint:]
-					lineLen = 1
-				}
-			}
+// mailFrom issues the MAIL command with additional ESMTP parameters, which
+// smtp.Client.Mail cannot send. The BODY and SMTPUTF8 parameters that
+// smtp.Client.Mail adds are kept, unless params has its own BODY.
+func mailFrom(c *smtp.Client, from string, params ...string) error {
+	if len(params) == 0 {
+		return c.Mail(from)
+	}
+	if strings.ContainsAny(from, "\r\n") {
+		return errors.New("smtp: A line must not contain CR or LF")
+	}
+
+	cmd := "MAIL FROM:<" + from + ">"
+	if ok, _ := c.Extension("8BITMIME"); ok && !slices.ContainsFunc(params, isBodyParam) {
+		cmd += " BODY=8BITMIME"
+	}
+	if ok, _ := c.Extension("SMTPUTF8"); ok {
+		cmd += " SMTPUTF8"
+	}
+	cmd += " " + strings.Join(params, " ")
+
+	id, err := c.Text.Cmd("%s", cmd)
+	if err != nil {
+		return err
+	}
+	c.Text.StartResponse(id)
+	defer c.Text.EndResponse(id)
+	_, _, err = c.Text.ReadResponse(250)
+	return err
+}
+
+// isBodyParam reports whether the MAIL parameter p is a BODY parameter.
+func isBodyParam(p string) bool {
+	return strings.HasPrefix(strings.ToUpper(p), "BODY=")
+}
+
+// hasExtensions reports whether the server advertises all of exts.
+func hasExtensions(c *smtp.Client, exts ...string) bool {
+	for _, ext := range exts {
+		if ok, _ := c.Extension(ext); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// headerOrder is the order in which well-known headers are written unless
+// the caller specifies otherwise. Keys are in canonical form.
+var headerOrder = []string{
+	"Date", "From", "Sender", "Reply-To", "To", "Cc", "Bcc", "Subject",
+	"Message-Id", "In-Reply-To", "References",
+	"Mime-Version", "Content-Type", "Content-Transfer-Encoding",
+}
+
+// writeHeaders writes the MIME headers to the buffer, folding lines longer
+// than width columns (maxLineLength if width is 0).
+// Keys listed in order come first, then the well-known headers in headerOrder,
+// then any remaining keys sorted, so the output is deterministic.
+func writeHeaders(buf *bytes.Buffer, h textproto.MIMEHeader, width int, order ...string) {
+	if width <= 0 {
+		width = maxLineLength
+	}
+	seen := make(map[string]bool, len(h))
+	emit := func(k string) {
+		if seen[k] {
+			return
+		}
+		seen[k] = true
+		for _, v := range h[k] {
+			writeHeader(buf, k, v, width)
+		}
+	}
+
+	for _, k := range order {
+		emit(k)
+	}
+	for _, k := range headerOrder {
+		emit(k)
+	}
+	rest := make([]string, 0, len(h))
+	for k := range h {
+		if !seen[k] {
+			rest = append(rest, k)
 		}
 	}
+	sort.Strings(rest)
+	for _, k := range rest {
+		emit(k)
+	}
 }
 
-// recipients extracts all recipient addresses (To, Cc, Bcc) from the headers.
-func recipients(h textproto.MIMEHeader) []string {
-	var out []string
-	for _, f := range []string{"To", "Cc", "Bcc"} {
-		for _, addr := range strings.Split(h.Get(f), ",") {
-			if addr = strings.TrimSpace(addr); addr != "" {
-				out = append(out, addr)
+// writeHeader writes a single header field, folding it before whitespace so
+// that lines fit in width columns where possible (RFC 5322, section 2.2.3).
+// Folding never splits a word, so encoded-words are kept intact; a word
+// longer than width is left on a line of its own.
+func writeHeader(buf *bytes.Buffer, k, v string, width int) {
+	line := k + ": " + v
+	minBreak := len(k) + 2 // don't fold directly after the field name
+	for len(line) > width {
+		i := strings.LastIndexByte(line[:width+1], ' ')
+		if i < minBreak {
+			// No whitespace in range: fold after the overlong word instead.
+			j := strings.IndexByte(line[minBreak:], ' ')
+			if j < 0 {
+				break
 			}
+			i = minBreak + j
 		}
+		buf.WriteString(line[:i])
+		buf.WriteString("\r\n")
+		line = line[i:]
+		minBreak = 1
 	}
-	return out
+	buf.WriteString(line)
+	buf.WriteString("\r\n")
 }
 
 // SendRaw sends the raw RFC2822 message (headers+body) via SMTP to smtpAddr.
 // From, To, Cc, Bcc headers are extracted and used for MAIL/RCPT commands.
-// The message is streamed as-is via DATA.
+// The message is streamed as-is via DATA, so raw need not be seekable.
 func SendRaw(ctx context.Context, raw io.Reader, smtpAddr string) error {
-	tp := textproto.NewReader(bufio.NewReader(raw))
-	headers, err := tp.ReadMIMEHeader()
+	// Keep the header block as read, since it has to be sent ahead of the
+	// rest of the stream once the envelope has been taken from it.
+	br := bufio.NewReader(raw)
+	var head bytes.Buffer
+	for {
+		line, err := br.ReadString('\n')
+		head.WriteString(line)
+		if err == io.EOF || line == "\r\n" || line == "\n" {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read header: %w", err)
+		}
+	}
+	headers, err := textproto.NewReader(bufio.NewReader(bytes.NewReader(head.Bytes()))).ReadMIMEHeader()
 	if err != nil {
 		return fmt.Errorf("failed to parse header: %w", err)
 	}
@@ -439,7 +1249,7 @@ func SendRaw(ctx context.Context, raw io.Reader, smtpAddr string) error {
 	}
 	defer conn.Close()
 
-	client, err := smtp.NewClient(conn, host)
+	client, err := smtp.NewClient(&limitConn{Conn: conn, lineLimit: lineLimit{max: defaultMaxReplyLineBytes}}, host)
 	if err != nil {
 		return fmt.Errorf("smtp.NewClient: %w", err)
 	}
@@ -473,11 +1283,10 @@ func SendRaw(ctx context.Context, raw io.Reader, smtpAddr string) error {
 		return fmt.Errorf("SMTP DATA failed: %w", err)
 	}
 
-	if seeker, ok := raw.(io.Seeker); ok {
-		// seek to start if possible
-		seeker.Seek(0, io.SeekStart)
+	if _, err := wc.Write(head.Bytes()); err != nil {
+		return fmt.Errorf("sending mail data failed: %w", err)
 	}
-	if _, err := io.Copy(wc, tp.R); err != nil {
+	if _, err := io.Copy(wc, br); err != nil {
 		return fmt.Errorf("sending mail data failed: %w", err)
 	}
 	if err := wc.Close(); err != nil {
@@ -487,6 +1296,9 @@ func SendRaw(ctx context.Context, raw io.Reader, smtpAddr string) error {
 	return nil
 }
 
+// parseAddressList returns the bare addresses in list. If list is not a
+// valid RFC 5322 address list, it returns the entries as split by
+// splitAddressList instead, for extractAddr to make sense of.
 func parseAddressList(list string) []string {
 	if list == "" {
 		return nil
@@ -494,15 +1306,7 @@ func parseAddressList(list string) []string {
 
 	addrList, err := mail.ParseAddressList(list)
 	if err != nil {
-		// fallback: try to split by comma if parse fails
-		spl := regexp.MustCompile(`\s*,\s*`).Split(list, -1)
-		var out []string
-		for _, s := range spl {
-			if s = strings.TrimSpace(s); s != "" {
-				out = append(out, s)
-			}
-		}
-		return out
+		return splitAddressList(list)
 	}
 	out := make([]string, 0, len(addrList))
 	for _, a := range addrList {
@@ -512,12 +1316,25 @@ func parseAddressList(list string) []string {
 	return out
 }
 
+// AddressParser returns the bare address for an address in a format that
+// net/mail does not accept; see EmailConfig.AddressParser.
+type AddressParser func(addr string) (string, error)
+
 // extractAddr extracts only the email address part (no name/comment).
 func extractAddr(addr string) (string, error) {
+	return extractAddrWith(addr, nil)
+}
+
+// extractAddrWith is like extractAddr, but an address that net/mail cannot
+// parse is given to parse, if set, before falling back to heuristics.
+func extractAddrWith(addr string, parse AddressParser) (string, error) {
 	a, err := mail.ParseAddress(addr)
 	if err == nil {
 		return a.Address, nil
 	}
+	if parse != nil {
+		return parse(addr)
+	}
 	// fallback: try <address> pattern or return as is if looks like an email
 	re := regexp.MustCompile(`<([^>]+)>`)
 	m := re.FindStringSubmatch(addr)
@@ -533,36 +1350,17 @@ func extractAddr(addr string) (string, error) {
 	return "", errors.New("invalid address format")
 }
 
-// writeTextPart writes the text body with quoted-printable encoding when needed
-func writeTextPart(w io.Writer, t *tpl.Template, data any) error {
-	var bodyBuf bytes.Buffer
-	if err := t.Execute(&bodyBuf, data); err != nil {
-		return fmt.Errorf("failed to execute template: %w", err)
-	}
-
-	body := bodyBuf.String()
-
-	// Always use quoted-printable for non-ASCII content or long lines
-	if !isASCII(body) || hasLongLines(body) {
-		qpWriter := quotedprintable.NewWriter(w)
-		defer qpWriter.Close()
-		if _, err := qpWriter.Write([]byte(body)); err != nil {
-			return fmt.Errorf("failed to write quoted-printable: %w", err)
-		}
-		return nil
-	}
-
-	if _, err := w.Write([]byte(body)); err != nil {
-		return fmt.Errorf("failed to write body: %w", err)
-	}
-	return nil
+// writeTextPart writes the text body with quoted-printable encoding, wrapped
+// at width columns, when needed.
+func writeTextPart(w io.Writer, body string, width int) error {
+	return writeEncoded(w, body, transferEncoding(body, width), width)
 }
 
-// hasLongLines checks if any line in the text exceeds 76 characters
-func hasLongLines(text string) bool {
+// hasLongLines checks if any line in the text exceeds width characters
+func hasLongLines(text string, width int) bool {
 	lines := strings.Split(text, "\n")
 	for _, line := range lines {
-		if len(line) > maxContentLength {
+		if len(line) > width {
 			return true
 		}
 	}
@@ -2,74 +2,241 @@ package pigeon
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"mime/multipart"
 	"net"
+	"net/mail"
 	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"text/template"
 	"time"
+
+	"github.com/dotarpa/pigeon/tpl"
 )
 
-func startMockSMTP(t *testing.T) (addr string, received <-chan string, teardown func()) {
+// mockSMTP is a minimal scriptable SMTP server for tests. The exported fields
+// may be set before calling start to change its behavior.
+type mockSMTP struct {
+	// Banner replaces the default "220" greeting.
+	Banner string
+	// Extensions are advertised in the EHLO response.
+	Extensions []string
+	// Replies overrides the reply to a command, keyed by its upper-case verb
+	// (e.g. "RCPT", "DATA"); "." is the reply sent after the message data.
+	Replies map[string]string
+	// KeepCRLF preserves the line endings of the message data; by default
+	// they are normalized to LF.
+	KeepCRLF bool
+	// TLSConfig enables STARTTLS with the given server configuration.
+	TLSConfig *tls.Config
+	// Stall lists the verbs of commands that get no reply, as from a server
+	// that has silently gone away.
+	Stall []string
+
+	addr     string
+	received chan string
+	ln       net.Listener
+
+	mu       sync.Mutex
+	commands []string
+	remotes  []string
+}
+
+// start begins accepting connections; the server is closed when the test ends.
+func (m *mockSMTP) start(t testing.TB) {
 	t.Helper()
 	ln, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
 		t.Fatalf("Listen: %v", err)
 	}
-	ch := make(chan string, 1)
+	m.ln = ln
+	m.addr = ln.Addr().String()
+	m.received = make(chan string, 16)
+	t.Cleanup(m.close)
 
 	go func() {
-		conn, err := ln.Accept()
-		if err != nil {
-			return
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			m.mu.Lock()
+			m.remotes = append(m.remotes, conn.RemoteAddr().String())
+			m.mu.Unlock()
+			go m.serve(conn)
 		}
-		defer conn.Close()
-		reader := bufio.NewReader(conn)
-		writer := bufio.NewWriter(conn)
+	}()
+}
 
-		fmt.Fprintf(writer, "220 localhost SimpleSMTP\r\n")
-		writer.Flush()
+func (m *mockSMTP) close() { m.ln.Close() }
 
-		var data strings.Builder
-		inData := false
-		for {
-			line, _ := reader.ReadString('\n')
-			line = strings.TrimRight(line, "\r\n")
-			if !inData {
-				switch {
-				case strings.HasPrefix(strings.ToUpper(line), "HELO"),
-					strings.HasPrefix(strings.ToUpper(line), "EHLO"):
-					fmt.Fprintf(writer, "250 OK\r\n")
-				case strings.HasPrefix(strings.ToUpper(line), "MAIL FROM"),
-					strings.HasPrefix(strings.ToUpper(line), "RCPT TO"):
-					fmt.Fprintf(writer, "250 OK\r\n")
-				case strings.HasPrefix(strings.ToUpper(line), "DATA"):
-					fmt.Fprintf(writer, "354 End data with <CR><LF>.<CR><LF>\r\n")
-					inData = true
-				case strings.HasPrefix(strings.ToUpper(line), "QUIT"):
-					fmt.Fprintf(writer, "221 Bye\r\n")
-					writer.Flush()
-					return
-				default:
-					fmt.Fprintf(writer, "250 OK\r\n")
-				}
+// smarthost returns the server address as a HostPort.
+func (m *mockSMTP) smarthost() HostPort {
+	var hp HostPort
+	hp.Host, hp.Port, _ = net.SplitHostPort(m.addr)
+	return hp
+}
+
+// Commands returns every command line received so far.
+func (m *mockSMTP) Commands() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.commands...)
+}
+
+// RemoteAddrs returns the client address of every accepted connection.
+func (m *mockSMTP) RemoteAddrs() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.remotes...)
+}
+
+func (m *mockSMTP) reply(verb, def string) string {
+	if r, ok := m.Replies[verb]; ok {
+		return r
+	}
+	return def
+}
+
+func (m *mockSMTP) serve(conn net.Conn) {
+	defer func() { conn.Close() }()
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+	tlsActive := false
+
+	banner := m.Banner
+	if banner == "" {
+		banner = "220 localhost SimpleSMTP"
+	}
+	fmt.Fprintf(writer, "%s\r\n", banner)
+	writer.Flush()
+
+	var data strings.Builder
+	inData := false
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		raw := line
+		line = strings.TrimRight(line, "\r\n")
+		if inData {
+			if line == "." {
+				// end of data
+				fmt.Fprintf(writer, "%s\r\n", m.reply(".", "250 OK"))
 				writer.Flush()
+				m.received <- data.String()
+				data.Reset()
+				inData = false
+			} else if m.KeepCRLF {
+				data.WriteString(raw)
 			} else {
-				if line == "." {
-					// end of data
-					fmt.Fprintf(writer, "250 OK\r\n")
-					writer.Flush()
-					ch <- data.String()
-					inData = false
-				} else {
-					data.WriteString(line + "\n")
+				data.WriteString(line + "\n")
+			}
+			continue
+		}
+
+		m.mu.Lock()
+		m.commands = append(m.commands, line)
+		m.mu.Unlock()
+
+		verb, _, _ := strings.Cut(strings.ToUpper(line), " ")
+		if slices.Contains(m.Stall, verb) {
+			continue
+		}
+		switch verb {
+		case "EHLO":
+			if r, ok := m.Replies[verb]; ok {
+				fmt.Fprintf(writer, "%s\r\n", r)
+				break
+			}
+			exts := m.Extensions
+			if m.TLSConfig != nil && !tlsActive {
+				exts = append([]string{"STARTTLS"}, exts...)
+			}
+			if len(exts) == 0 {
+				fmt.Fprintf(writer, "250 OK\r\n")
+				break
+			}
+			fmt.Fprintf(writer, "250-localhost\r\n")
+			for i, ext := range exts {
+				sep := "-"
+				if i == len(exts)-1 {
+					sep = " "
 				}
+				fmt.Fprintf(writer, "250%s%s\r\n", sep, ext)
+			}
+		case "STARTTLS":
+			if m.TLSConfig == nil || tlsActive {
+				fmt.Fprintf(writer, "502 Not implemented\r\n")
+				break
+			}
+			fmt.Fprintf(writer, "220 Ready to start TLS\r\n")
+			writer.Flush()
+			tlsConn := tls.Server(conn, m.TLSConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn, tlsActive = tlsConn, true
+			reader = bufio.NewReader(conn)
+			writer = bufio.NewWriter(conn)
+			continue
+		case "BDAT":
+			// Chunk data follows the command line; it is delivered
+			// as received, without line ending normalization.
+			fields := strings.Fields(line)
+			n, err := strconv.Atoi(fields[1])
+			if err != nil {
+				fmt.Fprintf(writer, "501 Syntax error\r\n")
+				break
 			}
+			chunk := make([]byte, n)
+			if _, err := io.ReadFull(reader, chunk); err != nil {
+				return
+			}
+			data.Write(chunk)
+			if len(fields) > 2 && strings.EqualFold(fields[2], "LAST") {
+				m.received <- data.String()
+				data.Reset()
+			}
+			fmt.Fprintf(writer, "%s\r\n", m.reply(verb, "250 OK"))
+		case "DATA":
+			r := m.reply(verb, "354 End data with <CR><LF>.<CR><LF>")
+			fmt.Fprintf(writer, "%s\r\n", r)
+			inData = strings.HasPrefix(r, "354")
+		case "XCLIENT":
+			// The server greets the client anew after XCLIENT.
+			fmt.Fprintf(writer, "%s\r\n", m.reply(verb, "220 localhost SimpleSMTP"))
+		case "QUIT":
+			fmt.Fprintf(writer, "%s\r\n", m.reply(verb, "221 Bye"))
+			writer.Flush()
+			return
+		default:
+			fmt.Fprintf(writer, "%s\r\n", m.reply(verb, "250 OK"))
 		}
-	}()
+		writer.Flush()
+	}
+}
 
-	return ln.Addr().String(), ch, func() { ln.Close() }
+func startMockSMTP(t *testing.T) (addr string, received <-chan string, teardown func()) {
+	t.Helper()
+	m := &mockSMTP{}
+	m.start(t)
+	return m.addr, m.received, m.close
 }
 
 func TestSend_Basic(t *testing.T) {
@@ -119,7 +286,7 @@ func TestSend_Basic(t *testing.T) {
 }
 
 // tplWriteTemp is helper creating temp file with given content.
-func tplWriteTemp(t *testing.T, content string) string {
+func tplWriteTemp(t testing.TB, content string) string {
 	t.Helper()
 	f, err := os.CreateTemp(t.TempDir(), "e2e-*.tmpl")
 	if err != nil {
@@ -460,3 +627,1131 @@ func TestSend_QuotedPrintableExactly76Chars(t *testing.T) {
 		t.Fatal("no message received by mock SMTP")
 	}
 }
+
+func TestSend_LocalAddr(t *testing.T) {
+	m := &mockSMTP{}
+	m.start(t)
+
+	// Reserve a free port to bind the client side of the connection to.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	localAddr := ln.Addr().String()
+	ln.Close()
+
+	tmplPath := tplWriteTemp(t, "From: sender@example.com\nTo: recv@example.com\nSub: LocalAddr\n\nBody.")
+	cfg := EmailConfig{
+		Smarthost:    m.smarthost(),
+		LocalAddr:    localAddr,
+		TemplatePath: tmplPath,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := Send(ctx, cfg, nil); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+	if remotes := m.RemoteAddrs(); len(remotes) != 1 || remotes[0] != localAddr {
+		t.Errorf("connection came from %v, want %s", remotes, localAddr)
+	}
+}
+
+func TestSend_LocalAddrNotLocal(t *testing.T) {
+	tmplPath := tplWriteTemp(t, "From: sender@example.com\nTo: recv@example.com\nSub: LocalAddr\n\nBody.")
+	cfg := EmailConfig{
+		Smarthost:    HostPort{Host: "127.0.0.1", Port: "25"},
+		LocalAddr:    "192.0.2.1",
+		TemplatePath: tmplPath,
+	}
+
+	retry, err := Send(context.Background(), cfg, nil)
+	if err == nil || !strings.Contains(err.Error(), "not assigned to any interface") {
+		t.Fatalf("expected local address error, got %v", err)
+	}
+	if retry {
+		t.Errorf("expected retry=false for invalid local address")
+	}
+}
+
+func TestSend_HeaderOrder(t *testing.T) {
+	addr, recv, teardown := startMockSMTP(t)
+	defer teardown()
+
+	tmplContent := "Sub: Order\nX-Second: {{ .Second }}\nTo: recv@example.com\nReply-To: reply@example.com\nFrom: sender@example.com\n\nBody."
+	tmplPath := tplWriteTemp(t, tmplContent)
+
+	smarthost := HostPort{}
+	smarthost.Host, smarthost.Port, _ = net.SplitHostPort(addr)
+
+	cfg := EmailConfig{
+		Smarthost:    smarthost,
+		TemplatePath: tmplPath,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := Send(ctx, cfg, map[string]string{"Second": "two"}); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+
+	select {
+	case raw := <-recv:
+		var keys []string
+		for _, l := range strings.Split(raw, "\n") {
+			if l == "" {
+				break
+			}
+			k, _, _ := strings.Cut(l, ":")
+			keys = append(keys, k)
+		}
+		want := []string{"Subject", "X-Second", "To", "Reply-To", "From", "Date", "Mime-Version", "Content-Type", "Content-Transfer-Encoding", "X-Mailer"}
+		if strings.Join(keys, ",") != strings.Join(want, ",") {
+			t.Errorf("header order = %v, want %v", keys, want)
+		}
+		if !strings.Contains(raw, "X-Second: two\n") {
+			t.Errorf("templated extra header not rendered: %s", raw)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no message received by mock SMTP")
+	}
+}
+
+func TestSend_ConcurrentNoBleed(t *testing.T) {
+	m := &mockSMTP{}
+	m.start(t)
+
+	tmplPath := tplWriteTemp(t, "From: sender@example.com\nTo: recv@example.com\nSub: msg-{{ .ID }}\n\nbody-{{ .ID }}-{{ .Pad }}")
+	cfg := EmailConfig{
+		Smarthost:    m.smarthost(),
+		TemplatePath: tmplPath,
+	}
+
+	const n = 20
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			// Vary the body size so pooled buffers of different sizes are reused.
+			data := map[string]any{"ID": i, "Pad": strings.Repeat("x", i*10)}
+			_, err := Send(ctx, cfg, data)
+			errs <- err
+		}(i)
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i < n; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("Send error: %v", err)
+		}
+		select {
+		case raw := <-m.received:
+			var id string
+			for _, l := range strings.Split(raw, "\n") {
+				if strings.HasPrefix(l, "Subject: msg-") {
+					id = strings.TrimPrefix(l, "Subject: msg-")
+				}
+			}
+			if id == "" {
+				t.Fatalf("subject missing: %s", raw)
+			}
+			if strings.Count(raw, "body-") != 1 || !strings.Contains(raw, "body-"+id+"-") {
+				t.Errorf("message %s has mismatched body: %s", id, raw)
+			}
+			seen[id] = true
+		case <-time.After(2 * time.Second):
+			t.Fatal("no message received by mock SMTP")
+		}
+	}
+	if len(seen) != n {
+		t.Errorf("received %d distinct messages, want %d", len(seen), n)
+	}
+}
+
+func BenchmarkSend(b *testing.B) {
+	m := &mockSMTP{}
+	m.start(b)
+	go func() {
+		for range m.received {
+		}
+	}()
+
+	tmplPath := tplWriteTemp(b, "From: {{ .From }}\nTo: recv@example.com\nSub: Bench {{ .N }}\n\nHello {{ .Name }},\n"+strings.Repeat("line of text\n", 50))
+	cfg := EmailConfig{
+		Smarthost:    m.smarthost(),
+		TemplatePath: tmplPath,
+	}
+	data := map[string]any{"From": "sender@example.com", "Name": "Bench", "N": 1}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Send(context.Background(), cfg, data); err != nil {
+			b.Fatalf("Send error: %v", err)
+		}
+	}
+}
+
+func TestRenderHeader_ConfigFallbackCached(t *testing.T) {
+	tmplPath := tplWriteTemp(t, "Sub: cached\n\nBody.")
+	tp, err := tpl.ParseFile(tmplPath)
+	if err != nil {
+		t.Fatalf("ParseFile error: %v", err)
+	}
+
+	var cfg EmailConfig
+	const fallback = "{{ .Name }} <fallback-cache@example.com>"
+	for _, name := range []string{"Alice", "Bob"} {
+		got, err := renderHeader(tp, cfg, "From", fallback, map[string]string{"Name": name})
+		if err != nil {
+			t.Fatalf("renderHeader error: %v", err)
+		}
+		if want := name + " <fallback-cache@example.com>"; got != want {
+			t.Errorf("renderHeader = %q, want %q", got, want)
+		}
+	}
+	if _, ok := headerTemplates.get("From\x00" + fallback + "\x00" + funcNames(cfg.templateFuncs())); !ok {
+		t.Errorf("config-supplied header template was not cached")
+	}
+}
+
+func TestTemplateCache_Evicts(t *testing.T) {
+	c := &templateCache{max: 2}
+	for _, key := range []string{"a", "b"} {
+		c.add(key, template.New(key))
+	}
+	c.get("a") // b is now the least recently used
+	c.add("c", template.New("c"))
+
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, ok := c.get(key); ok != want {
+			t.Errorf("get(%q) ok = %v, want %v", key, ok, want)
+		}
+	}
+	if n := c.order.Len(); n != 2 {
+		t.Errorf("cache holds %d templates, want 2", n)
+	}
+}
+
+func TestSend_TemplateText(t *testing.T) {
+	m := &mockSMTP{}
+	m.start(t)
+	cfg := EmailConfig{
+		Smarthost:    m.smarthost(),
+		TemplateText: "From: a@example.com\nTo: b@example.com\nSubject: Inline {{ .Name }}\n\nHello, {{ .Name }}!",
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := Send(ctx, cfg, map[string]string{"Name": "Bob"}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(<-m.received))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	body, _ := io.ReadAll(msg.Body)
+	if got := msg.Header.Get("Subject"); got != "Inline Bob" || strings.TrimSpace(string(body)) != "Hello, Bob!" {
+		t.Errorf("Subject = %q, body = %q", got, body)
+	}
+
+	// TemplatePath takes precedence over the inline template.
+	cfg.TemplatePath = tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: From file\n\nbody")
+	raw, err := BuildMessage(cfg, map[string]string{"Name": "Bob"})
+	if err != nil {
+		t.Fatalf("BuildMessage: %v", err)
+	}
+	if !bytes.Contains(raw, []byte("Subject: From file\r\n")) {
+		t.Errorf("TemplatePath not preferred:\n%s", raw)
+	}
+}
+
+func TestSend_AddressParser(t *testing.T) {
+	m := &mockSMTP{}
+	m.start(t)
+	// Normalizes the "user at example dot com" format of a legacy system.
+	parse := func(addr string) (string, error) {
+		user, domain, ok := strings.Cut(strings.TrimSpace(addr), " at ")
+		if !ok {
+			return "", fmt.Errorf("unrecognized address %q", addr)
+		}
+		return user + "@" + strings.ReplaceAll(domain, " dot ", "."), nil
+	}
+	cfg := EmailConfig{
+		Smarthost:     m.smarthost(),
+		AddressParser: parse,
+		TemplatePath:  tplWriteTemp(t, "From: alerts@example.com\nTo: bob at example dot com, carol@example.com\nSubject: Legacy\n\nBody."),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := Send(ctx, cfg, nil); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	<-m.received
+	var rcpts []string
+	for _, cmd := range m.Commands() {
+		if addr, ok := strings.CutPrefix(cmd, "RCPT TO:"); ok {
+			rcpts = append(rcpts, addr)
+		}
+	}
+	if want := []string{"<bob@example.com>", "<carol@example.com>"}; !reflect.DeepEqual(rcpts, want) {
+		t.Errorf("RCPT TO %v, want %v", rcpts, want)
+	}
+
+	// The parser's error is reported rather than falling back to heuristics.
+	cfg.TemplatePath = tplWriteTemp(t, "From: alerts@example.com\nTo: <bob@example.com\nSubject: Legacy\n\nBody.")
+	if _, err := BuildMessage(cfg, nil); err == nil || !strings.Contains(err.Error(), "unrecognized address") {
+		t.Errorf("BuildMessage error = %v, want parser error", err)
+	}
+}
+
+func TestSend_NonASCIICustomHeaders(t *testing.T) {
+	m := &mockSMTP{}
+	m.start(t)
+
+	tmplPath := tplWriteTemp(t, "From: sender@example.com\nTo: recv@example.com\nSub: 件名のテスト\n\nBody.")
+	cfg := EmailConfig{
+		Smarthost:    m.smarthost(),
+		TemplatePath: tmplPath,
+		Headers: map[string]string{
+			"X-Original-Subject": "元の件名",
+			"Reply-To":           "山田 太郎 <taro@example.com>",
+			"X-Plain":            "ascii only",
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := Send(ctx, cfg, nil); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+
+	select {
+	case raw := <-m.received:
+		msg, err := mail.ReadMessage(strings.NewReader(raw))
+		if err != nil {
+			t.Fatalf("ReadMessage: %v", err)
+		}
+		dec := new(mime.WordDecoder)
+
+		orig := msg.Header.Get("X-Original-Subject")
+		if !strings.HasPrefix(orig, "=?UTF-8?q?") {
+			t.Errorf("X-Original-Subject not encoded-word wrapped: %q", orig)
+		}
+		if got, err := dec.DecodeHeader(orig); err != nil || got != "元の件名" {
+			t.Errorf("X-Original-Subject decodes to %q (%v)", got, err)
+		}
+
+		replyTo := msg.Header.Get("Reply-To")
+		if !strings.HasSuffix(replyTo, " <taro@example.com>") || !isASCII(replyTo) {
+			t.Errorf("Reply-To address not preserved or not encoded: %q", replyTo)
+		}
+		if addr, err := msg.Header.AddressList("Reply-To"); err != nil || addr[0].Name != "山田 太郎" {
+			t.Errorf("Reply-To parses to %v (%v)", addr, err)
+		}
+
+		if got := msg.Header.Get("X-Plain"); got != "ascii only" {
+			t.Errorf("X-Plain = %q", got)
+		}
+		if got, err := dec.DecodeHeader(msg.Header.Get("Subject")); err != nil || got != "件名のテスト" {
+			t.Errorf("Subject decodes to %q (%v)", got, err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no message received by mock SMTP")
+	}
+}
+
+func TestBuildMessage_ParsedTemplate(t *testing.T) {
+	parsed, err := tpl.ParseReader("stdin", strings.NewReader("From: a@example.com\nTo: b@example.com\nSubject: Piped\n\nHello, {{.Name}}"))
+	if err != nil {
+		t.Fatalf("ParseReader: %v", err)
+	}
+
+	// Template takes precedence over TemplatePath.
+	cfg := EmailConfig{Template: parsed, TemplatePath: "/nonexistent.tmpl"}
+	msg, err := BuildMessage(cfg, map[string]string{"Name": "Bob"})
+	if err != nil {
+		t.Fatalf("BuildMessage: %v", err)
+	}
+	if !strings.Contains(string(msg), "Subject: Piped\r\n") || !strings.HasSuffix(string(msg), "\r\n\r\nHello, Bob") {
+		t.Errorf("unexpected message:\n%s", msg)
+	}
+}
+
+func TestWriteEML_LineEnding(t *testing.T) {
+	m := &mockSMTP{KeepCRLF: true}
+	m.start(t)
+
+	cfg := EmailConfig{
+		Smarthost:    m.smarthost(),
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Lines\n\nfirst\nsecond\n"),
+		LineEnding:   "lf",
+	}
+
+	path := filepath.Join(t.TempDir(), "out.eml")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteEML(f, cfg, nil); err != nil {
+		t.Fatalf("WriteEML: %v", err)
+	}
+	f.Close()
+	eml, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(eml, []byte("\r")) {
+		t.Errorf(".eml contains CR with LineEnding=lf: %q", eml)
+	}
+	if !bytes.Contains(eml, []byte("Subject: Lines\n")) || !bytes.HasSuffix(eml, []byte("\n\nfirst\nsecond\n")) {
+		t.Errorf("unexpected .eml content: %q", eml)
+	}
+
+	// Send ignores LineEnding and always transmits CRLF.
+	if _, err := Send(context.Background(), cfg, nil); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	raw := <-m.received
+	if got := strings.Count(raw, "\n"); got == 0 || got != strings.Count(raw, "\r\n") {
+		t.Errorf("transmitted message has bare LF line endings: %q", raw)
+	}
+	if want := strings.ReplaceAll(string(eml), "\n", "\r\n"); raw != want {
+		t.Errorf("transmitted message differs from .eml:\ngot  %q\nwant %q", raw, want)
+	}
+
+	cfg.LineEnding = "cr"
+	if err := WriteEML(io.Discard, cfg, nil); err == nil {
+		t.Error("expected error for invalid LineEnding")
+	}
+}
+
+func TestBuildMessage_CRLFBody(t *testing.T) {
+	cfg := EmailConfig{
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Lines\n\nline1\nline2\n"),
+	}
+	raw, err := BuildMessage(cfg, nil)
+	if err != nil {
+		t.Fatalf("BuildMessage: %v", err)
+	}
+	if n := bytes.Count(raw, []byte("\n")); n != bytes.Count(raw, []byte("\r\n")) {
+		t.Errorf("message has bare LF line endings: %q", raw)
+	}
+	if !bytes.HasSuffix(raw, []byte("\r\n\r\nline1\r\nline2\r\n")) {
+		t.Errorf("body does not have CRLF line endings: %q", raw)
+	}
+}
+
+func TestBuildMessage_ThreadingHeaders(t *testing.T) {
+	cfg := EmailConfig{
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Re: Status\n\nUpdate"),
+		InReplyTo:    "<3@example.com>",
+		References:   []string{"<1@example.com>", "<2.abc@mail.example.com>", "<3@example.com>"},
+	}
+	raw, err := BuildMessage(cfg, nil)
+	if err != nil {
+		t.Fatalf("BuildMessage: %v", err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if got := msg.Header.Get("In-Reply-To"); got != "<3@example.com>" {
+		t.Errorf("In-Reply-To = %q", got)
+	}
+	if got := msg.Header.Get("References"); got != "<1@example.com> <2.abc@mail.example.com> <3@example.com>" {
+		t.Errorf("References = %q", got)
+	}
+
+	for _, bad := range []string{"3@example.com", "<3example.com>", "<3@example.com", "<a b@example.com>"} {
+		cfg.InReplyTo = bad
+		if _, err := BuildMessage(cfg, nil); err == nil {
+			t.Errorf("InReplyTo %q: expected error", bad)
+		}
+	}
+	cfg.InReplyTo = ""
+	cfg.References = []string{"<1@example.com>", "2@example.com"}
+	if _, err := BuildMessage(cfg, nil); err == nil {
+		t.Error("expected error for unbracketed References entry")
+	}
+}
+
+func TestBuildMessage_ConditionalCustomHeaders(t *testing.T) {
+	cfg := EmailConfig{
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Alert\n\nbody"),
+		Headers: map[string]string{
+			"X-Priority":  "{{ if .Urgent }}1{{ end }}",
+			"X-Ticket":    "  {{ .Ticket }}  ",
+			"X-App":       "pigeon",
+			"X-Blank-Lit": "   ",
+		},
+	}
+
+	tests := []struct {
+		data         map[string]any
+		wantPriority bool
+	}{
+		{map[string]any{"Urgent": true, "Ticket": "OPS-1"}, true},
+		{map[string]any{"Urgent": false, "Ticket": "OPS-1"}, false},
+	}
+	for _, tt := range tests {
+		raw, err := BuildMessage(cfg, tt.data)
+		if err != nil {
+			t.Fatalf("BuildMessage: %v", err)
+		}
+		msg, err := mail.ReadMessage(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatalf("ReadMessage: %v", err)
+		}
+		_, hasPriority := msg.Header["X-Priority"]
+		if hasPriority != tt.wantPriority {
+			t.Errorf("Urgent=%v: X-Priority present = %v, want %v", tt.data["Urgent"], hasPriority, tt.wantPriority)
+		}
+		if tt.wantPriority && msg.Header.Get("X-Priority") != "1" {
+			t.Errorf("X-Priority = %q, want 1", msg.Header.Get("X-Priority"))
+		}
+		if got := msg.Header.Get("X-Ticket"); got != "OPS-1" {
+			t.Errorf("X-Ticket = %q, want OPS-1", got)
+		}
+		if got := msg.Header.Get("X-App"); got != "pigeon" {
+			t.Errorf("X-App = %q, want pigeon", got)
+		}
+		if _, ok := msg.Header["X-Blank-Lit"]; ok {
+			t.Error("whitespace-only header should be omitted")
+		}
+	}
+}
+
+func TestBuildMessage_DedupAttachments(t *testing.T) {
+	dir := t.TempDir()
+	report := filepath.Join(dir, "report.txt")
+	copyOf := filepath.Join(dir, "report-copy.txt")
+	other := filepath.Join(dir, "other.txt")
+	for path, content := range map[string]string{report: "numbers", copyOf: "numbers", other: "different"} {
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var logs bytes.Buffer
+	cfg := EmailConfig{
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Files\n\nSee attached."),
+		// The same file twice (once via a relative-looking path), a copy
+		// with identical content and a distinct file.
+		Attachments: []string{report, filepath.Join(dir, ".", "report.txt"), copyOf, other},
+		Logger:      slog.New(slog.NewTextHandler(&logs, nil)),
+	}
+
+	countParts := func(raw []byte) []string {
+		msg, err := mail.ReadMessage(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatalf("ReadMessage: %v", err)
+		}
+		_, params, _ := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+		mr := multipart.NewReader(msg.Body, params["boundary"])
+		var names []string
+		for {
+			p, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+			if p.FileName() != "" {
+				names = append(names, p.FileName())
+			}
+		}
+		return names
+	}
+
+	raw, err := BuildMessage(cfg, nil)
+	if err != nil {
+		t.Fatalf("BuildMessage: %v", err)
+	}
+	if got := countParts(raw); len(got) != 4 {
+		t.Errorf("without dedup got attachments %v, want all 4", got)
+	}
+
+	cfg.DedupAttachments = true
+	raw, err = BuildMessage(cfg, nil)
+	if err != nil {
+		t.Fatalf("BuildMessage: %v", err)
+	}
+	if got := countParts(raw); strings.Join(got, ",") != "report.txt,other.txt" {
+		t.Errorf("with dedup got attachments %v, want [report.txt other.txt]", got)
+	}
+	if n := strings.Count(logs.String(), "skipping"); n != 2 {
+		t.Errorf("logged %d skipped attachments, want 2:\n%s", n, logs.String())
+	}
+}
+
+func TestSend_SenderForMultipleFrom(t *testing.T) {
+	m := &mockSMTP{}
+	m.start(t)
+
+	cfg := EmailConfig{
+		Smarthost:    m.smarthost(),
+		TemplatePath: tplWriteTemp(t, "From: Alice <alice@example.com>, Bob <bob@example.com>\nTo: team@example.com\nSubject: Joint update\n\nHello"),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := Send(ctx, cfg, nil); err == nil || !strings.Contains(err.Error(), "Sender") {
+		t.Fatalf("Send without Sender = %v, want Sender required error", err)
+	}
+
+	cfg.Sender = "Alice <alice@example.com>, carol@example.com"
+	if _, err := Send(ctx, cfg, nil); err == nil || !strings.Contains(err.Error(), "single address") {
+		t.Fatalf("Send with two Sender addresses = %v, want error", err)
+	}
+
+	cfg.Sender = "Assistant <assistant@example.com>"
+	if _, err := Send(ctx, cfg, nil); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	raw := <-m.received
+	if !strings.Contains(raw, "\nSender: Assistant <assistant@example.com>\n") {
+		t.Errorf("Sender header missing:\n%s", raw)
+	}
+	var mail string
+	for _, cmd := range m.Commands() {
+		if strings.HasPrefix(cmd, "MAIL FROM:") {
+			mail = cmd
+		}
+	}
+	if mail != "MAIL FROM:<alice@example.com>" {
+		t.Errorf("MAIL command = %q, want the first From address", mail)
+	}
+}
+
+func TestSend_PreEncodedSubject(t *testing.T) {
+	tests := []struct {
+		name    string
+		subject string
+		want    string // transmitted verbatim if set
+		decoded string
+	}{
+		{name: "pre-encoded", subject: "=?UTF-8?B?5pel5aCx?=", want: "=?UTF-8?B?5pel5aCx?=", decoded: "日報"},
+		{name: "plain", subject: "日報 for Monday", decoded: "日報 for Monday"},
+		{name: "mixed", subject: "=?UTF-8?B?5pel5aCx?= – Monday", decoded: "日報 – Monday"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &mockSMTP{}
+			m.start(t)
+			cfg := EmailConfig{
+				Smarthost:    m.smarthost(),
+				TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: "+tt.subject+"\n\nbody"),
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if _, err := Send(ctx, cfg, nil); err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+
+			msg, err := mail.ReadMessage(strings.NewReader(<-m.received))
+			if err != nil {
+				t.Fatalf("ReadMessage: %v", err)
+			}
+			got := msg.Header.Get("Subject")
+			if tt.want != "" && got != tt.want {
+				t.Errorf("Subject = %q, want %q unchanged", got, tt.want)
+			}
+			// Encoded once: decoding yields the text, not another encoded-word.
+			dec, err := new(mime.WordDecoder).DecodeHeader(got)
+			if err != nil || dec != tt.decoded {
+				t.Errorf("Subject %q decodes to (%q, %v), want %q", got, dec, err, tt.decoded)
+			}
+		})
+	}
+}
+
+func TestBuildMessage_ConfigValueFuncs(t *testing.T) {
+	tmplPath := tplWriteTemp(t, "To: b@example.com\nSubject: Funcs\n\nbody")
+	if err := os.WriteFile(filepath.Join(filepath.Dir(tmplPath), "team.txt"), []byte("Ops"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, sep := range []string{"-", "_"} {
+		cfg := EmailConfig{
+			TemplatePath: tmplPath,
+			From:         `{{ include "team.txt" }} <ops@example.com>`,
+			Headers: map[string]string{
+				"X-Campaign": "{{ slugify .Name }}",
+				"X-Tags":     `{{ join "," .Tags }}`,
+			},
+			TemplateFuncs: template.FuncMap{
+				"slugify": func(s string) string { return strings.ReplaceAll(strings.ToLower(s), " ", sep) },
+			},
+		}
+		raw, err := BuildMessage(cfg, map[string]any{"Name": "Spring Sale", "Tags": []string{"a", "b"}})
+		if err != nil {
+			t.Fatalf("BuildMessage: %v", err)
+		}
+		msg, err := mail.ReadMessage(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatalf("ReadMessage: %v", err)
+		}
+		// Each config calls its own slugify, although the parse is cached.
+		for k, want := range map[string]string{"From": "Ops <ops@example.com>", "X-Campaign": "spring" + sep + "sale", "X-Tags": "a,b"} {
+			if got := msg.Header.Get(k); got != want {
+				t.Errorf("%s = %q, want %q", k, got, want)
+			}
+		}
+	}
+}
+
+func TestBuildMessage_HeaderFoldWidth(t *testing.T) {
+	subject := "Rapport hebdomadaire: état des serveurs de production, incidents résolus et tâches planifiées"
+	refs := []string{"<20240101.0001@mail.example.com>", "<20240102.0002@mail.example.com>", "<20240103.0003@mail.example.com>"}
+	cfg := EmailConfig{
+		TemplatePath:    tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: "+subject+"\n\nbody"),
+		References:      refs,
+		Headers:         map[string]string{"X-Long": strings.Repeat("word ", 30)},
+		HeaderFoldWidth: 72,
+	}
+	raw, err := BuildMessage(cfg, nil)
+	if err != nil {
+		t.Fatalf("BuildMessage: %v", err)
+	}
+
+	head, _, _ := strings.Cut(string(raw), "\r\n\r\n")
+	folded := 0
+	for _, line := range strings.Split(head, "\r\n") {
+		if len(line) > 72 {
+			t.Errorf("line exceeds 72 columns (%d): %q", len(line), line)
+		}
+		if strings.HasPrefix(line, " ") {
+			folded++
+		}
+		// Every encoded-word starts and ends on the same line.
+		for _, w := range strings.Fields(line) {
+			if strings.HasPrefix(w, "=?") != strings.HasSuffix(w, "?=") {
+				t.Errorf("encoded-word split across lines: %q", line)
+			}
+		}
+	}
+	if folded == 0 {
+		t.Fatal("expected folded header lines")
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	dec, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || dec != subject {
+		t.Errorf("Subject = (%q, %v), want %q", dec, err, subject)
+	}
+	if got := msg.Header.Get("References"); got != strings.Join(refs, " ") {
+		t.Errorf("References = %q", got)
+	}
+	if got := msg.Header.Get("X-Long"); got != strings.TrimSpace(strings.Repeat("word ", 30)) {
+		t.Errorf("X-Long = %q", got)
+	}
+}
+
+func TestSend_MessageRewriter(t *testing.T) {
+	m := &mockSMTP{}
+	m.start(t)
+
+	cfg := EmailConfig{
+		Smarthost:    m.smarthost(),
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Rewrite\n\nHello"),
+		MessageRewriter: func(msg []byte) ([]byte, error) {
+			return append([]byte("X-Rewritten: yes\r\n"), msg...), nil
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := Send(ctx, cfg, nil); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	raw := <-m.received
+	msg, err := mail.ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if got := msg.Header.Get("X-Rewritten"); got != "yes" {
+		t.Errorf("X-Rewritten = %q, want yes", got)
+	}
+	if got := msg.Header.Get("Subject"); got != "Rewrite" {
+		t.Errorf("Subject = %q, want Rewrite", got)
+	}
+
+	cfg.MessageRewriter = func([]byte) ([]byte, error) { return nil, errors.New("boom") }
+	retry, err := Send(ctx, cfg, nil)
+	if err == nil || retry || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("Send with failing rewriter = (%v, %v), want permanent error", retry, err)
+	}
+}
+
+func TestBuildMessageEnvelope(t *testing.T) {
+	cfg := EmailConfig{
+		TemplatePath: tplWriteTemp(t, "From: Sender <sender@example.com>\n"+
+			"To: Alice <alice@example.com>, bob@example.com\n"+
+			"Cc: \"Bob, again\" <BOB@example.com>, carol@example.com\n"+
+			"Bcc: alice@example.com, dave@example.com\n"+
+			"Subject: Envelope\n\nHello"),
+	}
+	msg, env, err := BuildMessageEnvelope(cfg, nil)
+	if err != nil {
+		t.Fatalf("BuildMessageEnvelope: %v", err)
+	}
+	if len(msg) == 0 {
+		t.Error("empty message")
+	}
+	if env.From != "sender@example.com" {
+		t.Errorf("From = %q, want sender@example.com", env.From)
+	}
+	want := []string{"alice@example.com", "bob@example.com", "carol@example.com", "dave@example.com"}
+	if !slices.Equal(env.Rcpts, want) {
+		t.Errorf("Rcpts = %q, want %q", env.Rcpts, want)
+	}
+}
+
+func TestSendRaw_NonSeekable(t *testing.T) {
+	m := &mockSMTP{}
+	m.start(t)
+
+	raw := "From: Alice <alice@example.com>\r\n" +
+		"To: bob@example.com\r\n" +
+		"Subject: Raw message\r\n" +
+		"\r\n" +
+		"Hello, Bob\r\n"
+	// Hide the Seek method of strings.Reader, as for a pipe.
+	r := struct{ io.Reader }{strings.NewReader(raw)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := SendRaw(ctx, r, m.addr); err != nil {
+		t.Fatalf("SendRaw: %v", err)
+	}
+
+	got := <-m.received
+	if want := strings.ReplaceAll(raw, "\r\n", "\n"); got != want {
+		t.Errorf("received message:\n%q\nwant:\n%q", got, want)
+	}
+	var rcpt string
+	for _, cmd := range m.Commands() {
+		if strings.HasPrefix(cmd, "RCPT TO:") {
+			rcpt = cmd
+		}
+	}
+	if rcpt != "RCPT TO:<bob@example.com>" {
+		t.Errorf("RCPT command = %q", rcpt)
+	}
+}
+
+func TestSendRaw_Recipients(t *testing.T) {
+	m := &mockSMTP{}
+	m.start(t)
+
+	// Unparseable recipients are skipped, and only exact duplicates are
+	// dropped, since the local part of an address may be case-sensitive.
+	raw := "From: alice@example.com\r\n" +
+		"To: bob@example.com, not an address\r\n" +
+		"Cc: Bob@example.com, bob@example.com\r\n" +
+		"Subject: Raw message\r\n" +
+		"\r\n" +
+		"Hello\r\n"
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := SendRaw(ctx, strings.NewReader(raw), m.addr); err != nil {
+		t.Fatalf("SendRaw: %v", err)
+	}
+	<-m.received
+
+	var rcpts []string
+	for _, cmd := range m.Commands() {
+		if addr, ok := strings.CutPrefix(cmd, "RCPT TO:"); ok {
+			rcpts = append(rcpts, addr)
+		}
+	}
+	if want := []string{"<bob@example.com>", "<Bob@example.com>"}; !slices.Equal(rcpts, want) {
+		t.Errorf("RCPT TO %v, want %v", rcpts, want)
+	}
+}
+
+func TestSend_TemplatedConfigAddresses(t *testing.T) {
+	m := &mockSMTP{}
+	m.start(t)
+
+	cfg := EmailConfig{
+		Smarthost:    m.smarthost(),
+		From:         "{{ .Team }} <noreply@example.com>",
+		To:           "{{ .Recipient }}",
+		Cc:           "{{ if .Manager }}{{ .Manager }}{{ end }}",
+		ReplyTo:      "support+{{ .Ticket }}@example.com",
+		TemplatePath: tplWriteTemp(t, "Subject: Ticket {{ .Ticket }}\n\nHello"),
+	}
+	data := map[string]string{
+		"Team":      "Support",
+		"Recipient": "Hanako <hanako@example.com>",
+		"Manager":   "",
+		"Ticket":    "42",
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := Send(ctx, cfg, data); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(<-m.received))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	for key, want := range map[string]string{
+		"From":     "Support <noreply@example.com>",
+		"To":       "Hanako <hanako@example.com>",
+		"Reply-To": "support+42@example.com",
+		"Cc":       "",
+	} {
+		if got := msg.Header.Get(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+	var rcpts []string
+	for _, cmd := range m.Commands() {
+		if strings.HasPrefix(cmd, "RCPT TO:") {
+			rcpts = append(rcpts, cmd)
+		}
+	}
+	if !slices.Equal(rcpts, []string{"RCPT TO:<hanako@example.com>"}) {
+		t.Errorf("RCPT commands = %q", rcpts)
+	}
+
+	// A To that renders blank is as good as missing.
+	data["Recipient"] = " "
+	if _, err := Send(ctx, cfg, data); err == nil || !strings.Contains(err.Error(), "missing To address") {
+		t.Errorf("Send with blank To = %v, want missing To error", err)
+	}
+}
+
+func TestSend_UndisclosedRecipients(t *testing.T) {
+	m := &mockSMTP{}
+	m.start(t)
+	cfg := EmailConfig{
+		Smarthost:    m.smarthost(),
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nBcc: b@example.com, c@example.com\nSubject: Newsletter\n\nHello"),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := Send(ctx, cfg, nil); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(<-m.received))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if got := msg.Header.Get("To"); got != "undisclosed-recipients:;" {
+		t.Errorf("To = %q, want undisclosed-recipients:;", got)
+	}
+	var rcpts []string
+	for _, cmd := range m.Commands() {
+		if strings.HasPrefix(cmd, "RCPT TO:") {
+			rcpts = append(rcpts, cmd)
+		}
+	}
+	if want := []string{"RCPT TO:<b@example.com>", "RCPT TO:<c@example.com>"}; !slices.Equal(rcpts, want) {
+		t.Errorf("RCPT commands = %q, want %q", rcpts, want)
+	}
+
+	cfg.UndisclosedRecipients = "Newsletter subscribers:;"
+	raw, err := BuildMessage(cfg, nil)
+	if err != nil {
+		t.Fatalf("BuildMessage: %v", err)
+	}
+	if !strings.Contains(string(raw), "\r\nTo: Newsletter subscribers:;\r\n") {
+		t.Errorf("message lacks the configured To placeholder:\n%s", raw)
+	}
+}
+
+func TestBuildMessage_TextContentTypeParams(t *testing.T) {
+	tests := []struct {
+		params  map[string]string
+		want    string
+		wantErr bool
+	}{
+		{params: nil, want: "text/plain; charset=UTF-8"},
+		{params: map[string]string{"format": "flowed", "DelSp": "yes"}, want: "text/plain; charset=UTF-8; delsp=yes; format=flowed"},
+		{params: map[string]string{"charset": "us-ascii"}, want: "text/plain; charset=us-ascii"},
+		{params: map[string]string{"bad name": "x"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		cfg := EmailConfig{
+			TemplatePath:          tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Flowed\n\nHello"),
+			TextContentTypeParams: tt.params,
+		}
+		raw, err := BuildMessage(cfg, nil)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("params %v: expected error", tt.params)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("params %v: BuildMessage: %v", tt.params, err)
+		}
+		msg, err := mail.ReadMessage(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatalf("ReadMessage: %v", err)
+		}
+		if got := msg.Header.Get("Content-Type"); got != tt.want {
+			t.Errorf("params %v: Content-Type = %q, want %q", tt.params, got, tt.want)
+		}
+	}
+}
+
+func TestSend_ArchiveBcc(t *testing.T) {
+	const archive = "journal@archive.example.com"
+	m := &mockSMTP{}
+	m.start(t)
+	cfg := EmailConfig{
+		Smarthost:    m.smarthost(),
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nBcc: c@example.com\nSubject: Archived\n\nbody"),
+		ArchiveBcc:   archive,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rcptsOf := func(cmds []string) []string {
+		var rcpts []string
+		for _, cmd := range cmds {
+			if addr, ok := strings.CutPrefix(cmd, "RCPT TO:"); ok {
+				rcpts = append(rcpts, strings.Trim(addr, "<>"))
+			}
+		}
+		return rcpts
+	}
+
+	if _, err := Send(ctx, cfg, nil); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if got, want := rcptsOf(m.Commands()), []string{"b@example.com", "c@example.com", archive}; !slices.Equal(got, want) {
+		t.Errorf("RCPT TO %v, want %v", got, want)
+	}
+	if raw := <-m.received; strings.Contains(raw, archive) {
+		t.Errorf("archive address appears in the message:\n%s", raw)
+	}
+
+	// Batch items with their own recipients keep the shared Bcc and are
+	// archived too.
+	batch := &mockSMTP{}
+	batch.start(t)
+	cfg.Smarthost = batch.smarthost()
+	results := SendBatch(ctx, cfg, []BatchItem{{To: "d@example.com"}})
+	if results[0].Err != nil {
+		t.Fatalf("SendBatch: %v", results[0].Err)
+	}
+	if got, want := rcptsOf(batch.Commands()), []string{"d@example.com", "c@example.com", archive}; !slices.Equal(got, want) {
+		t.Errorf("batch RCPT TO %v, want %v", got, want)
+	}
+	if raw := <-batch.received; strings.Contains(raw, archive) {
+		t.Errorf("archive address appears in the batch message:\n%s", raw)
+	}
+}
+
+func TestBuildMessage_TemplateName(t *testing.T) {
+	welcome, err := tpl.ParseReader("welcome", strings.NewReader("Subject: Welcome\n\nHello {{ .Name }}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := EmailConfig{
+		From:         "a@example.com",
+		To:           "b@example.com",
+		Templates:    map[string]*tpl.Template{"welcome": welcome},
+		TemplateName: "welcome",
+		TemplatePath: "unused.tmpl",
+	}
+	raw, err := BuildMessage(cfg, map[string]string{"Name": "Bob"})
+	if err != nil {
+		t.Fatalf("BuildMessage: %v", err)
+	}
+	if !strings.Contains(string(raw), "Subject: Welcome\r\n") || !strings.HasSuffix(string(raw), "Hello Bob") {
+		t.Errorf("unexpected message:\n%s", raw)
+	}
+
+	cfg.TemplateName = "missing"
+	if _, err := BuildMessage(cfg, nil); err == nil || !strings.Contains(err.Error(), `template "missing" not found`) {
+		t.Errorf("BuildMessage with unknown template: err = %v", err)
+	}
+}
+
+func TestBuildMessage_TemplateResolver(t *testing.T) {
+	store := map[string]string{
+		"templates/welcome.tmpl": "From: a@example.com\nTo: b@example.com\nSubject: Welcome\n\nHello {{ .Name }}",
+	}
+	var resolved []string
+	cfg := EmailConfig{
+		TemplatePath: "templates/welcome.tmpl",
+		TemplateResolver: func(path string) (io.ReadCloser, error) {
+			resolved = append(resolved, path)
+			s, ok := store[path]
+			if !ok {
+				return nil, fs.ErrNotExist
+			}
+			return io.NopCloser(strings.NewReader(s)), nil
+		},
+	}
+	raw, err := BuildMessage(cfg, map[string]string{"Name": "Bob"})
+	if err != nil {
+		t.Fatalf("BuildMessage: %v", err)
+	}
+	if !strings.Contains(string(raw), "Subject: Welcome\r\n") || !strings.HasSuffix(string(raw), "Hello Bob") {
+		t.Errorf("unexpected message:\n%s", raw)
+	}
+	if want := []string{"templates/welcome.tmpl"}; !reflect.DeepEqual(resolved, want) {
+		t.Errorf("resolved %q, want %q", resolved, want)
+	}
+
+	cfg.TemplatePath = "templates/missing.tmpl"
+	if _, err := BuildMessage(cfg, nil); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("BuildMessage with unknown template: err = %v", err)
+	}
+}
+
+func TestBuildMessage_UndefinedFunc(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  EmailConfig
+		want []string
+	}{
+		{
+			name: "template header",
+			cfg:  EmailConfig{TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: {{ shout .Name }}\n\nbody")},
+			want: []string{"Subject header", `undefined function "shout"`},
+		},
+		{
+			name: "config header",
+			cfg: EmailConfig{
+				TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Hi\n\nbody"),
+				Headers:      map[string]string{"X-Campaign": "{{ slugify .Name }}"},
+			},
+			want: []string{"X-Campaign", `undefined function "slugify"`},
+		},
+		{
+			name: "html",
+			cfg: EmailConfig{
+				TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Hi\n\nbody"),
+				HTML:         "<p>{{ shout .Name }}</p>",
+			},
+			want: []string{"HTML", `undefined function "shout"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := BuildMessage(tt.cfg, map[string]string{"Name": "Alice"})
+			if err == nil {
+				t.Fatal("expected parse error")
+			}
+			for _, w := range tt.want {
+				if !strings.Contains(err.Error(), w) {
+					t.Errorf("error %q does not mention %s", err, w)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package pigeon
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// defaultMIMEVersion is the MIME-Version sent unless cfg.MIMEVersion is set.
+const defaultMIMEVersion = "1.0"
+
+// maxSMTPLineLength is the longest line, without CRLF, that 7bit and 8bit
+// data may contain (RFC 5322, section 2.1.1).
+const maxSMTPLineLength = 998
+
+// mimeVersion returns the MIME-Version header value for cfg.
+func (c *EmailConfig) mimeVersion() (string, error) {
+	if c.MIMEVersion == "" {
+		return defaultMIMEVersion, nil
+	}
+	if strings.ContainsAny(c.MIMEVersion, "\r\n") || !isASCII(c.MIMEVersion) {
+		return "", fmt.Errorf("invalid mime_version %q", c.MIMEVersion)
+	}
+	return c.MIMEVersion, nil
+}
+
+// base64LineLength returns the length of the base64-encoded lines of
+// attachments: cfg.Base64LineLength, or 76, the maximum of RFC 2045. A line
+// must hold whole 4-character groups.
+func (c *EmailConfig) base64LineLength() (int, error) {
+	switch n := c.Base64LineLength; {
+	case n == 0:
+		return maxContentLength, nil
+	case n < 4 || n > maxContentLength || n%4 != 0:
+		return 0, fmt.Errorf("invalid base64_line_length %d: must be a multiple of 4 between 4 and %d", n, maxContentLength)
+	default:
+		return n, nil
+	}
+}
+
+// messageEncoding validates cfg.ContentTransferEncoding for a message whose
+// body is multipart or a single text part, and returns it in lower case.
+// A multipart body can only be labeled 7bit, 8bit or binary (RFC 2045,
+// section 6.4); a single text part may also be quoted-printable or base64,
+// but 7bit and 8bit only fit text they can carry as is.
+func messageEncoding(cfg EmailConfig, multipart bool, text string) (string, error) {
+	cte := strings.ToLower(strings.TrimSpace(cfg.ContentTransferEncoding))
+	if cte == "" {
+		return "", nil
+	}
+	if cfg.PGP != nil {
+		return "", errors.New("content_transfer_encoding cannot be used with pgp")
+	}
+	if multipart {
+		switch cte {
+		case "7bit", "8bit", "binary":
+			return cte, nil
+		}
+		return "", fmt.Errorf("content_transfer_encoding %q is not allowed for a multipart message: must be 7bit, 8bit or binary", cfg.ContentTransferEncoding)
+	}
+
+	switch cte {
+	case "quoted-printable", "base64":
+	case "7bit", "8bit":
+		if cte == "7bit" && !isASCII(text) {
+			return "", errors.New("content_transfer_encoding 7bit cannot carry non-ASCII text")
+		}
+		for _, line := range strings.Split(text, "\n") {
+			if len(strings.TrimSuffix(line, "\r")) > maxSMTPLineLength {
+				return "", fmt.Errorf("content_transfer_encoding %s cannot carry lines longer than %d characters", cte, maxSMTPLineLength)
+			}
+		}
+	default:
+		return "", fmt.Errorf("invalid content_transfer_encoding %q: must be 7bit, 8bit, quoted-printable or base64", cfg.ContentTransferEncoding)
+	}
+	return cte, nil
+}
+
+// writeEncoded writes the text body in the given Content-Transfer-Encoding,
+// wrapping quoted-printable text at width columns.
+func writeEncoded(w io.Writer, body, cte string, width int) error {
+	switch cte {
+	case "quoted-printable":
+		return writeQuotedPrintable(w, body, width)
+	case "base64":
+		// Text is encoded in its canonical form, with CRLF line endings
+		// (RFC 2045, section 6.8).
+		encodeAndWrapBase64(w, []byte(toCRLF(body)), maxContentLength)
+		return nil
+	}
+	// 7bit and 8bit text is written with CRLF line endings, like the
+	// headers; applyLineEnding converts the whole message if needed.
+	if _, err := io.WriteString(w, toCRLF(body)); err != nil {
+		return fmt.Errorf("failed to write body: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package pigeon
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildMessage_ContentTransferEncoding(t *testing.T) {
+	const text = "Grüße aus Köln.\n"
+	tests := []struct {
+		cte    string
+		decode func(io.Reader) io.Reader
+	}{
+		{cte: "base64", decode: func(r io.Reader) io.Reader { return base64.NewDecoder(base64.StdEncoding, r) }},
+		{cte: "Quoted-Printable", decode: func(r io.Reader) io.Reader { return quotedprintable.NewReader(r) }},
+		{cte: "8bit", decode: func(r io.Reader) io.Reader { return r }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cte, func(t *testing.T) {
+			cfg := EmailConfig{
+				TemplatePath:            tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: CTE\n\n"+text),
+				ContentTransferEncoding: tt.cte,
+				MIMEVersion:             "1.0 (produced by pigeon)",
+			}
+			raw, err := BuildMessage(cfg, nil)
+			if err != nil {
+				t.Fatalf("BuildMessage: %v", err)
+			}
+			msg, err := mail.ReadMessage(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatalf("ReadMessage: %v", err)
+			}
+			if got := msg.Header.Get("Content-Transfer-Encoding"); got != strings.ToLower(tt.cte) {
+				t.Errorf("Content-Transfer-Encoding = %q, want %q", got, strings.ToLower(tt.cte))
+			}
+			if got := msg.Header.Get("Mime-Version"); got != "1.0 (produced by pigeon)" {
+				t.Errorf("MIME-Version = %q", got)
+			}
+			body, err := io.ReadAll(tt.decode(msg.Body))
+			if err != nil {
+				t.Fatalf("decode body: %v", err)
+			}
+			if got := strings.ReplaceAll(string(body), "\r\n", "\n"); got != text {
+				t.Errorf("body = %q, want %q", got, text)
+			}
+
+			size, err := EstimateSize(cfg, nil)
+			if err != nil || size != int64(len(raw)) {
+				t.Errorf("EstimateSize = (%d, %v), want %d", size, err, len(raw))
+			}
+		})
+	}
+
+	cfg := EmailConfig{
+		TemplatePath:            tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: CTE\n\n"+text),
+		ContentTransferEncoding: "7bit",
+	}
+	if _, err := BuildMessage(cfg, nil); err == nil || !strings.Contains(err.Error(), "non-ASCII") {
+		t.Errorf("BuildMessage with 7bit non-ASCII text: err = %v", err)
+	}
+	cfg.ContentTransferEncoding = "uuencode"
+	if _, err := BuildMessage(cfg, nil); err == nil {
+		t.Error("BuildMessage with unknown encoding: want error")
+	}
+	cfg.ContentTransferEncoding, cfg.MIMEVersion = "", "1.0\r\nX-Injected: yes"
+	if _, err := BuildMessage(cfg, nil); err == nil {
+		t.Error("BuildMessage with CRLF in mime_version: want error")
+	}
+}
+
+func TestBuildMessage_MultipartContentTransferEncoding(t *testing.T) {
+	attPath := filepath.Join(t.TempDir(), "report.pdf")
+	if err := os.WriteFile(attPath, []byte("%PDF-1.4"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := EmailConfig{
+		TemplatePath:            tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: CTE\n\nSee attached."),
+		Attachments:             []string{attPath},
+		ContentTransferEncoding: "quoted-printable",
+	}
+	if _, err := BuildMessage(cfg, nil); err == nil || !strings.Contains(err.Error(), "not allowed for a multipart message") {
+		t.Errorf("BuildMessage with quoted-printable multipart: err = %v", err)
+	}
+	if _, err := EstimateSize(cfg, nil); err == nil {
+		t.Error("EstimateSize with quoted-printable multipart: want error")
+	}
+
+	cfg.ContentTransferEncoding = "8bit"
+	raw, err := BuildMessage(cfg, nil)
+	if err != nil {
+		t.Fatalf("BuildMessage: %v", err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if got := msg.Header.Get("Content-Transfer-Encoding"); got != "8bit" {
+		t.Errorf("Content-Transfer-Encoding = %q, want 8bit", got)
+	}
+	if size, err := EstimateSize(cfg, nil); err != nil || size != int64(len(raw)) {
+		t.Errorf("EstimateSize = (%d, %v), want %d", size, err, len(raw))
+	}
+}
+
+func TestEncodeAndWrapBase64_Width(t *testing.T) {
+	for _, width := range []int{4, 64, 76} {
+		for _, n := range []int{0, 1, 47, 48, 49, 1000} {
+			data := bytes.Repeat([]byte{0xA5}, n)
+			var buf bytes.Buffer
+			encodeAndWrapBase64(&buf, data, width)
+			if got := base64WrappedLen(int64(n), width); got != int64(buf.Len()) {
+				t.Errorf("width %d, %d bytes: base64WrappedLen = %d, encoded %d", width, n, got, buf.Len())
+			}
+			lines := strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n")
+			for i, line := range lines {
+				if i < len(lines)-1 && len(line) != width {
+					t.Fatalf("width %d, %d bytes: line %d is %d characters", width, n, i, len(line))
+				}
+			}
+			got, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(buf.String(), "\r\n", ""))
+			if err != nil || !bytes.Equal(got, data) {
+				t.Errorf("width %d, %d bytes: round trip failed: %v", width, n, err)
+			}
+		}
+	}
+}
+
+func TestBuildMessage_Base64LineLength(t *testing.T) {
+	attPath := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(attPath, bytes.Repeat([]byte("0123456789"), 100), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := EmailConfig{
+		TemplatePath:     tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Legacy\n\nSee attached."),
+		Attachments:      []string{attPath},
+		Base64LineLength: 64,
+	}
+	raw, err := BuildMessage(cfg, nil)
+	if err != nil {
+		t.Fatalf("BuildMessage: %v", err)
+	}
+	if size, err := EstimateSize(cfg, nil); err != nil || size != int64(len(raw)) {
+		t.Errorf("EstimateSize = %d, %v; message is %d bytes", size, err, len(raw))
+	}
+
+	// The attachment is the last part: its body runs from the blank line
+	// after its headers to the closing delimiter.
+	i := bytes.LastIndex(raw, []byte("Content-Transfer-Encoding: base64"))
+	body := raw[i+bytes.Index(raw[i:], []byte("\r\n\r\n"))+4:]
+	body = body[:bytes.Index(body, []byte("\r\n--"))]
+	lines := strings.Split(strings.TrimSuffix(string(body), "\r\n"), "\r\n")
+	for i, line := range lines {
+		if (i < len(lines)-1 && len(line) != 64) || len(line) > 64 {
+			t.Errorf("line %d is %d characters: %q", i, len(line), line)
+		}
+	}
+
+	cfg.Base64LineLength = 62
+	if _, err := BuildMessage(cfg, nil); err == nil {
+		t.Error("expected error for a width that is not a multiple of 4")
+	}
+}
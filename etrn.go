@@ -0,0 +1,73 @@
+package pigeon
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// RequestQueueRelease asks the smarthost to start delivering the mail it
+// holds for domain, using the ETRN command (RFC 1985). It is meant for hosts
+// that are only intermittently connected and whose relay queues their mail
+// meanwhile. domain may carry the "@" or "#" prefix RFC 1985 defines for
+// subdomains and queue names.
+//
+// Any 25x reply is success, including 251 (no messages waiting). A refusal,
+// e.g. 458 (unable to queue messages), is returned as an *SMTPError whose
+// Temporary method reports whether asking again later may help.
+func RequestQueueRelease(ctx context.Context, cfg EmailConfig, domain string) error {
+	if domain == "" || strings.ContainsAny(domain, " \t\r\n") {
+		return fmt.Errorf("invalid ETRN domain %q", domain)
+	}
+
+	cfg.Logger = contextLogger(ctx, cfg.Logger)
+	d, err := newDialer(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	hostPort := cfg.Smarthost.String()
+	if hostPort == "" {
+		hostPort = "localhost:25"
+	}
+	conn, err := dialSmarthost(ctx, d, cfg, hostPort)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Unblock the dialogue when ctx is done. The deadline survives the
+	// greeting's own.
+	conn = &deadlineConn{Conn: conn}
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	host, _, _ := net.SplitHostPort(hostPort)
+	c, _, err := greet(bufferConn(conn, cfg), host, cfg)
+	if err != nil {
+		return err
+	}
+	defer c.Quit()
+
+	if cfg.Hello != "" {
+		_ = c.Hello(cfg.Hello)
+	}
+	if _, err := startTLS(c, host, cfg); err != nil {
+		return err
+	}
+
+	id, err := c.Text.Cmd("ETRN %s", domain)
+	if err != nil {
+		return err
+	}
+	c.Text.StartResponse(id)
+	defer c.Text.EndResponse(id)
+	code, msg, err := c.Text.ReadResponse(25)
+	if err != nil {
+		_, err = smtpFailure("ETRN", err, true)
+		return err
+	}
+	cfg.logInfo("ETRN accepted", "domain", domain, "code", code, "reply", msg)
+	return nil
+}
@@ -0,0 +1,41 @@
+package pigeon
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRequestQueueRelease(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	m := &mockSMTP{Extensions: []string{"ETRN"}, Replies: map[string]string{"ETRN": "250 Queuing started"}}
+	m.start(t)
+	if err := RequestQueueRelease(ctx, EmailConfig{Smarthost: m.smarthost()}, "example.com"); err != nil {
+		t.Fatalf("RequestQueueRelease: %v", err)
+	}
+	if !slices.Contains(m.Commands(), "ETRN example.com") {
+		t.Errorf("commands = %v, want ETRN example.com", m.Commands())
+	}
+
+	empty := &mockSMTP{Replies: map[string]string{"ETRN": "251 OK, no messages waiting for node example.com"}}
+	empty.start(t)
+	if err := RequestQueueRelease(ctx, EmailConfig{Smarthost: empty.smarthost()}, "example.com"); err != nil {
+		t.Errorf("251 reply: %v", err)
+	}
+
+	busy := &mockSMTP{Replies: map[string]string{"ETRN": "458 Unable to queue messages for node example.com"}}
+	busy.start(t)
+	err := RequestQueueRelease(ctx, EmailConfig{Smarthost: busy.smarthost()}, "example.com")
+	var smtpErr *SMTPError
+	if !errors.As(err, &smtpErr) || smtpErr.Code != 458 || smtpErr.Command != "ETRN" || !smtpErr.Temporary() {
+		t.Errorf("458 reply: err = %v, want temporary ETRN *SMTPError", err)
+	}
+
+	if err := RequestQueueRelease(ctx, EmailConfig{Smarthost: m.smarthost()}, "example.com\r\nQUIT"); err == nil {
+		t.Error("expected error for domain with CRLF")
+	}
+}
@@ -0,0 +1,36 @@
+// Command stdin sends a message whose template is read from standard input,
+// using the smarthost and addresses from ../config.yml. Run it from the
+// example directory:
+//
+//	printf 'Subject: Disk alert\n\nDisk space is low on {{.Host}}.\n' | go run ./stdin
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"github.com/dotarpa/pigeon"
+	"github.com/dotarpa/pigeon/tpl"
+)
+
+func main() {
+	cfg, err := pigeon.LoadFile("config.yml")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	t, err := tpl.ParseReader("stdin", os.Stdin)
+	if err != nil {
+		log.Fatalf("failed to parse template from stdin: %v", err)
+	}
+	cfg.Template = t
+	cfg.Attachments = nil
+
+	host, _ := os.Hostname()
+	retry, err := pigeon.Send(context.Background(), *cfg, map[string]any{"Host": host})
+	if err != nil {
+		log.Fatalf("Send failed: %v (retry=%v)", err, retry)
+	}
+	log.Println("Mail sent successfully")
+}
@@ -0,0 +1,73 @@
+package pigeon
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// maxURLAttachmentSize caps the size of an attachment fetched from a URL.
+const maxURLAttachmentSize = 25 << 20
+
+// defaultHTTPClient fetches URL attachments when EmailConfig.HTTPClient is nil.
+var defaultHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// httpClient returns the client used to fetch URL attachments.
+func (c *EmailConfig) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return defaultHTTPClient
+}
+
+// isURL reports whether an attachment path is an http(s) URL.
+func isURL(path string) bool {
+	return strings.HasPrefix(path, "https://") || strings.HasPrefix(path, "http://")
+}
+
+// fetchAttachment downloads the attachment at rawURL. The filename is taken
+// from the Content-Disposition header if present, otherwise from the last
+// element of the URL path; the content type is the one served, if any.
+func fetchAttachment(client *http.Client, rawURL string) (fname, ctype string, data []byte, err error) {
+	resp, err := client.Get(rawURL)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to fetch attachment: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", "", nil, fmt.Errorf("failed to fetch attachment %s: %s", rawURL, resp.Status)
+	}
+	data, err = io.ReadAll(io.LimitReader(resp.Body, maxURLAttachmentSize+1))
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to fetch attachment %s: %w", rawURL, err)
+	}
+	if len(data) > maxURLAttachmentSize {
+		return "", "", nil, fmt.Errorf("attachment %s exceeds %d bytes", rawURL, maxURLAttachmentSize)
+	}
+
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		fname = path.Base(params["filename"])
+	}
+	if fname == "" || fname == "." || fname == "/" {
+		fname = "attachment"
+		if u, err := url.Parse(rawURL); err == nil {
+			if base := path.Base(u.Path); base != "." && base != "/" {
+				fname = base
+			}
+		}
+	}
+
+	ctype = resp.Header.Get("Content-Type")
+	if mediaType, _, err := mime.ParseMediaType(ctype); err == nil {
+		ctype = mediaType
+	} else {
+		ctype = mime.TypeByExtension(path.Ext(fname))
+	}
+	return fname, ctype, data, nil
+}
@@ -0,0 +1,109 @@
+package pigeon
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestBuildMessage_URLAttachment(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/reports/q3.csv", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		io.WriteString(w, "month,total\njul,10\n")
+	})
+	mux.HandleFunc("/download", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Disposition", `attachment; filename="summary.pdf"`)
+		w.Write([]byte("%PDF-1.4"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	cfg := EmailConfig{
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Reports\n\nSee attached."),
+		Attachments:  []string{srv.URL + "/reports/q3.csv", srv.URL + "/download?id=7"},
+		HTTPClient:   srv.Client(),
+	}
+	raw, err := BuildMessage(cfg, nil)
+	if err != nil {
+		t.Fatalf("BuildMessage: %v", err)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("ParseMediaType: %v", err)
+	}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	if _, err := mr.NextPart(); err != nil { // text part
+		t.Fatalf("NextPart: %v", err)
+	}
+
+	want := []struct{ name, ctype, content string }{
+		{"q3.csv", "text/csv", "month,total\njul,10\n"},
+		{"summary.pdf", "application/pdf", "%PDF-1.4"},
+	}
+	for _, w := range want {
+		p, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("NextPart: %v", err)
+		}
+		if p.FileName() != w.name {
+			t.Errorf("filename = %q, want %q", p.FileName(), w.name)
+		}
+		if ct, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type")); ct != w.ctype {
+			t.Errorf("%s: Content-Type = %q, want %q", w.name, ct, w.ctype)
+		}
+		// multipart.Reader decodes quoted-printable only, so decode base64 here.
+		b, _ := io.ReadAll(p)
+		if got := decodeBase64Lines(t, b); got != w.content {
+			t.Errorf("%s: content = %q, want %q", w.name, got, w.content)
+		}
+	}
+
+	size, err := EstimateSize(cfg, nil)
+	if err != nil {
+		t.Fatalf("EstimateSize: %v", err)
+	}
+	if size != int64(len(raw)) {
+		t.Errorf("EstimateSize = %d, len(BuildMessage) = %d", size, len(raw))
+	}
+}
+
+func TestBuildMessage_URLAttachmentNotFound(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	cfg := EmailConfig{
+		Smarthost:    HostPort{Host: "127.0.0.1", Port: "1"},
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\n\nbody"),
+		Attachments:  []string{srv.URL + "/missing.pdf"},
+		HTTPClient:   srv.Client(),
+	}
+	retry, err := Send(context.Background(), cfg, nil)
+	if err == nil || retry {
+		t.Fatalf("Send = (%v, %v), want permanent error", retry, err)
+	}
+}
+
+// decodeBase64Lines decodes line-wrapped base64 content.
+func decodeBase64Lines(t *testing.T, b []byte) string {
+	t.Helper()
+	s := strings.NewReplacer("\r", "", "\n", "").Replace(string(b))
+	dec, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatalf("invalid base64 %q: %v", s, err)
+	}
+	return string(dec)
+}
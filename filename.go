@@ -0,0 +1,123 @@
+package pigeon
+
+import (
+	"fmt"
+	"mime"
+	"strings"
+	"unicode/utf8"
+)
+
+// filenameSegment is the longest parameter value EncodeAttachmentFilename
+// puts on one line before splitting it into RFC 2231 continuations.
+const filenameSegment = 60
+
+// EncodeAttachmentFilename returns the filename parameter of a
+// Content-Disposition header for an attachment called name, e.g.
+//
+//	filename="report.pdf"
+//	filename*=UTF-8''%E5%A0%B1%E5%91%8A.pdf
+//
+// Printable ASCII names are quoted; others are percent-encoded as UTF-8
+// (RFC 2231). A value too long for one line is split into numbered
+// continuation parameters (filename*0*=..., filename*1*=...), each on a
+// folded line of its own and never splitting a character. The result
+// follows "attachment; ".
+func EncodeAttachmentFilename(name string) string {
+	return encodeParam("filename", name)
+}
+
+// encodeParam encodes the MIME parameter key=value as described for
+// EncodeAttachmentFilename.
+func encodeParam(key, value string) string {
+	if isPrintableASCII(value) {
+		if len(value) <= filenameSegment {
+			return key + "=" + quoteParam(value)
+		}
+		segs := splitSegments(value, func(r rune) string { return string(r) })
+		for i, s := range segs {
+			segs[i] = fmt.Sprintf("%s*%d=%s", key, i, quoteParam(s))
+		}
+		return strings.Join(segs, ";\r\n ")
+	}
+
+	const charset = "UTF-8''"
+	segs := splitSegments(value, percentEncodeRune)
+	if len(segs) == 1 {
+		return key + "*=" + charset + segs[0]
+	}
+	for i, s := range segs {
+		if i == 0 {
+			s = charset + s
+		}
+		segs[i] = fmt.Sprintf("%s*%d*=%s", key, i, s)
+	}
+	return strings.Join(segs, ";\r\n ")
+}
+
+// encodeNameParam encodes the legacy name parameter of a Content-Type
+// header. Non-ASCII names are sent as an RFC 2047 encoded-word, which older
+// clients that ignore RFC 2231 still display.
+func encodeNameParam(name string) string {
+	if isPrintableASCII(name) {
+		return "name=" + quoteParam(name)
+	}
+	return "name=\"" + mime.BEncoding.Encode("UTF-8", name) + "\""
+}
+
+// splitSegments encodes s rune by rune with enc and splits the result into
+// segments of at most filenameSegment bytes. A segment holds at least one
+// rune.
+func splitSegments(s string, enc func(rune) string) []string {
+	var segs []string
+	var cur strings.Builder
+	for _, r := range s {
+		e := enc(r)
+		if cur.Len() > 0 && cur.Len()+len(e) > filenameSegment {
+			segs = append(segs, cur.String())
+			cur.Reset()
+		}
+		cur.WriteString(e)
+	}
+	return append(segs, cur.String())
+}
+
+// percentEncodeRune returns r as RFC 2231 extended value characters:
+// attribute characters as-is and everything else as %XX UTF-8 octets.
+func percentEncodeRune(r rune) string {
+	if r < utf8.RuneSelf && isAttrChar(byte(r)) {
+		return string(r)
+	}
+	var buf [utf8.UTFMax]byte
+	n := utf8.EncodeRune(buf[:], r)
+	var b strings.Builder
+	for _, c := range buf[:n] {
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// isAttrChar reports whether c may appear unencoded in an RFC 2231 extended
+// value (the attr-char set of RFC 5987).
+func isAttrChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$&+-.^_`|~", c) >= 0
+}
+
+// isPrintableASCII reports whether s consists only of printable ASCII
+// characters and spaces.
+func isPrintableASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// quoteParam returns s as a quoted-string, escaping quotes and backslashes.
+func quoteParam(s string) string {
+	return "\"" + strings.NewReplacer("\\", "\\\\", "\"", "\\\"").Replace(s) + "\""
+}
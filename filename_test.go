@@ -0,0 +1,94 @@
+package pigeon
+
+import (
+	"bytes"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncodeAttachmentFilename(t *testing.T) {
+	tests := []struct {
+		name string
+		want string // exact encoding, if checked
+	}{
+		{name: "report.pdf", want: `filename="report.pdf"`},
+		{name: "annual report.pdf", want: `filename="annual report.pdf"`},
+		{name: `say "hi".txt`, want: `filename="say \"hi\".txt"`},
+		{name: "報告書.pdf", want: `filename*=UTF-8''%E5%A0%B1%E5%91%8A%E6%9B%B8.pdf`},
+		{name: "🎉 party.png", want: `filename*=UTF-8''%F0%9F%8E%89%20party.png`},
+		{name: strings.Repeat("年次報告", 10) + ".xlsx"},
+		{name: strings.Repeat("quarterly-", 10) + "report.csv"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := EncodeAttachmentFilename(tt.name)
+			if tt.want != "" && got != tt.want {
+				t.Errorf("EncodeAttachmentFilename = %s, want %s", got, tt.want)
+			}
+			for _, line := range strings.Split(got, "\r\n") {
+				if len(line) > 78 {
+					t.Errorf("line longer than 78 characters: %s", line)
+				}
+			}
+			_, params, err := mime.ParseMediaType("attachment; " + got)
+			if err != nil {
+				t.Fatalf("ParseMediaType(%q): %v", got, err)
+			}
+			if params["filename"] != tt.name {
+				t.Errorf("decoded filename = %q, want %q", params["filename"], tt.name)
+			}
+		})
+	}
+
+	long := EncodeAttachmentFilename(strings.Repeat("年次報告", 10) + ".xlsx")
+	if !strings.HasPrefix(long, "filename*0*=UTF-8''") || !strings.Contains(long, ";\r\n filename*1*=") {
+		t.Errorf("long name not split into continuations:\n%s", long)
+	}
+}
+
+func TestBuildMessage_NonASCIIAttachmentName(t *testing.T) {
+	name := "請求書 🧾.pdf"
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte("%PDF-1.4"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := EmailConfig{
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Invoice\n\nAttached."),
+		Attachments:  []string{path},
+	}
+	raw, err := BuildMessage(cfg, nil)
+	if err != nil {
+		t.Fatalf("BuildMessage: %v", err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	if _, err := mr.NextPart(); err != nil { // text body
+		t.Fatal(err)
+	}
+	p, err := mr.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.FileName() != name {
+		t.Errorf("FileName = %q, want %q", p.FileName(), name)
+	}
+	_, ctParams, err := mime.ParseMediaType(p.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded, err := new(mime.WordDecoder).DecodeHeader(ctParams["name"]); err != nil || decoded != name {
+		t.Errorf("Content-Type name = %q (%v), want %q", ctParams["name"], err, name)
+	}
+}
@@ -2,4 +2,18 @@ module github.com/dotarpa/pigeon
 
 go 1.23.4
 
-require gopkg.in/yaml.v3 v3.0.1
+require (
+	github.com/ProtonMail/go-crypto v1.3.0
+	github.com/microcosm-cc/bluemonday v1.0.27
+	golang.org/x/net v0.26.0
+	golang.org/x/text v0.22.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/aymerick/douceur v0.2.0 // indirect
+	github.com/cloudflare/circl v1.6.1 // indirect
+	github.com/gorilla/css v1.0.1 // indirect
+	golang.org/x/crypto v0.33.0 // indirect
+	golang.org/x/sys v0.30.0 // indirect
+)
@@ -0,0 +1,131 @@
+package pigeon
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"sync"
+	"time"
+)
+
+// defaultGreetingTimeout is how long to wait for the server greeting, as
+// recommended by RFC 5321, section 4.5.3.2.1.
+const defaultGreetingTimeout = 5 * time.Minute
+
+// greet reads the server greeting on conn and returns a client for the rest
+// of the session. The server speaks first, so the wait is bounded by
+// cfg.GreetingTimeout; a greeting that is not 220 is an *SMTPError. A read
+// deadline set on a *deadlineConn before, or while waiting, is kept.
+func greet(conn net.Conn, host string, cfg EmailConfig) (c *smtp.Client, retry bool, err error) {
+	timeout := cfg.GreetingTimeout
+	if timeout <= 0 {
+		timeout = defaultGreetingTimeout
+	}
+
+	// bufio.Reader.ReadLine hands back a partial line without an error when
+	// the read times out, so a banner cut off mid-line would pass as
+	// complete. Watch the reads instead.
+	dc := findDeadlineConn(conn)
+	wc := &timeoutConn{Conn: conn}
+	lc := &limitConn{Conn: wc, lineLimit: lineLimit{max: cfg.maxReplyLineBytes()}}
+	restore := dc.limitRead(time.Now().Add(timeout))
+	c, err = smtp.NewClient(lc, host)
+	lc.off = true
+	restore()
+	if err == nil && wc.timedOut {
+		c.Close()
+		err = errors.New("incomplete greeting")
+	}
+	if lc.err != nil {
+		if err == nil {
+			c.Close()
+		}
+		return nil, false, lc.err
+	}
+	if err != nil {
+		if wc.timedOut {
+			return nil, true, fmt.Errorf("timed out waiting for server greeting after %v: %w", timeout, err)
+		}
+		// A 4xx greeting means the server is temporarily not ready; the
+		// banner text is kept in the returned *SMTPError.
+		retry, err = smtpFailure("greeting", err, true)
+		return nil, retry, err
+	}
+	limitReplies(c, cfg)
+	return c, false, nil
+}
+
+// timeoutConn records whether a read on the connection timed out.
+type timeoutConn struct {
+	net.Conn
+	timedOut bool
+}
+
+func (c *timeoutConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		c.timedOut = true
+	}
+	return n, err
+}
+
+// deadlineConn is a net.Conn that remembers its read deadline, which net.Conn
+// does not expose, so that a temporary one can be undone.
+type deadlineConn struct {
+	net.Conn
+	mu   sync.Mutex
+	read time.Time
+}
+
+func (c *deadlineConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.read = t
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *deadlineConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.read = t
+	return c.Conn.SetReadDeadline(t)
+}
+
+// NetConn returns the wrapped connection.
+func (c *deadlineConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// findDeadlineConn returns the *deadlineConn conn is or wraps, or a new one
+// wrapping conn if there is none.
+func findDeadlineConn(conn net.Conn) *deadlineConn {
+	for c := conn; ; {
+		if dc, ok := c.(*deadlineConn); ok {
+			return dc
+		}
+		wc, ok := c.(interface{ NetConn() net.Conn })
+		if !ok {
+			return &deadlineConn{Conn: conn}
+		}
+		c = wc.NetConn()
+	}
+}
+
+// limitRead sets the read deadline to t, or to the connection's own read
+// deadline if that is earlier, until the returned function restores the
+// connection's own. Deadlines set in between are the connection's own.
+func (c *deadlineConn) limitRead(t time.Time) (restore func()) {
+	c.mu.Lock()
+	if !c.read.IsZero() && c.read.Before(t) {
+		t = c.read
+	}
+	c.Conn.SetReadDeadline(t)
+	c.mu.Unlock()
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.Conn.SetReadDeadline(c.read)
+	}
+}
@@ -0,0 +1,85 @@
+package pigeon
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSend_GreetingTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ln.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// Start the greeting but never finish the line.
+		conn.Write([]byte("220 mx.example.com"))
+		<-done
+	}()
+
+	var hp HostPort
+	hp.Host, hp.Port, _ = net.SplitHostPort(ln.Addr().String())
+	cfg := EmailConfig{
+		Smarthost:       hp,
+		TemplatePath:    tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Hi\n\nbody"),
+		GreetingTimeout: 100 * time.Millisecond,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	retry, err := Send(ctx, cfg, nil)
+	if err == nil || !strings.Contains(err.Error(), "timed out waiting for server greeting") {
+		t.Fatalf("Send error = %v, want greeting timeout", err)
+	}
+	if !retry {
+		t.Error("greeting timeout should be retryable")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Send took %v, want about the greeting timeout", elapsed)
+	}
+}
+
+func TestDeadlineConn_LimitRead(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	timesOut := func(c net.Conn) bool {
+		_, err := c.Read(make([]byte, 1))
+		var netErr net.Error
+		return errors.As(err, &netErr) && netErr.Timeout()
+	}
+
+	// The connection's own deadline wins when it is earlier, and a deadline
+	// set while the greeting's is in place, e.g. on cancellation, is kept.
+	dc := &deadlineConn{Conn: client}
+	dc.SetDeadline(time.Now().Add(-time.Second))
+	restore := dc.limitRead(time.Now().Add(time.Hour))
+	if !timesOut(dc) {
+		t.Error("read before the own deadline: want timeout")
+	}
+	dc.SetDeadline(time.Now())
+	restore()
+	if !timesOut(dc) {
+		t.Error("read after restore: want the deadline set in between")
+	}
+
+	// Without a deadline of its own, the connection has none afterwards.
+	dc = &deadlineConn{Conn: client}
+	dc.limitRead(time.Now().Add(-time.Second))()
+	go server.Write([]byte("x"))
+	if n, err := dc.Read(make([]byte, 1)); n != 1 || err != nil {
+		t.Errorf("read after restore = (%d, %v), want no deadline", n, err)
+	}
+}
@@ -0,0 +1,228 @@
+package pigeon
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/dotarpa/pigeon/tpl"
+)
+
+// controlledHeaders are written by pigeon to describe the message body and
+// cannot be set by the template or cfg.Headers.
+var controlledHeaders = map[string]bool{
+	"Mime-Version": true, "Content-Type": true, "Content-Transfer-Encoding": true,
+}
+
+// assembleHeaders renders the message headers from the template and cfg.
+// When several sources set the same field, the later one below wins:
+//
+//  1. generated headers: Date, TLS-Required, X-Mailer and a placeholder
+//     Subject;
+//  2. config fields (From, Sender, To, Cc, Bcc, ReplyTo, InReplyTo,
+//     References), which fill in for fields the template does not declare;
+//  3. template headers;
+//  4. cfg.Headers.
+//
+// A template header or cfg.Headers value that renders blank removes the
+// field. Empty entries in rendered address lists, such as the trailing
+// separator left by {{ range }}, are dropped. The content headers in
+// controlledHeaders are always pigeon's: they are ignored in the template and
+// cfg.Headers, and apart from MIME-Version (cfg.MIMEVersion) left to the
+// caller.
+func assembleHeaders(t *tpl.Template, cfg EmailConfig, data any) (textproto.MIMEHeader, error) {
+	hdr := make(textproto.MIMEHeader)
+	set := func(k, v string) {
+		if k == "Subject" {
+			hdr.Set(k, encodingUTF8Subject(v, cfg.HeaderFoldWidth))
+			return
+		}
+		hdr.Set(k, encodeHeaderValue(k, v))
+	}
+
+	// 1. Generated headers.
+	hdr.Set("Date", messageTime(cfg).Format(time.RFC1123Z))
+	hdr.Set("Subject", "(no subject)")
+	// An explicit opt-out of TLS enforcement is signaled to receivers (RFC 8689).
+	if cfg.RequireTLS != nil && !*cfg.RequireTLS {
+		hdr.Set("TLS-Required", "No")
+	}
+	if mailer := cfg.xMailer(); mailer != "" {
+		set("X-Mailer", mailer)
+	}
+
+	// 2. Config fields, rendered as templates with data.
+	for _, f := range []struct{ key, value string }{
+		{"From", cfg.From},
+		{"Sender", cfg.Sender},
+		{"To", cfg.To},
+		{"Cc", cfg.Cc},
+		{"Bcc", cfg.Bcc},
+		{"Reply-To", cfg.ReplyTo},
+	} {
+		v, err := renderConfigValue(cfg, f.key, f.value, data)
+		if err != nil {
+			return nil, err
+		}
+		if v = cleanAddressList(v); v != "" {
+			set(f.key, v)
+		}
+	}
+	if cfg.InReplyTo != "" {
+		if !validMessageID(cfg.InReplyTo) {
+			return nil, fmt.Errorf("invalid in_reply_to %q: must be a message ID in angle brackets", cfg.InReplyTo)
+		}
+		hdr.Set("In-Reply-To", cfg.InReplyTo)
+	}
+	if len(cfg.References) > 0 {
+		for _, id := range cfg.References {
+			if !validMessageID(id) {
+				return nil, fmt.Errorf("invalid references entry %q: must be a message ID in angle brackets", id)
+			}
+		}
+		hdr.Set("References", strings.Join(cfg.References, " "))
+	}
+
+	// 3. Template headers.
+	for _, f := range t.Fields() {
+		if controlledHeaders[f.Key] {
+			continue
+		}
+		v, err := renderHeader(t, cfg, f.Key, "", data)
+		if err != nil {
+			return nil, err
+		}
+		if addressHeaders[f.Key] {
+			v = cleanAddressList(v)
+		}
+		if v = strings.TrimSpace(v); v == "" {
+			hdr.Del(f.Key)
+			continue
+		}
+		set(f.Key, v)
+	}
+
+	// 4. Custom headers from the configuration. Values with template actions
+	// are rendered with data, and those that render blank are removed, so
+	// that they can be set conditionally. Literal values are kept as written.
+	for k, v := range cfg.Headers {
+		k = textproto.CanonicalMIMEHeaderKey(k)
+		if controlledHeaders[k] {
+			continue
+		}
+		if !isTemplate(v) && !addressHeaders[k] {
+			if strings.TrimSpace(v) == "" {
+				hdr.Del(k)
+				continue
+			}
+			set(k, v)
+			continue
+		}
+		v, err := renderConfigValue(cfg, "header "+k, v, data)
+		if err != nil {
+			return nil, err
+		}
+		if addressHeaders[k] {
+			v = cleanAddressList(v)
+		}
+		if v = strings.TrimSpace(v); v == "" {
+			hdr.Del(k)
+			continue
+		}
+		set(k, v)
+	}
+
+	version, err := cfg.mimeVersion()
+	if err != nil {
+		return nil, err
+	}
+	hdr.Set("MIME-Version", version)
+
+	if hdr.Get("From") == "" {
+		return nil, errors.New("missing From address")
+	}
+	// A message for Cc or Bcc recipients only is sent with a placeholder To.
+	if hdr.Get("To") == "" {
+		if hdr.Get("Cc") == "" && hdr.Get("Bcc") == "" {
+			return nil, errors.New("missing To address")
+		}
+		set("To", cfg.undisclosedRecipients())
+	}
+	// RFC 5322 requires a Sender when From lists more than one mailbox.
+	if sender := hdr.Get("Sender"); sender != "" {
+		if list, err := mail.ParseAddressList(sender); err != nil || len(list) != 1 {
+			return nil, fmt.Errorf("invalid Sender %q: must be a single address", sender)
+		}
+	} else if n := len(parseAddressList(hdr.Get("From"))); n > 1 {
+		return nil, fmt.Errorf("From lists %d addresses, so a Sender address is required", n)
+	}
+	return hdr, nil
+}
+
+// undisclosedRecipients returns the To header for a message without To
+// recipients.
+func (c *EmailConfig) undisclosedRecipients() string {
+	if c.UndisclosedRecipients == "" {
+		return "undisclosed-recipients:;"
+	}
+	return c.UndisclosedRecipients
+}
+
+// cleanAddressList drops the empty entries from the rendered address list v,
+// e.g. "a@example.com, , b@example.com, " becomes
+// "a@example.com, b@example.com".
+func cleanAddressList(v string) string {
+	return strings.Join(splitAddressList(v), ", ")
+}
+
+// splitAddressList splits the address list v at its commas, returning the
+// non-empty entries with surrounding space trimmed. Commas inside quoted
+// display names, comments and angle brackets are not separators, so
+// `"Smith, John" <john@example.com>, b@example.com` has two entries.
+func splitAddressList(v string) []string {
+	var (
+		entries []string
+		start   int
+		quoted  bool
+		depth   int // nesting of comments and angle brackets
+	)
+	for i := 0; i < len(v); i++ {
+		switch c := v[i]; {
+		case c == '\\' && quoted:
+			i++
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '(' || c == '<':
+			depth++
+		case (c == ')' || c == '>') && depth > 0:
+			depth--
+		case c == ',' && depth == 0:
+			entries = append(entries, v[start:i])
+			start = i + 1
+		}
+	}
+	entries = append(entries, v[start:])
+
+	out := entries[:0]
+	for _, e := range entries {
+		if e = strings.TrimSpace(e); e != "" {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// messageTime returns the current time in cfg.Timezone, or in UTC if it is
+// unset or invalid.
+func messageTime(cfg EmailConfig) time.Time {
+	if cfg.Timezone != "" {
+		if loc, err := time.LoadLocation(cfg.Timezone); err == nil {
+			return time.Now().In(loc)
+		}
+	}
+	return time.Now().UTC()
+}
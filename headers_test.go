@@ -0,0 +1,208 @@
+package pigeon
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAssembleHeaders_Precedence(t *testing.T) {
+	tests := []struct {
+		name    string
+		tmpl    string // header lines, without From and To
+		cfg     EmailConfig
+		key     string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "template over generated",
+			tmpl: "Date: Mon, 01 Jan 2024 00:00:00 +0000\n",
+			key:  "Date", want: "Mon, 01 Jan 2024 00:00:00 +0000",
+		},
+		{
+			name: "template Message-Id over generated",
+			tmpl: "Message-Id: <fixed@example.com>\n",
+			key:  "Message-Id", want: "<fixed@example.com>",
+		},
+		{
+			name: "config field over generated",
+			cfg:  EmailConfig{InReplyTo: "<parent@example.com>"},
+			key:  "In-Reply-To", want: "<parent@example.com>",
+		},
+		{
+			name: "template over config field",
+			tmpl: "Reply-To: template@example.com\n",
+			cfg:  EmailConfig{ReplyTo: "config@example.com"},
+			key:  "Reply-To", want: "template@example.com",
+		},
+		{
+			name: "blank template header removes config field",
+			tmpl: "Cc: {{ .Empty }}\n",
+			cfg:  EmailConfig{Cc: "config@example.com"},
+			key:  "Cc", want: "",
+		},
+		{
+			name: "config headers over template",
+			tmpl: "X-Mailer: template\n",
+			cfg:  EmailConfig{Headers: map[string]string{"X-Mailer": "config"}},
+			key:  "X-Mailer", want: "config",
+		},
+		{
+			name: "config headers over generated",
+			cfg:  EmailConfig{Headers: map[string]string{"date": "Tue, 02 Jan 2024 00:00:00 +0000"}},
+			key:  "Date", want: "Tue, 02 Jan 2024 00:00:00 +0000",
+		},
+		{
+			name: "blank config header removes template header",
+			tmpl: "X-Campaign: spring\n",
+			cfg:  EmailConfig{Headers: map[string]string{"X-Campaign": "{{ .Empty }}"}},
+			key:  "X-Campaign", want: "",
+		},
+		{
+			name: "template Content-Type ignored",
+			tmpl: "Content-Type: text/html\n",
+			key:  "Content-Type", want: "",
+		},
+		{
+			name: "config MIME-Version ignored",
+			cfg:  EmailConfig{Headers: map[string]string{"MIME-Version": "2.0"}},
+			key:  "Mime-Version", want: "1.0",
+		},
+		{
+			name:    "config headers cannot drop From",
+			cfg:     EmailConfig{Headers: map[string]string{"From": "{{ .Empty }}"}},
+			wantErr: "missing From address",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.TemplatePath = tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\n"+tt.tmpl+"\nbody")
+			tp, err := parseTemplate(cfg)
+			if err != nil {
+				t.Fatalf("parseTemplate: %v", err)
+			}
+			hdr, err := assembleHeaders(tp, cfg, map[string]string{"Empty": ""})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("assembleHeaders error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("assembleHeaders: %v", err)
+			}
+			if got := hdr.Get(tt.key); got != tt.want {
+				t.Errorf("%s = %q, want %q", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAssembleHeaders_CustomHeaderTemplates(t *testing.T) {
+	cfg := EmailConfig{
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\n\nbody"),
+		Headers: map[string]string{
+			"X-Tracking-ID": "{{ .TrackID }}",
+			"X-Literal":     "50% off } {",
+		},
+	}
+	tp, err := parseTemplate(cfg)
+	if err != nil {
+		t.Fatalf("parseTemplate: %v", err)
+	}
+	hdr, err := assembleHeaders(tp, cfg, map[string]string{"TrackID": "trk-42"})
+	if err != nil {
+		t.Fatalf("assembleHeaders: %v", err)
+	}
+	if got := hdr.Get("X-Tracking-Id"); got != "trk-42" {
+		t.Errorf("X-Tracking-Id = %q, want trk-42", got)
+	}
+	if got := hdr.Get("X-Literal"); got != "50% off } {" {
+		t.Errorf("X-Literal = %q, want it unchanged", got)
+	}
+
+	for value, want := range map[string]string{
+		"{{ .TrackID ":       "failed to parse header X-Tracking-Id template",
+		"{{ .TrackID.Bad }}": "failed to execute header X-Tracking-Id template",
+	} {
+		cfg.Headers = map[string]string{"X-Tracking-ID": value}
+		if _, err := assembleHeaders(tp, cfg, map[string]string{"TrackID": "trk-42"}); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Headers X-Tracking-ID %q: error = %v, want %q", value, err, want)
+		}
+	}
+}
+
+func TestCleanAddressList(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"a@example.com, b@example.com, ", "a@example.com, b@example.com"},
+		{", a@example.com,, ,b@example.com", "a@example.com, b@example.com"},
+		{`"Doe, Jane" <jane@example.com>, , bob@example.com (Bob, ops)`, `"Doe, Jane" <jane@example.com>, bob@example.com (Bob, ops)`},
+		{`"a \", b" <a@example.com>,`, `"a \", b" <a@example.com>`},
+		{" , ", ""},
+	}
+	for _, tt := range tests {
+		if got := cleanAddressList(tt.in); got != tt.want {
+			t.Errorf("cleanAddressList(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSplitAddressList(t *testing.T) {
+	list := `"Smith, John" <john@example.com>, Ops [EU] <ops@example.com>`
+	want := []string{`"Smith, John" <john@example.com>`, "Ops [EU] <ops@example.com>"}
+	if got := splitAddressList(list); !slices.Equal(got, want) {
+		t.Errorf("splitAddressList = %q, want %q", got, want)
+	}
+
+	// The list is not valid RFC 5322, so parseAddressList falls back to
+	// splitting it, and the quoted comma must not split the first entry.
+	rcpts, err := envelopeRcpts(nil, list)
+	if err != nil {
+		t.Fatalf("envelopeRcpts: %v", err)
+	}
+	if want := []string{"john@example.com", "ops@example.com"}; !slices.Equal(rcpts, want) {
+		t.Errorf("envelopeRcpts = %q, want %q", rcpts, want)
+	}
+}
+
+func TestSend_BccFromSlice(t *testing.T) {
+	admins := []string{"ann@example.com", "ben@example.com", "cat@example.com"}
+	for _, bcc := range []string{
+		`{{ range .Admins }}{{ . }}, {{ end }}`,
+		`{{ range .Admins }}{{ if . }}{{ . }}{{ end }}, {{ end }}`,
+		`{{ join ", " .Admins }}`,
+	} {
+		m := &mockSMTP{}
+		m.start(t)
+		cfg := EmailConfig{
+			Smarthost:    m.smarthost(),
+			TemplatePath: tplWriteTemp(t, "From: alerts@example.com\nTo: ops@example.com\nBcc: "+bcc+"\nSubject: Alert\n\nDisk full"),
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		// An empty entry in the middle of the list is dropped too.
+		data := map[string]any{"Admins": []string{admins[0], "", admins[1], admins[2]}}
+		if strings.Contains(bcc, "join") {
+			data["Admins"] = admins
+		}
+		_, err := Send(ctx, cfg, data)
+		cancel()
+		if err != nil {
+			t.Fatalf("Send with Bcc %q: %v", bcc, err)
+		}
+
+		var rcpts []string
+		for _, cmd := range m.Commands() {
+			if addr, ok := strings.CutPrefix(cmd, "RCPT TO:"); ok {
+				rcpts = append(rcpts, strings.Trim(addr, "<>"))
+			}
+		}
+		if want := append([]string{"ops@example.com"}, admins...); !slices.Equal(rcpts, want) {
+			t.Errorf("Bcc %q: RCPT TO %v, want %v", bcc, rcpts, want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package pigeon
+
+import (
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// htmlPolicy is the allow-list applied to HTML bodies when SanitizeHTML is
+// set. It keeps the formatting, links and images of user-generated content
+// but drops scripts, styles and event handlers.
+var htmlPolicy = bluemonday.UGCPolicy()
+
+// HTMLTransformer rewrites a rendered HTML body before it is added to the
+// message, e.g. to add a tracking pixel or rewrite link targets. data is the
+// message data the body was rendered with.
+type HTMLTransformer func(html string, data any) (string, error)
+
+// renderHTML renders cfg.HTML as an html/template with data, with the same
+// functions as the message template available, sanitizing the
+// result if cfg.SanitizeHTML is set and then passing it through
+// cfg.HTMLTransformer. It returns "" if cfg.HTML is empty.
+func renderHTML(cfg EmailConfig, data any) (string, error) {
+	if cfg.HTML == "" {
+		return "", nil
+	}
+	t, err := htmltemplate.New("html").Funcs(htmltemplate.FuncMap(cfg.templateFuncs())).Parse(cfg.HTML)
+	if err != nil {
+		return "", templateParseError("HTML", err)
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if err := t.Execute(buf, data); err != nil {
+		return "", fmt.Errorf("failed to execute HTML template: %w", err)
+	}
+	html := buf.String()
+	if cfg.SanitizeHTML {
+		html = htmlPolicy.Sanitize(html)
+	}
+	if cfg.HTMLTransformer == nil {
+		return html, nil
+	}
+	html, err = cfg.HTMLTransformer(html, data)
+	if err != nil {
+		return "", fmt.Errorf("failed to transform HTML body: %w", err)
+	}
+	return html, nil
+}
+
+// htmlPartHeader returns the content headers for an HTML body, choosing
+// quoted-printable when the markup is not 7-bit safe.
+func htmlPartHeader(html string, width int) textproto.MIMEHeader {
+	return textproto.MIMEHeader{
+		"Content-Type":              {"text/html; charset=UTF-8"},
+		"Content-Transfer-Encoding": {transferEncoding(html, width)},
+	}
+}
+
+// writeAlternative writes text, with part headers textHdr, and html as the
+// parts of a multipart/alternative body with the given boundary, plain text
+// first so that clients prefer the HTML version (RFC 2046, section 5.1.4).
+func writeAlternative(w io.Writer, boundary string, textHdr textproto.MIMEHeader, text, html string, width int) error {
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return err
+	}
+	pw, _ := mw.CreatePart(textHdr)
+	if err := writeTextPart(pw, text, width); err != nil {
+		return err
+	}
+	pw, _ = mw.CreatePart(htmlPartHeader(html, width))
+	if err := writeTextPart(pw, html, width); err != nil {
+		return err
+	}
+	return mw.Close()
+}
@@ -0,0 +1,175 @@
+package pigeon
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readParts collects the bodies of the parts of a multipart entity into
+// parts, keyed by media type, descending into nested multiparts.
+// Quoted-printable bodies are decoded by multipart.Reader.
+func readParts(t *testing.T, ctype string, body io.Reader, parts map[string]string) {
+	t.Helper()
+	mediaType, params, err := mime.ParseMediaType(ctype)
+	if err != nil {
+		t.Fatalf("ParseMediaType(%q): %v", ctype, err)
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		b, _ := io.ReadAll(body)
+		parts[mediaType] = string(b)
+		return
+	}
+	parts[mediaType] = ""
+	mr := multipart.NewReader(body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.Fatalf("NextPart: %v", err)
+		}
+		readParts(t, p.Header.Get("Content-Type"), p, parts)
+	}
+}
+
+func TestBuildMessage_HTML(t *testing.T) {
+	attPath := filepath.Join(t.TempDir(), "report.bin")
+	if err := os.WriteFile(attPath, []byte("report"), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	for _, attachments := range [][]string{nil, {attPath}} {
+		cfg := EmailConfig{
+			TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: HTML\n\nHello, {{ .Name }}"),
+			HTML:         "<p>Hello, <b>{{ .Name }}</b></p>",
+			Attachments:  attachments,
+		}
+		raw, err := BuildMessage(cfg, map[string]string{"Name": "<Bob>"})
+		if err != nil {
+			t.Fatalf("BuildMessage: %v", err)
+		}
+		msg, err := mail.ReadMessage(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatalf("ReadMessage: %v", err)
+		}
+
+		parts := map[string]string{}
+		readParts(t, msg.Header.Get("Content-Type"), msg.Body, parts)
+		if _, ok := parts["multipart/alternative"]; !ok {
+			t.Errorf("attachments=%v: no multipart/alternative part: %v", attachments, parts)
+		}
+		if got := parts["text/plain"]; got != "Hello, <Bob>" {
+			t.Errorf("attachments=%v: text part = %q", attachments, got)
+		}
+		// Data is escaped in the HTML version.
+		if got := parts["text/html"]; got != "<p>Hello, <b>&lt;Bob&gt;</b></p>" {
+			t.Errorf("attachments=%v: HTML part = %q", attachments, got)
+		}
+		if len(attachments) > 0 {
+			if _, ok := parts["multipart/mixed"]; !ok {
+				t.Errorf("attachments=%v: no multipart/mixed part: %v", attachments, parts)
+			}
+		}
+	}
+}
+
+func TestBuildMessage_HTMLFuncs(t *testing.T) {
+	tmplPath := tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: HTML\n\nHi")
+	if err := os.WriteFile(filepath.Join(filepath.Dir(tmplPath), "footer.txt"), []byte("Ops & Co"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := EmailConfig{
+		TemplatePath: tmplPath,
+		HTML:         `<p>{{ join ", " .Items }}</p><p>{{ formatTime "DateOnly" .At }}</p><p>{{ include "footer.txt" }}</p>`,
+	}
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	raw, err := BuildMessage(cfg, map[string]any{"Items": []string{"a", "b"}, "At": at})
+	if err != nil {
+		t.Fatalf("BuildMessage: %v", err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	parts := map[string]string{}
+	readParts(t, msg.Header.Get("Content-Type"), msg.Body, parts)
+	// Included text is escaped like any other value.
+	if got, want := parts["text/html"], "<p>a, b</p><p>2024-05-01</p><p>Ops &amp; Co</p>"; got != want {
+		t.Errorf("HTML part = %q, want %q", got, want)
+	}
+}
+
+func TestBuildMessage_SanitizeHTML(t *testing.T) {
+	html := `<p onclick="steal()">Hi <a href="https://example.com">there</a></p><script>alert(1)</script>`
+
+	for _, sanitize := range []bool{false, true} {
+		cfg := EmailConfig{
+			TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: HTML\n\nHi there"),
+			HTML:         html,
+			SanitizeHTML: sanitize,
+		}
+		raw, err := BuildMessage(cfg, nil)
+		if err != nil {
+			t.Fatalf("BuildMessage: %v", err)
+		}
+		msg, err := mail.ReadMessage(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatalf("ReadMessage: %v", err)
+		}
+		parts := map[string]string{}
+		readParts(t, msg.Header.Get("Content-Type"), msg.Body, parts)
+		got := parts["text/html"]
+		if hasScript := strings.Contains(got, "<script>") || strings.Contains(got, "onclick"); hasScript == sanitize {
+			t.Errorf("sanitize=%v: HTML part = %q", sanitize, got)
+		}
+		if !strings.Contains(got, `<a href="https://example.com"`) {
+			t.Errorf("sanitize=%v: link missing from HTML part: %q", sanitize, got)
+		}
+	}
+}
+
+func TestBuildMessage_HTMLTransformer(t *testing.T) {
+	const pixel = `<img src="https://track.example.com/o/42.gif" width="1" height="1">`
+	cfg := EmailConfig{
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: HTML\n\nHello, {{ .Name }}"),
+		HTML:         "<p>Hello, {{ .Name }}</p>",
+		SanitizeHTML: true,
+		HTMLTransformer: func(html string, data any) (string, error) {
+			if data.(map[string]string)["Name"] != "Bob" {
+				t.Errorf("HTMLTransformer data = %v", data)
+			}
+			return html + pixel, nil
+		},
+	}
+	raw, err := BuildMessage(cfg, map[string]string{"Name": "Bob"})
+	if err != nil {
+		t.Fatalf("BuildMessage: %v", err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	parts := map[string]string{}
+	readParts(t, msg.Header.Get("Content-Type"), msg.Body, parts)
+	if want := "<p>Hello, Bob</p>" + pixel; parts["text/html"] != want {
+		t.Errorf("HTML part = %q, want %q", parts["text/html"], want)
+	}
+	if strings.Contains(parts["text/plain"], "track.example.com") {
+		t.Errorf("pixel in text part: %q", parts["text/plain"])
+	}
+
+	cfg.HTMLTransformer = func(string, any) (string, error) { return "", errors.New("boom") }
+	if _, err := BuildMessage(cfg, map[string]string{"Name": "Bob"}); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("BuildMessage error = %v, want transformer error", err)
+	}
+}
@@ -0,0 +1,36 @@
+package pigeon
+
+import (
+	"context"
+	"log/slog"
+)
+
+// loggerKey is the context key for the logger set by ContextWithLogger.
+type loggerKey struct{}
+
+// ContextWithLogger returns a copy of ctx carrying logger. Sends made with
+// the returned context log to it instead of EmailConfig.Logger, so that a
+// service can attach per-request attributes, e.g.
+//
+//	ctx = pigeon.ContextWithLogger(ctx, slog.Default().With("request_id", id))
+//
+// Connections are logged at debug level with the remote address, each
+// accepted recipient at debug level, and each delivered message at info
+// level with its sender, number of recipients and size. A message that could
+// not be delivered is logged with the error at warn level if the failure is
+// temporary, and at error level otherwise.
+func ContextWithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// contextLogger returns the logger carried by ctx, or def if there is none,
+// or slog.Default() if def is nil too.
+func contextLogger(ctx context.Context, def *slog.Logger) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok && l != nil {
+		return l
+	}
+	if def != nil {
+		return def
+	}
+	return slog.Default()
+}
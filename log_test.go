@@ -0,0 +1,106 @@
+package pigeon
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSend_ContextLogger(t *testing.T) {
+	m := &mockSMTP{}
+	m.start(t)
+
+	var cfgLog, ctxLog bytes.Buffer
+	cfg := EmailConfig{
+		Smarthost:    m.smarthost(),
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com, c@example.com\nSubject: Hi\n\nBody."),
+		Logger:       slog.New(slog.NewJSONHandler(&cfgLog, nil)),
+	}
+	logger := slog.New(slog.NewJSONHandler(&ctxLog, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ctx = ContextWithLogger(ctx, logger.With("request_id", "req-42"))
+
+	if retry, err := Send(ctx, cfg, nil); err != nil {
+		t.Fatalf("Send = (%v, %v)", retry, err)
+	}
+	<-m.received
+
+	if cfgLog.Len() != 0 {
+		t.Errorf("EmailConfig.Logger was used despite the context logger:\n%s", cfgLog.String())
+	}
+
+	var msgs []string
+	for _, line := range strings.Split(strings.TrimSpace(ctxLog.String()), "\n") {
+		var rec map[string]any
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("log line %q: %v", line, err)
+		}
+		if rec["request_id"] != "req-42" {
+			t.Errorf("record %q has request_id %v", rec["msg"], rec["request_id"])
+		}
+		msg, _ := rec["msg"].(string)
+		switch msg {
+		case "connected to SMTP server":
+			if rec["remote_addr"] != m.addr {
+				t.Errorf("remote_addr = %v, want %s", rec["remote_addr"], m.addr)
+			}
+		case "recipient accepted":
+			msg += " " + rec["rcpt"].(string)
+		case "message sent":
+			if rec["from"] != "a@example.com" || rec["rcpts"] != float64(2) || rec["size"] == nil {
+				t.Errorf("message sent record = %v", rec)
+			}
+		}
+		msgs = append(msgs, msg)
+	}
+	want := "connected to SMTP server|recipient accepted b@example.com|recipient accepted c@example.com|message sent"
+	if got := strings.Join(msgs, "|"); got != want {
+		t.Errorf("log records = %s, want %s", got, want)
+	}
+}
+
+func TestSend_LogsFailure(t *testing.T) {
+	tests := []struct {
+		reply string
+		level string
+	}{
+		{reply: "451 4.3.0 Try again later", level: "WARN"},
+		{reply: "550 5.1.1 No such user", level: "ERROR"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.level, func(t *testing.T) {
+			m := &mockSMTP{Replies: map[string]string{"RCPT": tt.reply}}
+			m.start(t)
+			var buf bytes.Buffer
+			cfg := EmailConfig{
+				Smarthost:    m.smarthost(),
+				TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Hi\n\nBody."),
+				Logger:       slog.New(slog.NewJSONHandler(&buf, nil)),
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if _, err := Send(ctx, cfg, nil); err == nil {
+				t.Fatal("Send: want error")
+			}
+
+			var rec map[string]any
+			if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+				t.Fatalf("log %q: %v", buf.String(), err)
+			}
+			if rec["msg"] != "message not sent" || rec["level"] != tt.level || !strings.Contains(rec["err"].(string), tt.reply[:3]) {
+				t.Errorf("log record = %v, want %s record with the error", rec, tt.level)
+			}
+		})
+	}
+}
+
+func TestContextLogger_Default(t *testing.T) {
+	if got := contextLogger(context.Background(), nil); got != slog.Default() {
+		t.Errorf("contextLogger without loggers = %v, want slog.Default()", got)
+	}
+}
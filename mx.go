@@ -0,0 +1,141 @@
+package pigeon
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+)
+
+// deliverMX delivers msg directly to the mail exchangers of each recipient
+// domain. Recipients are grouped by domain and one transaction is made per
+// domain, trying the domain's MX hosts in preference order until one accepts
+// a connection. Domains without MX records fall back to their A/AAAA records
+// (RFC 5321, section 5.1).
+//
+// If any domain fails, the combined error is returned and retry reports
+// whether any of the failures was temporary. If other domains succeeded, the
+// error is a *PartialDeliveryError naming only the recipients of the failed
+// domains, so that a retry does not deliver the message twice to the rest.
+func deliverMX(ctx context.Context, d *net.Dialer, cfg EmailConfig, from string, rcpts []string, msg []byte) (retry bool, err error) {
+	port := cfg.Smarthost.Port
+	if port == "" {
+		port = "25"
+	}
+
+	domains, byDomain, err := groupByDomain(rcpts)
+	if err != nil {
+		return false, err
+	}
+
+	var (
+		errs      []error
+		failed    []string
+		delivered bool
+	)
+	for _, domain := range domains {
+		r, err := deliverDomain(ctx, d, cfg, domain, port, from, byDomain[domain], msg)
+		if err != nil {
+			retry = retry || r
+			errs = append(errs, fmt.Errorf("%s: %w", domain, err))
+			failed = append(failed, byDomain[domain]...)
+			continue
+		}
+		delivered = true
+	}
+	err = errors.Join(errs...)
+	if err != nil && delivered {
+		err = &PartialDeliveryError{Failed: failed, Err: err}
+	}
+	return retry, err
+}
+
+// PartialDeliveryError is returned when a message sent with DirectMX was
+// delivered to some recipient domains but not to others. Only the Failed
+// recipients need the message again.
+type PartialDeliveryError struct {
+	// Failed lists the bare addresses the message was not delivered to.
+	Failed []string
+	// Err is the combined error of the failed domains.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *PartialDeliveryError) Error() string {
+	return fmt.Sprintf("delivery failed for %s: %v", strings.Join(e.Failed, ", "), e.Err)
+}
+
+// Unwrap returns the combined error of the failed domains.
+func (e *PartialDeliveryError) Unwrap() error { return e.Err }
+
+// deliverDomain delivers msg to rcpts, which all belong to domain.
+func deliverDomain(ctx context.Context, d *net.Dialer, cfg EmailConfig, domain, port, from string, rcpts []string, msg []byte) (retry bool, err error) {
+	hosts, err := lookupMX(ctx, cfg.resolver(), domain)
+	if err != nil {
+		var dnsErr *net.DNSError
+		return errors.As(err, &dnsErr) && (dnsErr.IsTemporary || dnsErr.IsTimeout), err
+	}
+
+	hostPorts := make([]string, len(hosts))
+	for i, host := range hosts {
+		hostPorts[i] = net.JoinHostPort(host, port)
+	}
+	return deliverHosts(ctx, d, cfg, hostPorts, from, rcpts, msg)
+}
+
+// lookupMX returns the mail exchangers for domain in preference order. The
+// records are sorted here, since a Resolver other than net.Resolver need not
+// return them sorted.
+func lookupMX(ctx context.Context, r Resolver, domain string) ([]string, error) {
+	mxs, err := r.LookupMX(ctx, domain)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			// No MX records: the domain itself is the implicit MX.
+			return []string{domain}, nil
+		}
+		return nil, fmt.Errorf("MX lookup failed: %w", err)
+	}
+	if len(mxs) == 0 {
+		return []string{domain}, nil
+	}
+
+	mxs = slices.Clone(mxs)
+	slices.SortStableFunc(mxs, func(a, b *net.MX) int { return cmp.Compare(a.Pref, b.Pref) })
+	hosts := make([]string, 0, len(mxs))
+	for _, mx := range mxs {
+		host := strings.TrimSuffix(mx.Host, ".")
+		if host == "" {
+			// Null MX (RFC 7505): the domain does not accept mail.
+			return nil, errors.New("domain does not accept mail (null MX)")
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
+
+// groupByDomain groups the bare addresses of rcpts by lower-cased domain,
+// returning the domains in order of first appearance.
+func groupByDomain(rcpts []string) ([]string, map[string][]string, error) {
+	var domains []string
+	byDomain := make(map[string][]string)
+	for _, rcpt := range rcpts {
+		addr, err := extractAddr(rcpt)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid recipient %q: %w", rcpt, err)
+		}
+		i := strings.LastIndex(addr, "@")
+		if i < 0 || i == len(addr)-1 {
+			return nil, nil, fmt.Errorf("recipient %q has no domain", rcpt)
+		}
+		domain := strings.ToLower(addr[i+1:])
+		if _, ok := byDomain[domain]; !ok {
+			domains = append(domains, domain)
+		}
+		byDomain[domain] = append(byDomain[domain], addr)
+	}
+	return domains, byDomain, nil
+}
@@ -0,0 +1,143 @@
+package pigeon
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSend_DirectMX(t *testing.T) {
+	m := &mockSMTP{}
+	m.start(t)
+	_, port, _ := net.SplitHostPort(m.addr)
+
+	loopback := []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}}
+	res := &stubResolver{
+		ips: map[string][]net.IPAddr{
+			"mx2.one.test": loopback,
+			"two.test":     loopback,
+		},
+		mx: map[string][]*net.MX{
+			// The preferred MX does not resolve, so delivery falls back to mx2.
+			"one.test": {{Host: "mx1.one.test.", Pref: 10}, {Host: "mx2.one.test.", Pref: 20}},
+		},
+	}
+
+	tmplPath := tplWriteTemp(t, "From: sender@example.com\nTo: a@one.test, b@two.test\nCc: c@ONE.test\nSub: MX\n\nBody.")
+	cfg := EmailConfig{
+		Smarthost:    HostPort{Port: port},
+		DirectMX:     true,
+		Resolver:     res,
+		TemplatePath: tmplPath,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	retry, err := Send(ctx, cfg, nil)
+	if err != nil {
+		t.Fatalf("Send error: %v (retry=%v)", err, retry)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-m.received:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected 2 messages, got %d", i)
+		}
+	}
+
+	var rcpts []string
+	for _, c := range m.Commands() {
+		if strings.HasPrefix(c, "RCPT TO:") {
+			rcpts = append(rcpts, c)
+		}
+	}
+	want := []string{"RCPT TO:<a@one.test>", "RCPT TO:<c@ONE.test>", "RCPT TO:<b@two.test>"}
+	if !reflect.DeepEqual(rcpts, want) {
+		t.Errorf("RCPT commands = %v, want %v", rcpts, want)
+	}
+	if len(m.RemoteAddrs()) != 2 {
+		t.Errorf("expected one connection per domain, got %d", len(m.RemoteAddrs()))
+	}
+}
+
+func TestSend_DirectMXPartial(t *testing.T) {
+	m := &mockSMTP{}
+	m.start(t)
+	_, port, _ := net.SplitHostPort(m.addr)
+
+	res := &stubResolver{
+		ips: map[string][]net.IPAddr{"one.test": {{IP: net.ParseIP("127.0.0.1")}}},
+		mx:  map[string][]*net.MX{"null.test": {{Host: ".", Pref: 0}}},
+	}
+	cfg := EmailConfig{
+		Smarthost:    HostPort{Port: port},
+		DirectMX:     true,
+		Resolver:     res,
+		TemplatePath: tplWriteTemp(t, "From: sender@example.com\nTo: a@one.test, b@null.test, c@NULL.test\nSubject: MX\n\nBody."),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	retry, err := Send(ctx, cfg, nil)
+	var perr *PartialDeliveryError
+	if !errors.As(err, &perr) {
+		t.Fatalf("Send error = %v, want *PartialDeliveryError", err)
+	}
+	if want := []string{"b@null.test", "c@NULL.test"}; !reflect.DeepEqual(perr.Failed, want) {
+		t.Errorf("Failed = %v, want %v", perr.Failed, want)
+	}
+	if retry {
+		t.Error("retry = true for a null MX")
+	}
+	<-m.received
+
+	// With no domain delivered, the error is not partial.
+	cfg.TemplatePath = tplWriteTemp(t, "From: sender@example.com\nTo: b@null.test\nSubject: MX\n\nBody.")
+	if _, err := Send(ctx, cfg, nil); err == nil || errors.As(err, &perr) {
+		t.Errorf("Send error = %v, want a plain failure", err)
+	}
+}
+
+func TestLookupMX(t *testing.T) {
+	res := &stubResolver{mx: map[string][]*net.MX{
+		"example.test": {{Host: "a.example.test.", Pref: 5}, {Host: "b.example.test.", Pref: 10}},
+		"unsorted.test": {
+			{Host: "backup.unsorted.test.", Pref: 20},
+			{Host: "primary1.unsorted.test.", Pref: 10},
+			{Host: "last.unsorted.test.", Pref: 30},
+			{Host: "primary2.unsorted.test.", Pref: 10},
+		},
+		"null.test": {{Host: ".", Pref: 0}},
+	}}
+
+	hosts, err := lookupMX(context.Background(), res, "example.test")
+	if err != nil || !reflect.DeepEqual(hosts, []string{"a.example.test", "b.example.test"}) {
+		t.Errorf("lookupMX(example.test) = %v, %v", hosts, err)
+	}
+
+	// Records are tried by preference whatever order the resolver returns
+	// them in, keeping that order among equal preferences.
+	hosts, err = lookupMX(context.Background(), res, "unsorted.test")
+	want := []string{"primary1.unsorted.test", "primary2.unsorted.test", "backup.unsorted.test", "last.unsorted.test"}
+	if err != nil || !reflect.DeepEqual(hosts, want) {
+		t.Errorf("lookupMX(unsorted.test) = %v, %v; want %v", hosts, err, want)
+	}
+	if res.mx["unsorted.test"][0].Pref != 20 {
+		t.Error("lookupMX reordered the resolver's records")
+	}
+
+	hosts, err = lookupMX(context.Background(), res, "nomx.test")
+	if err != nil || !reflect.DeepEqual(hosts, []string{"nomx.test"}) {
+		t.Errorf("lookupMX(nomx.test) = %v, %v; want implicit MX", hosts, err)
+	}
+
+	if _, err := lookupMX(context.Background(), res, "null.test"); err == nil {
+		t.Errorf("expected error for null MX")
+	}
+}
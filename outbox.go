@@ -0,0 +1,50 @@
+package pigeon
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// writeOutbox writes msg to a new .eml file in cfg.OutboxDir. The file is
+// named after the current time and the Message-ID, so that a directory
+// listing sorts in sending order. A message without a Message-ID gets a
+// generated one in the file.
+//
+// The file starts with Return-Path and X-Envelope-To headers, as a delivery
+// agent would add them, so that recipients found only in the envelope, such
+// as Bcc or ArchiveBcc addresses, are recorded too.
+func writeOutbox(cfg EmailConfig, env Envelope, msg []byte) error {
+	var trace bytes.Buffer
+	fmt.Fprintf(&trace, "Return-Path: <%s>\r\n", env.From)
+	fmt.Fprintf(&trace, "X-Envelope-To: %s\r\n", strings.Join(env.Rcpts, ", "))
+	messageID := env.messageID
+	if messageID == "" {
+		messageID = newMessageID(env.From)
+		fmt.Fprintf(&trace, "Message-ID: %s\r\n", messageID)
+	}
+
+	id := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '@':
+			return r
+		case r == '<' || r == '>':
+			return -1
+		}
+		return '_'
+	}, messageID)
+	name := fmt.Sprintf("%s_%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), id)
+
+	f, err := os.OpenFile(filepath.Join(cfg.OutboxDir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create outbox file: %w", err)
+	}
+	if _, err := f.Write(applyLineEnding(cfg, append(trace.Bytes(), msg...))); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write outbox file: %w", err)
+	}
+	return f.Close()
+}
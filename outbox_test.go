@@ -0,0 +1,76 @@
+package pigeon
+
+import (
+	"context"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSend_OutboxDir(t *testing.T) {
+	dir := t.TempDir()
+	cfg := EmailConfig{
+		OutboxDir:    dir,
+		TemplatePath: tplWriteTemp(t, "From: Alerts <alerts@example.com>\nTo: ops@example.com\nSubject: Disk {{.Host}}\n\nDisk space low on {{.Host}}"),
+		ArchiveBcc:   "journal@example.com",
+	}
+
+	for i := 0; i < 2; i++ {
+		retry, err := Send(context.Background(), cfg, map[string]string{"Host": "db1"})
+		if err != nil || retry {
+			t.Fatalf("Send = (%v, %v)", retry, err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("outbox has %d files, want 2", len(entries))
+	}
+
+	for _, e := range entries {
+		f, err := os.Open(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := mail.ReadMessage(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: ReadMessage: %v", e.Name(), err)
+		}
+
+		if got := msg.Header.Get("Subject"); got != "Disk db1" {
+			t.Errorf("Subject = %q", got)
+		}
+		if got := msg.Header.Get("To"); got != "ops@example.com" {
+			t.Errorf("To = %q", got)
+		}
+		if got := msg.Header.Get("Return-Path"); got != "<alerts@example.com>" {
+			t.Errorf("Return-Path = %q", got)
+		}
+		if got := msg.Header.Get("X-Envelope-To"); got != "ops@example.com, journal@example.com" {
+			t.Errorf("X-Envelope-To = %q", got)
+		}
+		id := msg.Header.Get("Message-Id")
+		if !validMessageID(id) || !strings.HasSuffix(id, "@example.com>") {
+			t.Errorf("Message-Id = %q", id)
+		}
+		if want := strings.Trim(id, "<>") + ".eml"; !strings.HasSuffix(e.Name(), want) {
+			t.Errorf("file name %q does not include Message-ID %s", e.Name(), id)
+		}
+	}
+}
+
+func TestSend_OutboxDirMissing(t *testing.T) {
+	cfg := EmailConfig{
+		OutboxDir:    filepath.Join(t.TempDir(), "missing"),
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\n\nbody"),
+	}
+	if _, err := Send(context.Background(), cfg, nil); err == nil {
+		t.Fatal("expected error for missing outbox directory")
+	}
+}
@@ -0,0 +1,124 @@
+package pigeon
+
+import (
+	"errors"
+	"net/textproto"
+	"strings"
+
+	"github.com/dotarpa/pigeon/tpl"
+)
+
+// ErrEmptyBody is returned when the body renders blank and the message has no
+// HTML body or attachments either, unless EmailConfig.AllowEmptyBody is set.
+var ErrEmptyBody = errors.New("rendered message body is empty")
+
+// MIMEPart is a leaf part of a message body, as returned by BuildParts.
+type MIMEPart struct {
+	// Header holds the part's headers, e.g. Content-Type and
+	// Content-Transfer-Encoding.
+	Header textproto.MIMEHeader
+	// Body is the part's content before transfer encoding.
+	Body []byte
+}
+
+// BuildParts renders the body of the message BuildMessage would build for
+// cfg and data, and returns its leaf parts in order: the text body, the HTML
+// body if any, then the attachments. The parts are returned before they are
+// encoded and serialized, and without any OpenPGP wrapping, so that tests can
+// inspect each one without parsing the message.
+func BuildParts(cfg EmailConfig, data any) ([]MIMEPart, error) {
+	t, err := parseTemplate(cfg)
+	if err != nil {
+		return nil, err
+	}
+	b, err := renderParts(t, cfg, data)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := []MIMEPart{{Header: b.textHdr, Body: []byte(b.text)}}
+	if b.html != "" {
+		parts = append(parts, MIMEPart{Header: htmlPartHeader(b.html, b.qpWidth), Body: []byte(b.html)})
+	}
+	for _, a := range b.attachments {
+		parts = append(parts, MIMEPart{Header: a.header(), Body: a.data})
+	}
+	return parts, nil
+}
+
+// bodyParts holds the rendered body of a message and its loaded attachments.
+type bodyParts struct {
+	textHdr     textproto.MIMEHeader
+	text, html  string
+	attachments []*attachment
+	// cte is the validated ContentTransferEncoding of the message, if set.
+	cte string
+	// qpWidth is the column at which quoted-printable text is wrapped.
+	qpWidth int
+	// b64Width is the length of the base64-encoded lines of attachments.
+	b64Width int
+}
+
+// renderParts renders the text and HTML bodies of t with data and loads the
+// attachments in cfg, whose paths are rendered with data as well. The text
+// part is given cfg.ContentTransferEncoding if it is the whole body.
+func renderParts(t *tpl.Template, cfg EmailConfig, data any) (*bodyParts, error) {
+	text, err := renderBody(t, cfg, data)
+	if err != nil {
+		return nil, err
+	}
+	html, err := renderHTML(cfg, data)
+	if err != nil {
+		return nil, err
+	}
+	ctype, err := textContentType(cfg)
+	if err != nil {
+		return nil, err
+	}
+	specs, err := renderAttachments(cfg, data)
+	if err != nil {
+		return nil, err
+	}
+	if needsContentIDDomain(specs) {
+		domain, err := contentIDDomain(t, cfg, data)
+		if err != nil {
+			return nil, err
+		}
+		specs, html = qualifyContentIDs(specs, html, domain)
+	}
+	cfg.Attachments, cfg.AttachmentSpecs = nil, specs
+	width, err := cfg.qpLineLength()
+	if err != nil {
+		return nil, err
+	}
+	b64Width, err := cfg.base64LineLength()
+	if err != nil {
+		return nil, err
+	}
+	attachments, err := loadAttachments(cfg)
+	if err != nil {
+		return nil, err
+	}
+	multipart := html != "" || len(attachments) > 0
+	if err := checkEmptyBody(cfg, text, multipart); err != nil {
+		return nil, err
+	}
+	cte, err := messageEncoding(cfg, multipart, text)
+	if err != nil {
+		return nil, err
+	}
+
+	textHdr := textPartHeader(ctype, text, width)
+	if cte != "" && !multipart {
+		textHdr.Set("Content-Transfer-Encoding", cte)
+	}
+	return &bodyParts{textHdr: textHdr, text: text, html: html, attachments: attachments, cte: cte, qpWidth: width, b64Width: b64Width}, nil
+}
+
+// checkEmptyBody returns ErrEmptyBody if text is blank and is the whole body.
+func checkEmptyBody(cfg EmailConfig, text string, multipart bool) error {
+	if !multipart && !cfg.AllowEmptyBody && strings.TrimSpace(text) == "" {
+		return ErrEmptyBody
+	}
+	return nil
+}
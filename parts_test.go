@@ -0,0 +1,91 @@
+package pigeon
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBuildParts(t *testing.T) {
+	attPath := filepath.Join(t.TempDir(), "report.pdf")
+	if err := os.WriteFile(attPath, []byte("%PDF-1.4"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := EmailConfig{
+		TemplatePath:    tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Parts\n\nHello {{ .Name }}."),
+		HTML:            "<p>Hello {{ .Name }}.</p>",
+		AttachmentSpecs: []Attachment{{Path: attPath, Description: "Report"}},
+	}
+	parts, err := BuildParts(cfg, map[string]string{"Name": "Alice"})
+	if err != nil {
+		t.Fatalf("BuildParts: %v", err)
+	}
+	if len(parts) != 3 {
+		t.Fatalf("got %d parts, want 3", len(parts))
+	}
+
+	tests := []struct {
+		ctype, encoding, body string
+	}{
+		{ctype: "text/plain; charset=UTF-8", encoding: "7bit", body: "Hello Alice."},
+		{ctype: "text/html; charset=UTF-8", encoding: "7bit", body: "<p>Hello Alice.</p>"},
+		{ctype: `application/pdf; name="report.pdf"`, encoding: "base64", body: "%PDF-1.4"},
+	}
+	for i, tt := range tests {
+		p := parts[i]
+		if got := p.Header.Get("Content-Type"); got != tt.ctype {
+			t.Errorf("part %d: Content-Type = %q, want %q", i, got, tt.ctype)
+		}
+		if got := p.Header.Get("Content-Transfer-Encoding"); got != tt.encoding {
+			t.Errorf("part %d: Content-Transfer-Encoding = %q, want %q", i, got, tt.encoding)
+		}
+		if string(p.Body) != tt.body {
+			t.Errorf("part %d: Body = %q, want %q", i, p.Body, tt.body)
+		}
+	}
+	if got := parts[2].Header.Get("Content-Description"); got != "Report" {
+		t.Errorf("Content-Description = %q, want Report", got)
+	}
+
+	cfg.AttachmentSpecs = []Attachment{{Path: filepath.Join(t.TempDir(), "missing.pdf")}}
+	if _, err := BuildParts(cfg, nil); err == nil {
+		t.Error("BuildParts with a missing attachment: want error")
+	}
+}
+
+func TestSend_EmptyBody(t *testing.T) {
+	m := &mockSMTP{}
+	m.start(t)
+	cfg := EmailConfig{
+		Smarthost:    m.smarthost(),
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Ping\n\n{{ if .Show }}Hello{{ end }}\n  \n"),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if retry, err := Send(ctx, cfg, map[string]bool{"Show": false}); !errors.Is(err, ErrEmptyBody) || retry {
+		t.Errorf("Send = (%v, %v), want ErrEmptyBody", retry, err)
+	}
+	if _, err := EstimateSize(cfg, map[string]bool{"Show": false}); !errors.Is(err, ErrEmptyBody) {
+		t.Errorf("EstimateSize: err = %v, want ErrEmptyBody", err)
+	}
+	if len(m.RemoteAddrs()) != 0 {
+		t.Error("connected to the server for an empty message")
+	}
+
+	cfg.AllowEmptyBody = true
+	if _, err := Send(ctx, cfg, map[string]bool{"Show": false}); err != nil {
+		t.Fatalf("Send with AllowEmptyBody: %v", err)
+	}
+	<-m.received
+
+	// A blank body is fine when something else is sent.
+	cfg.AllowEmptyBody = false
+	cfg.HTML = "<p>Hello</p>"
+	if _, err := Send(ctx, cfg, map[string]bool{"Show": false}); err != nil {
+		t.Errorf("Send with HTML body: %v", err)
+	}
+}
@@ -0,0 +1,201 @@
+package pigeon
+
+import (
+	"bytes"
+	"crypto"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// wrapPGP replaces body with an OpenPGP/MIME (RFC 3156) multipart/signed or
+// multipart/encrypted entity. The Content-Type and Content-Transfer-Encoding
+// headers describing the original body are moved from hdr into the protected
+// entity, and hdr is updated to describe the wrapper.
+func wrapPGP(pc *PGPConfig, hdr textproto.MIMEHeader, body *bytes.Buffer) error {
+	var signer *openpgp.Entity
+	if pc.SigningKey != "" {
+		var err error
+		if signer, err = loadSigningKey(pc.SigningKey, pc.Passphrase); err != nil {
+			return err
+		}
+	}
+
+	var recipients openpgp.EntityList
+	for _, path := range pc.RecipientKeys {
+		keys, err := readArmoredKeyFile(path)
+		if err != nil {
+			return err
+		}
+		recipients = append(recipients, keys...)
+	}
+
+	if signer == nil && len(recipients) == 0 {
+		return errors.New("pgp: signing_key or recipient_keys must be specified")
+	}
+
+	// The protected entity is the original body together with its content
+	// headers, in canonical CRLF form so that the signature covers exactly
+	// the bytes that are transmitted.
+	inner := textproto.MIMEHeader{}
+	for _, k := range []string{"Content-Type", "Content-Transfer-Encoding"} {
+		if v := hdr.Get(k); v != "" {
+			inner.Set(k, v)
+		}
+		hdr.Del(k)
+	}
+	var entity bytes.Buffer
+	writeHeaders(&entity, inner, maxLineLength)
+	entity.WriteString("\r\n")
+	entity.WriteString(toCRLF(body.String()))
+
+	pgpCfg := &packet.Config{DefaultHash: crypto.SHA256}
+	boundary := fmt.Sprintf("pigeon_pgp_%d", time.Now().UnixNano())
+	body.Reset()
+
+	if len(recipients) == 0 {
+		var sig bytes.Buffer
+		if err := openpgp.DetachSign(&sig, signer, bytes.NewReader(entity.Bytes()), pgpCfg); err != nil {
+			return fmt.Errorf("pgp: failed to sign message: %w", err)
+		}
+		micalg, err := pgpMicalg(sig.Bytes())
+		if err != nil {
+			return err
+		}
+
+		hdr.Set("Content-Type", fmt.Sprintf(
+			"multipart/signed; boundary=%q; micalg=%s; protocol=\"application/pgp-signature\"",
+			boundary, micalg))
+
+		fmt.Fprintf(body, "--%s\r\n", boundary)
+		body.Write(entity.Bytes())
+		fmt.Fprintf(body, "\r\n--%s\r\n", boundary)
+		body.WriteString("Content-Type: application/pgp-signature; name=\"signature.asc\"\r\n")
+		body.WriteString("Content-Description: OpenPGP digital signature\r\n")
+		body.WriteString("Content-Disposition: attachment; filename=\"signature.asc\"\r\n\r\n")
+		if err := writeArmored(body, "PGP SIGNATURE", sig.Bytes()); err != nil {
+			return err
+		}
+		fmt.Fprintf(body, "\r\n--%s--\r\n", boundary)
+		return nil
+	}
+
+	var enc bytes.Buffer
+	aw, err := armor.Encode(&enc, "PGP MESSAGE", nil)
+	if err != nil {
+		return fmt.Errorf("pgp: failed to encrypt message: %w", err)
+	}
+	pw, err := openpgp.Encrypt(aw, recipients, signer, nil, pgpCfg)
+	if err != nil {
+		return fmt.Errorf("pgp: failed to encrypt message: %w", err)
+	}
+	if _, err := pw.Write(entity.Bytes()); err != nil {
+		return fmt.Errorf("pgp: failed to encrypt message: %w", err)
+	}
+	if err := pw.Close(); err != nil {
+		return fmt.Errorf("pgp: failed to encrypt message: %w", err)
+	}
+	if err := aw.Close(); err != nil {
+		return fmt.Errorf("pgp: failed to encrypt message: %w", err)
+	}
+
+	hdr.Set("Content-Type", fmt.Sprintf(
+		"multipart/encrypted; boundary=%q; protocol=\"application/pgp-encrypted\"", boundary))
+
+	fmt.Fprintf(body, "--%s\r\n", boundary)
+	body.WriteString("Content-Type: application/pgp-encrypted\r\n")
+	body.WriteString("Content-Description: PGP/MIME version identification\r\n\r\n")
+	body.WriteString("Version: 1\r\n")
+	fmt.Fprintf(body, "\r\n--%s\r\n", boundary)
+	body.WriteString("Content-Type: application/octet-stream; name=\"encrypted.asc\"\r\n")
+	body.WriteString("Content-Description: OpenPGP encrypted message\r\n")
+	body.WriteString("Content-Disposition: inline; filename=\"encrypted.asc\"\r\n\r\n")
+	body.WriteString(toCRLF(enc.String()))
+	fmt.Fprintf(body, "\r\n--%s--\r\n", boundary)
+	return nil
+}
+
+// loadSigningKey reads the first entity from an armored private key file,
+// decrypting it with passphrase when the key is protected.
+func loadSigningKey(path string, passphrase Secret) (*openpgp.Entity, error) {
+	keys, err := readArmoredKeyFile(path)
+	if err != nil {
+		return nil, err
+	}
+	signer := keys[0]
+	if signer.PrivateKey == nil {
+		return nil, fmt.Errorf("pgp: %s does not contain a private key", path)
+	}
+	if signer.PrivateKey.Encrypted {
+		if passphrase == "" {
+			return nil, fmt.Errorf("pgp: private key %s is encrypted but no passphrase was given", path)
+		}
+		if err := signer.DecryptPrivateKeys([]byte(passphrase)); err != nil {
+			return nil, fmt.Errorf("pgp: failed to decrypt private key %s: %w", path, err)
+		}
+	}
+	return signer, nil
+}
+
+// readArmoredKeyFile reads an ASCII-armored key ring from path.
+func readArmoredKeyFile(path string) (openpgp.EntityList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	keys, err := openpgp.ReadArmoredKeyRing(f)
+	if err != nil {
+		return nil, fmt.Errorf("pgp: failed to read key %s: %w", path, err)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("pgp: no keys found in %s", path)
+	}
+	return keys, nil
+}
+
+// pgpMicalg returns the RFC 3156 micalg parameter for a binary signature packet.
+func pgpMicalg(sig []byte) (string, error) {
+	p, err := packet.Read(bytes.NewReader(sig))
+	if err != nil {
+		return "", fmt.Errorf("pgp: failed to read signature: %w", err)
+	}
+	s, ok := p.(*packet.Signature)
+	if !ok {
+		return "", errors.New("pgp: unexpected packet in signature")
+	}
+	name := strings.ReplaceAll(s.Hash.String(), "-", "")
+	return "pgp-" + strings.ToLower(name), nil
+}
+
+// writeArmored writes data to w as an ASCII-armored block with CRLF line endings.
+func writeArmored(w io.Writer, blockType string, data []byte) error {
+	var buf bytes.Buffer
+	aw, err := armor.Encode(&buf, blockType, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := aw.Write(data); err != nil {
+		return err
+	}
+	if err := aw.Close(); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, toCRLF(buf.String()))
+	return err
+}
+
+// toCRLF normalizes all line endings in s to CRLF.
+func toCRLF(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.ReplaceAll(s, "\n", "\r\n")
+}
@@ -0,0 +1,186 @@
+package pigeon
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+)
+
+// writeArmoredKey serializes e (private or public part) to a temp file.
+func writeArmoredKey(t *testing.T, e *openpgp.Entity, private bool) string {
+	t.Helper()
+	var buf bytes.Buffer
+	blockType := openpgp.PublicKeyType
+	if private {
+		blockType = openpgp.PrivateKeyType
+	}
+	w, err := armor.Encode(&buf, blockType, nil)
+	if err != nil {
+		t.Fatalf("armor.Encode: %v", err)
+	}
+	if private {
+		err = e.SerializePrivate(w, nil)
+	} else {
+		err = e.Serialize(w)
+	}
+	if err != nil {
+		t.Fatalf("serialize key: %v", err)
+	}
+	w.Close()
+
+	path := filepath.Join(t.TempDir(), "key.asc")
+	if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	return path
+}
+
+func sendPGP(t *testing.T, pc *PGPConfig) string {
+	t.Helper()
+	addr, recv, teardown := startMockSMTP(t)
+	defer teardown()
+
+	tmplContent := "From: sender@example.com\nTo: recv@example.com\nSub: PGP Test\n\nHello, PGP"
+	tmplPath := tplWriteTemp(t, tmplContent)
+
+	smarthost := HostPort{}
+	smarthost.Host, smarthost.Port, _ = net.SplitHostPort(addr)
+
+	cfg := EmailConfig{
+		Smarthost:    smarthost,
+		TemplatePath: tmplPath,
+		PGP:          pc,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := Send(ctx, cfg, nil); err != nil {
+		t.Fatalf("Send error: %v", err)
+	}
+
+	select {
+	case raw := <-recv:
+		return raw
+	case <-time.After(2 * time.Second):
+		t.Fatal("no message received by mock SMTP")
+	}
+	return ""
+}
+
+func TestSend_PGPSigned(t *testing.T) {
+	signer, err := openpgp.NewEntity("Sender", "", "sender@example.com", nil)
+	if err != nil {
+		t.Fatalf("NewEntity: %v", err)
+	}
+
+	raw := sendPGP(t, &PGPConfig{SigningKey: writeArmoredKey(t, signer, true)})
+
+	msg, err := mail.ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("ParseMediaType: %v", err)
+	}
+	if mediaType != "multipart/signed" {
+		t.Fatalf("Content-Type = %q, want multipart/signed", mediaType)
+	}
+	if params["protocol"] != "application/pgp-signature" || params["micalg"] != "pgp-sha256" {
+		t.Errorf("unexpected multipart/signed params: %v", params)
+	}
+
+	// The mock server strips CRs, so restore the canonical form before verifying.
+	body, _ := io.ReadAll(msg.Body)
+	delim := "--" + params["boundary"]
+	parts := strings.Split(string(body), delim)
+	if len(parts) != 4 {
+		t.Fatalf("expected 2 parts, got %d: %s", len(parts)-2, body)
+	}
+	signed := toCRLF(strings.TrimSuffix(strings.TrimPrefix(parts[1], "\n"), "\n"))
+	if !strings.Contains(signed, "Hello, PGP") {
+		t.Errorf("signed part missing body: %q", signed)
+	}
+	sig := parts[2][strings.Index(parts[2], "-----BEGIN"):]
+
+	keyring := openpgp.EntityList{signer}
+	if _, err := openpgp.CheckArmoredDetachedSignature(keyring, strings.NewReader(signed), strings.NewReader(sig), nil); err != nil {
+		t.Errorf("signature does not verify: %v", err)
+	}
+}
+
+func TestSend_PGPEncrypted(t *testing.T) {
+	signer, err := openpgp.NewEntity("Sender", "", "sender@example.com", nil)
+	if err != nil {
+		t.Fatalf("NewEntity: %v", err)
+	}
+	rcpt, err := openpgp.NewEntity("Receiver", "", "recv@example.com", nil)
+	if err != nil {
+		t.Fatalf("NewEntity: %v", err)
+	}
+
+	raw := sendPGP(t, &PGPConfig{
+		SigningKey:    writeArmoredKey(t, signer, true),
+		RecipientKeys: []string{writeArmoredKey(t, rcpt, false)},
+	})
+
+	msg, err := mail.ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("ParseMediaType: %v", err)
+	}
+	if mediaType != "multipart/encrypted" || params["protocol"] != "application/pgp-encrypted" {
+		t.Fatalf("Content-Type = %q %v, want multipart/encrypted", mediaType, params)
+	}
+
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	control, err := mr.NextPart()
+	if err != nil {
+		t.Fatalf("control part: %v", err)
+	}
+	if ct := control.Header.Get("Content-Type"); ct != "application/pgp-encrypted" {
+		t.Errorf("control part Content-Type = %q", ct)
+	}
+	data, err := mr.NextPart()
+	if err != nil {
+		t.Fatalf("data part: %v", err)
+	}
+
+	block, err := armor.Decode(data)
+	if err != nil {
+		t.Fatalf("armor.Decode: %v", err)
+	}
+	md, err := openpgp.ReadMessage(block.Body, openpgp.EntityList{rcpt, signer}, nil, nil)
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	plain, err := io.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		t.Fatalf("read decrypted body: %v", err)
+	}
+	if !strings.Contains(string(plain), "Content-Type: text/plain; charset=UTF-8\r\n") {
+		t.Errorf("decrypted entity missing content headers: %q", plain)
+	}
+	if !strings.HasSuffix(string(plain), "\r\n\r\nHello, PGP") {
+		t.Errorf("decrypted body mismatch: %q", plain)
+	}
+	if !md.IsSigned || md.SignatureError != nil {
+		t.Errorf("encrypted message not validly signed: signed=%v err=%v", md.IsSigned, md.SignatureError)
+	}
+}
@@ -0,0 +1,84 @@
+package pigeon
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// minQPLineLength is the narrowest quoted-printable line that still fits an
+// encoded octet ("=XX") followed by a soft line break ("=").
+const minQPLineLength = 4
+
+// qpLineLength returns the column at which quoted-printable text is
+// soft-wrapped: cfg.QPLineLength, or 76, the maximum of RFC 2045.
+func (c *EmailConfig) qpLineLength() (int, error) {
+	switch n := c.QPLineLength; {
+	case n == 0:
+		return maxContentLength, nil
+	case n < minQPLineLength || n > maxContentLength:
+		return 0, fmt.Errorf("invalid qp_line_length %d: must be between %d and %d", n, minQPLineLength, maxContentLength)
+	default:
+		return n, nil
+	}
+}
+
+// writeQuotedPrintable writes body in the quoted-printable encoding of
+// RFC 2045, section 6.7, with no encoded line longer than width characters,
+// including the "=" of a soft line break. An encoded octet is never split
+// across lines.
+//
+// Templates usually produce bare LF line endings, so body is first
+// normalized to CRLF: every LF, with or without a preceding CR, is a hard
+// line break and encoded as CRLF. Soft breaks are only inserted within a
+// line, and any CR that is not part of a line break is encoded as "=0D".
+func writeQuotedPrintable(w io.Writer, body string, width int) error {
+	bw := bufio.NewWriter(w)
+	lines := strings.Split(toCRLF(body), "\r\n")
+	for i, line := range lines {
+		last := i == len(lines)-1
+		n := 0
+		for j := 0; j < len(line); j++ {
+			c := line[j]
+			tok := ""
+			switch {
+			case c == ' ' || c == '\t':
+				// Whitespace at the end of a line must be encoded, since
+				// transports may strip it.
+				if j == len(line)-1 {
+					tok = fmt.Sprintf("=%02X", c)
+				}
+			case c < '!' || c > '~' || c == '=':
+				tok = fmt.Sprintf("=%02X", c)
+			}
+			size := len(tok)
+			if tok == "" {
+				size = 1
+			}
+			// Leave room for the "=" of a soft break, unless this is the
+			// last octet of the line.
+			limit := width - 1
+			if j == len(line)-1 {
+				limit = width
+			}
+			if n+size > limit {
+				bw.WriteString("=\r\n")
+				n = 0
+			}
+			if tok == "" {
+				bw.WriteByte(c)
+			} else {
+				bw.WriteString(tok)
+			}
+			n += size
+		}
+		if !last {
+			bw.WriteString("\r\n")
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write quoted-printable: %w", err)
+	}
+	return nil
+}
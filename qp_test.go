@@ -0,0 +1,120 @@
+package pigeon
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestWriteQuotedPrintable(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	alphabet := []byte("ab =\t\r\nü.")
+	inputs := []string{
+		"",
+		"plain",
+		"trailing space \nand tab\t",
+		strings.Repeat("=", 100),
+		strings.Repeat("x", 59) + "=" + strings.Repeat("y", 10),
+		strings.Repeat("Grüße ", 30),
+	}
+	for i := 0; i < 200; i++ {
+		b := make([]byte, rng.Intn(300))
+		for j := range b {
+			b[j] = alphabet[rng.Intn(len(alphabet))]
+		}
+		inputs = append(inputs, string(b))
+	}
+
+	for _, width := range []int{minQPLineLength, 10, 60, maxContentLength} {
+		for _, in := range inputs {
+			var buf bytes.Buffer
+			if err := writeQuotedPrintable(&buf, in, width); err != nil {
+				t.Fatalf("writeQuotedPrintable: %v", err)
+			}
+			for _, line := range strings.Split(buf.String(), "\r\n") {
+				if len(line) > width {
+					t.Fatalf("width %d: line %q is %d characters long", width, line, len(line))
+				}
+				if strings.HasSuffix(line, " ") || strings.HasSuffix(line, "\t") {
+					t.Fatalf("width %d: line %q ends in whitespace", width, line)
+				}
+			}
+			got, err := io.ReadAll(quotedprintable.NewReader(&buf))
+			if err != nil {
+				t.Fatalf("width %d: decode %q: %v", width, in, err)
+			}
+			if want := toCRLF(in); string(got) != want {
+				t.Fatalf("width %d: round trip of %q = %q", width, in, got)
+			}
+		}
+	}
+}
+
+func TestWriteTextPart_BareLF(t *testing.T) {
+	body := "Dear Jürgen,\n\n" + strings.Repeat("This line is long enough to need a soft break somewhere. ", 3) +
+		"\nTrailing space \n\nEnd with =\n"
+	var buf bytes.Buffer
+	if err := writeTextPart(&buf, body, maxContentLength); err != nil {
+		t.Fatalf("writeTextPart: %v", err)
+	}
+	enc := buf.String()
+	if strings.Contains(strings.ReplaceAll(enc, "\r\n", ""), "\n") || strings.Contains(strings.ReplaceAll(enc, "\r\n", ""), "\r") {
+		t.Fatalf("encoded body has bare line breaks:\n%q", enc)
+	}
+	for _, line := range strings.Split(enc, "\r\n") {
+		if len(line) > maxContentLength {
+			t.Errorf("line %q is %d characters long", line, len(line))
+		}
+	}
+	got, err := io.ReadAll(quotedprintable.NewReader(strings.NewReader(enc)))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if want := strings.ReplaceAll(body, "\n", "\r\n"); string(got) != want {
+		t.Errorf("decoded = %q, want %q", got, want)
+	}
+}
+
+func TestBuildMessage_QPLineLength(t *testing.T) {
+	body := strings.Repeat("A line of plain ASCII text that is longer than sixty columns. ", 3) + "\nÜberschrift mit Umlauten, die ebenfalls länger als sechzig Zeichen ist."
+	cfg := EmailConfig{
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Narrow\n\n"+body),
+		QPLineLength: 60,
+	}
+	raw, err := BuildMessage(cfg, nil)
+	if err != nil {
+		t.Fatalf("BuildMessage: %v", err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if got := msg.Header.Get("Content-Transfer-Encoding"); got != "quoted-printable" {
+		t.Fatalf("Content-Transfer-Encoding = %q", got)
+	}
+	encoded, _ := io.ReadAll(msg.Body)
+	for _, line := range strings.Split(string(encoded), "\r\n") {
+		if len(line) > 60 {
+			t.Errorf("line longer than 60 characters: %q", line)
+		}
+	}
+	decoded, err := io.ReadAll(quotedprintable.NewReader(bytes.NewReader(encoded)))
+	if err != nil || strings.ReplaceAll(string(decoded), "\r\n", "\n") != body {
+		t.Errorf("decoded body = %q (%v), want %q", decoded, err, body)
+	}
+
+	// Short ASCII lines stay 7bit.
+	cfg.TemplatePath = tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Narrow\n\nShort line.")
+	if _, res, err := BuildMessageResult(cfg, nil); err != nil || res.Parts[0].Encoding != "7bit" {
+		t.Errorf("BuildMessageResult = (%+v, %v), want 7bit body", res.Parts, err)
+	}
+
+	cfg.QPLineLength = 100
+	if _, err := BuildMessage(cfg, nil); err == nil || !strings.Contains(err.Error(), "qp_line_length") {
+		t.Errorf("BuildMessage with qp_line_length 100: err = %v", err)
+	}
+}
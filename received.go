@@ -0,0 +1,94 @@
+package pigeon
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// receivedHeader returns the value of a Received trace field (RFC 5321,
+// section 4.4) recording the hop by which the message entered this host,
+// e.g.
+//
+//	from client.example.net ([192.0.2.10]) by relay.example.com with ESMTP
+//	id 1a2b3c4d5e6f7a8b for <b@example.com>; Mon, 01 Jan 2024 09:00:00 +0900
+//
+// The next hop, to the SMTP server, is recorded by that server itself. The
+// original client is taken from cfg.XClient; without it, the message was
+// submitted locally and the from and with clauses are left out. This host is
+// cfg.Hello, or else the host name or the address of local.
+//
+// "for" is only added for a single recipient, so that Bcc recipients are not
+// disclosed to each other, and the date is in cfg.Timezone.
+func receivedHeader(cfg EmailConfig, local net.Addr, rcpts []string) string {
+	var b strings.Builder
+	if x := cfg.XClient; x != nil && (x.Name != "" || x.Addr != "") {
+		name := x.Name
+		if name == "" || name == "[UNAVAILABLE]" {
+			name = "unknown"
+		}
+		b.WriteString("from " + name)
+		if x.Addr != "" {
+			b.WriteString(" (" + addressLiteral(x.Addr) + ")")
+		}
+		b.WriteString(" ")
+	}
+	b.WriteString("by " + receivedBy(cfg, local))
+	if cfg.XClient != nil && cfg.XClient.Proto != "" {
+		b.WriteString(" with " + strings.ToUpper(cfg.XClient.Proto))
+	}
+	var id [8]byte
+	rand.Read(id[:])
+	b.WriteString(" id " + hex.EncodeToString(id[:]))
+	if len(rcpts) == 1 {
+		b.WriteString(" for <" + rcpts[0] + ">")
+	}
+	b.WriteString("; " + messageTime(cfg).Format(time.RFC1123Z))
+	return b.String()
+}
+
+// receivedBy returns the name of this host for a Received field: cfg.Hello,
+// the host name, or the address literal of local.
+func receivedBy(cfg EmailConfig, local net.Addr) string {
+	if cfg.Hello != "" {
+		return cfg.Hello
+	}
+	if name, err := os.Hostname(); err == nil && name != "" {
+		return name
+	}
+	if tcp, ok := local.(*net.TCPAddr); ok {
+		return addressLiteral(tcp.IP.String())
+	}
+	return "unknown"
+}
+
+// addressLiteral returns the IP address addr as an RFC 5321 address
+// literal, e.g. "[192.0.2.1]" or "[IPv6:2001:db8::1]". addr may already
+// carry Postfix's "IPV6:" prefix.
+func addressLiteral(addr string) string {
+	if len(addr) > 5 && strings.EqualFold(addr[:5], "IPV6:") {
+		addr = addr[5:]
+	}
+	if ip := net.ParseIP(addr); ip != nil && ip.To4() == nil {
+		return "[IPv6:" + addr + "]"
+	}
+	return "[" + addr + "]"
+}
+
+// prependReceived returns msg with a Received header field of value v added
+// at the top, folded at cfg.HeaderFoldWidth.
+func prependReceived(cfg EmailConfig, v string, msg []byte) []byte {
+	width := cfg.HeaderFoldWidth
+	if width <= 0 {
+		width = maxLineLength
+	}
+	var buf bytes.Buffer
+	buf.Grow(len(v) + len(msg) + 16)
+	writeHeader(&buf, "Received", v, width)
+	buf.Write(msg)
+	return buf.Bytes()
+}
@@ -0,0 +1,75 @@
+package pigeon
+
+import (
+	"context"
+	"net/mail"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSend_AddReceivedHeader(t *testing.T) {
+	m := &mockSMTP{}
+	m.start(t)
+	cfg := EmailConfig{
+		Smarthost:         m.smarthost(),
+		Hello:             "relay.example.com",
+		AddReceivedHeader: true,
+		Timezone:          "Asia/Tokyo",
+		TemplatePath:      tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Trace\n\nBody."),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := Send(ctx, cfg, nil); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	raw := <-m.received
+	if !strings.HasPrefix(raw, "Received: by relay.example.com ") {
+		t.Fatalf("message does not start with Received:\n%s", raw)
+	}
+	for _, line := range strings.Split(raw[:strings.Index(raw, "\n\n")], "\n") {
+		if len(line) > maxLineLength {
+			t.Errorf("header line longer than %d: %q", maxLineLength, line)
+		}
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	got := msg.Header.Get("Received")
+	// Only the hop into this host is recorded, not the one to the server.
+	re := regexp.MustCompile(`^by relay\.example\.com id [0-9a-f]{16} for <b@example\.com>; (.+)$`)
+	sm := re.FindStringSubmatch(got)
+	if sm == nil {
+		t.Fatalf("Received = %q", got)
+	}
+	date, err := time.Parse(time.RFC1123Z, sm[1])
+	if err != nil {
+		t.Fatalf("Received date %q: %v", sm[1], err)
+	}
+	if _, offset := date.Zone(); offset != 9*60*60 {
+		t.Errorf("Received date %q is not in Asia/Tokyo", sm[1])
+	}
+
+	// The original client comes from XClient. With several recipients, none
+	// of them is named.
+	cfg.XClient = &XClientConfig{Addr: "IPV6:2001:db8::1", Name: "client.example.net", Proto: "esmtp"}
+	got = receivedHeader(cfg, nil, []string{"b@example.com", "c@example.com"})
+	if want := "from client.example.net ([IPv6:2001:db8::1]) by relay.example.com with ESMTP id "; !strings.HasPrefix(got, want) || strings.Contains(got, " for ") {
+		t.Errorf("receivedHeader = %q, want prefix %q and no for clause", got, want)
+	}
+}
+
+func TestAddressLiteral(t *testing.T) {
+	for addr, want := range map[string]string{
+		"192.0.2.1":        "[192.0.2.1]",
+		"2001:db8::1":      "[IPv6:2001:db8::1]",
+		"IPV6:2001:db8::1": "[IPv6:2001:db8::1]",
+	} {
+		if got := addressLiteral(addr); got != want {
+			t.Errorf("addressLiteral(%q) = %q, want %q", addr, got, want)
+		}
+	}
+}
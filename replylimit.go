@@ -0,0 +1,101 @@
+package pigeon
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+)
+
+// defaultMaxReplyLineBytes is the default limit on the length of a line of
+// an SMTP reply. RFC 5321 allows 512 bytes; servers that send more are
+// tolerated up to this generous limit.
+const defaultMaxReplyLineBytes = 64 << 10
+
+// ErrReplyTooLong is returned when a line of a server reply is longer than
+// EmailConfig.MaxReplyLineBytes.
+var ErrReplyTooLong = errors.New("SMTP reply line too long")
+
+// maxReplyLineBytes returns the longest reply line accepted from servers.
+func (c *EmailConfig) maxReplyLineBytes() int {
+	if c.MaxReplyLineBytes <= 0 {
+		return defaultMaxReplyLineBytes
+	}
+	return c.MaxReplyLineBytes
+}
+
+// lineLimit counts the bytes of the current line read so far and fails once
+// there are more than max. The failure sticks: bufio.Reader.ReadLine hands
+// back the start of an overlong line without the error, so it must come
+// again on the next read.
+type lineLimit struct {
+	max, n int
+	err    error
+}
+
+// check accounts for the bytes b read next.
+func (l *lineLimit) check(b []byte) error {
+	if l.err != nil {
+		return l.err
+	}
+	for _, c := range b {
+		if c == '\n' {
+			l.n = 0
+			continue
+		}
+		if l.n++; l.n > l.max {
+			l.err = fmt.Errorf("%w: more than %d bytes", ErrReplyTooLong, l.max)
+			return l.err
+		}
+	}
+	return nil
+}
+
+// limitReader is an io.Reader that fails on lines longer than its limit.
+type limitReader struct {
+	r io.Reader
+	lineLimit
+}
+
+func (r *limitReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.r.Read(p)
+	if lerr := r.check(p[:n]); lerr != nil {
+		return n, lerr
+	}
+	return n, err
+}
+
+// limitConn is a net.Conn whose reads fail on lines longer than its limit
+// until the limit is turned off, e.g. before the connection is handed to TLS,
+// whose records are not divided into lines.
+type limitConn struct {
+	net.Conn
+	lineLimit
+	off bool
+}
+
+func (c *limitConn) Read(p []byte) (int, error) {
+	if c.off {
+		return c.Conn.Read(p)
+	}
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.Conn.Read(p)
+	if lerr := c.check(p[:n]); lerr != nil {
+		return n, lerr
+	}
+	return n, err
+}
+
+// limitReplies makes the client c fail on reply lines longer than
+// cfg.MaxReplyLineBytes, rather than buffering them whole. It is applied
+// again after STARTTLS, which gives c a new reader.
+func limitReplies(c *smtp.Client, cfg EmailConfig) {
+	c.Text.R = bufio.NewReader(&limitReader{r: c.Text.R, lineLimit: lineLimit{max: cfg.maxReplyLineBytes()}})
+}
@@ -0,0 +1,67 @@
+package pigeon
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSend_MaxReplyLineBytes(t *testing.T) {
+	long := strings.Repeat("x", 4096)
+	tests := []struct {
+		name string
+		m    *mockSMTP
+		max  int
+		want error
+	}{
+		{name: "greeting", m: &mockSMTP{Banner: "220 " + long}, max: 1024, want: ErrReplyTooLong},
+		{name: "EHLO", m: &mockSMTP{Replies: map[string]string{"EHLO": "250 " + long}}, max: 1024, want: ErrReplyTooLong},
+		{name: "within default", m: &mockSMTP{Replies: map[string]string{"EHLO": "250 " + long}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.m.start(t)
+			cfg := EmailConfig{
+				Smarthost:         tt.m.smarthost(),
+				MaxReplyLineBytes: tt.max,
+				TemplatePath:      tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Limit\n\nHello"),
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			_, err := Send(ctx, cfg, nil)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Send error = %v, want %v", err, tt.want)
+			}
+			if err != nil && !strings.Contains(err.Error(), "more than 1024 bytes") {
+				t.Errorf("Send error = %v, want the limit in it", err)
+			}
+		})
+	}
+}
+
+func TestSendRaw_ReplyTooLong(t *testing.T) {
+	m := &mockSMTP{Banner: "220 " + strings.Repeat("x", defaultMaxReplyLineBytes)}
+	m.start(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	raw := "From: a@example.com\r\nTo: b@example.com\r\nSubject: Raw\r\n\r\nHello\r\n"
+	if err := SendRaw(ctx, strings.NewReader(raw), m.addr); !errors.Is(err, ErrReplyTooLong) {
+		t.Errorf("SendRaw error = %v, want ErrReplyTooLong", err)
+	}
+}
+
+func TestLineLimit(t *testing.T) {
+	l := lineLimit{max: 6}
+	for _, b := range []string{"250", "4\r\n250-", "ab", "\n"} {
+		if err := l.check([]byte(b)); err != nil {
+			t.Fatalf("check(%q): %v", b, err)
+		}
+	}
+	if err := l.check([]byte("1234567")); !errors.Is(err, ErrReplyTooLong) {
+		t.Errorf("check of a 7-byte line = %v, want ErrReplyTooLong", err)
+	}
+}
@@ -0,0 +1,117 @@
+package pigeon
+
+import (
+	"bytes"
+	"context"
+	"mime"
+	"net/textproto"
+)
+
+// Result describes a message built by BuildMessageResult or sent by
+// SendReport.
+type Result struct {
+	// Envelope is the SMTP envelope of the message.
+	Envelope Envelope
+	// MessageID is the message's Message-ID, including the angle brackets,
+	// or "" if it has none.
+	MessageID string
+	// Size is the size of the message in bytes.
+	Size int
+	// Parts lists the leaf parts of the message body in order: the text
+	// body, the HTML body if any, then the attachments.
+	Parts []PartInfo
+	// Attachments describes the attachments in order, as sent.
+	Attachments []AttachmentResult
+	// Suppressed lists the recipients left out because they are on
+	// EmailConfig.Suppression.
+	Suppressed []string
+	// Invalid lists the recipients left out because
+	// EmailConfig.RecipientValidator rejected them.
+	Invalid []InvalidRecipient
+	// Retry reports whether a failed send may be retried, as returned by Send.
+	Retry bool
+}
+
+// PartInfo describes one part of a message body.
+type PartInfo struct {
+	// ContentType is the part's media type, e.g. "text/plain".
+	ContentType string
+	// Filename is the attachment's file name, or "" for the body.
+	Filename string
+	// Encoding is the Content-Transfer-Encoding chosen for the part:
+	// "7bit", "quoted-printable" or "base64". Parts sent with BINARYMIME
+	// are converted to "binary" on the wire.
+	Encoding string
+}
+
+// AttachmentResult describes an attachment of a message, e.g. for auditing
+// or billing by size.
+type AttachmentResult struct {
+	// Filename is the attachment's file name as sent.
+	Filename string
+	// ContentType is the attachment's media type, e.g. "application/pdf".
+	ContentType string
+	// Size is the size of the attachment's body once encoded, in bytes.
+	Size int64
+	// Source is where the content came from: "file", "url", or "memory"
+	// for Attachment.Content.
+	Source string
+}
+
+// result returns the AttachmentResult for a, sent with part headers h,
+// base64 lines of b64Width characters and quoted-printable lines of qpWidth.
+func (a *attachment) result(h textproto.MIMEHeader, b64Width, qpWidth int) AttachmentResult {
+	source := "file"
+	switch {
+	case a.Content != "":
+		source = "memory"
+	case isURL(a.Path):
+		source = "url"
+	}
+	return AttachmentResult{Filename: a.name, ContentType: partInfo(h, a.name).ContentType, Size: a.encodedLen(b64Width, qpWidth), Source: source}
+}
+
+// partInfo returns the PartInfo for a part with headers h.
+func partInfo(h textproto.MIMEHeader, filename string) PartInfo {
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	return PartInfo{ContentType: mediaType, Filename: filename, Encoding: h.Get("Content-Transfer-Encoding")}
+}
+
+// SendReport is like Send but returns a Result describing the message that
+// was sent. Its Retry field reports whether a failure was temporary.
+func SendReport(ctx context.Context, cfg EmailConfig, data any) (Result, error) {
+	return sendReport(ctx, cfg, data, nil)
+}
+
+// sendReport implements SendReport, delivering through the smarthost with a
+// session from pool, if not nil.
+func sendReport(ctx context.Context, cfg EmailConfig, data any, pool *sessionPool) (Result, error) {
+	cfg.Logger = contextLogger(ctx, cfg.Logger)
+	if err := cfg.checkSmarthost(); err != nil {
+		return Result{}, err
+	}
+
+	msg := getBuffer()
+	defer putBuffer(msg)
+	res, err := buildMessage(cfg, data, msg)
+	if err != nil {
+		return res, err
+	}
+	if cfg.Smarthost, err = renderSmarthost(cfg, data); err != nil {
+		return res, err
+	}
+
+	res.Retry, err = deliver(ctx, cfg, res.Envelope, msg.Bytes(), pool)
+	return res, err
+}
+
+// BuildMessageResult is like BuildMessage but also returns a Result
+// describing the message, e.g. the transfer encoding chosen for each part.
+func BuildMessageResult(cfg EmailConfig, data any) ([]byte, Result, error) {
+	var buf bytes.Buffer
+	res, err := writeEML(&buf, cfg, data)
+	if err != nil {
+		return nil, Result{}, err
+	}
+	return buf.Bytes(), res, nil
+}
@@ -0,0 +1,142 @@
+package pigeon
+
+import (
+	"bytes"
+	"context"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildMessageResult_Encodings(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{name: "ascii", body: "Hello, world.", want: "7bit"},
+		{name: "non-ascii", body: "こんにちは", want: "quoted-printable"},
+		{name: "long lines", body: strings.Repeat("long ", 40), want: "quoted-printable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := EmailConfig{
+				TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Encoding\n\n"+tt.body),
+			}
+			raw, res, err := BuildMessageResult(cfg, nil)
+			if err != nil {
+				t.Fatalf("BuildMessageResult: %v", err)
+			}
+			want := []PartInfo{{ContentType: "text/plain", Encoding: tt.want}}
+			if !reflect.DeepEqual(res.Parts, want) {
+				t.Errorf("Parts = %+v, want %+v", res.Parts, want)
+			}
+
+			msg, err := mail.ReadMessage(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatalf("ReadMessage: %v", err)
+			}
+			if got := msg.Header.Get("Content-Transfer-Encoding"); got != res.Parts[0].Encoding {
+				t.Errorf("Content-Transfer-Encoding = %q, reported %q", got, res.Parts[0].Encoding)
+			}
+			if res.Size != len(raw) || res.MessageID != msg.Header.Get("Message-Id") {
+				t.Errorf("Size = %d, MessageID = %q; message is %d bytes with ID %q", res.Size, res.MessageID, len(raw), msg.Header.Get("Message-Id"))
+			}
+		})
+	}
+}
+
+func TestBuildMessageResult_Parts(t *testing.T) {
+	attPath := filepath.Join(t.TempDir(), "report.pdf")
+	if err := os.WriteFile(attPath, []byte("%PDF-1.4"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := EmailConfig{
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Parts\n\nSee attached."),
+		HTML:         "<p>Sieh dir den Anhang an – danke!</p>",
+		Attachments:  []string{attPath},
+	}
+	_, res, err := BuildMessageResult(cfg, nil)
+	if err != nil {
+		t.Fatalf("BuildMessageResult: %v", err)
+	}
+	want := []PartInfo{
+		{ContentType: "text/plain", Encoding: "7bit"},
+		{ContentType: "text/html", Encoding: "quoted-printable"},
+		{ContentType: "application/pdf", Filename: "report.pdf", Encoding: "base64"},
+	}
+	if !reflect.DeepEqual(res.Parts, want) {
+		t.Errorf("Parts = %+v, want %+v", res.Parts, want)
+	}
+}
+
+func TestSendReport(t *testing.T) {
+	m := &mockSMTP{}
+	m.start(t)
+	cfg := EmailConfig{
+		Smarthost:    m.smarthost(),
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nCc: c@example.com\nSubject: Report\n\nHello"),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := SendReport(ctx, cfg, nil)
+	if err != nil || res.Retry {
+		t.Fatalf("SendReport = (%+v, %v)", res, err)
+	}
+	if want := []string{"b@example.com", "c@example.com"}; res.Envelope.From != "a@example.com" || !reflect.DeepEqual(res.Envelope.Rcpts, want) {
+		t.Errorf("Envelope = %+v", res.Envelope)
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(<-m.received))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if got := msg.Header.Get("Message-Id"); got != res.MessageID {
+		t.Errorf("Message-Id = %q, reported %q", got, res.MessageID)
+	}
+
+	busy := &mockSMTP{Replies: map[string]string{"RCPT": "450 4.2.1 Mailbox busy"}}
+	busy.start(t)
+	cfg.Smarthost = busy.smarthost()
+	res, err = SendReport(ctx, cfg, nil)
+	if err == nil || !res.Retry {
+		t.Errorf("SendReport = (%+v, %v), want retryable error", res, err)
+	}
+}
+
+func TestSendReport_Attachments(t *testing.T) {
+	m := &mockSMTP{}
+	m.start(t)
+	pdf := filepath.Join(t.TempDir(), "report.pdf")
+	if err := os.WriteFile(pdf, bytes.Repeat([]byte{0xAB}, 100), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := EmailConfig{
+		Smarthost:    m.smarthost(),
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Report\n\nSee attached."),
+		AttachmentSpecs: []Attachment{
+			{Path: pdf},
+			{Path: "notes.txt", Content: "hello\n", Encoding: "quoted-printable"},
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := SendReport(ctx, cfg, nil)
+	if err != nil {
+		t.Fatalf("SendReport: %v", err)
+	}
+	<-m.received
+	want := []AttachmentResult{
+		// 100 bytes are 136 base64 characters, in two CRLF-terminated lines.
+		{Filename: "report.pdf", ContentType: "application/pdf", Size: 136 + 2*2, Source: "file"},
+		{Filename: "notes.txt", ContentType: "text/plain", Size: int64(len("hello\r\n")), Source: "memory"},
+	}
+	if !reflect.DeepEqual(res.Attachments, want) {
+		t.Errorf("Attachments = %+v, want %+v", res.Attachments, want)
+	}
+}
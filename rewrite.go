@@ -0,0 +1,34 @@
+package pigeon
+
+import (
+	"errors"
+	"fmt"
+)
+
+// rewriteMessage passes msg through cfg.MessageRewriter, if set.
+func rewriteMessage(cfg EmailConfig, msg []byte) ([]byte, error) {
+	if cfg.MessageRewriter == nil {
+		return msg, nil
+	}
+	msg, err := cfg.MessageRewriter(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rewrite message: %w", err)
+	}
+	return msg, nil
+}
+
+// rewriteRecipients passes rcpts through cfg.RecipientRewriter, if set, and
+// returns the recipients to send to.
+func rewriteRecipients(cfg EmailConfig, rcpts []string) ([]string, error) {
+	if cfg.RecipientRewriter == nil {
+		return rcpts, nil
+	}
+	out, err := cfg.RecipientRewriter(append([]string(nil), rcpts...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to rewrite recipients: %w", err)
+	}
+	if len(out) == 0 {
+		return nil, errors.New("recipient rewriter returned no recipients")
+	}
+	return out, nil
+}
@@ -0,0 +1,78 @@
+package pigeon
+
+import (
+	"context"
+	"errors"
+	"net/mail"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSend_RecipientRewriter(t *testing.T) {
+	m := &mockSMTP{}
+	m.start(t)
+
+	var seen []string
+	cfg := EmailConfig{
+		Smarthost:    m.smarthost(),
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com, c@example.com\nCc: d@example.com\nSubject: Staging\n\nBody."),
+		RecipientRewriter: func(rcpts []string) ([]string, error) {
+			seen = rcpts
+			return []string{"qa@internal.test"}, nil
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if retry, err := Send(ctx, cfg, nil); err != nil {
+		t.Fatalf("Send = (%v, %v)", retry, err)
+	}
+	raw := <-m.received
+
+	if want := []string{"b@example.com", "c@example.com", "d@example.com"}; !reflect.DeepEqual(seen, want) {
+		t.Errorf("rewriter got %v, want %v", seen, want)
+	}
+	var rcpts []string
+	for _, cmd := range m.Commands() {
+		if strings.HasPrefix(cmd, "RCPT TO:") {
+			rcpts = append(rcpts, cmd)
+		}
+	}
+	if want := []string{"RCPT TO:<qa@internal.test>"}; !reflect.DeepEqual(rcpts, want) {
+		t.Errorf("RCPT commands = %v, want %v", rcpts, want)
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if got := msg.Header.Get("To"); got != "b@example.com, c@example.com" {
+		t.Errorf("To header = %q, want it unchanged", got)
+	}
+}
+
+func TestSend_RecipientRewriterError(t *testing.T) {
+	cfg := EmailConfig{
+		Smarthost:    HostPort{Host: "127.0.0.1", Port: "1"},
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Hi\n\nBody."),
+	}
+	errBlocked := errors.New("blocked")
+	tests := []struct {
+		name     string
+		rewriter func([]string) ([]string, error)
+		want     string
+	}{
+		{name: "empty", rewriter: func([]string) ([]string, error) { return nil, nil }, want: "no recipients"},
+		{name: "error", rewriter: func([]string) ([]string, error) { return nil, errBlocked }, want: "blocked"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg.RecipientRewriter = tt.rewriter
+			retry, err := Send(context.Background(), cfg, nil)
+			if err == nil || retry || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Send = (%v, %v), want permanent error mentioning %q", retry, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package pigeon
+
+import (
+	"mime"
+	"net/textproto"
+	"strings"
+)
+
+// WrapAsRFC822 returns an attachment that carries msg, a complete message
+// such as one returned by BuildMessage, as a message/rfc822 part named
+// filename ("message.eml" if empty), e.g. to forward or archive a copy of a
+// message inside a new one. The message is attached unencoded, with CRLF
+// line endings, so that its headers stay readable (RFC 2046, section 5.2.1).
+func WrapAsRFC822(msg []byte, filename string) Attachment {
+	if filename == "" {
+		filename = "message.eml"
+	}
+	return Attachment{Path: filename, ContentType: "message/rfc822", Content: string(msg)}
+}
+
+// isMessageType reports whether ctype is a message/* media type. Such parts
+// may not be base64 or quoted-printable encoded.
+func isMessageType(ctype string) bool {
+	mediaType, _, err := mime.ParseMediaType(ctype)
+	return err == nil && strings.HasPrefix(mediaType, "message/")
+}
+
+// header returns the part headers for a. A message/* part is 7bit, or 8bit
+// if it contains non-ASCII octets.
+func (a *attachment) header() textproto.MIMEHeader {
+	h := attachmentPartHeader(a.Attachment, a.name, a.ctype)
+	switch {
+	case isMessageType(a.ctype):
+		cte := "7bit"
+		if !isASCII(string(a.data)) {
+			cte = "8bit"
+		}
+		h.Set("Content-Transfer-Encoding", cte)
+	case a.quotedPrintable():
+		h.Set("Content-Transfer-Encoding", "quoted-printable")
+	}
+	return h
+}
+
+// encodedLen returns the size of a's body once encoded, with base64 lines of
+// b64Width characters and quoted-printable lines of qpWidth.
+func (a *attachment) encodedLen(b64Width, qpWidth int) int64 {
+	if isMessageType(a.ctype) {
+		return int64(len(a.data))
+	}
+	if a.quotedPrintable() {
+		var w countingWriter
+		writeQuotedPrintable(&w, string(a.data), qpWidth)
+		return w.n
+	}
+	return base64WrappedLen(int64(len(a.data)), b64Width)
+}
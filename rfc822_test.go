@@ -0,0 +1,80 @@
+package pigeon
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestWrapAsRFC822(t *testing.T) {
+	inner, err := BuildMessage(EmailConfig{
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Grüße\nMessage-ID: <original@example.com>\nX-Ticket: 42\n\nOriginal body."),
+	}, nil)
+	if err != nil {
+		t.Fatalf("BuildMessage(inner): %v", err)
+	}
+
+	cfg := EmailConfig{
+		TemplatePath:    tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Your copy\n\nA copy of your message is attached."),
+		AttachmentSpecs: []Attachment{WrapAsRFC822(inner, "original.eml")},
+	}
+	raw, err := BuildMessage(cfg, nil)
+	if err != nil {
+		t.Fatalf("BuildMessage: %v", err)
+	}
+	size, err := EstimateSize(cfg, nil)
+	if err != nil || size != int64(len(raw)) {
+		t.Errorf("EstimateSize = %d, %v; message is %d bytes", size, err, len(raw))
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	if _, err := mr.NextPart(); err != nil { // text body
+		t.Fatal(err)
+	}
+	p, err := mr.NextRawPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctype, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type")); ctype != "message/rfc822" {
+		t.Errorf("Content-Type = %q, want message/rfc822", p.Header.Get("Content-Type"))
+	}
+	if cte := p.Header.Get("Content-Transfer-Encoding"); cte != "7bit" {
+		t.Errorf("Content-Transfer-Encoding = %q, want 7bit", cte)
+	}
+	if p.FileName() != "original.eml" {
+		t.Errorf("FileName = %q", p.FileName())
+	}
+
+	body, err := io.ReadAll(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body, inner) {
+		t.Errorf("attached message differs from the original:\n%s", body)
+	}
+	attached, err := mail.ReadMessage(bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("ReadMessage(attached): %v", err)
+	}
+	orig, _ := mail.ReadMessage(bytes.NewReader(inner))
+	for _, k := range []string{"Subject", "Message-Id", "X-Ticket"} {
+		if got, want := attached.Header.Get(k), orig.Header.Get(k); got != want || want == "" {
+			t.Errorf("attached %s = %q, want %q", k, got, want)
+		}
+	}
+	if b, _ := io.ReadAll(attached.Body); !strings.Contains(string(b), "Original body.") {
+		t.Errorf("attached body = %q", b)
+	}
+}
@@ -0,0 +1,57 @@
+package pigeon
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+)
+
+// sampleBuckets is the resolution of InSample: percentages are honored to
+// two decimal places.
+const sampleBuckets = 10000
+
+// InSample reports whether addr falls within a sample of percent (0 to 100)
+// of all addresses. Membership is derived from a hash of the address,
+// compared case-insensitively, so an address is always in or out of the
+// sample for a given percentage, and a larger sample contains every smaller
+// one. It is what SendBatch uses for BatchConfig.SamplePercent, and lets
+// callers send the remaining addresses something else.
+func InSample(addr string, percent float64) bool {
+	h := fnv.New64a()
+	h.Write([]byte(strings.ToLower(strings.TrimSpace(addr))))
+	return float64(h.Sum64()%sampleBuckets) < percent*sampleBuckets/100
+}
+
+// checkSample validates SamplePercent.
+func (b BatchConfig) checkSample() error {
+	if b.SamplePercent < 0 || b.SamplePercent > 100 {
+		return fmt.Errorf("invalid batch sample_percent %v: must be between 0 and 100", b.SamplePercent)
+	}
+	return nil
+}
+
+// sampled reports whether a message to rcpts is in the sample. The first
+// recipient that is not an ArchiveBcc address decides; without SamplePercent
+// every message is.
+func (b BatchConfig) sampled(rcpts []string, archiveBcc string) bool {
+	if b.SamplePercent == 0 {
+		return true
+	}
+	archive, _ := envelopeRcpts(nil, archiveBcc)
+	for _, rcpt := range rcpts {
+		if !containsFold(archive, rcpt) {
+			return InSample(rcpt, b.SamplePercent)
+		}
+	}
+	return false
+}
+
+// containsFold reports whether list contains s, compared case-insensitively.
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package pigeon
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInSample(t *testing.T) {
+	n := 0
+	for i := 0; i < 1000; i++ {
+		addr := fmt.Sprintf("user%d@example.com", i)
+		in := InSample(addr, 10)
+		if in {
+			n++
+		}
+		if InSample(strings.ToUpper(addr), 10) != in {
+			t.Errorf("%s: membership depends on case", addr)
+		}
+		if in && !InSample(addr, 25) {
+			t.Errorf("%s is in the 10%% sample but not the 25%% one", addr)
+		}
+	}
+	if n < 70 || n > 130 {
+		t.Errorf("10%% sample selected %d of 1000 addresses", n)
+	}
+	if InSample("a@example.com", 0) || !InSample("a@example.com", 100) {
+		t.Error("0% should select nothing and 100% everything")
+	}
+}
+
+func TestSendBatch_SamplePercent(t *testing.T) {
+	m := &mockSMTP{}
+	m.start(t)
+
+	cfg := EmailConfig{
+		Smarthost:    m.smarthost(),
+		From:         "news@example.com",
+		TemplatePath: tplWriteTemp(t, "To: {{ .To }}\nSubject: Hello\n\nHi"),
+		ArchiveBcc:   "archive@example.com",
+		Batch:        BatchConfig{SamplePercent: 30},
+	}
+	var items []BatchItem
+	for i := 0; i < 20; i++ {
+		to := fmt.Sprintf("user%d@example.com", i)
+		items = append(items, BatchItem{To: to, Data: map[string]string{"To": to}})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	results := SendBatch(ctx, cfg, items)
+
+	var want []string
+	for i, res := range results {
+		if res.Err != nil {
+			t.Fatalf("result %d: %v", i, res.Err)
+		}
+		if res.Sampled != InSample(items[i].To, 30) {
+			t.Errorf("result %d Sampled = %v", i, res.Sampled)
+		}
+		if res.Sampled {
+			want = append(want, "<"+items[i].To+">", "<archive@example.com>")
+		}
+	}
+	if len(want) == 0 || len(want) == 2*len(items) {
+		t.Fatalf("sample of %d addresses selected %d", len(items), len(want)/2)
+	}
+	var rcpts []string
+	for _, cmd := range m.Commands() {
+		if strings.HasPrefix(cmd, "RCPT TO:") {
+			rcpts = append(rcpts, strings.TrimPrefix(cmd, "RCPT TO:"))
+		}
+	}
+	if got := strings.Join(rcpts, " "); got != strings.Join(want, " ") {
+		t.Errorf("RCPT TO = %s, want %s", got, strings.Join(want, " "))
+	}
+
+	cfg.Batch.SamplePercent = 150
+	if res := SendBatch(ctx, cfg, items[:1]); res[0].Err == nil {
+		t.Error("SamplePercent 150 should be rejected")
+	}
+}
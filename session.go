@@ -0,0 +1,162 @@
+package pigeon
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"sync"
+	"time"
+)
+
+// session is an SMTP session to the smarthost kept open between the sends of
+// a Client.
+type session struct {
+	conn     net.Conn
+	c        *smtp.Client
+	hostPort string
+	host     string
+	timer    *time.Timer // closes the session once it has been idle too long
+}
+
+// watch applies the deadline and cancellation of ctx to the session's
+// connection, as SendOverConn does, until the returned function is called.
+// That function clears the deadline again and reports whether ctx was still
+// live, i.e. whether the session is still usable.
+func (s *session) watch(ctx context.Context) (release func() bool) {
+	if deadline, ok := ctx.Deadline(); ok {
+		s.conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { s.conn.SetDeadline(time.Now()) })
+	return func() bool {
+		if !stop() {
+			return false
+		}
+		s.conn.SetDeadline(time.Time{})
+		return ctx.Err() == nil
+	}
+}
+
+// quit ends the session with QUIT, or by closing the connection if the server
+// does not answer.
+func (s *session) quit() {
+	if err := s.c.Quit(); err != nil {
+		s.c.Close()
+	}
+}
+
+// sessionPool keeps the idle session of a Client, if any, and ends it after
+// timeout without use. Sends that find no idle session open their own, and
+// sessions returned while another one is idle are ended.
+type sessionPool struct {
+	timeout time.Duration
+
+	mu     sync.Mutex
+	idle   *session
+	closed bool
+}
+
+// get takes the idle session to hostPort, if there is one. An idle session
+// to another host is ended.
+func (p *sessionPool) get(hostPort string) *session {
+	p.mu.Lock()
+	s := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+	if s == nil {
+		return nil
+	}
+	s.timer.Stop()
+	if s.hostPort != hostPort {
+		s.quit()
+		return nil
+	}
+	return s
+}
+
+// put keeps s as the idle session, or ends it if the pool is closed or
+// already has one.
+func (p *sessionPool) put(s *session) {
+	p.mu.Lock()
+	if p.closed || p.idle != nil {
+		p.mu.Unlock()
+		s.quit()
+		return
+	}
+	p.idle = s
+	s.timer = time.AfterFunc(p.timeout, func() {
+		p.mu.Lock()
+		if p.idle != s {
+			// Taken by get in the meantime.
+			p.mu.Unlock()
+			return
+		}
+		p.idle = nil
+		p.mu.Unlock()
+		s.quit()
+	})
+	p.mu.Unlock()
+}
+
+// close ends the idle session and makes put end the sessions returned later.
+func (p *sessionPool) close() {
+	p.mu.Lock()
+	p.closed = true
+	s := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+	if s != nil {
+		s.timer.Stop()
+		s.quit()
+	}
+}
+
+// deliver sends msg from the envelope sender to rcpts over the idle session
+// to hostPort, or over a new one if there is none or the server has closed
+// it, and keeps the session for the next send if the delivery succeeds. The
+// session is bound by ctx while in use, so that a server that has gone away
+// silently does not block the send.
+func (p *sessionPool) deliver(ctx context.Context, d *net.Dialer, cfg EmailConfig, hostPort, from string, rcpts []string, msg []byte) (retry bool, err error) {
+	var release func() bool
+	s := p.get(hostPort)
+	if s != nil {
+		// The server may have dropped the connection while it was idle.
+		release = s.watch(ctx)
+		if err := s.c.Reset(); err != nil {
+			release()
+			cfg.logDebug("idle SMTP connection lost, reconnecting", "host", s.host, "err", err)
+			s.c.Close()
+			s = nil
+		}
+	}
+	if s == nil {
+		conn, err := dialSmarthost(ctx, d, cfg, hostPort)
+		if err != nil {
+			return true, err // network failure - retry allowed
+		}
+		s = &session{conn: &deadlineConn{Conn: conn}, hostPort: hostPort, host: smarthostName(hostPort)}
+		release = s.watch(ctx)
+		c, retry, err := openSession(s.conn, s.host, cfg)
+		if err != nil {
+			release()
+			conn.Close()
+			if ctx.Err() != nil {
+				return true, fmt.Errorf("%w: %w", ctx.Err(), err)
+			}
+			return retry, err
+		}
+		s.c = c
+	}
+
+	retry, err = sendMail(s.c, s.conn, s.host, cfg, from, rcpts, msg)
+	if live := release(); !live || err != nil {
+		// A session interrupted by ctx is in an unknown state.
+		s.quit()
+		if err != nil && ctx.Err() != nil {
+			return true, fmt.Errorf("%w: %w", ctx.Err(), err)
+		}
+		return retry, err
+	}
+	p.put(s)
+	return false, nil
+}
@@ -0,0 +1,126 @@
+package pigeon
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClient_IdleTimeout(t *testing.T) {
+	m := &mockSMTP{}
+	m.start(t)
+	c := NewClient(EmailConfig{
+		Smarthost:    m.smarthost(),
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Reuse\n\nBody."),
+		IdleTimeout:  200 * time.Millisecond,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	send := func() {
+		t.Helper()
+		if _, err := c.Send(ctx, nil); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		<-m.received
+	}
+	waitQuit := func(n int) {
+		t.Helper()
+		for countCommands(m, "QUIT") != n {
+			if ctx.Err() != nil {
+				t.Fatalf("waiting for QUIT %d: %v", n, m.Commands())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// Sends within the idle timeout share a connection.
+	send()
+	send()
+	if n := len(m.RemoteAddrs()); n != 1 {
+		t.Errorf("got %d connections for two sends, want 1", n)
+	}
+	if got := m.Commands(); !slices.Contains(got, "RSET") || slices.Contains(got, "QUIT") {
+		t.Errorf("commands = %q, want RSET before reuse and no QUIT", got)
+	}
+
+	// The idle connection is ended, and the next send reconnects.
+	waitQuit(1)
+	send()
+	if n := len(m.RemoteAddrs()); n != 2 {
+		t.Errorf("got %d connections after the idle timeout, want 2", n)
+	}
+
+	// Shutdown ends the idle connection right away.
+	if err := c.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	waitQuit(2)
+}
+
+func TestClient_IdleConnectionLost(t *testing.T) {
+	m := &mockSMTP{Replies: map[string]string{"RSET": "421 4.4.2 Idle too long, closing"}}
+	m.start(t)
+	c := NewClient(EmailConfig{
+		Smarthost:    m.smarthost(),
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Reuse\n\nBody."),
+		IdleTimeout:  time.Minute,
+	})
+	defer c.Shutdown(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for range 2 {
+		if _, err := c.Send(ctx, nil); err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+		<-m.received
+	}
+	if n := len(m.RemoteAddrs()); n != 2 {
+		t.Errorf("got %d connections, want a new one after the server dropped the idle one", n)
+	}
+}
+
+func TestClient_IdleConnectionStalled(t *testing.T) {
+	m := &mockSMTP{Stall: []string{"RSET"}}
+	m.start(t)
+	c := NewClient(EmailConfig{
+		Smarthost:    m.smarthost(),
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Reuse\n\nBody."),
+		IdleTimeout:  time.Minute,
+	})
+	defer c.Shutdown(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := c.Send(ctx, nil); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	<-m.received
+
+	// The server no longer answers on the idle connection; the send is
+	// bounded by its context rather than blocking on the RSET probe.
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	retry, err := c.Send(ctx, nil)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Send took %v, want about the context timeout", elapsed)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) || !retry {
+		t.Errorf("Send = (%v, %v), want retryable deadline error", retry, err)
+	}
+}
+
+// countCommands returns how many commands m has received with the verb.
+func countCommands(m *mockSMTP, verb string) int {
+	n := 0
+	for _, cmd := range m.Commands() {
+		if strings.EqualFold(cmd, verb) || strings.HasPrefix(strings.ToUpper(cmd), verb+" ") {
+			n++
+		}
+	}
+	return n
+}
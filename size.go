@@ -0,0 +1,219 @@
+package pigeon
+
+import (
+	"bytes"
+	"fmt"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+)
+
+// EstimateSize returns the size in bytes of the message Send would transmit
+// for cfg and data, as Send declares it with the SIZE extension (RFC 1870):
+// after MessageRewriter and the DKIM signature, with CRLF line endings
+// whatever cfg.LineEnding says, and without dot-stuffing. A Received header
+// (AddReceivedHeader) depends on the connection and is not counted.
+//
+// Headers and the text body are rendered as usual, but attachments are sized
+// from file metadata instead of being read and base64-encoded, which makes it
+// much cheaper than BuildMessage for messages with large attachments.
+// Attachments given as URLs are fetched to learn their size. Messages with
+// DKIM, a MessageRewriter, PGP, DedupAttachments, an HTML body, or
+// transcoded attachments or ones whose charset is detected are built in
+// full, since their size depends on the rewritten, signed or encrypted
+// output, on attachment contents or on the rendered HTML.
+func EstimateSize(cfg EmailConfig, data any) (int64, error) {
+	charsets := slices.ContainsFunc(cfg.AttachmentSpecs, func(a Attachment) bool { return a.SourceCharset != "" || a.DetectCharset })
+	if cfg.DKIM != nil || cfg.MessageRewriter != nil || cfg.PGP != nil || cfg.DedupAttachments || cfg.HTML != "" || charsets {
+		msg := getBuffer()
+		defer putBuffer(msg)
+		if _, err := buildMessage(cfg, data, msg); err != nil {
+			return 0, err
+		}
+		raw, err := finalizeMessage(cfg, msg.Bytes())
+		if err != nil {
+			return 0, err
+		}
+		return int64(dataSize(raw)), nil
+	}
+
+	t, err := parseTemplate(cfg)
+	if err != nil {
+		return 0, err
+	}
+	hdr, err := assembleHeaders(t, cfg, data)
+	if err != nil {
+		return 0, err
+	}
+	if cfg.SuppressHeaders {
+		env, err := newEnvelope(cfg, hdr, data)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := suppress(cfg, hdr, &env); err != nil {
+			return 0, err
+		}
+	}
+	text, err := renderBody(t, cfg, data)
+	if err != nil {
+		return 0, err
+	}
+	ctype, err := textContentType(cfg)
+	if err != nil {
+		return 0, err
+	}
+	width, err := cfg.qpLineLength()
+	if err != nil {
+		return 0, err
+	}
+	b64Width, err := cfg.base64LineLength()
+	if err != nil {
+		return 0, err
+	}
+	attachments, err := renderAttachments(cfg, data)
+	if err != nil {
+		return 0, err
+	}
+	if needsContentIDDomain(attachments) {
+		domain, err := contentIDDomain(t, cfg, data)
+		if err != nil {
+			return 0, err
+		}
+		attachments, _ = qualifyContentIDs(attachments, "", domain)
+	}
+	if err := checkEmptyBody(cfg, text, len(attachments) > 0); err != nil {
+		return 0, err
+	}
+	textHdr := textPartHeader(ctype, text, width)
+	cte, err := messageEncoding(cfg, len(attachments) > 0, text)
+	if err != nil {
+		return 0, err
+	}
+
+	var body countingWriter
+	if len(attachments) == 0 {
+		if cte != "" {
+			textHdr.Set("Content-Transfer-Encoding", cte)
+		}
+		for k, v := range textHdr {
+			hdr[k] = v
+		}
+		if err := writeEncoded(&body, text, textHdr.Get("Content-Transfer-Encoding"), width); err != nil {
+			return 0, err
+		}
+	} else {
+		boundary, err := cfg.boundary()
+		if err != nil {
+			return 0, err
+		}
+		hdr.Set("Content-Type", "multipart/mixed; boundary="+boundary)
+		if cte != "" {
+			hdr.Set("Content-Transfer-Encoding", cte)
+		}
+
+		// Mirror the framing written by multipart.Writer: the first
+		// delimiter has no leading CRLF and the close delimiter ends with one.
+		body.n += partHeaderSize(textHdr) + int64(len("--"+boundary+"\r\n"))
+		if err := writeTextPart(&body, text, width); err != nil {
+			return 0, err
+		}
+		for _, spec := range attachments {
+			path := spec.Path
+			body.n += int64(len("\r\n--" + boundary + "\r\n"))
+			h := attachmentHeader(spec)
+			if isURL(path) || spec.Content != "" || isMessageType(h.Get("Content-Type")) || spec.quotedPrintable() {
+				// The name and size of a remote file are only known once
+				// it has been fetched, message parts are sent with
+				// normalized line endings rather than encoded, and the
+				// size of quoted-printable depends on the content.
+				a, err := loadAttachment(cfg, spec)
+				if err != nil {
+					return 0, err
+				}
+				body.n += partHeaderSize(a.header()) + a.encodedLen(b64Width, width)
+				continue
+			}
+			fi, err := os.Stat(path)
+			if err != nil {
+				return 0, attachmentFileError(path, err)
+			}
+			body.n += partHeaderSize(h) + base64WrappedLen(fi.Size(), b64Width)
+		}
+		body.n += int64(len("\r\n--" + boundary + "--\r\n"))
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+	writeHeaders(buf, hdr, cfg.HeaderFoldWidth, fieldOrder(t)...)
+	return int64(buf.Len()) + 2 + body.n, nil
+}
+
+// partHeaderSize returns the number of bytes multipart.Writer uses to write h,
+// including the blank line that ends the part header.
+func partHeaderSize(h textproto.MIMEHeader) int64 {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var n int64
+	for _, k := range keys {
+		for _, v := range h[k] {
+			n += int64(len(k) + len(": ") + len(v) + len("\r\n"))
+		}
+	}
+	return n + 2
+}
+
+// base64WrappedLen returns the length of n bytes as written by
+// encodeAndWrapBase64: base64 in lines of width characters, each terminated
+// by CRLF.
+func base64WrappedLen(n int64, width int) int64 {
+	lineBytes := int64(width / 4 * 3)
+	size := n / lineBytes * int64(width+2)
+	if rem := n % lineBytes; rem > 0 {
+		size += (rem+2)/3*4 + 2
+	}
+	return size
+}
+
+// countingWriter counts the bytes written to it.
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// dataSize returns the size in bytes of msg as the DATA writer transmits it:
+// with bare LF line endings converted to CRLF and a final CRLF added if msg
+// lacks one. Dot-stuffing is not counted (RFC 1870, section 4).
+func dataSize(msg []byte) int {
+	n := len(msg) + bytes.Count(msg, []byte("\n")) - bytes.Count(msg, []byte("\r\n"))
+	if !bytes.HasSuffix(msg, []byte("\n")) {
+		n += len("\r\n")
+	}
+	return n
+}
+
+// sizeParam checks a message of n bytes against the limit the server
+// advertises with the SIZE extension (RFC 1870), so that an oversized message
+// is rejected before it is transmitted. It returns the MAIL parameter
+// declaring the size, or "" if the server does not advertise SIZE.
+func sizeParam(c *smtp.Client, n int) (string, error) {
+	ok, v := c.Extension("SIZE")
+	if !ok {
+		return "", nil
+	}
+	// A missing or zero limit means the server declares no fixed maximum.
+	if limit, err := strconv.ParseInt(v, 10, 64); err == nil && limit > 0 && int64(n) > limit {
+		return "", fmt.Errorf("message too large for this relay: %d bytes exceeds the server's SIZE limit of %d", n, limit)
+	}
+	return "SIZE=" + strconv.Itoa(n), nil
+}
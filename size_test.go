@@ -0,0 +1,228 @@
+package pigeon
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEstimateSize(t *testing.T) {
+	dir := t.TempDir()
+	small := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(small, []byte("hello attachment"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	large := filepath.Join(dir, "blob.bin")
+	if err := os.WriteFile(large, bytes.Repeat([]byte{0xAB}, 100_003), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		tmpl        string
+		attachments []Attachment
+	}{
+		{
+			name: "plain",
+			tmpl: "From: a@example.com\nTo: b@example.com\nSubject: Hi {{.Name}}\n\nHello, {{.Name}}!",
+		},
+		{
+			name: "quoted-printable",
+			tmpl: "From: a@example.com\nTo: b@example.com\nSubject: Grüße\n\nGrüße, {{.Name}}\n" + strings.Repeat("x", 200),
+		},
+		{
+			name:        "attachments",
+			tmpl:        "From: a@example.com\nTo: b@example.com, c@example.com\nSubject: Files\n\nSee attached.",
+			attachments: []Attachment{{Path: small}, {Path: large}},
+		},
+		{
+			name:        "quoted-printable attachment",
+			tmpl:        "From: a@example.com\nTo: b@example.com\nSubject: Notes\n\nSee attached.",
+			attachments: []Attachment{{Path: small, Encoding: "quoted-printable"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := EmailConfig{
+				TemplatePath:    tplWriteTemp(t, tt.tmpl),
+				AttachmentSpecs: tt.attachments,
+				Headers:         map[string]string{"X-Mailer": "pigeon"},
+			}
+			data := map[string]string{"Name": "Pigeon"}
+
+			msg, err := BuildMessage(cfg, data)
+			if err != nil {
+				t.Fatalf("BuildMessage: %v", err)
+			}
+			got, err := EstimateSize(cfg, data)
+			if err != nil {
+				t.Fatalf("EstimateSize: %v", err)
+			}
+
+			// The Date header and multipart boundary may differ between the
+			// two calls if the clock ticks, so allow a few bytes of slack.
+			if diff := got - int64(len(msg)); diff < -8 || diff > 8 {
+				t.Errorf("EstimateSize = %d, len(BuildMessage) = %d", got, len(msg))
+			}
+		})
+	}
+}
+
+func TestEstimateSize_Wire(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		cfg  EmailConfig
+	}{
+		{name: "dkim", cfg: EmailConfig{DKIM: &DKIMConfig{Domain: "example.com", Selector: "mail", PrivateKey: writeDKIMKey(t, key)}}},
+		{name: "rewriter", cfg: EmailConfig{MessageRewriter: func(msg []byte) ([]byte, error) {
+			return append([]byte("X-Rewritten: yes\n"), bytes.ReplaceAll(msg, []byte("\r\n"), []byte("\n"))...), nil
+		}}},
+		{name: "line ending", cfg: EmailConfig{LineEnding: "lf"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.TemplatePath = tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Wire\n\nline1\nline2")
+			wire, err := BuildWireMessage(cfg, nil)
+			if err != nil {
+				t.Fatalf("BuildWireMessage: %v", err)
+			}
+			got, err := EstimateSize(cfg, nil)
+			if err != nil {
+				t.Fatalf("EstimateSize: %v", err)
+			}
+			if diff := got - int64(len(wire)); diff < -8 || diff > 8 {
+				t.Errorf("EstimateSize = %d, len(BuildWireMessage) = %d", got, len(wire))
+			}
+		})
+	}
+}
+
+func TestEstimateSize_MissingAttachment(t *testing.T) {
+	cfg := EmailConfig{
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\n\nbody"),
+		Attachments:  []string{filepath.Join(t.TempDir(), "missing.pdf")},
+	}
+	if _, err := EstimateSize(cfg, nil); err == nil {
+		t.Fatal("expected error for missing attachment")
+	}
+}
+
+func TestSend_SizeLimit(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit int
+		body  string
+	}{
+		{name: "within limit", limit: 10_000, body: "short"},
+		{name: "no fixed limit", limit: 0, body: strings.Repeat("long ", 500)},
+		{name: "too large", limit: 1_000, body: strings.Repeat("long ", 500)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &mockSMTP{Extensions: []string{"SIZE " + strconv.Itoa(tt.limit)}, KeepCRLF: true}
+			m.start(t)
+
+			cfg := EmailConfig{
+				Smarthost:    m.smarthost(),
+				TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Size\n\n"+tt.body),
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			retry, err := Send(ctx, cfg, nil)
+			if tt.limit > 0 && len(tt.body) > tt.limit {
+				if err == nil || retry || !strings.Contains(err.Error(), "message too large") {
+					t.Fatalf("Send = (%v, %v), want permanent size error", retry, err)
+				}
+				for _, cmd := range m.Commands() {
+					if strings.HasPrefix(cmd, "MAIL") || cmd == "DATA" {
+						t.Errorf("sent %q for an oversized message", cmd)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+
+			raw := <-m.received
+			var mail string
+			for _, cmd := range m.Commands() {
+				if strings.HasPrefix(cmd, "MAIL FROM:") {
+					mail = cmd
+				}
+			}
+			if want := " SIZE=" + strconv.Itoa(len(raw)); !strings.HasSuffix(mail, want) {
+				t.Errorf("MAIL command %q, want suffix %q", mail, want)
+			}
+		})
+	}
+}
+
+func TestSend_SizeParamBareLF(t *testing.T) {
+	m := &mockSMTP{Extensions: []string{"SIZE 10000"}, KeepCRLF: true}
+	m.start(t)
+	cfg := EmailConfig{
+		Smarthost:    m.smarthost(),
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Size\n\nline1\nline2"),
+		// A rewriter may hand back bare LF line endings, which the DATA
+		// writer converts to CRLF on the wire.
+		MessageRewriter: func(msg []byte) ([]byte, error) {
+			return bytes.TrimSuffix(bytes.ReplaceAll(msg, []byte("\r\n"), []byte("\n")), []byte("\n")), nil
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := Send(ctx, cfg, nil); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	raw := <-m.received
+	var mail string
+	for _, cmd := range m.Commands() {
+		if strings.HasPrefix(cmd, "MAIL FROM:") {
+			mail = cmd
+		}
+	}
+	if want := " SIZE=" + strconv.Itoa(len(raw)); !strings.HasSuffix(mail, want) {
+		t.Errorf("MAIL command %q, want suffix %q", mail, want)
+	}
+}
+
+func BenchmarkEstimateSize(b *testing.B) {
+	path := filepath.Join(b.TempDir(), "blob.bin")
+	if err := os.WriteFile(path, bytes.Repeat([]byte{0xAB}, 4<<20), 0600); err != nil {
+		b.Fatal(err)
+	}
+	cfg := EmailConfig{
+		TemplatePath: tplWriteTemp(b, "From: a@example.com\nTo: b@example.com\nSubject: Bench\n\nHello"),
+		Attachments:  []string{path},
+	}
+
+	b.Run("EstimateSize", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := EstimateSize(cfg, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("BuildMessage", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := BuildMessage(cfg, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
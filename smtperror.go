@@ -0,0 +1,228 @@
+package pigeon
+
+import (
+	"errors"
+	"fmt"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// ErrRateLimited matches, with errors.Is, an *SMTPError that says the server
+// is temporarily out of resources or throttling the sender (see
+// SMTPError.RateLimited). Such failures call for a longer back-off than
+// other temporary ones.
+var ErrRateLimited = errors.New("smtp: rate limited")
+
+// SMTPError is a failure reply from an SMTP server.
+type SMTPError struct {
+	// Command is the SMTP command that was rejected, e.g. "RCPT".
+	Command string
+	// Code is the basic reply code, e.g. 550.
+	Code int
+	// EnhancedCode is the RFC 3463 enhanced status code (class, subject,
+	// detail), e.g. {5, 1, 1}; it is all zero if the reply has none.
+	EnhancedCode [3]int
+	// Message is the reply text without the enhanced status code.
+	Message string
+}
+
+// Error implements the error interface.
+func (e *SMTPError) Error() string {
+	code := strconv.Itoa(e.Code)
+	if e.EnhancedCode != [3]int{} {
+		code += " " + formatEnhancedCode(e.EnhancedCode)
+	}
+	return fmt.Sprintf("smtp: %s failed: %s %s", e.Command, code, e.Message)
+}
+
+// Temporary reports whether the failure is transient (a 4xx reply), so that
+// the message may be accepted if sent again later.
+func (e *SMTPError) Temporary() bool {
+	if e.Code != 0 {
+		return e.Code/100 == 4
+	}
+	return e.EnhancedCode[0] == 4
+}
+
+// rateLimitPhrases are reply texts relays use for throttling without a
+// dedicated status code, compared in lower case.
+var rateLimitPhrases = []string{
+	"rate limit",
+	"ratelimit",
+	"rate-limit",
+	"throttl",
+	"too many messages",
+	"too many connections",
+	"sending limit",
+	"insufficient system storage",
+}
+
+// RateLimited reports whether the failure is a temporary resource or rate
+// limit: insufficient system storage (452, or enhanced status 4.3.1), 4.7.28
+// (as used for sending rate limits), or a temporary reply whose text speaks
+// of rate limiting or throttling. "Too many recipients" (452 4.5.3) is not
+// one.
+func (e *SMTPError) RateLimited() bool {
+	if !e.Temporary() || e.EnhancedCode == [3]int{4, 5, 3} {
+		return false
+	}
+	switch e.EnhancedCode {
+	case [3]int{4, 3, 1}, [3]int{4, 7, 28}:
+		return true
+	}
+	if e.Code == 452 && e.EnhancedCode == [3]int{} {
+		return true
+	}
+	msg := strings.ToLower(e.Message)
+	for _, phrase := range rateLimitPhrases {
+		if strings.Contains(msg, phrase) {
+			return true
+		}
+	}
+	return false
+}
+
+// Is reports whether e matches target; a rate-limited failure matches
+// ErrRateLimited.
+func (e *SMTPError) Is(target error) bool {
+	return target == ErrRateLimited && e.RateLimited()
+}
+
+// Description returns a human-readable description of the enhanced status
+// code, or "" if the reply has none.
+func (e *SMTPError) Description() string {
+	return EnhancedCodeDescription(e.EnhancedCode)
+}
+
+// ParseEnhancedCode extracts an RFC 3463 enhanced status code
+// ("class.subject.detail") from the start of an SMTP reply text such as
+// "5.1.1 User unknown". It returns the code and the remaining text; ok is
+// false if text does not start with an enhanced status code.
+func ParseEnhancedCode(text string) (code [3]int, rest string, ok bool) {
+	field, rest, _ := strings.Cut(text, " ")
+	parts := strings.Split(field, ".")
+	if len(parts) != 3 {
+		return [3]int{}, text, false
+	}
+	for i, p := range parts {
+		if p == "" || len(p) > 3 {
+			return [3]int{}, text, false
+		}
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return [3]int{}, text, false
+		}
+		code[i] = n
+	}
+	switch code[0] {
+	case 2, 4, 5:
+	default:
+		return [3]int{}, text, false
+	}
+	return code, strings.TrimSpace(rest), true
+}
+
+// enhancedCodeDescriptions describes common enhanced status codes by subject
+// and detail; the class (2, 4 or 5) only says whether the condition is
+// success, temporary or permanent (RFC 3463, section 3).
+var enhancedCodeDescriptions = map[[2]int]string{
+	{0, 0}:  "Other undefined status",
+	{1, 0}:  "Other address status",
+	{1, 1}:  "Bad destination mailbox address",
+	{1, 2}:  "Bad destination system address",
+	{1, 3}:  "Bad destination mailbox address syntax",
+	{1, 6}:  "Destination mailbox has moved",
+	{1, 7}:  "Bad sender's mailbox address syntax",
+	{1, 8}:  "Bad sender's system address",
+	{2, 0}:  "Other or undefined mailbox status",
+	{2, 1}:  "Mailbox disabled, not accepting messages",
+	{2, 2}:  "Mailbox full",
+	{2, 3}:  "Message length exceeds administrative limit",
+	{3, 0}:  "Other or undefined mail system status",
+	{3, 1}:  "Mail system full",
+	{3, 4}:  "Message too big for system",
+	{4, 0}:  "Other or undefined network or routing status",
+	{4, 1}:  "No answer from host",
+	{4, 2}:  "Bad connection",
+	{4, 4}:  "Unable to route",
+	{4, 7}:  "Delivery time expired",
+	{5, 0}:  "Other or undefined protocol status",
+	{5, 1}:  "Invalid command",
+	{5, 2}:  "Syntax error",
+	{5, 3}:  "Too many recipients",
+	{6, 0}:  "Other or undefined media error",
+	{7, 0}:  "Other or undefined security status",
+	{7, 1}:  "Delivery not authorized, message refused",
+	{7, 8}:  "Authentication credentials invalid",
+	{7, 30}: "REQUIRETLS support required",
+}
+
+// enhancedSubjectDescriptions describes each subject class, used for codes
+// without a specific description.
+var enhancedSubjectDescriptions = map[int]string{
+	0: "Other or undefined status",
+	1: "Addressing status",
+	2: "Mailbox status",
+	3: "Mail system status",
+	4: "Network and routing status",
+	5: "Mail delivery protocol status",
+	6: "Message content or media status",
+	7: "Security or policy status",
+}
+
+// EnhancedCodeDescription returns a human-readable description of an
+// enhanced status code, e.g. "Mailbox full" for 4.2.2 or 5.2.2. Codes without
+// a specific description are described by their subject. It returns "" for
+// the zero code.
+func EnhancedCodeDescription(code [3]int) string {
+	if code == [3]int{} {
+		return ""
+	}
+	if d, ok := enhancedCodeDescriptions[[2]int{code[1], code[2]}]; ok {
+		return d
+	}
+	return enhancedSubjectDescriptions[code[1]]
+}
+
+func formatEnhancedCode(code [3]int) string {
+	return fmt.Sprintf("%d.%d.%d", code[0], code[1], code[2])
+}
+
+// newSMTPError converts a reply error returned by net/smtp for cmd into an
+// *SMTPError. Multi-line replies repeating the enhanced status code on each
+// line have it removed from every line. Other errors are returned unchanged.
+func newSMTPError(cmd string, err error) error {
+	var tpErr *textproto.Error
+	if !errors.As(err, &tpErr) {
+		return err
+	}
+
+	e := &SMTPError{Command: cmd, Code: tpErr.Code, Message: tpErr.Msg}
+	lines := strings.Split(tpErr.Msg, "\n")
+	code, rest, ok := ParseEnhancedCode(lines[0])
+	if !ok {
+		return e
+	}
+	e.EnhancedCode = code
+	lines[0] = rest
+	for i, line := range lines[1:] {
+		if c, r, ok := ParseEnhancedCode(line); ok && c == code {
+			lines[i+1] = r
+		}
+	}
+	e.Message = strings.Join(lines, "\n")
+	return e
+}
+
+// smtpFailure returns the retry flag and error for a failed SMTP command.
+// Server replies are reported as *SMTPError and are retryable if temporary;
+// for any other error (e.g. a broken connection) retry is def.
+func smtpFailure(cmd string, err error, def bool) (retry bool, _ error) {
+	err = newSMTPError(cmd, err)
+	var smtpErr *SMTPError
+	if errors.As(err, &smtpErr) {
+		return smtpErr.Temporary(), err
+	}
+	return def, err
+}
@@ -0,0 +1,207 @@
+package pigeon
+
+import (
+	"context"
+	"errors"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseEnhancedCode(t *testing.T) {
+	tests := []struct {
+		text     string
+		wantCode [3]int
+		wantRest string
+		wantOK   bool
+	}{
+		{"5.1.1 The email account that you tried to reach does not exist.", [3]int{5, 1, 1}, "The email account that you tried to reach does not exist.", true},
+		{"4.2.2 The email account that you tried to reach is over quota.", [3]int{4, 2, 2}, "The email account that you tried to reach is over quota.", true},
+		{"5.7.1 Service unavailable; Client host [192.0.2.1] blocked using zen.spamhaus.org", [3]int{5, 7, 1}, "Service unavailable; Client host [192.0.2.1] blocked using zen.spamhaus.org", true},
+		{"2.0.0 OK  1700000000 a1b2c3 - gsmtp", [3]int{2, 0, 0}, "OK  1700000000 a1b2c3 - gsmtp", true},
+		{"5.7.30 REQUIRETLS support required", [3]int{5, 7, 30}, "REQUIRETLS support required", true},
+		{"4.4.2", [3]int{4, 4, 2}, "", true},
+		{"Requested action aborted: local error in processing", [3]int{}, "Requested action aborted: local error in processing", false},
+		{"3.1.1 not a valid class", [3]int{}, "3.1.1 not a valid class", false},
+		{"5.1.1000 detail too long", [3]int{}, "5.1.1000 detail too long", false},
+		{"1.2.3.4 is an address", [3]int{}, "1.2.3.4 is an address", false},
+	}
+
+	for _, tt := range tests {
+		code, rest, ok := ParseEnhancedCode(tt.text)
+		if code != tt.wantCode || rest != tt.wantRest || ok != tt.wantOK {
+			t.Errorf("ParseEnhancedCode(%q) = (%v, %q, %v), want (%v, %q, %v)",
+				tt.text, code, rest, ok, tt.wantCode, tt.wantRest, tt.wantOK)
+		}
+	}
+}
+
+func TestEnhancedCodeDescription(t *testing.T) {
+	tests := []struct {
+		code [3]int
+		want string
+	}{
+		{[3]int{4, 2, 2}, "Mailbox full"},
+		{[3]int{5, 2, 2}, "Mailbox full"},
+		{[3]int{5, 1, 1}, "Bad destination mailbox address"},
+		{[3]int{5, 7, 99}, "Security or policy status"},
+		{[3]int{}, ""},
+	}
+	for _, tt := range tests {
+		if got := EnhancedCodeDescription(tt.code); got != tt.want {
+			t.Errorf("EnhancedCodeDescription(%v) = %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestNewSMTPError_MultiLine(t *testing.T) {
+	// A Gmail-style reply repeating the enhanced code on every line.
+	err := newSMTPError("RCPT", &textproto.Error{
+		Code: 550,
+		Msg:  "5.1.1 The email account that you tried to reach does not exist. Please try\n5.1.1 double-checking the recipient's email address for typos.",
+	})
+	var smtpErr *SMTPError
+	if !errors.As(err, &smtpErr) {
+		t.Fatalf("got %T, want *SMTPError", err)
+	}
+	if smtpErr.EnhancedCode != [3]int{5, 1, 1} || smtpErr.Temporary() {
+		t.Errorf("unexpected error: %+v", smtpErr)
+	}
+	want := "The email account that you tried to reach does not exist. Please try\ndouble-checking the recipient's email address for typos."
+	if smtpErr.Message != want {
+		t.Errorf("Message = %q, want %q", smtpErr.Message, want)
+	}
+}
+
+func TestSend_SMTPError(t *testing.T) {
+	tests := []struct {
+		name      string
+		replies   map[string]string
+		wantCmd   string
+		wantCode  [3]int
+		wantRetry bool
+	}{
+		{"user unknown", map[string]string{"RCPT": "550 5.1.1 <b@example.com>: Recipient address rejected: User unknown"}, "RCPT", [3]int{5, 1, 1}, false},
+		{"mailbox full", map[string]string{"RCPT": "452 4.2.2 Mailbox full"}, "RCPT", [3]int{4, 2, 2}, true},
+		{"message too big", map[string]string{".": "552 5.3.4 Message size exceeds fixed limit"}, "DATA", [3]int{5, 3, 4}, false},
+		{"no enhanced code", map[string]string{"MAIL": "451 Requested action aborted"}, "MAIL", [3]int{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &mockSMTP{Replies: tt.replies}
+			m.start(t)
+
+			cfg := EmailConfig{
+				Smarthost:    m.smarthost(),
+				TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Hi\n\nbody"),
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			retry, err := Send(ctx, cfg, nil)
+			var smtpErr *SMTPError
+			if !errors.As(err, &smtpErr) {
+				t.Fatalf("Send error = %v, want *SMTPError", err)
+			}
+			if smtpErr.Command != tt.wantCmd || smtpErr.EnhancedCode != tt.wantCode {
+				t.Errorf("SMTPError = %+v, want command %s and code %v", smtpErr, tt.wantCmd, tt.wantCode)
+			}
+			if retry != tt.wantRetry {
+				t.Errorf("retry = %v, want %v", retry, tt.wantRetry)
+			}
+		})
+	}
+}
+
+func TestSMTPError_RateLimited(t *testing.T) {
+	tests := []struct {
+		code int
+		msg  string
+		want bool
+	}{
+		{452, "4.3.1 Insufficient system storage", true},
+		{452, "Insufficient system storage", true},
+		{421, "4.7.28 Our system has detected an unusual rate of unsolicited mail", true},
+		{451, "4.7.1 Sender rate limit exceeded, try again later", true},
+		{450, "Too many messages from this IP, throttled", true},
+		{452, "4.5.3 Too many recipients", false},
+		{452, "4.2.2 Mailbox full", false},
+		{451, "4.7.1 Greylisted, please try again", false},
+		{552, "5.3.1 Mail system full", false},
+	}
+	for _, tt := range tests {
+		err := newSMTPError("DATA", &textproto.Error{Code: tt.code, Msg: tt.msg})
+		if got := errors.Is(err, ErrRateLimited); got != tt.want {
+			t.Errorf("%d %s: errors.Is(err, ErrRateLimited) = %v, want %v", tt.code, tt.msg, got, tt.want)
+		}
+	}
+}
+
+func TestSend_RateLimited(t *testing.T) {
+	m := &mockSMTP{Replies: map[string]string{"DATA": "452 4.3.1 Insufficient system storage"}}
+	m.start(t)
+
+	cfg := EmailConfig{
+		Smarthost:    m.smarthost(),
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Hi\n\nbody"),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	retry, err := Send(ctx, cfg, nil)
+	if !retry || !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("Send = (%v, %v), want retryable ErrRateLimited", retry, err)
+	}
+	var smtpErr *SMTPError
+	if !errors.As(err, &smtpErr) || smtpErr.Command != "DATA" || smtpErr.Code != 452 {
+		t.Errorf("Send error = %v, want DATA *SMTPError with code 452", err)
+	}
+}
+
+func TestSend_GreetingError(t *testing.T) {
+	tests := []struct {
+		name      string
+		banner    string
+		wantErr   string
+		wantRetry bool
+	}{
+		{"not available", "421 Service not available", "421 Service not available", true},
+		{"multiline not ready", "421-mx.example.com busy\r\n421 4.3.2 try again later", "4.3.2", true},
+		{"no service", "554 5.7.1 No SMTP service here", "No SMTP service here", false},
+		{"multiline ok", "220-mx.example.com ESMTP\r\n220 ready", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &mockSMTP{Banner: tt.banner}
+			m.start(t)
+
+			cfg := EmailConfig{
+				Smarthost:    m.smarthost(),
+				TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Hi\n\nbody"),
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			retry, err := Send(ctx, cfg, nil)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Send: %v", err)
+				}
+				return
+			}
+			var smtpErr *SMTPError
+			if !errors.As(err, &smtpErr) || smtpErr.Command != "greeting" {
+				t.Fatalf("Send error = %v, want greeting *SMTPError", err)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error %q does not contain banner text %q", err, tt.wantErr)
+			}
+			if retry != tt.wantRetry {
+				t.Errorf("retry = %v, want %v", retry, tt.wantRetry)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package pigeon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// srvPrefix marks a smarthost resolved from DNS SRV records, e.g.
+// "srv:_submission._tcp.example.com".
+const srvPrefix = "srv:"
+
+// isSRVName reports whether the smarthost s names SRV records.
+func isSRVName(s string) bool {
+	return strings.HasPrefix(s, srvPrefix)
+}
+
+// isSRV reports whether the smarthost is an SRV name rather than a host and
+// port.
+func (hp HostPort) isSRV() bool {
+	return hp.Port == "" && isSRVName(hp.Host)
+}
+
+// deliverSRV delivers msg through the targets of the SRV smarthost name,
+// trying them in the order RFC 2782 prescribes until one accepts a
+// connection.
+func deliverSRV(ctx context.Context, d *net.Dialer, cfg EmailConfig, name, from string, rcpts []string, msg []byte) (retry bool, err error) {
+	targets, err := lookupSRV(ctx, cfg.resolver(), name)
+	if err != nil {
+		var dnsErr *net.DNSError
+		return errors.As(err, &dnsErr) && (dnsErr.IsTemporary || dnsErr.IsTimeout), err
+	}
+	return deliverHosts(ctx, d, cfg, targets, from, rcpts, msg)
+}
+
+// deliverHosts delivers msg to the first of hostPorts that accepts a
+// connection. Once connected, the outcome of that transaction is returned
+// without trying the remaining hosts.
+func deliverHosts(ctx context.Context, d *net.Dialer, cfg EmailConfig, hostPorts []string, from string, rcpts []string, msg []byte) (retry bool, err error) {
+	var errs []error
+	for _, hostPort := range hostPorts {
+		conn, err := dialSmarthost(ctx, d, cfg, hostPort)
+		if err != nil {
+			errs = append(errs, err)
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		host, _, _ := net.SplitHostPort(hostPort)
+		retry, err := transmit(conn, host, cfg, from, rcpts, msg)
+		conn.Close()
+		return retry, err
+	}
+	return true, errors.Join(errs...)
+}
+
+// lookupSRV returns the targets of the SRV records for name as "host:port",
+// ordered by priority and, within a priority, shuffled by weight. r must
+// implement srvResolver.
+func lookupSRV(ctx context.Context, r Resolver, name string) ([]string, error) {
+	sr, ok := r.(srvResolver)
+	if !ok {
+		return nil, fmt.Errorf("SRV lookup for %s failed: resolver %T has no LookupSRV method", name, r)
+	}
+	_, srvs, err := sr.LookupSRV(ctx, "", "", name)
+	if err != nil && len(srvs) == 0 {
+		return nil, fmt.Errorf("SRV lookup for %s failed: %w", name, err)
+	}
+	if len(srvs) == 0 {
+		return nil, fmt.Errorf("no SRV records found for %s", name)
+	}
+	if len(srvs) == 1 && strings.TrimSuffix(srvs[0].Target, ".") == "" {
+		// A target of "." means the service is not offered (RFC 2782).
+		return nil, fmt.Errorf("%s: service not available (SRV target \".\")", name)
+	}
+
+	srvs = orderSRV(srvs)
+	targets := make([]string, 0, len(srvs))
+	for _, srv := range srvs {
+		host := strings.TrimSuffix(srv.Target, ".")
+		if host == "" {
+			continue
+		}
+		targets = append(targets, net.JoinHostPort(host, strconv.Itoa(int(srv.Port))))
+	}
+	return targets, nil
+}
+
+// orderSRV sorts srvs by ascending priority and orders the records of equal
+// priority by weighted random selection (RFC 2782).
+func orderSRV(srvs []*net.SRV) []*net.SRV {
+	srvs = append([]*net.SRV(nil), srvs...)
+	sort.SliceStable(srvs, func(i, j int) bool { return srvs[i].Priority < srvs[j].Priority })
+
+	for i := 0; i < len(srvs); {
+		j := i + 1
+		for j < len(srvs) && srvs[j].Priority == srvs[i].Priority {
+			j++
+		}
+		shuffleByWeight(srvs[i:j])
+		i = j
+	}
+	return srvs
+}
+
+// shuffleByWeight reorders srvs in place, repeatedly picking the next record
+// with probability proportional to its weight. Records of weight 0 have a
+// small chance of being picked ahead of weighted ones.
+func shuffleByWeight(srvs []*net.SRV) {
+	sum := 0
+	for _, srv := range srvs {
+		sum += int(srv.Weight)
+	}
+	for len(srvs) > 1 && sum > 0 {
+		n := rand.IntN(sum + 1)
+		s := 0
+		for i, srv := range srvs {
+			s += int(srv.Weight)
+			if s >= n {
+				srvs[0], srvs[i] = srvs[i], srvs[0]
+				break
+			}
+		}
+		sum -= int(srvs[0].Weight)
+		srvs = srvs[1:]
+	}
+}
@@ -0,0 +1,113 @@
+package pigeon
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestSend_SRVSmarthost(t *testing.T) {
+	m := &mockSMTP{}
+	m.start(t)
+	_, port, _ := net.SplitHostPort(m.addr)
+	mockPort, _ := strconv.Atoi(port)
+
+	// Nothing listens on the preferred target's port, so delivery fails
+	// over to the next priority.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedPort := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	loopback := []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}}
+	res := &stubResolver{
+		ips: map[string][]net.IPAddr{
+			"primary.example.test": loopback,
+			"backup.example.test":  loopback,
+		},
+		srv: map[string][]*net.SRV{
+			"_submission._tcp.example.test": {
+				{Target: "backup.example.test.", Port: uint16(mockPort), Priority: 20, Weight: 1},
+				{Target: "primary.example.test.", Port: uint16(closedPort), Priority: 10, Weight: 1},
+			},
+		},
+	}
+
+	var cfg EmailConfig
+	if err := yaml.Unmarshal([]byte("smarthost: srv:_submission._tcp.example.test\n"), &cfg); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	cfg.Resolver = res
+	cfg.TemplatePath = tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: SRV\n\nBody.")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if retry, err := Send(ctx, cfg, nil); err != nil {
+		t.Fatalf("Send = (%v, %v)", retry, err)
+	}
+	<-m.received
+
+	want := []string{"SRV _submission._tcp.example.test", "primary.example.test", "backup.example.test"}
+	if !reflect.DeepEqual(res.queries, want) {
+		t.Errorf("queries = %v, want %v", res.queries, want)
+	}
+}
+
+func TestLookupSRV(t *testing.T) {
+	res := &stubResolver{srv: map[string][]*net.SRV{
+		"_smtp._tcp.example.test": {
+			{Target: "c.example.test.", Port: 25, Priority: 30},
+			{Target: "a.example.test.", Port: 587, Priority: 10},
+			{Target: "b.example.test.", Port: 2525, Priority: 20},
+		},
+		"_smtp._tcp.none.test": {{Target: ".", Priority: 0}},
+	}}
+
+	targets, err := lookupSRV(context.Background(), res, "_smtp._tcp.example.test")
+	want := []string{"a.example.test:587", "b.example.test:2525", "c.example.test:25"}
+	if err != nil || !reflect.DeepEqual(targets, want) {
+		t.Errorf("lookupSRV = %v, %v; want %v", targets, err, want)
+	}
+
+	if _, err := lookupSRV(context.Background(), res, "_smtp._tcp.none.test"); err == nil {
+		t.Error("expected error for SRV target \".\"")
+	}
+	if _, err := lookupSRV(context.Background(), res, "_smtp._tcp.missing.test"); err == nil {
+		t.Error("expected error for missing SRV records")
+	}
+
+	// A Resolver without LookupSRV still works for everything else.
+	basic := struct{ Resolver }{res}
+	if _, err := lookupSRV(context.Background(), basic, "_smtp._tcp.example.test"); err == nil || !strings.Contains(err.Error(), "no LookupSRV method") {
+		t.Errorf("lookupSRV with a resolver without LookupSRV: err = %v", err)
+	}
+}
+
+func TestOrderSRV_Weight(t *testing.T) {
+	srvs := []*net.SRV{
+		{Target: "light", Priority: 10, Weight: 1},
+		{Target: "heavy", Priority: 10, Weight: 99},
+		{Target: "fallback", Priority: 20, Weight: 100},
+	}
+	heavyFirst := 0
+	for i := 0; i < 1000; i++ {
+		got := orderSRV(srvs)
+		if got[2].Target != "fallback" {
+			t.Fatalf("lower priority record ordered before higher: %v", got)
+		}
+		if got[0].Target == "heavy" {
+			heavyFirst++
+		}
+	}
+	if heavyFirst < 900 {
+		t.Errorf("heavy target first %d of 1000 times, want about 990", heavyFirst)
+	}
+}
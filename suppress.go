@@ -0,0 +1,145 @@
+package pigeon
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ErrAllSuppressed is returned when every recipient of a message is on the
+// suppression list, so that there is nobody to send it to.
+var ErrAllSuppressed = errors.New("all recipients are suppressed")
+
+// SuppressionList reports addresses that must not be sent to, e.g. because
+// they bounced or unsubscribed.
+type SuppressionList interface {
+	// IsSuppressed reports whether the bare address addr is suppressed.
+	IsSuppressed(addr string) bool
+}
+
+// MemorySuppressionList is a SuppressionList held in memory. Addresses are
+// compared case-insensitively. It is safe for concurrent use.
+type MemorySuppressionList struct {
+	mu    sync.RWMutex
+	addrs map[string]bool
+}
+
+// NewMemorySuppressionList returns a suppression list of addrs.
+func NewMemorySuppressionList(addrs ...string) *MemorySuppressionList {
+	l := &MemorySuppressionList{addrs: make(map[string]bool, len(addrs))}
+	l.Add(addrs...)
+	return l
+}
+
+// Add suppresses addrs.
+func (l *MemorySuppressionList) Add(addrs ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, addr := range addrs {
+		l.addrs[strings.ToLower(strings.TrimSpace(addr))] = true
+	}
+}
+
+// Remove lifts the suppression of addrs.
+func (l *MemorySuppressionList) Remove(addrs ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, addr := range addrs {
+		delete(l.addrs, strings.ToLower(strings.TrimSpace(addr)))
+	}
+}
+
+// IsSuppressed implements SuppressionList.
+func (l *MemorySuppressionList) IsSuppressed(addr string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.addrs[strings.ToLower(addr)]
+}
+
+// LoadSuppressionList reads a suppression list from a file with one address
+// per line. Blank lines and lines starting with "#" are ignored.
+func LoadSuppressionList(path string) (*MemorySuppressionList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	l := NewMemorySuppressionList()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		l.Add(line)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read suppression list %s: %w", path, err)
+	}
+	return l, nil
+}
+
+// suppress removes the recipients on cfg.Suppression from env and, with
+// cfg.SuppressHeaders, from the To, Cc and Bcc fields of hdr. It returns the
+// removed addresses, or ErrAllSuppressed if no recipient is left.
+func suppress(cfg EmailConfig, hdr textproto.MIMEHeader, env *Envelope) ([]string, error) {
+	if cfg.Suppression == nil {
+		return nil, nil
+	}
+	var kept, suppressed []string
+	for _, rcpt := range env.Rcpts {
+		if cfg.Suppression.IsSuppressed(rcpt) {
+			suppressed = append(suppressed, rcpt)
+		} else {
+			kept = append(kept, rcpt)
+		}
+	}
+	if len(suppressed) == 0 {
+		return nil, nil
+	}
+	cfg.logInfo("skipping suppressed recipients", "rcpts", suppressed)
+	if len(kept) == 0 {
+		return suppressed, fmt.Errorf("%w: %s", ErrAllSuppressed, strings.Join(suppressed, ", "))
+	}
+	env.Rcpts = kept
+
+	if cfg.SuppressHeaders {
+		for _, k := range []string{"To", "Cc", "Bcc"} {
+			if v := hdr.Get(k); v != "" {
+				hdr.Set(k, removeSuppressed(cfg.Suppression, v))
+			}
+		}
+		if hdr.Get("To") == "" {
+			hdr.Set("To", encodeHeaderValue("To", cfg.undisclosedRecipients()))
+		}
+		if hdr.Get("Cc") == "" {
+			hdr.Del("Cc")
+		}
+		if hdr.Get("Bcc") == "" {
+			hdr.Del("Bcc")
+		}
+	}
+	return suppressed, nil
+}
+
+// removeSuppressed returns the address list with the suppressed addresses
+// left out. A list that cannot be parsed is returned unchanged.
+func removeSuppressed(l SuppressionList, list string) string {
+	addrs, err := mail.ParseAddressList(list)
+	if err != nil {
+		return list
+	}
+	kept := make([]string, 0, len(addrs))
+	for _, a := range addrs {
+		if !l.IsSuppressed(a.Address) {
+			kept = append(kept, a.String())
+		}
+	}
+	return strings.Join(kept, ", ")
+}
@@ -0,0 +1,114 @@
+package pigeon
+
+import (
+	"context"
+	"errors"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSend_Suppression(t *testing.T) {
+	m := &mockSMTP{}
+	m.start(t)
+
+	cfg := EmailConfig{
+		Smarthost:       m.smarthost(),
+		TemplatePath:    tplWriteTemp(t, "From: a@example.com\nTo: Alice <alice@example.com>, Bob <bob@example.com>\nCc: carol@example.com\nSubject: News\n\nHello"),
+		Suppression:     NewMemorySuppressionList("BOB@example.com"),
+		SuppressHeaders: true,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := SendReport(ctx, cfg, nil)
+	if err != nil {
+		t.Fatalf("SendReport: %v", err)
+	}
+	if want := []string{"bob@example.com"}; !reflect.DeepEqual(res.Suppressed, want) {
+		t.Errorf("Suppressed = %q, want %q", res.Suppressed, want)
+	}
+	if want := []string{"alice@example.com", "carol@example.com"}; !reflect.DeepEqual(res.Envelope.Rcpts, want) {
+		t.Errorf("Rcpts = %q, want %q", res.Envelope.Rcpts, want)
+	}
+	var rcpts []string
+	for _, cmd := range m.Commands() {
+		if strings.HasPrefix(cmd, "RCPT TO:") {
+			rcpts = append(rcpts, cmd)
+		}
+	}
+	if want := []string{"RCPT TO:<alice@example.com>", "RCPT TO:<carol@example.com>"}; !reflect.DeepEqual(rcpts, want) {
+		t.Errorf("RCPT commands = %q, want %q", rcpts, want)
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(<-m.received))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if got := msg.Header.Get("To"); got != `"Alice" <alice@example.com>` {
+		t.Errorf("To = %q, want only Alice", got)
+	}
+
+	// With every recipient suppressed, nothing is sent.
+	cfg.Suppression = NewMemorySuppressionList("alice@example.com", "bob@example.com", "carol@example.com")
+	n := len(m.RemoteAddrs())
+	res, err = SendReport(ctx, cfg, nil)
+	if !errors.Is(err, ErrAllSuppressed) || res.Retry || len(res.Suppressed) != 3 {
+		t.Errorf("SendReport = (%+v, %v), want ErrAllSuppressed", res, err)
+	}
+	if len(m.RemoteAddrs()) != n {
+		t.Error("connected to the server although all recipients are suppressed")
+	}
+}
+
+func TestSendBatch_Suppression(t *testing.T) {
+	m := &mockSMTP{}
+	m.start(t)
+
+	cfg := EmailConfig{
+		Smarthost:    m.smarthost(),
+		From:         "news@example.com",
+		To:           "{{ .To }}",
+		TemplatePath: tplWriteTemp(t, "Subject: News\n\nHello"),
+		Suppression:  NewMemorySuppressionList("bob@example.com"),
+	}
+	var items []BatchItem
+	for _, to := range []string{"alice@example.com", "bob@example.com", "carol@example.com"} {
+		items = append(items, BatchItem{To: to, Data: map[string]string{"To": to}})
+	}
+	results := SendBatch(context.Background(), cfg, items)
+	if results[0].Err != nil || results[2].Err != nil {
+		t.Errorf("results = %+v, want alice and carol sent", results)
+	}
+	if r := results[1]; !errors.Is(r.Err, ErrAllSuppressed) || !reflect.DeepEqual(r.Suppressed, []string{"bob@example.com"}) {
+		t.Errorf("result 1 = %+v, want bob suppressed", r)
+	}
+}
+
+func TestLoadSuppressionList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "suppressed.txt")
+	if err := os.WriteFile(path, []byte("# bounced\nbob@example.com\n\n  Carol@Example.com  \n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	l, err := LoadSuppressionList(path)
+	if err != nil {
+		t.Fatalf("LoadSuppressionList: %v", err)
+	}
+	for addr, want := range map[string]bool{
+		"bob@example.com":   true,
+		"carol@example.com": true,
+		"alice@example.com": false,
+		"# bounced":         false,
+	} {
+		if got := l.IsSuppressed(addr); got != want {
+			t.Errorf("IsSuppressed(%q) = %v, want %v", addr, got, want)
+		}
+	}
+	l.Remove("bob@example.com")
+	if l.IsSuppressed("bob@example.com") {
+		t.Error("bob@example.com still suppressed after Remove")
+	}
+}
@@ -0,0 +1,111 @@
+package pigeon
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/smtp"
+	"os"
+)
+
+// requireTLS reports whether RequireTLS is explicitly enabled.
+func (c *EmailConfig) requireTLS() bool {
+	return c.RequireTLS != nil && *c.RequireTLS
+}
+
+// verifyTLS reports whether the server certificate is verified: when TLS is
+// required, or when TLSConfig or TLSRootCAFile says which servers to trust.
+// Otherwise STARTTLS is opportunistic, encrypting without authenticating the
+// server, so that relays with self-signed certificates keep working.
+func (c *EmailConfig) verifyTLS() bool {
+	return c.requireTLS() || c.TLSConfig != nil || c.TLSRootCAFile != ""
+}
+
+// startTLS upgrades the connection with STARTTLS when the server offers it.
+// A server without STARTTLS is an error only if TLS is required. A server
+// certificate that fails verification is a permanent error.
+func startTLS(c *smtp.Client, host string, cfg EmailConfig) (retry bool, err error) {
+	// Load the client configuration first so that a bad certificate is
+	// reported even when the server does not offer STARTTLS.
+	tlsCfg, err := cfg.tlsConfig(host)
+	if err != nil {
+		return false, err
+	}
+	if !cfg.verifyTLS() {
+		tlsCfg.InsecureSkipVerify = true
+	}
+	if ok, _ := c.Extension("STARTTLS"); !ok {
+		if cfg.requireTLS() {
+			return false, errors.New("server does not support STARTTLS, but require_tls is set")
+		}
+		return false, nil
+	}
+	if err := c.StartTLS(tlsCfg); err != nil {
+		var certErr *tls.CertificateVerificationError
+		if errors.As(err, &certErr) {
+			return false, fmt.Errorf("STARTTLS failed: %w", err)
+		}
+		retry, err = smtpFailure("STARTTLS", err, true)
+		return retry, fmt.Errorf("STARTTLS failed: %w", err)
+	}
+	limitReplies(c, cfg)
+	return false, nil
+}
+
+// tlsConfig returns the client TLS configuration for host, based on
+// TLSConfig and with the client certificate from TLSClientCertFile and
+// TLSClientKeyFile and the root CAs from TLSRootCAFile, if set.
+func (c *EmailConfig) tlsConfig(host string) (*tls.Config, error) {
+	tlsCfg := &tls.Config{}
+	if c.TLSConfig != nil {
+		tlsCfg = c.TLSConfig.Clone()
+	}
+	if tlsCfg.ServerName == "" {
+		tlsCfg.ServerName = host
+	}
+
+	if c.TLSClientCertFile != "" || c.TLSClientKeyFile != "" {
+		if c.TLSClientCertFile == "" || c.TLSClientKeyFile == "" {
+			return nil, errors.New("tls_client_cert_file and tls_client_key_file must be specified together")
+		}
+		cert, err := tls.LoadX509KeyPair(c.TLSClientCertFile, c.TLSClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS client certificate: %w", err)
+		}
+		tlsCfg.Certificates = append(tlsCfg.Certificates, cert)
+	}
+
+	if c.TLSRootCAFile != "" {
+		pool, err := c.rootCAs()
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.RootCAs = pool
+	} else if c.TLSRootCAOnly {
+		return nil, errors.New("tls_root_ca_only requires tls_root_ca_file")
+	}
+	return tlsCfg, nil
+}
+
+// rootCAs returns the certificates in TLSRootCAFile, added to the system
+// roots unless TLSRootCAOnly is set.
+func (c *EmailConfig) rootCAs() (*x509.CertPool, error) {
+	data, err := os.ReadFile(c.TLSRootCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read TLS root CA file: %w", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !c.TLSRootCAOnly {
+		sys, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load system root CAs: %w", err)
+		}
+		pool = sys
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no PEM certificates found in TLS root CA file %s", c.TLSRootCAFile)
+	}
+	return pool, nil
+}
@@ -0,0 +1,370 @@
+package pigeon
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testTLSConfigs returns a server configuration with a self-signed
+// certificate for 127.0.0.1 and a client configuration that trusts it.
+func testTLSConfigs(t testing.TB) (server, client *tls.Config) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "pigeon test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		DNSNames:              []string{"localhost"},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseCertificate: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	server = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	client = &tls.Config{RootCAs: pool}
+	return server, client
+}
+
+func TestSend_RequireTLS(t *testing.T) {
+	serverTLS, clientTLS := testTLSConfigs(t)
+	yes, no := true, false
+
+	tests := []struct {
+		name       string
+		serverTLS  *tls.Config
+		extensions []string
+		requireTLS *bool
+		wantErr    bool
+		wantParam  bool
+		wantHeader bool
+	}{
+		{name: "advertised", serverTLS: serverTLS, extensions: []string{"REQUIRETLS"}, requireTLS: &yes, wantParam: true},
+		{name: "not advertised", serverTLS: serverTLS, requireTLS: &yes},
+		{name: "unset", serverTLS: serverTLS, extensions: []string{"REQUIRETLS"}},
+		{name: "opt out", serverTLS: serverTLS, extensions: []string{"REQUIRETLS"}, requireTLS: &no, wantHeader: true},
+		{name: "no STARTTLS", extensions: []string{"REQUIRETLS"}, requireTLS: &yes, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &mockSMTP{TLSConfig: tt.serverTLS, Extensions: tt.extensions}
+			m.start(t)
+
+			cfg := EmailConfig{
+				Smarthost:    m.smarthost(),
+				TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: TLS\n\nsecret"),
+				RequireTLS:   tt.requireTLS,
+				TLSConfig:    clientTLS,
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			retry, err := Send(ctx, cfg, nil)
+			if tt.wantErr {
+				if err == nil || retry {
+					t.Fatalf("Send = (%v, %v), want permanent error", retry, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+
+			var mail string
+			for _, cmd := range m.Commands() {
+				if strings.HasPrefix(cmd, "MAIL FROM:") {
+					mail = cmd
+				}
+			}
+			if got := strings.HasSuffix(mail, " REQUIRETLS"); got != tt.wantParam {
+				t.Errorf("MAIL command %q: REQUIRETLS present = %v, want %v", mail, got, tt.wantParam)
+			}
+
+			raw := <-m.received
+			if got := strings.Contains(strings.ToLower(raw), "\ntls-required: no\n"); got != tt.wantHeader {
+				t.Errorf("TLS-Required header present = %v, want %v", got, tt.wantHeader)
+			}
+		})
+	}
+}
+
+func TestSend_OpportunisticTLS(t *testing.T) {
+	serverTLS, _ := testTLSConfigs(t)
+	yes := true
+
+	tests := []struct {
+		name       string
+		requireTLS *bool
+		wantErr    bool
+	}{
+		// The self-signed certificate is accepted when TLS is opportunistic...
+		{name: "unset"},
+		// ...but not when TLS is required, and retrying would not help.
+		{name: "required", requireTLS: &yes, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &mockSMTP{TLSConfig: serverTLS}
+			m.start(t)
+			cfg := EmailConfig{
+				Smarthost:    m.smarthost(),
+				TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: TLS\n\nsecret"),
+				RequireTLS:   tt.requireTLS,
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			retry, err := Send(ctx, cfg, nil)
+			if tt.wantErr {
+				if err == nil || retry || !strings.Contains(err.Error(), "certificate") {
+					t.Fatalf("Send = (%v, %v), want permanent certificate error", retry, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+			<-m.received
+			if !slices.Contains(m.Commands(), "STARTTLS") {
+				t.Errorf("commands = %q, want STARTTLS", m.Commands())
+			}
+		})
+	}
+}
+
+// writeClientCert writes a self-signed client certificate and its key as
+// PEM files and returns their paths with a pool that trusts the certificate.
+func writeClientCert(t *testing.T) (certFile, keyFile string, pool *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "pigeon client"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseCertificate: %v", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey: %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "client.crt")
+	keyFile = filepath.Join(dir, "client.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	pool = x509.NewCertPool()
+	pool.AddCert(cert)
+	return certFile, keyFile, pool
+}
+
+func TestSend_TLSClientCert(t *testing.T) {
+	serverTLS, clientTLS := testTLSConfigs(t)
+	certFile, keyFile, clientCAs := writeClientCert(t)
+	serverTLS.ClientAuth = tls.RequireAndVerifyClientCert
+	serverTLS.ClientCAs = clientCAs
+
+	tests := []struct {
+		name      string
+		cert, key string
+		wantErr   bool
+		permanent bool
+	}{
+		{name: "client cert", cert: certFile, key: keyFile},
+		{name: "no client cert", wantErr: true},
+		{name: "missing key", cert: certFile, wantErr: true, permanent: true},
+		{name: "unreadable cert", cert: filepath.Join(t.TempDir(), "missing.crt"), key: keyFile, wantErr: true, permanent: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &mockSMTP{TLSConfig: serverTLS}
+			m.start(t)
+
+			cfg := EmailConfig{
+				Smarthost:         m.smarthost(),
+				TemplatePath:      tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: mTLS\n\nhello"),
+				TLSConfig:         clientTLS,
+				TLSClientCertFile: tt.cert,
+				TLSClientKeyFile:  tt.key,
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			retry, err := Send(ctx, cfg, nil)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Send succeeded, want error")
+				}
+				if tt.permanent && retry {
+					t.Errorf("Send = (%v, %v), want permanent error", retry, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+			select {
+			case raw := <-m.received:
+				if !strings.Contains(raw, "hello") {
+					t.Errorf("unexpected message: %q", raw)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("no message received")
+			}
+		})
+	}
+}
+
+// newTestCA returns a server configuration with a certificate for 127.0.0.1
+// issued by a new CA, and the CA certificate written to a PEM file.
+func newTestCA(t *testing.T) (server *tls.Config, caFile string) {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(10),
+		Subject:               pkix.Name{CommonName: "pigeon test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatalf("ParseCertificate: %v", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(11),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+
+	caFile = filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	server = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	return server, caFile
+}
+
+func TestSend_TLSRootCAFile(t *testing.T) {
+	serverTLS, caFile := newTestCA(t)
+	badFile := filepath.Join(t.TempDir(), "bad.pem")
+	if err := os.WriteFile(badFile, []byte("not a certificate\n"), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		caFile  string
+		caOnly  bool
+		wantErr string
+	}{
+		{name: "bundle", caFile: caFile},
+		{name: "bundle only", caFile: caFile, caOnly: true},
+		{name: "no bundle", wantErr: "certificate"},
+		{name: "invalid bundle", caFile: badFile, wantErr: "no PEM certificates found"},
+		{name: "missing bundle", caFile: filepath.Join(t.TempDir(), "missing.pem"), wantErr: "failed to read TLS root CA file"},
+		{name: "only without bundle", caOnly: true, wantErr: "tls_root_ca_only"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &mockSMTP{TLSConfig: serverTLS}
+			m.start(t)
+
+			yes := true
+			cfg := EmailConfig{
+				Smarthost:     m.smarthost(),
+				TemplatePath:  tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: CA\n\nhello"),
+				RequireTLS:    &yes,
+				TLSRootCAFile: tt.caFile,
+				TLSRootCAOnly: tt.caOnly,
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			_, err := Send(ctx, cfg, nil)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Send error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+			<-m.received
+		})
+	}
+}
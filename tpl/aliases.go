@@ -0,0 +1,53 @@
+package tpl
+
+import (
+	"net/textproto"
+	"sync"
+)
+
+// builtinHeaderAliases are the header aliases every template accepts,
+// keyed by canonical field name.
+var builtinHeaderAliases = map[string]string{
+	"Sub": "Subject",
+}
+
+var (
+	headerAliasesMu sync.RWMutex
+	headerAliases   = map[string]string{}
+)
+
+// SetHeaderAliases sets the header aliases of templates parsed afterwards,
+// replacing those set before: a template field named by a key of aliases is
+// read as the field named by its value, e.g. {"Reply": "Reply-To"}, to
+// accept the conventions of other template formats. Names are
+// case-insensitive.
+//
+// "Sub" is always an alias for "Subject" unless aliases maps it elsewhere.
+// SetHeaderAliases(nil) leaves only the built-in aliases. It is safe for
+// concurrent use.
+func SetHeaderAliases(aliases map[string]string) {
+	m := make(map[string]string, len(aliases))
+	for alias, field := range aliases {
+		m[textproto.CanonicalMIMEHeaderKey(alias)] = textproto.CanonicalMIMEHeaderKey(field)
+	}
+
+	headerAliasesMu.Lock()
+	defer headerAliasesMu.Unlock()
+	headerAliases = m
+}
+
+// resolveAlias returns the canonical field name for the template field
+// named k, resolving aliases.
+func resolveAlias(k string) string {
+	k = textproto.CanonicalMIMEHeaderKey(k)
+	headerAliasesMu.RLock()
+	field, ok := headerAliases[k]
+	headerAliasesMu.RUnlock()
+	if ok {
+		return field
+	}
+	if field, ok := builtinHeaderAliases[k]; ok {
+		return field
+	}
+	return k
+}
@@ -0,0 +1,40 @@
+package tpl
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSetHeaderAliases(t *testing.T) {
+	t.Cleanup(func() { SetHeaderAliases(nil) })
+	SetHeaderAliases(map[string]string{"reply": "reply-to"})
+
+	tpl, err := ParseReader("test", strings.NewReader("Reply: help@example.com\nSub: Hello\nTo: a@example.com\n\nbody"))
+	if err != nil {
+		t.Fatalf("ParseReader error: %v", err)
+	}
+	if got := tpl.Header().Get("Reply-To"); got != "help@example.com" {
+		t.Errorf("Reply-To = %q, want help@example.com", got)
+	}
+	if _, ok := tpl.Header()["Reply"]; ok {
+		t.Error("alias Reply kept as a header")
+	}
+	want := []Field{{"Reply-To", "help@example.com"}, {"Subject", "Hello"}, {"To", "a@example.com"}}
+	if !reflect.DeepEqual(tpl.Fields(), want) {
+		t.Errorf("Fields = %v, want %v", tpl.Fields(), want)
+	}
+
+	// The aliases replace those set before, and "Sub" stays built in.
+	SetHeaderAliases(nil)
+	tpl, err = ParseReader("test", strings.NewReader("Reply: help@example.com\nSub: Hello\n\nbody"))
+	if err != nil {
+		t.Fatalf("ParseReader error: %v", err)
+	}
+	if got := tpl.Header().Get("Reply"); got != "help@example.com" {
+		t.Errorf("Reply = %q after reset, want it unmapped", got)
+	}
+	if got := tpl.Header().Get("Subject"); got != "Hello" {
+		t.Errorf("Subject = %q, want Hello", got)
+	}
+}
@@ -0,0 +1,62 @@
+package tpl
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// ParseError is returned by ParseFile and ParseReader when a header or body
+// template fails to parse.
+type ParseError struct {
+	// Name identifies the template source, usually its path.
+	Name string
+	// Field is the header field whose template failed to parse, or "" for
+	// the body.
+	Field string
+	// Func is the undefined function the template calls, or "" if the
+	// error has another cause.
+	Func string
+	// Err is the error returned by text/template.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *ParseError) Error() string {
+	where := "body"
+	if e.Field != "" {
+		where = e.Field + " header"
+	}
+	if e.Func != "" {
+		return fmt.Sprintf("template %s: %s calls undefined function %q: %v", e.Name, where, e.Func, e.Err)
+	}
+	return fmt.Sprintf("template %s: failed to parse %s: %v", e.Name, where, e.Err)
+}
+
+// Unwrap returns the underlying text/template error.
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// undefinedFuncRE matches the parse error text/template and html/template
+// report for a call to a function missing from the FuncMap.
+var undefinedFuncRE = regexp.MustCompile(`function "([^"]+)" not defined`)
+
+// UndefinedFunc returns the name of the undefined function a template parse
+// error err complains about, and whether it is such an error.
+func UndefinedFunc(err error) (string, bool) {
+	if err == nil {
+		return "", false
+	}
+	m := undefinedFuncRE.FindStringSubmatch(err.Error())
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// newParseError wraps the error from parsing the template of field (""
+// for the body) in the source name.
+func newParseError(name, field string, err error) *ParseError {
+	fn, _ := UndefinedFunc(err)
+	return &ParseError{Name: name, Field: field, Func: fn, Err: err}
+}
@@ -0,0 +1,43 @@
+package tpl
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseFile_UndefinedFunc(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		field   string
+	}{
+		{name: "header", content: "From: a@example.com\nSubject: {{ shout .Name }}\n\nbody", field: "Subject"},
+		{name: "body", content: "From: a@example.com\n\nHello {{ shout .Name }}", field: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTempFile(t, tt.content)
+			_, err := ParseFile(path)
+			var perr *ParseError
+			if !errors.As(err, &perr) {
+				t.Fatalf("ParseFile error = %v, want *ParseError", err)
+			}
+			if perr.Name != path || perr.Field != tt.field || perr.Func != "shout" {
+				t.Errorf("ParseError = %+v", perr)
+			}
+			msg := err.Error()
+			if !strings.Contains(msg, `undefined function "shout"`) || !strings.Contains(msg, path) ||
+				(tt.field != "" && !strings.Contains(msg, tt.field+" header")) {
+				t.Errorf("error %q does not name the field and function", msg)
+			}
+		})
+	}
+
+	// Other parse errors are still ParseErrors, without Func.
+	_, err := ParseFile(writeTempFile(t, "Subject: {{ .Name\n\nbody"))
+	var perr *ParseError
+	if !errors.As(err, &perr) || perr.Field != "Subject" || perr.Func != "" {
+		t.Errorf("ParseFile error = %#v", err)
+	}
+}
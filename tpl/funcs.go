@@ -0,0 +1,193 @@
+package tpl
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// builtinFuncs returns the functions available to every template:
+//
+//	include "file"          contents of a file in the include directory
+//	now                     the current time
+//	formatTime layout t     t formatted with layout (a Go layout or a name
+//	                        such as "RFC3339" or "DateTime")
+//	humanizeBytes n         n bytes in binary units, e.g. "1.5 MiB"
+//	join sep list           the elements of list joined by sep
+func builtinFuncs(includeDir string) template.FuncMap {
+	return template.FuncMap{
+		"include":       includeFunc(includeDir),
+		"now":           time.Now,
+		"formatTime":    formatTime,
+		"humanizeBytes": humanizeBytes,
+		"join":          join,
+	}
+}
+
+// predefinedFuncs are the functions text/template itself provides.
+var predefinedFuncs = []string{
+	"and", "call", "eq", "ge", "gt", "html", "index", "js", "le", "len", "lt",
+	"ne", "not", "or", "print", "printf", "println", "slice", "urlquery",
+}
+
+var (
+	defaultFuncsMu sync.RWMutex
+	defaultFuncs   = template.FuncMap{}
+)
+
+// RegisterDefaultFunc makes fn available as name in the header and body of
+// every template parsed afterwards, without passing it to WithFuncs.
+// Functions given with WithFuncs take precedence over default functions.
+// Registering a name again replaces the earlier function.
+//
+// Like template.Funcs, it panics if fn is not a valid template function, and
+// it also panics if name is a built-in function. It is safe for concurrent
+// use.
+func RegisterDefaultFunc(name string, fn any) {
+	if _, ok := builtinFuncs("")[name]; ok || slices.Contains(predefinedFuncs, name) {
+		panic(fmt.Sprintf("tpl: cannot register %q: it is a built-in function", name))
+	}
+	// Funcs panics if fn is not a function with a valid signature.
+	template.New("").Funcs(template.FuncMap{name: fn})
+
+	defaultFuncsMu.Lock()
+	defer defaultFuncsMu.Unlock()
+	defaultFuncs[name] = fn
+}
+
+// DefaultFuncs returns a copy of the functions registered with
+// RegisterDefaultFunc.
+func DefaultFuncs() template.FuncMap {
+	defaultFuncsMu.RLock()
+	defer defaultFuncsMu.RUnlock()
+	funcs := make(template.FuncMap, len(defaultFuncs))
+	for name, fn := range defaultFuncs {
+		funcs[name] = fn
+	}
+	return funcs
+}
+
+// Funcs returns the functions a template parsed with opts can call: the
+// built-in functions, the default functions and those given with WithFuncs,
+// each overriding the ones before. It lets text that is not part of a
+// Template, such as an html/template body, offer the same functions.
+func Funcs(opts ...Option) template.FuncMap {
+	o := options{includeDir: "."}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	funcs := builtinFuncs(o.includeDir)
+	for name, fn := range DefaultFuncs() {
+		funcs[name] = fn
+	}
+	for name, fn := range o.funcs {
+		funcs[name] = fn
+	}
+	return funcs
+}
+
+// timeLayouts maps layout names accepted by formatTime to Go layouts.
+var timeLayouts = map[string]string{
+	"ANSIC":       time.ANSIC,
+	"RFC822":      time.RFC822,
+	"RFC822Z":     time.RFC822Z,
+	"RFC1123":     time.RFC1123,
+	"RFC1123Z":    time.RFC1123Z,
+	"RFC3339":     time.RFC3339,
+	"RFC3339Nano": time.RFC3339Nano,
+	"Kitchen":     time.Kitchen,
+	"DateTime":    time.DateTime,
+	"DateOnly":    time.DateOnly,
+	"TimeOnly":    time.TimeOnly,
+}
+
+// formatTime formats t with layout, which is either a Go time layout or one
+// of the names in timeLayouts.
+func formatTime(layout string, t time.Time) string {
+	if l, ok := timeLayouts[layout]; ok {
+		layout = l
+	}
+	return t.Format(layout)
+}
+
+// humanizeBytes formats a byte count with binary (IEC) units.
+func humanizeBytes(n any) (string, error) {
+	var f float64
+	switch v := reflect.ValueOf(n); v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		f = float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		f = float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		f = v.Float()
+	default:
+		return "", fmt.Errorf("humanizeBytes: unsupported type %T", n)
+	}
+
+	if math.Abs(f) < 1024 {
+		return fmt.Sprintf("%d B", int64(f)), nil
+	}
+	units := []string{"KiB", "MiB", "GiB", "TiB", "PiB", "EiB"}
+	i := -1
+	for math.Abs(f) >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", f, units[i]), nil
+}
+
+// join concatenates the elements of list, which may be a slice or array of
+// any type, separated by sep.
+func join(sep string, list any) (string, error) {
+	if ss, ok := list.([]string); ok {
+		return strings.Join(ss, sep), nil
+	}
+	v := reflect.ValueOf(list)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+	default:
+		return "", fmt.Errorf("join: unsupported type %T", list)
+	}
+	parts := make([]string, v.Len())
+	for i := range parts {
+		parts[i] = fmt.Sprint(v.Index(i).Interface())
+	}
+	return strings.Join(parts, sep), nil
+}
+
+// includeFunc returns the include template function, which returns the
+// contents of a file relative to dir, e.g. {{ include "disclaimer.txt" }}.
+// Paths that are absolute, contain "..", or resolve through a symlink to a
+// location outside dir are rejected.
+func includeFunc(dir string) func(name string) (string, error) {
+	return func(name string) (string, error) {
+		if !filepath.IsLocal(name) {
+			return "", fmt.Errorf("include %q: path is outside the include directory", name)
+		}
+
+		base, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return "", fmt.Errorf("include %q: %w", name, err)
+		}
+		path, err := filepath.EvalSymlinks(filepath.Join(base, name))
+		if err != nil {
+			return "", fmt.Errorf("include %q: %w", name, err)
+		}
+		if rel, err := filepath.Rel(base, path); err != nil || !filepath.IsLocal(rel) {
+			return "", fmt.Errorf("include %q: path is outside the include directory", name)
+		}
+
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("include %q: %w", name, err)
+		}
+		return string(b), nil
+	}
+}
@@ -0,0 +1,261 @@
+package tpl
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestInclude(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "partials"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "partials", "disclaimer.txt"), []byte("This message is confidential."), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "mail.tmpl")
+	content := "From: a@example.com\nSubject: {{ include \"partials/disclaimer.txt\" }}\n\nHello {{ .Name }}\n--\n{{ include \"partials/disclaimer.txt\" }}"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tpl, err := ParseFile(path)
+	if err != nil {
+		t.Fatalf("ParseFile error: %v", err)
+	}
+	var body bytes.Buffer
+	if err := tpl.Execute(&body, map[string]string{"Name": "Bob"}); err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+	if want := "Hello Bob\n--\nThis message is confidential."; body.String() != want {
+		t.Errorf("body = %q, want %q", body.String(), want)
+	}
+	var subj bytes.Buffer
+	if err := tpl.ExecuteHeader(&subj, "Subject", nil); err != nil {
+		t.Fatalf("ExecuteHeader error: %v", err)
+	}
+	if subj.String() != "This message is confidential." {
+		t.Errorf("Subject = %q", subj.String())
+	}
+
+	// WithIncludeDir overrides the template's directory.
+	other := writeTempFile(t, "From: a@example.com\n\n{{ include \"disclaimer.txt\" }}")
+	tpl, err = ParseFile(other, WithIncludeDir(filepath.Join(dir, "partials")))
+	if err != nil {
+		t.Fatalf("ParseFile error: %v", err)
+	}
+	body.Reset()
+	if err := tpl.Execute(&body, nil); err != nil || body.String() != "This message is confidential." {
+		t.Errorf("Execute with WithIncludeDir = (%q, %v)", body.String(), err)
+	}
+}
+
+func TestInclude_MissingFile(t *testing.T) {
+	// A missing file is only an error when the template is executed.
+	tpl, err := ParseFile(writeTempFile(t, "From: a@example.com\n\n{{ include \"missing.txt\" }}"))
+	if err != nil {
+		t.Fatalf("ParseFile error: %v", err)
+	}
+	err = tpl.Execute(&bytes.Buffer{}, nil)
+	if err == nil || !strings.Contains(err.Error(), `include "missing.txt"`) {
+		t.Fatalf("Execute error = %v, want missing include error", err)
+	}
+}
+
+func TestInclude_Traversal(t *testing.T) {
+	root := t.TempDir()
+	secret := filepath.Join(root, "secret.txt")
+	if err := os.WriteFile(secret, []byte("top secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(root, "templates")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(secret, filepath.Join(dir, "link.txt")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	for _, name := range []string{"../secret.txt", "sub/../../secret.txt", secret, "link.txt"} {
+		tpl, err := ParseReader("test", strings.NewReader("From: a@example.com\n\n{{ include .Path }}"), WithIncludeDir(dir))
+		if err != nil {
+			t.Fatalf("ParseReader error: %v", err)
+		}
+		var body bytes.Buffer
+		err = tpl.Execute(&body, map[string]string{"Path": name})
+		if err == nil || !strings.Contains(err.Error(), "outside the include directory") {
+			t.Errorf("include %q: error = %v, body = %q; want traversal blocked", name, err, body.String())
+		}
+	}
+}
+
+func TestBuiltinFuncs(t *testing.T) {
+	content := `From: a@example.com
+Subject: [{{ formatTime "DateOnly" .At }}] {{ humanizeBytes .Free }} free
+
+At: {{ formatTime "2006-01-02 15:04 MST" .At }}
+Free: {{ humanizeBytes .Free }} of {{ humanizeBytes .Total }} ({{ humanizeBytes 512 }} reserved)
+Hosts: {{ join ", " .Hosts }}
+Ports: {{ .Ports | join "/" }}
+Year: {{ (now).Year }}`
+
+	tpl, err := ParseFile(writeTempFile(t, content))
+	if err != nil {
+		t.Fatalf("ParseFile error: %v", err)
+	}
+	data := map[string]any{
+		"At":    time.Date(2024, 3, 9, 14, 5, 0, 0, time.UTC),
+		"Free":  int64(1536),
+		"Total": uint64(5 << 30),
+		"Hosts": []string{"db1", "db2"},
+		"Ports": []int{25, 587},
+	}
+
+	var body bytes.Buffer
+	if err := tpl.Execute(&body, data); err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+	want := fmt.Sprintf(`At: 2024-03-09 14:05 UTC
+Free: 1.5 KiB of 5.0 GiB (512 B reserved)
+Hosts: db1, db2
+Ports: 25/587
+Year: %d`, time.Now().Year())
+	if body.String() != want {
+		t.Errorf("body =\n%s\nwant\n%s", body.String(), want)
+	}
+
+	var subj bytes.Buffer
+	if err := tpl.ExecuteHeader(&subj, "Subject", data); err != nil {
+		t.Fatalf("ExecuteHeader error: %v", err)
+	}
+	if want := "[2024-03-09] 1.5 KiB free"; subj.String() != want {
+		t.Errorf("Subject = %q, want %q", subj.String(), want)
+	}
+}
+
+func TestWithFuncs_Override(t *testing.T) {
+	funcs := template.FuncMap{
+		"humanizeBytes": func(n int) string { return fmt.Sprintf("%d bytes", n) },
+		"shout":         strings.ToUpper,
+	}
+	tpl, err := ParseReader("test", strings.NewReader("Subject: {{ shout \"hi\" }}\n\n{{ humanizeBytes 2048 }}"), WithFuncs(funcs))
+	if err != nil {
+		t.Fatalf("ParseReader error: %v", err)
+	}
+
+	var body, subj bytes.Buffer
+	if err := tpl.Execute(&body, nil); err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+	if body.String() != "2048 bytes" {
+		t.Errorf("body = %q, want user-supplied humanizeBytes", body.String())
+	}
+	if err := tpl.ExecuteHeader(&subj, "Subject", nil); err != nil || subj.String() != "HI" {
+		t.Errorf("Subject = (%q, %v), want HI", subj.String(), err)
+	}
+}
+
+func TestFuncs(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "sig.txt"), []byte("-- Bob"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	funcs := Funcs(WithIncludeDir(dir), WithFuncs(template.FuncMap{"join": fmt.Sprint}))
+	for _, name := range []string{"now", "formatTime", "humanizeBytes"} {
+		if funcs[name] == nil {
+			t.Errorf("Funcs has no %s", name)
+		}
+	}
+	if got, err := funcs["include"].(func(string) (string, error))("sig.txt"); err != nil || got != "-- Bob" {
+		t.Errorf("include = (%q, %v), want file from the include directory", got, err)
+	}
+	if _, ok := funcs["join"].(func(...any) string); !ok {
+		t.Errorf("join = %T, want the WithFuncs override", funcs["join"])
+	}
+}
+
+func TestRegisterDefaultFunc(t *testing.T) {
+	t.Cleanup(func() {
+		defaultFuncsMu.Lock()
+		delete(defaultFuncs, "greet")
+		defaultFuncsMu.Unlock()
+	})
+	RegisterDefaultFunc("greet", func(name string) string { return "Hello, " + name })
+
+	tpl, err := ParseReader("test", strings.NewReader("Subject: {{ greet .Name }}\n\n{{ greet .Name }}!"))
+	if err != nil {
+		t.Fatalf("ParseReader error: %v", err)
+	}
+	var body, subj bytes.Buffer
+	data := map[string]string{"Name": "Bob"}
+	if err := tpl.Execute(&body, data); err != nil || body.String() != "Hello, Bob!" {
+		t.Errorf("body = (%q, %v), want Hello, Bob!", body.String(), err)
+	}
+	if err := tpl.ExecuteHeader(&subj, "Subject", data); err != nil || subj.String() != "Hello, Bob" {
+		t.Errorf("Subject = (%q, %v), want Hello, Bob", subj.String(), err)
+	}
+
+	// WithFuncs takes precedence over a default function.
+	tpl, err = ParseReader("test", strings.NewReader("\n{{ greet .Name }}"),
+		WithFuncs(template.FuncMap{"greet": func(name string) string { return "Hi " + name }}))
+	if err != nil {
+		t.Fatalf("ParseReader error: %v", err)
+	}
+	body.Reset()
+	if err := tpl.Execute(&body, data); err != nil || body.String() != "Hi Bob" {
+		t.Errorf("body = (%q, %v), want Hi Bob", body.String(), err)
+	}
+}
+
+func TestRegisterDefaultFunc_Invalid(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		fn   any
+	}{
+		{"join", strings.Join},
+		{"printf", fmt.Sprintf},
+		{"notAFunc", 42},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("RegisterDefaultFunc(%q): expected panic", tt.name)
+				}
+			}()
+			RegisterDefaultFunc(tt.name, tt.fn)
+		}()
+	}
+	if _, ok := DefaultFuncs()["notAFunc"]; ok {
+		t.Error("invalid function was registered")
+	}
+}
+
+func TestRegisterDefaultFunc_Concurrent(t *testing.T) {
+	t.Cleanup(func() {
+		defaultFuncsMu.Lock()
+		delete(defaultFuncs, "concurrent")
+		defaultFuncsMu.Unlock()
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			RegisterDefaultFunc("concurrent", strings.ToUpper)
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := ParseReader("test", strings.NewReader("\nbody")); err != nil {
+				t.Errorf("ParseReader error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+}
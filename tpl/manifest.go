@@ -0,0 +1,46 @@
+package tpl
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LoadManifest parses the templates listed in a YAML manifest that maps
+// logical names to template files, e.g.
+//
+//	welcome: welcome.tmpl
+//	password-reset: account/reset.tmpl
+//
+// Relative paths are resolved against the manifest's directory. opts apply
+// to every template, so that they share functions and, with WithIncludeDir,
+// a directory of partials. The returned map is keyed by name.
+func LoadManifest(path string, opts ...Option) (map[string]*Template, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var manifest map[string]string
+	if err := yaml.Unmarshal(b, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %w", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	set := make(map[string]*Template, len(manifest))
+	for name, file := range manifest {
+		if file == "" {
+			return nil, fmt.Errorf("manifest %s: template %q has no path", path, name)
+		}
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		t, err := ParseFile(file, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("manifest %s: template %q: %w", path, name, err)
+		}
+		set[name] = t
+	}
+	return set, nil
+}
@@ -0,0 +1,67 @@
+package tpl
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestLoadManifest(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"welcome.tmpl":          "Subject: Welcome, {{ .Name }}\n\nHello {{ shout .Name }}!\n{{ include \"footer.txt\" }}",
+		"account/reset.tmpl":    "Subject: Reset your password\n\nUse code {{ .Code }}.\n{{ include \"footer.txt\" }}",
+		"partials/footer.txt":   "-- The Team",
+		"manifest.yaml":         "welcome: welcome.tmpl\npassword-reset: account/reset.tmpl\n",
+		"bad/manifest.yaml":     "broken: missing.tmpl\n",
+		"invalid/manifest.yaml": "- not a map\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	set, err := LoadManifest(filepath.Join(dir, "manifest.yaml"),
+		WithIncludeDir(filepath.Join(dir, "partials")),
+		WithFuncs(template.FuncMap{"shout": strings.ToUpper}))
+	if err != nil {
+		t.Fatalf("LoadManifest: %v", err)
+	}
+	if len(set) != 2 {
+		t.Fatalf("loaded %d templates, want 2", len(set))
+	}
+
+	tests := []struct {
+		name, subject, body string
+		data                map[string]string
+	}{
+		{"welcome", "Welcome, Bob", "Hello BOB!\n-- The Team", map[string]string{"Name": "Bob"}},
+		{"password-reset", "Reset your password", "Use code 1234.\n-- The Team", map[string]string{"Code": "1234"}},
+	}
+	for _, tt := range tests {
+		tpl := set[tt.name]
+		if tpl == nil {
+			t.Fatalf("template %q not loaded", tt.name)
+		}
+		var subj, body bytes.Buffer
+		if err := tpl.ExecuteHeader(&subj, "Subject", tt.data); err != nil || subj.String() != tt.subject {
+			t.Errorf("%s: Subject = (%q, %v), want %q", tt.name, subj.String(), err, tt.subject)
+		}
+		if err := tpl.Execute(&body, tt.data); err != nil || body.String() != tt.body {
+			t.Errorf("%s: body = (%q, %v), want %q", tt.name, body.String(), err, tt.body)
+		}
+	}
+
+	for _, name := range []string{"bad/manifest.yaml", "invalid/manifest.yaml", "missing.yaml"} {
+		if _, err := LoadManifest(filepath.Join(dir, name)); err == nil {
+			t.Errorf("LoadManifest(%s): expected error", name)
+		}
+	}
+}
@@ -5,9 +5,12 @@ package tpl
 
 import (
 	"bufio"
+	"fmt"
 	"io"
+	"net/mail"
 	"net/textproto"
 	"os"
+	"path/filepath"
 	"strings"
 	"text/template"
 )
@@ -18,28 +21,83 @@ import (
 // both supporting Go template variables.
 type Template struct {
 	hdr      textproto.MIMEHeader
+	fields   []Field
+	hdrTmpls map[string]*template.Template
 	bodyTmpl *template.Template
 	srcPath  string
 }
 
+// Field is a single header field as it appears in a template. Key is in
+// canonical MIME header form, with aliases such as "Sub" already resolved.
+type Field struct {
+	Key   string
+	Value string
+}
+
+// Option configures how a template is parsed.
+type Option func(*options)
+
+type options struct {
+	includeDir string
+	funcs      template.FuncMap
+}
+
+// WithIncludeDir sets the directory the include function reads files from.
+// It defaults to the directory of the template file for ParseFile and to
+// the current directory for ParseReader.
+func WithIncludeDir(dir string) Option {
+	return func(o *options) { o.includeDir = dir }
+}
+
+// WithFuncs adds funcs to the functions available in header and body
+// templates. They override built-in and default functions of the same name.
+func WithFuncs(funcs template.FuncMap) Option {
+	return func(o *options) {
+		if o.funcs == nil {
+			o.funcs = make(template.FuncMap, len(funcs))
+		}
+		for name, fn := range funcs {
+			o.funcs[name] = fn
+		}
+	}
+}
+
 // ParseFile parses an email template file in RFC2822-style format.
 // The file must contain headers (key: value), a blank line, and then
 // a body. Both headers and body may use Go template expressions.
 // Returns a Template that can be executed with data to produce a
 // complete message.
-func ParseFile(path string) (*Template, error) {
+func ParseFile(path string, opts ...Option) (*Template, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
 
-	tp := textproto.NewReader(bufio.NewReader(f))
+	opts = append([]Option{WithIncludeDir(filepath.Dir(path))}, opts...)
+	return ParseReader(path, f, opts...)
+}
+
+// ParseString parses the email template s, in the same format as ParseFile.
+// name identifies the template in error messages.
+func ParseString(name, s string, opts ...Option) (*Template, error) {
+	return ParseReader(name, strings.NewReader(s), opts...)
+}
+
+// ParseReader parses an email template from r, in the same format as
+// ParseFile. It allows templates to come from stdin, a pipe or memory.
+// name identifies the template in error messages. A template that fails to
+// parse is reported as a *ParseError.
+func ParseReader(name string, r io.Reader, opts ...Option) (*Template, error) {
+	funcs := Funcs(opts...)
+
+	tp := textproto.NewReader(bufio.NewReader(r))
 	hdr := make(textproto.MIMEHeader)
+	var fields []Field
 
-	// 1) Read headers (until a blank line)
+	// 1) Read headers (until a blank line), unfolding continuation lines
 	for {
-		line, err := tp.ReadLine()
+		line, err := tp.ReadContinuedLine()
 		if err != nil {
 			if err == io.EOF {
 				break
@@ -58,26 +116,45 @@ func ParseFile(path string) (*Template, error) {
 		k = strings.TrimSpace(k)
 		v = strings.TrimSpace(v)
 
-		// Allow "Sub:" as an alias for "Subject:"
-		if strings.EqualFold(k, "Sub") {
-			k = "Subject"
+		// Resolve aliases such as "Sub:" for "Subject:".
+		k = resolveAlias(k)
+		if _, dup := hdr[k]; dup {
+			// A repeated field replaces the earlier value in place.
+			for i := range fields {
+				if fields[i].Key == k {
+					fields[i].Value = v
+				}
+			}
+		} else {
+			fields = append(fields, Field{Key: k, Value: v})
 		}
 		hdr.Set(k, v)
 	}
 
-	// 2) Read the remainder as the body template
+	// 2) Parse each header value as a template so it can be executed
+	// repeatedly without re-parsing
+	hdrTmpls := make(map[string]*template.Template, len(fields))
+	for _, f := range fields {
+		ht, err := template.New(f.Key).Funcs(funcs).Parse(f.Value)
+		if err != nil {
+			return nil, newParseError(name, f.Key, err)
+		}
+		hdrTmpls[f.Key] = ht
+	}
+
+	// 3) Read the remainder as the body template
 	bodyBytes, err := io.ReadAll(tp.R)
 	if err != nil {
 		return nil, err
 	}
 
 	// Parse the body as a Go text/template
-	bodyTmpl, err := template.New(path).Parse(string(bodyBytes))
+	bodyTmpl, err := template.New(name).Funcs(funcs).Parse(string(bodyBytes))
 	if err != nil {
-		return nil, err
+		return nil, newParseError(name, "", err)
 	}
 
-	return &Template{hdr: hdr, bodyTmpl: bodyTmpl, srcPath: path}, nil
+	return &Template{hdr: hdr, fields: fields, hdrTmpls: hdrTmpls, bodyTmpl: bodyTmpl, srcPath: name}, nil
 }
 
 // Header returns the template's parsed MIME headers.
@@ -85,12 +162,28 @@ func (t *Template) Header() textproto.MIMEHeader {
 	return t.hdr
 }
 
+// Fields returns the template's header fields in the order they appear in
+// the source. The returned slice must not be modified.
+func (t *Template) Fields() []Field {
+	return t.fields
+}
+
 // Execute renders the message body using the provided data,
 // using Go text/template syntax.
 func (t *Template) Execute(w io.Writer, data any) error {
 	return t.bodyTmpl.Execute(w, data)
 }
 
+// ExecuteHeader renders the header field key using data. Header values are
+// parsed as templates once, when the template file is parsed.
+func (t *Template) ExecuteHeader(w io.Writer, key string, data any) error {
+	ht, ok := t.hdrTmpls[textproto.CanonicalMIMEHeaderKey(key)]
+	if !ok {
+		return fmt.Errorf("template has no %s header", key)
+	}
+	return ht.Execute(w, data)
+}
+
 // Subject returns the "Subject" field from the template headers.
 func (t *Template) Subject() string {
 	return t.hdr.Get("Subject")
@@ -107,3 +200,23 @@ func (t *Template) Cc() string { return t.hdr.Get("Cc") }
 
 // Bcc returns the "Bcc" field from the template headers.
 func (t *Template) Bcc() string { return t.hdr.Get("Bcc") }
+
+// Addresses parses the header field into addresses, without executing the
+// template, e.g. so that tooling can check the recipients of a template. It
+// only works for static fields: a field containing template actions is an
+// error, as is a missing field (mail.ErrHeaderNotPresent).
+func (t *Template) Addresses(field string) ([]*mail.Address, error) {
+	key := textproto.CanonicalMIMEHeaderKey(field)
+	v, ok := t.hdr[key]
+	if !ok || len(v) == 0 {
+		return nil, mail.ErrHeaderNotPresent
+	}
+	if strings.Contains(v[0], "{{") {
+		return nil, fmt.Errorf("%s header contains template expression %q; execute the template to get its addresses", key, v[0])
+	}
+	list, err := mail.ParseAddressList(v[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s header: %w", key, err)
+	}
+	return list, nil
+}
@@ -2,12 +2,16 @@ package tpl
 
 import (
 	"bytes"
+	"errors"
+	"io"
+	"net/mail"
 	"os"
 	"strings"
 	"testing"
+	"text/template"
 )
 
-func writeTempFile(t *testing.T, content string) string {
+func writeTempFile(t testing.TB, content string) string {
 	t.Helper()
 	f, err := os.CreateTemp(t.TempDir(), "mailtmpl-*.tmpl")
 	if err != nil {
@@ -219,3 +223,201 @@ This is a test message.`
 	// Header parts are not processed by tpl.Execute and need to be retrieved individually
 	// This is handled separately in the email.go Send function
 }
+
+func TestParseFile_FieldOrder(t *testing.T) {
+	tmpl := "Sub: ordered\nX-Second: 2\nto: bob@example.com\nFrom: alice@example.com\nX-Folded: first\n  second\n\nbody"
+
+	path := writeTempFile(t, tmpl)
+	tpl, err := ParseFile(path)
+	if err != nil {
+		t.Fatalf("ParseFile error: %v", err)
+	}
+
+	want := []Field{
+		{Key: "Subject", Value: "ordered"},
+		{Key: "X-Second", Value: "2"},
+		{Key: "To", Value: "bob@example.com"},
+		{Key: "From", Value: "alice@example.com"},
+		{Key: "X-Folded", Value: "first second"},
+	}
+	got := tpl.Fields()
+	if len(got) != len(want) {
+		t.Fatalf("Fields() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Fields()[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+	if tpl.Header().Get("X-Second") != "2" {
+		t.Errorf("map accessor missing X-Second")
+	}
+}
+
+func TestExecuteHeader_MatchesTextTemplate(t *testing.T) {
+	tmpl := `From: {{ .From }}
+To: {{ range $i, $a := .To }}{{ if $i }}, {{ end }}{{ $a }}{{ end }}
+Sub: Report for {{ .Name | printf "%q" }}
+
+body`
+
+	path := writeTempFile(t, tmpl)
+	tpl, err := ParseFile(path)
+	if err != nil {
+		t.Fatalf("ParseFile error: %v", err)
+	}
+
+	data := map[string]any{
+		"From": "sender@example.com",
+		"To":   []string{"a@example.com", "b@example.com"},
+		"Name": "Alice",
+	}
+	for _, key := range []string{"From", "To", "Subject"} {
+		var got, want bytes.Buffer
+		if err := tpl.ExecuteHeader(&got, key, data); err != nil {
+			t.Fatalf("ExecuteHeader(%s) error: %v", key, err)
+		}
+		ref := template.Must(template.New(key).Parse(tpl.Header().Get(key)))
+		if err := ref.Execute(&want, data); err != nil {
+			t.Fatalf("reference Execute(%s) error: %v", key, err)
+		}
+		if got.String() != want.String() {
+			t.Errorf("ExecuteHeader(%s) = %q, want %q", key, got.String(), want.String())
+		}
+	}
+
+	if err := tpl.ExecuteHeader(&bytes.Buffer{}, "Cc", data); err == nil {
+		t.Errorf("expected error for missing header")
+	}
+}
+
+func TestParseFile_InvalidHeaderTemplate(t *testing.T) {
+	path := writeTempFile(t, "From: {{ .From\nTo: bob@example.com\n\nbody")
+	if _, err := ParseFile(path); err == nil || !strings.Contains(err.Error(), "From") {
+		t.Errorf("expected From header parse error, got %v", err)
+	}
+}
+
+func TestTemplate_Addresses(t *testing.T) {
+	path := writeTempFile(t, "From: Alice <alice@example.com>\nTo: bob@example.com, \"Carol C.\" <carol@example.com>\nCc: {{ .Manager }}\nBcc: not an address\n\nbody")
+	tpl, err := ParseFile(path)
+	if err != nil {
+		t.Fatalf("ParseFile error: %v", err)
+	}
+
+	to, err := tpl.Addresses("to")
+	if err != nil {
+		t.Fatalf("Addresses(To): %v", err)
+	}
+	if len(to) != 2 || to[0].Address != "bob@example.com" || to[1].Name != "Carol C." || to[1].Address != "carol@example.com" {
+		t.Errorf("Addresses(To) = %v", to)
+	}
+
+	if _, err := tpl.Addresses("Cc"); err == nil || !strings.Contains(err.Error(), "contains template expression") {
+		t.Errorf("Addresses(Cc) error = %v, want template expression error", err)
+	}
+	if _, err := tpl.Addresses("Bcc"); err == nil {
+		t.Error("Addresses(Bcc) should fail for an invalid address")
+	}
+	if _, err := tpl.Addresses("Reply-To"); !errors.Is(err, mail.ErrHeaderNotPresent) {
+		t.Errorf("Addresses(Reply-To) error = %v, want mail.ErrHeaderNotPresent", err)
+	}
+}
+
+func BenchmarkExecuteHeader(b *testing.B) {
+	const value = `{{ .Name }} <{{ .Addr }}>`
+	data := map[string]string{"Name": "Alice", "Addr": "alice@example.com"}
+
+	b.Run("precompiled", func(b *testing.B) {
+		path := writeTempFile(b, "From: "+value+"\n\nbody")
+		tpl, err := ParseFile(path)
+		if err != nil {
+			b.Fatalf("ParseFile error: %v", err)
+		}
+		var buf bytes.Buffer
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buf.Reset()
+			if err := tpl.ExecuteHeader(&buf, "From", data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("parse-per-send", func(b *testing.B) {
+		var buf bytes.Buffer
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buf.Reset()
+			ht, err := template.New("from").Parse(value)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if err := ht.Execute(&buf, data); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestParseString(t *testing.T) {
+	tpl, err := ParseString("inline", "Sub: Hi {{.Name}}\n\nHello, {{.Name}}!")
+	if err != nil {
+		t.Fatalf("ParseString error: %v", err)
+	}
+	var body bytes.Buffer
+	if err := tpl.Execute(&body, map[string]string{"Name": "Bob"}); err != nil || body.String() != "Hello, Bob!" {
+		t.Errorf("body = (%q, %v), want Hello, Bob!", body.String(), err)
+	}
+	if got := tpl.Header().Get("Subject"); got != "Hi {{.Name}}" {
+		t.Errorf("Subject = %q", got)
+	}
+
+	_, err = ParseString("inline", "Subject: {{ .Name \n\nbody")
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.Name != "inline" {
+		t.Errorf("ParseString error = %v, want *ParseError for inline", err)
+	}
+}
+
+func TestParseReader_Pipe(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe: %v", err)
+	}
+	defer r.Close()
+
+	go func() {
+		defer w.Close()
+		io.WriteString(w, "From: alice@example.com\nTo: bob@example.com\nSubject: Hi {{.Name}}\n\nHello, {{.Name}}!\n")
+	}()
+
+	tpl, err := ParseReader("stdin", r)
+	if err != nil {
+		t.Fatalf("ParseReader error: %v", err)
+	}
+
+	if got := tpl.From(); got != "alice@example.com" {
+		t.Errorf("From = %q, want %q", got, "alice@example.com")
+	}
+	if got := tpl.To(); got != "bob@example.com" {
+		t.Errorf("To = %q, want %q", got, "bob@example.com")
+	}
+
+	data := map[string]string{"Name": "Bob"}
+	var subj bytes.Buffer
+	if err := tpl.ExecuteHeader(&subj, "Subject", data); err != nil {
+		t.Fatalf("ExecuteHeader error: %v", err)
+	}
+	if got := subj.String(); got != "Hi Bob" {
+		t.Errorf("Subject = %q, want %q", got, "Hi Bob")
+	}
+
+	var body bytes.Buffer
+	if err := tpl.Execute(&body, data); err != nil {
+		t.Fatalf("Execute error: %v", err)
+	}
+	if got := body.String(); got != "Hello, Bob!\n" {
+		t.Errorf("body = %q, want %q", got, "Hello, Bob!\n")
+	}
+}
@@ -0,0 +1,59 @@
+package pigeon
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrAllInvalid is returned when EmailConfig.RecipientValidator rejects
+// every recipient of a message, so that there is nobody to send it to.
+var ErrAllInvalid = errors.New("all recipients failed validation")
+
+// InvalidRecipient is a recipient left out of a message because
+// EmailConfig.RecipientValidator rejected it.
+type InvalidRecipient struct {
+	// Addr is the recipient's bare address.
+	Addr string
+	// Err is the validator's reason for rejecting it.
+	Err error
+}
+
+// validateRecipients passes each envelope recipient to
+// cfg.RecipientValidator and removes those it rejects from env, returning
+// them. With cfg.StrictRecipientValidation the first rejection fails the
+// send instead; otherwise it fails with ErrAllInvalid only if no recipient
+// is left.
+func validateRecipients(cfg EmailConfig, env *Envelope) ([]InvalidRecipient, error) {
+	if cfg.RecipientValidator == nil {
+		return nil, nil
+	}
+	var (
+		kept    []string
+		invalid []InvalidRecipient
+	)
+	for _, rcpt := range env.Rcpts {
+		err := cfg.RecipientValidator(rcpt)
+		if err == nil {
+			kept = append(kept, rcpt)
+			continue
+		}
+		if cfg.StrictRecipientValidation {
+			return nil, fmt.Errorf("invalid recipient %s: %w", rcpt, err)
+		}
+		cfg.logWarn("skipping invalid recipient", "rcpt", rcpt, "err", err)
+		invalid = append(invalid, InvalidRecipient{Addr: rcpt, Err: err})
+	}
+	if len(invalid) == 0 {
+		return nil, nil
+	}
+	if len(kept) == 0 {
+		addrs := make([]string, len(invalid))
+		for i, r := range invalid {
+			addrs[i] = r.Addr
+		}
+		return invalid, fmt.Errorf("%w: %s", ErrAllInvalid, strings.Join(addrs, ", "))
+	}
+	env.Rcpts = kept
+	return invalid, nil
+}
@@ -0,0 +1,58 @@
+package pigeon
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSendReport_RecipientValidator(t *testing.T) {
+	m := &mockSMTP{}
+	m.start(t)
+
+	errUnknown := errors.New("mailbox does not exist")
+	cfg := EmailConfig{
+		Smarthost:    m.smarthost(),
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com, gone@example.com\nCc: c@example.com\nSubject: Hi\n\nBody."),
+		RecipientValidator: func(addr string) error {
+			if addr == "gone@example.com" {
+				return errUnknown
+			}
+			return nil
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := SendReport(ctx, cfg, nil)
+	if err != nil {
+		t.Fatalf("SendReport: %v", err)
+	}
+	<-m.received
+	if want := []InvalidRecipient{{Addr: "gone@example.com", Err: errUnknown}}; !reflect.DeepEqual(res.Invalid, want) {
+		t.Errorf("Invalid = %v, want %v", res.Invalid, want)
+	}
+	var rcpts []string
+	for _, cmd := range m.Commands() {
+		if strings.HasPrefix(cmd, "RCPT TO:") {
+			rcpts = append(rcpts, cmd)
+		}
+	}
+	if want := []string{"RCPT TO:<b@example.com>", "RCPT TO:<c@example.com>"}; !reflect.DeepEqual(rcpts, want) {
+		t.Errorf("RCPT commands = %v, want %v", rcpts, want)
+	}
+
+	cfg.StrictRecipientValidation = true
+	if retry, err := Send(ctx, cfg, nil); !errors.Is(err, errUnknown) || retry {
+		t.Errorf("strict Send = (%v, %v), want permanent error wrapping the validator's", retry, err)
+	}
+
+	cfg.StrictRecipientValidation = false
+	cfg.RecipientValidator = func(string) error { return errUnknown }
+	if _, err := Send(ctx, cfg, nil); !errors.Is(err, ErrAllInvalid) {
+		t.Errorf("Send with every recipient invalid = %v, want ErrAllInvalid", err)
+	}
+}
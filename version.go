@@ -0,0 +1,17 @@
+package pigeon
+
+// Version is the version of the pigeon package, sent in the default X-Mailer
+// header.
+const Version = "0.1.0"
+
+// defaultXMailer is the X-Mailer header value unless EmailConfig.XMailer is
+// set.
+const defaultXMailer = "pigeon/" + Version
+
+// xMailer returns the X-Mailer header value for cfg, or "" if it is disabled.
+func (c *EmailConfig) xMailer() string {
+	if c.XMailer == nil {
+		return defaultXMailer
+	}
+	return *c.XMailer
+}
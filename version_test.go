@@ -0,0 +1,46 @@
+package pigeon
+
+import (
+	"bytes"
+	"net/mail"
+	"testing"
+)
+
+func TestBuildMessage_XMailer(t *testing.T) {
+	custom, empty := "Acme Newsletter 2.1", ""
+	tests := []struct {
+		name    string
+		xMailer *string
+		want    string
+	}{
+		{name: "default", want: "pigeon/" + Version},
+		{name: "custom", xMailer: &custom, want: custom},
+		{name: "cleared", xMailer: &empty},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := EmailConfig{
+				TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Mailer\n\nHello"),
+				XMailer:      tt.xMailer,
+			}
+			raw, err := BuildMessage(cfg, nil)
+			if err != nil {
+				t.Fatalf("BuildMessage: %v", err)
+			}
+			msg, err := mail.ReadMessage(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatalf("ReadMessage: %v", err)
+			}
+			got, ok := msg.Header["X-Mailer"]
+			if tt.want == "" {
+				if ok {
+					t.Errorf("X-Mailer = %q, want no header", got)
+				}
+				return
+			}
+			if len(got) != 1 || got[0] != tt.want {
+				t.Errorf("X-Mailer = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package pigeon
+
+import (
+	"bufio"
+	"bytes"
+	"net/textproto"
+)
+
+// BuildWireMessage is like BuildMessage but returns the message exactly as
+// Send writes it after the DATA command, for debugging at the protocol
+// level: MessageRewriter is applied, the DKIM signature is added, line
+// endings are CRLF whatever cfg.LineEnding says, and lines starting with "."
+// are dot-stuffed (RFC 5321, section 4.5.2). The final "." line that ends
+// the data is left out. A Received header (AddReceivedHeader) depends on the
+// connection and is not included.
+func BuildWireMessage(cfg EmailConfig, data any) ([]byte, error) {
+	msg := getBuffer()
+	defer putBuffer(msg)
+	if _, err := buildMessage(cfg, data, msg); err != nil {
+		return nil, err
+	}
+	raw, err := finalizeMessage(cfg, msg.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return dotStuff(raw), nil
+}
+
+// dotStuff returns msg as textproto.DotWriter, which net/smtp uses for DATA,
+// writes it, without the terminating "." line.
+func dotStuff(msg []byte) []byte {
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+	dw := textproto.NewWriter(bw).DotWriter()
+	dw.Write(msg)
+	dw.Close()
+	bw.Flush()
+	return bytes.TrimSuffix(buf.Bytes(), []byte(".\r\n"))
+}
@@ -0,0 +1,33 @@
+package pigeon
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestBuildWireMessage(t *testing.T) {
+	cfg := EmailConfig{
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Dots\n\nFirst\n.\n..two\nLast"),
+		LineEnding:   "lf",
+	}
+	wire, err := BuildWireMessage(cfg, nil)
+	if err != nil {
+		t.Fatalf("BuildWireMessage: %v", err)
+	}
+	if !bytes.Contains(wire, []byte("\r\nFirst\r\n..\r\n...two\r\nLast\r\n")) {
+		t.Errorf("body not dot-stuffed with CRLF line endings:\n%q", wire)
+	}
+	if bytes.HasSuffix(wire, []byte("\r\n.\r\n")) {
+		t.Errorf("wire message includes the end-of-data line:\n%q", wire)
+	}
+
+	// The logical message is not stuffed.
+	msg, err := BuildMessage(cfg, nil)
+	if err != nil {
+		t.Fatalf("BuildMessage: %v", err)
+	}
+	if !strings.Contains(string(msg), "\nFirst\n.\n..two\nLast") {
+		t.Errorf("BuildMessage body changed:\n%q", msg)
+	}
+}
@@ -0,0 +1,61 @@
+package pigeon
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// wrapText soft-wraps the lines of s that are longer than width characters
+// at word boundaries. Existing line breaks are kept, and quoted (">") or
+// indented lines are left alone, as are words longer than width.
+func wrapText(s string, width int) string {
+	lines := strings.Split(s, "\n")
+	out := make([]string, 0, len(lines))
+	for _, line := range lines {
+		line, cr := strings.CutSuffix(line, "\r")
+		if utf8.RuneCountInString(line) <= width || strings.HasPrefix(line, ">") ||
+			strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			out = append(out, restoreCR(line, cr))
+			continue
+		}
+		for _, l := range wrapLine(line, width) {
+			out = append(out, restoreCR(l, cr))
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// wrapLine breaks line into lines of at most width characters, breaking at
+// the last space that fits. A word longer than width is put on a line of its
+// own.
+func wrapLine(line string, width int) []string {
+	var out []string
+	for utf8.RuneCountInString(line) > width {
+		cut, n := -1, 0
+		for i, r := range line {
+			if n > width {
+				break
+			}
+			if r == ' ' {
+				cut = i
+			}
+			n++
+		}
+		if cut <= 0 {
+			if cut = strings.IndexByte(line, ' '); cut < 0 {
+				break
+			}
+		}
+		out = append(out, strings.TrimRight(line[:cut], " "))
+		line = strings.TrimLeft(line[cut:], " ")
+	}
+	return append(out, line)
+}
+
+// restoreCR appends "\r" to line if cr is set.
+func restoreCR(line string, cr bool) string {
+	if cr {
+		return line + "\r"
+	}
+	return line
+}
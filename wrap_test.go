@@ -0,0 +1,74 @@
+package pigeon
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWrapText(t *testing.T) {
+	paragraph := "The quarterly maintenance window starts on Saturday at 22:00 UTC and is expected to last about four hours, during which the mail relays will be unavailable."
+	quoted := "> " + strings.Repeat("quoted text that should stay on one line ", 3)
+	indented := "    " + strings.Repeat("indented_code_line ", 6)
+	long := "See https://example.com/" + strings.Repeat("x", 80) + " for details."
+	in := strings.Join([]string{"Hello team,", "", paragraph, quoted, indented, long, "Thanks"}, "\n")
+
+	got := wrapText(in, 72)
+	lines := strings.Split(got, "\n")
+	want := []string{
+		"Hello team,",
+		"",
+		"The quarterly maintenance window starts on Saturday at 22:00 UTC and is",
+		"expected to last about four hours, during which the mail relays will be",
+		"unavailable.",
+		quoted,
+		indented,
+		"See",
+		"https://example.com/" + strings.Repeat("x", 80),
+		"for details.",
+		"Thanks",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("wrapText =\n%s\nwant\n%s", got, strings.Join(want, "\n"))
+	}
+	if got := wrapText("a short line\r\n", 72); got != "a short line\r\n" {
+		t.Errorf("wrapText changed a short line: %q", got)
+	}
+}
+
+func TestBuildMessage_WrapText(t *testing.T) {
+	paragraph := strings.Repeat("Lorem ipsum dolor sit amet, consectetur adipiscing elit. ", 6)
+	cfg := EmailConfig{
+		TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: Wrap\n\n"+paragraph),
+		WrapText:     72,
+	}
+	raw, err := BuildMessage(cfg, nil)
+	if err != nil {
+		t.Fatalf("BuildMessage: %v", err)
+	}
+	head, body, _ := strings.Cut(string(raw), "\r\n\r\n")
+	// Wrapped ASCII text no longer needs quoted-printable.
+	if !strings.Contains(head, "Content-Transfer-Encoding: 7bit") {
+		t.Errorf("expected 7bit body:\n%s", head)
+	}
+	lines := strings.Split(strings.ReplaceAll(body, "\r\n", "\n"), "\n")
+	if len(lines) < 2 {
+		t.Fatalf("body was not wrapped: %q", body)
+	}
+	for _, line := range lines {
+		if len(line) > 72 {
+			t.Errorf("line longer than 72 columns: %q", line)
+		}
+	}
+	if got := strings.Join(strings.Fields(body), " "); got != strings.TrimSpace(paragraph) {
+		t.Errorf("wrapping changed the words: %q", got)
+	}
+
+	cfg.WrapText = 0
+	raw, err = BuildMessage(cfg, nil)
+	if err != nil {
+		t.Fatalf("BuildMessage: %v", err)
+	}
+	if !strings.Contains(string(raw), "Content-Transfer-Encoding: quoted-printable") {
+		t.Error("expected the unwrapped body to be quoted-printable")
+	}
+}
@@ -0,0 +1,99 @@
+package pigeon
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"slices"
+	"strings"
+)
+
+// XClientConfig passes the original client's attributes to a trusted relay
+// with the XCLIENT command, as supported by Postfix, so that the relay
+// applies the policy for that client rather than for pigeon. It is only
+// sent to servers that advertise XCLIENT; others get the message without it.
+type XClientConfig struct {
+	// Addr is the original client's IP address, e.g. "192.0.2.10" or, as
+	// Postfix writes IPv6 addresses, "IPV6:2001:db8::1".
+	Addr string `yaml:"addr,omitempty" json:"addr,omitempty"`
+	// Name is the original client's host name, or "[UNAVAILABLE]" if it
+	// has none.
+	Name string `yaml:"name,omitempty" json:"name,omitempty"`
+	// Proto is the protocol the original client spoke: "SMTP" or "ESMTP".
+	Proto string `yaml:"proto,omitempty" json:"proto,omitempty"`
+}
+
+// attrs returns the XCLIENT attributes of x, with xtext-encoded values, in
+// the order ADDR, NAME, PROTO. Unset fields are left out.
+func (x *XClientConfig) attrs() ([][2]string, error) {
+	var attrs [][2]string
+	if x.Addr != "" {
+		attrs = append(attrs, [2]string{"ADDR", xtext(x.Addr)})
+	}
+	if x.Name != "" {
+		attrs = append(attrs, [2]string{"NAME", xtext(x.Name)})
+	}
+	switch p := strings.ToUpper(x.Proto); p {
+	case "":
+	case "SMTP", "ESMTP":
+		attrs = append(attrs, [2]string{"PROTO", p})
+	default:
+		return nil, fmt.Errorf("invalid xclient proto %q: must be SMTP or ESMTP", x.Proto)
+	}
+	return attrs, nil
+}
+
+// xtext encodes s as RFC 3461 xtext: "+", "=" and characters outside
+// printable ASCII are written as "+" and two hex digits.
+func xtext(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < '!' || c > '~' || c == '+' || c == '=' {
+			fmt.Fprintf(&b, "+%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// xclient sends cfg.XClient to the server of c on conn, if it advertises
+// XCLIENT, and returns the client for the rest of the session. The server
+// answers XCLIENT with a new greeting and forgets the EHLO, so the returned
+// client reads that greeting and says EHLO again. c is returned unchanged if
+// there is nothing to send. The server must accept every attribute given.
+func xclient(conn net.Conn, c *smtp.Client, host string, cfg EmailConfig) (_ *smtp.Client, retry bool, err error) {
+	if cfg.XClient == nil {
+		return c, false, nil
+	}
+	attrs, err := cfg.XClient.attrs()
+	if err != nil || len(attrs) == 0 {
+		return c, false, err
+	}
+	if cfg.Hello != "" {
+		_ = c.Hello(cfg.Hello)
+	}
+	ok, supported := c.Extension("XCLIENT")
+	if !ok {
+		cfg.logDebug("server does not support XCLIENT", "host", host)
+		return c, false, nil
+	}
+
+	names := strings.Fields(strings.ToUpper(supported))
+	args := make([]string, 0, len(attrs))
+	for _, a := range attrs {
+		if !slices.Contains(names, a[0]) {
+			return c, false, fmt.Errorf("server does not accept XCLIENT attribute %s", a[0])
+		}
+		args = append(args, a[0]+"="+a[1])
+	}
+	if _, err := c.Text.Cmd("XCLIENT %s", strings.Join(args, " ")); err != nil {
+		return c, true, err
+	}
+	nc, retry, err := greet(conn, host, cfg)
+	if err != nil {
+		return c, retry, fmt.Errorf("XCLIENT failed: %w", err)
+	}
+	cfg.logDebug("sent XCLIENT", "host", host, "attributes", strings.Join(args, " "))
+	return nc, false, nil
+}
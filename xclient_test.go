@@ -0,0 +1,83 @@
+package pigeon
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSend_XClient(t *testing.T) {
+	tests := []struct {
+		name    string
+		exts    []string
+		replies map[string]string
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "advertised",
+			exts: []string{"XCLIENT NAME ADDR PROTO HELO"},
+			want: []string{
+				"EHLO relay.example.com",
+				"XCLIENT ADDR=192.0.2.10 NAME=client+20one.example.net PROTO=ESMTP",
+				"EHLO relay.example.com",
+				"MAIL FROM:<a@example.com>",
+			},
+		},
+		{
+			name: "not advertised",
+			want: []string{"EHLO relay.example.com", "MAIL FROM:<a@example.com>"},
+		},
+		{
+			name:    "attribute not accepted",
+			exts:    []string{"XCLIENT ADDR"},
+			wantErr: "attribute NAME",
+		},
+		{
+			name:    "refused",
+			exts:    []string{"XCLIENT NAME ADDR PROTO"},
+			replies: map[string]string{"XCLIENT": "550 5.7.0 Insufficient authorization"},
+			wantErr: "XCLIENT failed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &mockSMTP{Extensions: tt.exts, Replies: tt.replies}
+			m.start(t)
+			cfg := EmailConfig{
+				Smarthost:    m.smarthost(),
+				Hello:        "relay.example.com",
+				TemplatePath: tplWriteTemp(t, "From: a@example.com\nTo: b@example.com\nSubject: XCLIENT\n\nHello"),
+				XClient:      &XClientConfig{Addr: "192.0.2.10", Name: "client one.example.net", Proto: "esmtp"},
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			_, err := Send(ctx, cfg, nil)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Send error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Send: %v", err)
+			}
+			<-m.received
+			if got := m.Commands()[:len(tt.want)]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("commands = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestXClientConfig_Attrs(t *testing.T) {
+	if _, err := (&XClientConfig{Proto: "LMTP"}).attrs(); err == nil {
+		t.Error("attrs with proto LMTP: want error")
+	}
+	if got := xtext("a+b=c\x7f"); got != "a+2Bb+3Dc+7F" {
+		t.Errorf("xtext = %q", got)
+	}
+}